@@ -23,6 +23,16 @@ type File struct {
 	Path    string
 	Imports []Import
 	Defs    []Def
+	// Comments are the comments of the file
+	// in the order that they appear in the source.
+	Comments []Comment
+}
+
+// A Comment is a // or /* */ comment.
+type Comment struct {
+	loc.Range
+	// Text is the comment text, including the // or /* */.
+	Text string
 }
 
 // An Import is an import statement.
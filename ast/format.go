@@ -0,0 +1,691 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+package ast
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/eaburns/pea/loc"
+)
+
+// Format returns the source of a file in the canonical format.
+// The first argument is the file path or "" if unspecified;
+// it is only used in error messages.
+//
+// Comments are preserved.
+// Blank lines between definitions and statements are preserved,
+// but runs of blank lines are reduced to a single blank line.
+// A bracketed definition body, block, constructor, or type definition
+// is printed on a single line if it was on a single line in the source,
+// and otherwise it is printed with each element on its own line.
+// Line breaks before the keywords of a message or the messages of a cascade
+// are also preserved.
+// In a multi-line body, each statement but the last is followed by a period;
+// the last is followed by a period only if it was in the source.
+// Tabs before constructor elements and trailing comments are preserved,
+// keeping the columns of tab-aligned source.
+func Format(path string, src []byte) ([]byte, error) {
+	p := NewParser("")
+	if err := p.Parse(path, bytes.NewReader(src)); err != nil {
+		return nil, err
+	}
+	file := &p.Mod().Files[0]
+	pr := printer{
+		src:        string(src),
+		cmnts:      file.Comments,
+		cmntEnds:   make(map[int]int),
+		cmntStarts: make(map[int]int),
+	}
+	for _, c := range file.Comments {
+		pr.cmntEnds[c.Range[0]] = c.Range[1]
+		pr.cmntStarts[c.Range[1]] = c.Range[0]
+	}
+	pr.file(file)
+	return []byte(pr.out.String()), nil
+}
+
+type printer struct {
+	src string
+	// cmnts are the comments that have not yet been printed.
+	cmnts []Comment
+	out   strings.Builder
+	// indent is the indentation of the current line.
+	indent int
+	// midLine is whether anything has been written to the current line.
+	midLine bool
+	// last is the source offset of the end
+	// of the most recently printed list element or comment.
+	last int
+	// alignKeys is whether line breaks in the next call
+	// do not increase the indentation.
+	alignKeys bool
+	// cmntEnds maps the source offset of the start of each comment
+	// to the offset of its end.
+	cmntEnds map[int]int
+	// cmntStarts maps the source offset of the end of each comment
+	// to the offset of its start.
+	cmntStarts map[int]int
+}
+
+func (p *printer) write(s string) {
+	if !p.midLine {
+		for i := 0; i < p.indent; i++ {
+			p.out.WriteRune('\t')
+		}
+		p.midLine = true
+	}
+	p.out.WriteString(s)
+}
+
+func (p *printer) newline() {
+	p.out.WriteRune('\n')
+	p.midLine = false
+}
+
+func (p *printer) file(f *File) {
+	for i := range f.Imports {
+		imp := &f.Imports[i]
+		p.elem(imp.Range[0], i == 0)
+		if imp.All {
+			p.write("Import ")
+		} else {
+			p.write("import ")
+		}
+		p.write(imp.Path)
+		p.trailing(imp.Range[1])
+		p.newline()
+	}
+	for i, def := range f.Defs {
+		if i == 0 && len(f.Imports) > 0 {
+			p.newline()
+		}
+		r := def.GetRange()
+		p.elem(r[0], i == 0)
+		p.def(def)
+		p.trailing(r[1])
+		p.newline()
+	}
+	p.comments(len(p.src), len(f.Imports) == 0 && len(f.Defs) == 0)
+}
+
+func (p *printer) def(def Def) {
+	switch def := def.(type) {
+	case *Val:
+		p.write(def.String())
+		p.write(" := [")
+		p.stmts(def.Range, def.Init, false)
+		p.write("]")
+	case *Fun:
+		switch {
+		case def.Test:
+			p.write("test [" + def.Sig.Sel + " |")
+		case def.Stmts == nil:
+			p.write(def.String())
+			return
+		default:
+			p.write(strings.TrimSuffix(def.String(), "]"))
+		}
+		p.stmts(def.Range, def.Stmts, true)
+		p.write("]")
+	case *Type:
+		p.write(def.String())
+		p.typeBody(def)
+	}
+}
+
+func (p *printer) typeBody(t *Type) {
+	if t.Alias != nil {
+		p.write(" := " + t.Alias.String() + ".")
+		return
+	}
+	p.write(" {")
+	oneLine := p.oneLine(t.Range)
+	switch {
+	case t.Cases != nil && oneLine:
+		p.join(len(t.Cases), " | ", func(i int) string {
+			return caseString(&t.Cases[i])
+		})
+	case t.Cases != nil:
+		p.list(t.Range[1], len(t.Cases), func(i int) loc.Range {
+			return varRange(&t.Cases[i])
+		}, func(i int) {
+			p.write("| " + caseString(&t.Cases[i]))
+		})
+	case t.Virts != nil && oneLine:
+		p.join(len(t.Virts), " ", func(i int) string {
+			return t.Virts[i].String()
+		})
+	case t.Virts != nil:
+		p.list(t.Range[1], len(t.Virts), func(i int) loc.Range {
+			return t.Virts[i].Range
+		}, func(i int) {
+			p.write(t.Virts[i].String())
+		})
+	case oneLine:
+		p.join(len(t.Fields), " ", func(i int) string {
+			return fieldString(&t.Fields[i])
+		})
+	default:
+		p.list(t.Range[1], len(t.Fields), func(i int) loc.Range {
+			return varRange(&t.Fields[i])
+		}, func(i int) {
+			p.write(fieldString(&t.Fields[i]))
+		})
+	}
+	p.write("}")
+}
+
+// join writes the strings of n elements separated by sep.
+func (p *printer) join(n int, sep string, str func(i int) string) {
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			p.write(sep)
+		}
+		p.write(str(i))
+	}
+}
+
+// caseString returns the string of an Or type case.
+// The name of a case with a type ends in :.
+func caseString(c *Var) string {
+	if c.Type == nil {
+		return c.Name
+	}
+	return c.Name + " " + c.Type.String()
+}
+
+func fieldString(f *Var) string {
+	return f.Name + ": " + f.Type.String()
+}
+
+// varRange returns the range of a Var including its type.
+// The range of a Var is sometimes only that of its name.
+func varRange(v *Var) loc.Range {
+	r := v.Range
+	if v.Type != nil && v.Type.Range[1] > r[1] {
+		r[1] = v.Type.Range[1]
+	}
+	return r
+}
+
+// stmts prints the statements of the bracketed construct with the given range.
+// The caller prints the opening and closing brackets.
+// If the statements are printed on a single line
+// and space is true, the first is preceded by a space.
+func (p *printer) stmts(r loc.Range, stmts []Stmt, space bool) {
+	if p.oneLine(r) || len(stmts) == 0 && !p.hasComments(r) {
+		for i, stmt := range stmts {
+			switch {
+			case i > 0:
+				p.write(". ")
+			case space:
+				p.write(" ")
+			}
+			p.stmt(stmt)
+		}
+		return
+	}
+	p.list(r[1], len(stmts), func(i int) loc.Range {
+		return stmts[i].GetRange()
+	}, func(i int) {
+		p.stmt(stmts[i])
+		if i < len(stmts)-1 || p.period(stmts[i].GetRange()[1]) {
+			p.write(".")
+		}
+	})
+}
+
+// period returns whether the statement ending at the source offset end
+// is followed by a period in the source.
+func (p *printer) period(end int) bool {
+	off := p.skipSpace(end)
+	for off < len(p.src) && p.src[off] == ')' {
+		off = p.skipSpace(off + 1)
+	}
+	return off < len(p.src) && p.src[off] == '.'
+}
+
+func (p *printer) stmt(stmt Stmt) {
+	switch stmt := stmt.(type) {
+	case *Ret:
+		p.inline(stmt.start)
+		p.write("^")
+		p.expr(stmt.Expr, cascadePrec)
+	case *Assign:
+		p.inline(stmt.Vars[0].Range[0])
+		for i := range stmt.Vars {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.write(stmt.Vars[i].Name)
+			if stmt.Vars[i].Type != nil {
+				p.write(" " + stmt.Vars[i].Type.String())
+			}
+		}
+		p.write(" := ")
+		p.expr(stmt.Expr, cascadePrec)
+	case Expr:
+		p.expr(stmt, cascadePrec)
+	}
+}
+
+// The precedence of expressions, from loosest to tightest binding.
+const (
+	cascadePrec = iota
+	naryPrec
+	binaryPrec
+	unaryPrec
+	primaryPrec
+)
+
+func exprPrec(expr Expr) int {
+	switch call, ok := expr.(*Call); {
+	case !ok:
+		return primaryPrec
+	case len(call.Msgs) > 1:
+		return cascadePrec
+	default:
+		return msgPrec(&call.Msgs[0])
+	}
+}
+
+func msgPrec(msg *Msg) int {
+	switch {
+	case len(msg.Args) == 0:
+		return unaryPrec
+	case strings.HasSuffix(msg.Sel, ":"):
+		return naryPrec
+	default:
+		return binaryPrec
+	}
+}
+
+// expr prints an expression,
+// parenthesized if its precedence is less than prec.
+func (p *printer) expr(expr Expr, prec int) {
+	r := expr.GetRange()
+	p.inline(r[0])
+	if exprPrec(expr) < prec || p.parenthesized(r) {
+		p.write("(")
+		defer p.write(")")
+	}
+	switch expr := expr.(type) {
+	case *Call:
+		p.call(expr)
+	case *Ctor:
+		p.ctor(expr)
+	case *Block:
+		p.block(expr)
+	case *Ident:
+		if expr.Mod != nil {
+			p.write(expr.Mod.Text + " ")
+		}
+		p.write(expr.Text)
+	default:
+		// The Text of a literal has its escapes interpreted,
+		// so literals are printed as they are in the source.
+		p.write(p.src[r[0]:r[1]])
+	}
+}
+
+func (p *printer) call(call *Call) {
+	// broken is whether a line break has been printed in the call.
+	// After the first line break, the indentation is increased by one
+	// for the remainder of the call,
+	// unless the keywords are aligned with the start of the call.
+	broken := p.alignKeys
+	p.alignKeys = false
+	defer func(indent int) { p.indent = indent }(p.indent)
+	end := -1
+	if call.Recv != nil {
+		recvPrec := msgPrec(&call.Msgs[0])
+		if recvPrec == naryPrec {
+			recvPrec = binaryPrec
+		}
+		p.expr(call.Recv, recvPrec)
+		end = call.Recv.GetRange()[1]
+	}
+	for i := range call.Msgs {
+		msg := &call.Msgs[i]
+		if i > 0 {
+			p.write(",")
+		}
+		if start := msg.Range[0]; end >= 0 {
+			if msg.Mod != nil {
+				start = msg.Mod.Range[0]
+			}
+			p.space(end, start, &broken)
+		}
+		p.msg(msg, &broken)
+		end = msg.Range[1]
+	}
+}
+
+func (p *printer) msg(msg *Msg, broken *bool) {
+	if msg.Mod != nil {
+		p.write(msg.Mod.Text)
+		p.space(msg.Mod.Range[1], msg.Range[0], broken)
+	}
+	switch msgPrec(msg) {
+	case unaryPrec:
+		p.write(msg.Sel)
+	case binaryPrec:
+		p.write(msg.Sel)
+		p.space(msg.Range[0]+len(msg.Sel), msg.Args[0].GetRange()[0], broken)
+		p.expr(msg.Args[0], unaryPrec)
+	default:
+		keys := strings.SplitAfter(msg.Sel, ":")
+		start := msg.Range[0]
+		for i, arg := range msg.Args {
+			if i > 0 {
+				end := msg.Args[i-1].GetRange()[1]
+				start = p.skipSpace(end)
+				for p.src[start] == ')' {
+					start = p.skipSpace(start + 1)
+				}
+				p.space(end, start, broken)
+			}
+			p.write(keys[i])
+			p.space(start+len(keys[i]), arg.GetRange()[0], broken)
+			p.expr(arg, binaryPrec)
+		}
+	}
+}
+
+// space prints a space between two parts of a call,
+// the first ending at source offset end
+// and the second beginning at source offset start.
+// If there is a line break in the source between them,
+// a line break is printed instead,
+// and the indentation is increased if it was not already.
+func (p *printer) space(end, start int, broken *bool) {
+	if !p.lineBreak(end, start) {
+		p.write(" ")
+		return
+	}
+	if !*broken {
+		p.indent++
+		*broken = true
+	}
+	p.newline()
+}
+
+func (p *printer) ctor(ctor *Ctor) {
+	p.write("{")
+	indent := p.indent
+	end := ctor.Range[0] + len("{")
+	for i, arg := range ctor.Args {
+		r := arg.GetRange()
+		if i > 0 {
+			p.write(";")
+		}
+		if p.lineBreak(end, r[0]) {
+			if i > 0 {
+				p.trailing(end)
+			}
+			p.indent = indent + 1
+			p.newline()
+			p.elem(r[0], i == 0)
+			// Keyword arguments that are each on their own line,
+			// such as those of an And type literal,
+			// are aligned with the first.
+			if call, ok := arg.(*Call); ok && call.Recv == nil {
+				p.alignKeys = true
+			}
+		} else if i > 0 {
+			p.write(p.sep(r[0]))
+		}
+		p.expr(arg, cascadePrec)
+		end = r[1]
+	}
+	if len(ctor.Args) > 0 && p.lineBreak(end, ctor.Range[1]) {
+		if p.src[p.skipSpace(end)] == ';' {
+			p.write(";")
+		}
+		p.trailing(end)
+		p.indent = indent + 1
+		p.newline()
+		p.comments(ctor.Range[1], false)
+	} else if len(ctor.Args) > 0 && p.src[p.skipSpace(end)] == ';' {
+		p.write(";")
+	}
+	p.indent = indent
+	p.write("}")
+}
+
+func (p *printer) block(b *Block) {
+	p.write("[")
+	for i, parm := range b.Parms {
+		if i > 0 {
+			p.write(" ")
+		}
+		p.write(":" + parm.Name)
+		if parm.Type != nil {
+			p.write(" " + parm.Type.String())
+		}
+	}
+	if len(b.Parms) > 0 {
+		p.write(" |")
+	}
+	p.stmts(b.Range, b.Stmts, len(b.Parms) > 0)
+	p.write("]")
+}
+
+// list prints the elements of a bracketed list, each on its own line,
+// with the indentation increased by one.
+// The caller prints the opening and closing brackets.
+// The end argument is the source offset of the end of the list;
+// comments after the last element and before end are printed
+// after the last element.
+func (p *printer) list(end, n int, elemRange func(int) loc.Range, elem func(int)) {
+	p.indent++
+	for i := 0; i < n; i++ {
+		r := elemRange(i)
+		p.newline()
+		p.elem(r[0], i == 0)
+		elem(i)
+		p.trailing(r[1])
+	}
+	p.newline()
+	p.comments(end, n == 0)
+	p.indent--
+}
+
+// elem prints the comments before the start of a list element,
+// and a blank line before the element
+// if there was one in the source.
+// The first element of a list is never preceded by a blank line.
+func (p *printer) elem(start int, first bool) {
+	if p.comments(start, first) {
+		first = false
+	}
+	if !first && p.blankLine(p.last, start) {
+		p.newline()
+	}
+}
+
+// comments prints the comments before a source offset,
+// each on its own line,
+// and returns whether any comments were printed.
+// Each comment is preceded by a blank line
+// if there was one in the source,
+// except the first comment if first is true.
+func (p *printer) comments(end int, first bool) bool {
+	var printed bool
+	for len(p.cmnts) > 0 && p.cmnts[0].Range[0] < end {
+		c := p.cmnts[0]
+		p.cmnts = p.cmnts[1:]
+		if !first && p.blankLine(p.last, c.Range[0]) {
+			p.newline()
+		}
+		first = false
+		p.write(c.Text)
+		p.newline()
+		p.last = c.Range[1]
+		printed = true
+	}
+	return printed
+}
+
+// trailing prints the comment following a list element
+// that ends at the source offset end
+// if the comment is on the same source line as the end of the element.
+func (p *printer) trailing(end int) {
+	p.last = end
+	if len(p.cmnts) == 0 {
+		return
+	}
+	c := p.cmnts[0]
+	if c.Range[0] < end || strings.Contains(p.src[end:c.Range[0]], "\n") {
+		return
+	}
+	p.cmnts = p.cmnts[1:]
+	p.write(p.sep(c.Range[0]) + c.Text)
+	p.last = c.Range[1]
+}
+
+// sep returns the separator to print before an element
+// beginning at the source offset off on the current line.
+// It is the tabs before off in the source, if any,
+// which keep the columns of the source aligned,
+// and otherwise a single space.
+func (p *printer) sep(off int) string {
+	start := off
+	for start > 0 && p.src[start-1] == '\t' {
+		start--
+	}
+	if start == off {
+		return " "
+	}
+	return p.src[start:off]
+}
+
+// inline prints the comments before a source offset
+// on the current line.
+func (p *printer) inline(off int) {
+	for len(p.cmnts) > 0 && p.cmnts[0].Range[0] < off {
+		c := p.cmnts[0]
+		p.cmnts = p.cmnts[1:]
+		p.write(c.Text)
+		if strings.HasPrefix(c.Text, "//") {
+			p.newline()
+		} else {
+			p.write(" ")
+		}
+	}
+}
+
+// oneLine returns whether the source of the range
+// is on a single line and contains no comments.
+func (p *printer) oneLine(r loc.Range) bool {
+	return !strings.Contains(p.src[r[0]:r[1]], "\n") && !p.hasComments(r)
+}
+
+func (p *printer) hasComments(r loc.Range) bool {
+	for _, c := range p.cmnts {
+		if c.Range[0] >= r[1] {
+			break
+		}
+		if c.Range[0] >= r[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// lineBreak returns whether there is a line break
+// in the source between two offsets.
+func (p *printer) lineBreak(start, end int) bool {
+	return start < end && strings.Contains(p.src[start:end], "\n")
+}
+
+// skipSpace returns the source offset of the first character
+// at or after off that is not whitespace or part of a comment.
+func (p *printer) skipSpace(off int) int {
+	for off < len(p.src) {
+		if end, ok := p.cmntEnds[off]; ok {
+			off = end
+			continue
+		}
+		if !strings.ContainsRune(" \t\n", rune(p.src[off])) {
+			break
+		}
+		off++
+	}
+	return off
+}
+
+// skipSpaceBack returns the source offset just after the first character
+// before off that is not whitespace or part of a comment.
+func (p *printer) skipSpaceBack(off int) int {
+	for off > 0 {
+		if start, ok := p.cmntStarts[off]; ok {
+			off = start
+			continue
+		}
+		if !strings.ContainsRune(" \t\n", rune(p.src[off-1])) {
+			break
+		}
+		off--
+	}
+	return off
+}
+
+// parenthesized returns whether the source of a range
+// is enclosed in parentheses.
+func (p *printer) parenthesized(r loc.Range) bool {
+	open := p.skipSpaceBack(r[0]) - 1
+	if open < 0 || p.src[open] != '(' {
+		return false
+	}
+	close := p.skipSpace(r[1])
+	return close < len(p.src) && p.src[close] == ')' && p.closeParen(open) == close
+}
+
+// closeParen returns the source offset of the parenthesis
+// closing the one at the given offset.
+func (p *printer) closeParen(open int) int {
+	var depth int
+	for off := open; off < len(p.src); off++ {
+		if end, ok := p.cmntEnds[off]; ok {
+			off = end - 1
+			continue
+		}
+		switch p.src[off] {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return off
+			}
+		case '"', '\'', '`':
+			off = p.literalEnd(off) - 1
+		}
+	}
+	return -1
+}
+
+// literalEnd returns the source offset of the end
+// of the string or rune literal beginning at the given offset.
+func (p *printer) literalEnd(start int) int {
+	quote := p.src[start]
+	for off := start + 1; off < len(p.src); off++ {
+		switch p.src[off] {
+		case '\\':
+			off++
+		case quote:
+			return off + 1
+		}
+	}
+	return len(p.src)
+}
+
+var blankLineRegexp = regexp.MustCompile(`\n[ \t]*\n`)
+
+// blankLine returns whether there is a blank line
+// in the source between two offsets.
+func (p *printer) blankLine(start, end int) bool {
+	return start < end && blankLineRegexp.MatchString(p.src[start:end])
+}
@@ -0,0 +1,281 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+package ast
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "empty",
+			src:  "",
+			want: "",
+		},
+		{
+			name: "imports",
+			src:  "import \"a\"\n   Import \"b\"\nfunc [f]",
+			want: "import \"a\"\nImport \"b\"\n\nfunc [f]\n",
+		},
+		{
+			name: "blank lines between defs",
+			src:  "func [f]\nfunc [g]\n\n\n\nfunc [h]\n\n",
+			want: "func [f]\nfunc [g]\n\nfunc [h]\n",
+		},
+		{
+			name: "def signatures",
+			src:  "Func  (T  Foo)[ foo: x Int  bar: y T^T]\nmeth  T Array [at: i Int]\nVal x Int :=[5]",
+			want: "Func (T Foo) [foo: x Int bar: y T ^T]\nmeth T Array [at: i Int]\nVal x Int := [5]\n",
+		},
+		{
+			name: "one-line bodies",
+			src:  "func [f ^Int|^5]\nfunc [g|]\ntest [t|  f. g  ]",
+			want: "func [f ^Int | ^5]\nfunc [g |]\ntest [t | f. g]\n",
+		},
+		{
+			name: "multi-line bodies",
+			src: `func [f ^Int |
+    x := 5.
+      ^x]`,
+			want: `func [f ^Int |
+	x := 5.
+	^x
+]
+`,
+		},
+		{
+			name: "trailing periods are preserved",
+			src: `func [f |
+	a.
+	^b
+]
+func [g |
+	a.
+	(b).
+]
+func [h | a. b.]`,
+			want: `func [f |
+	a.
+	^b
+]
+func [g |
+	a.
+	(b).
+]
+func [h | a. b]
+`,
+		},
+		{
+			name: "blank lines between statements",
+			src: `func [f |
+
+	a.
+
+
+	b.
+
+]`,
+			want: `func [f |
+	a.
+
+	b.
+]
+`,
+		},
+		{
+			name: "comments",
+			src: `// Copyright
+
+// f does things.
+func [f |
+	// a is first.
+	a. // trailing
+	b /* inside */ foo: /* arg */ c.
+
+	// at the end
+] // after f
+/* end */`,
+			want: `// Copyright
+
+// f does things.
+func [f |
+	// a is first.
+	a. // trailing
+	b foo: /* inside */ /* arg */ c.
+
+	// at the end
+] // after f
+/* end */
+`,
+		},
+		{
+			name: "comment forces multi-line",
+			src:  "func [f | a /* x */]",
+			want: "func [f |\n\ta /* x */\n]\n",
+		},
+		{
+			name: "messages",
+			src:  "func [f | a  b+c   foo: d bar:e. #m  x. y #m foo: z. a b, c, d: e]",
+			want: "func [f | a b + c foo: d bar: e. #m x. y #m foo: z. a b, c, d: e]\n",
+		},
+		{
+			name: "parentheses are preserved",
+			src:  "func [f | (a + b) + (c). (a foo: b) bar. x foo: (y bar: z). (\"(\" + ')')]",
+			want: "func [f | (a + b) + (c). (a foo: b) bar. x foo: (y bar: z). (\"(\" + ')')]\n",
+		},
+		{
+			name: "keyword line breaks",
+			src: `func [f |
+	a = b
+ifTrue: [x]
+			ifFalse: [
+y]
+]`,
+			want: `func [f |
+	a = b
+		ifTrue: [x]
+		ifFalse: [
+			y
+		]
+]
+`,
+		},
+		{
+			name: "module tag before line break",
+			src: `func [f |
+	x #m
+		foo: y
+]`,
+			want: `func [f |
+	x #m
+		foo: y
+]
+`,
+		},
+		{
+			name: "blocks",
+			src:  "func [f | [:x:y Int|x]. [ :x |]. []. [a]]",
+			want: "func [f | [:x :y Int | x]. [:x |]. []. [a]]\n",
+		},
+		{
+			name: "ctors",
+			src: `func [f |
+	{}. {a;b;}. {
+		1;	2;	// one two
+		3;
+	}.
+	{
+	x: 1
+	y: 2
+	}
+]`,
+			want: `func [f |
+	{}.
+	{a; b;}.
+	{
+		1;	2;	// one two
+		3;
+	}.
+	{
+		x: 1
+		y: 2
+	}
+]
+`,
+		},
+		{
+			name: "type definitions",
+			src: `Type T List :=T  _List.
+type Xs{a:Int   b:String}
+type Ys{a|b:Int|c}
+type Zs{[foo][bar: Int ^String]}
+type Empty {}
+type T Opt {
+| none
+	|some: T
+}
+Type Fooer {
+// foo does foo.
+[foo]
+
+[bar]
+}`,
+			want: `Type T List := T _List.
+type Xs {a: Int b: String}
+type Ys {a | b: Int | c}
+type Zs {[foo] [bar: Int ^String]}
+type Empty {}
+type T Opt {
+	| none
+	| some: T
+}
+Type Fooer {
+	// foo does foo.
+	[foo]
+
+	[bar]
+}
+`,
+		},
+		{
+			name: "escapes",
+			src:  "func [f | \"a\\n\\x00\". '\\n'. `\\``]",
+			want: "func [f | \"a\\n\\x00\". '\\n'. `\\``]\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Format("", []byte(test.src))
+			if err != nil {
+				t.Fatalf("Format(%q) failed: %s", test.src, err)
+			}
+			if string(got) != test.want {
+				t.Errorf("Format(%q)\ngot:\n%s\nwant:\n%s", test.src, got, test.want)
+			}
+			again, err := Format("", got)
+			if err != nil {
+				t.Fatalf("Format(%q) failed: %s", got, err)
+			}
+			if string(again) != string(got) {
+				t.Errorf("Format(%q)\ngot:\n%s\nwant:\n%s", got, again, got)
+			}
+		})
+	}
+}
+
+// TestFormatLib tests that formatting
+// files of the standard library does not change them.
+func TestFormatLib(t *testing.T) {
+	for _, path := range []string{
+		"../lib/primitive/int.pea",
+		"../lib/string/builder.pea",
+		"../lib/string/fmt/fmt.pea",
+	} {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", path, err)
+		}
+		got, err := Format(path, src)
+		if err != nil {
+			t.Fatalf("Format(%s) failed: %s", path, err)
+		}
+		if string(got) != string(src) {
+			t.Errorf("Format(%s)\ngot:\n%s\nwant:\n%s", path, got, src)
+		}
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	_, err := Format("test.pea", []byte("func [f |"))
+	if err == nil || !strings.HasPrefix(err.Error(), "test.pea:") {
+		t.Errorf("Format()=_,%v, want error beginning with test.pea:", err)
+	}
+}
@@ -18500,109 +18500,118 @@ func __Action(parser *_Parser, start int) (int, *struct{}) {
 }
 
 func _CmntAccepts(parser *_Parser, start int) (deltaPos, deltaErr int) {
+	var labels [1]string
+	use(labels)
 	if dp, de, ok := _memo(parser, _Cmnt, start); ok {
 		return dp, de
 	}
 	pos, perr := start, -1
-	// "//" (!"\n" .)*/"/*" (!"*/" .)* "*/"
+	// action
+	// text:("//" (!"\n" .)*/"/*" (!"*/" .)* "*/")
 	{
-		pos3 := pos
-		// "//" (!"\n" .)*
-		// "//"
-		if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "//" {
-			perr = _max(perr, pos)
-			goto fail4
-		}
-		pos += 2
-		// (!"\n" .)*
-		for {
-			pos7 := pos
-			// (!"\n" .)
-			// !"\n" .
-			// !"\n"
-			{
-				pos12 := pos
-				perr14 := perr
-				// "\n"
-				if len(parser.text[pos:]) < 1 || parser.text[pos:pos+1] != "\n" {
+		pos0 := pos
+		// ("//" (!"\n" .)*/"/*" (!"*/" .)* "*/")
+		// "//" (!"\n" .)*/"/*" (!"*/" .)* "*/"
+		{
+			pos4 := pos
+			// "//" (!"\n" .)*
+			// "//"
+			if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "//" {
+				perr = _max(perr, pos)
+				goto fail5
+			}
+			pos += 2
+			// (!"\n" .)*
+			for {
+				pos8 := pos
+				// (!"\n" .)
+				// !"\n" .
+				// !"\n"
+				{
+					pos13 := pos
+					perr15 := perr
+					// "\n"
+					if len(parser.text[pos:]) < 1 || parser.text[pos:pos+1] != "\n" {
+						perr = _max(perr, pos)
+						goto ok12
+					}
+					pos++
+					pos = pos13
+					perr = _max(perr15, pos)
+					goto fail10
+				ok12:
+					pos = pos13
+					perr = perr15
+				}
+				// .
+				if r, w := _next(parser, pos); w == 0 || r == '\uFFFD' {
 					perr = _max(perr, pos)
-					goto ok11
+					goto fail10
+				} else {
+					pos += w
 				}
-				pos++
-				pos = pos12
-				perr = _max(perr14, pos)
-				goto fail9
-			ok11:
-				pos = pos12
-				perr = perr14
+				continue
+			fail10:
+				pos = pos8
+				break
 			}
-			// .
-			if r, w := _next(parser, pos); w == 0 || r == '\uFFFD' {
+			goto ok1
+		fail5:
+			pos = pos4
+			// "/*" (!"*/" .)* "*/"
+			// "/*"
+			if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "/*" {
 				perr = _max(perr, pos)
-				goto fail9
-			} else {
-				pos += w
+				goto fail16
 			}
-			continue
-		fail9:
-			pos = pos7
-			break
-		}
-		goto ok0
-	fail4:
-		pos = pos3
-		// "/*" (!"*/" .)* "*/"
-		// "/*"
-		if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "/*" {
-			perr = _max(perr, pos)
-			goto fail15
-		}
-		pos += 2
-		// (!"*/" .)*
-		for {
-			pos18 := pos
-			// (!"*/" .)
-			// !"*/" .
-			// !"*/"
-			{
-				pos23 := pos
-				perr25 := perr
-				// "*/"
-				if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "*/" {
+			pos += 2
+			// (!"*/" .)*
+			for {
+				pos19 := pos
+				// (!"*/" .)
+				// !"*/" .
+				// !"*/"
+				{
+					pos24 := pos
+					perr26 := perr
+					// "*/"
+					if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "*/" {
+						perr = _max(perr, pos)
+						goto ok23
+					}
+					pos += 2
+					pos = pos24
+					perr = _max(perr26, pos)
+					goto fail21
+				ok23:
+					pos = pos24
+					perr = perr26
+				}
+				// .
+				if r, w := _next(parser, pos); w == 0 || r == '\uFFFD' {
 					perr = _max(perr, pos)
-					goto ok22
+					goto fail21
+				} else {
+					pos += w
 				}
-				pos += 2
-				pos = pos23
-				perr = _max(perr25, pos)
-				goto fail20
-			ok22:
-				pos = pos23
-				perr = perr25
+				continue
+			fail21:
+				pos = pos19
+				break
 			}
-			// .
-			if r, w := _next(parser, pos); w == 0 || r == '\uFFFD' {
+			// "*/"
+			if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "*/" {
 				perr = _max(perr, pos)
-				goto fail20
-			} else {
-				pos += w
+				goto fail16
 			}
-			continue
-		fail20:
-			pos = pos18
-			break
-		}
-		// "*/"
-		if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "*/" {
-			perr = _max(perr, pos)
-			goto fail15
+			pos += 2
+			goto ok1
+		fail16:
+			pos = pos4
+			goto fail
+		ok1:
 		}
-		pos += 2
-		goto ok0
-	fail15:
-		pos = pos3
-		goto fail
-	ok0:
+		labels[0] = parser.text[pos0:pos]
 	}
 	return _memoize(parser, _Cmnt, start, pos, perr)
 fail:
@@ -18610,6 +18619,8 @@ fail:
 }
 
 func _CmntFail(parser *_Parser, start, errPos int) (int, *peg.Fail) {
+	var labels [1]string
+	use(labels)
 	pos, failure := _failMemo(parser, _Cmnt, start, errPos)
 	if failure != nil {
 		return pos, failure
@@ -18619,152 +18630,159 @@ func _CmntFail(parser *_Parser, start, errPos int) (int, *peg.Fail) {
 		Pos:  int(start),
 	}
 	key := _key{start: start, rule: _Cmnt}
-	// "//" (!"\n" .)*/"/*" (!"*/" .)* "*/"
+	// action
+	// text:("//" (!"\n" .)*/"/*" (!"*/" .)* "*/")
 	{
-		pos3 := pos
-		// "//" (!"\n" .)*
-		// "//"
-		if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "//" {
-			if pos >= errPos {
-				failure.Kids = append(failure.Kids, &peg.Fail{
-					Pos:  int(pos),
-					Want: "\"//\"",
-				})
+		pos0 := pos
+		// ("//" (!"\n" .)*/"/*" (!"*/" .)* "*/")
+		// "//" (!"\n" .)*/"/*" (!"*/" .)* "*/"
+		{
+			pos4 := pos
+			// "//" (!"\n" .)*
+			// "//"
+			if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "//" {
+				if pos >= errPos {
+					failure.Kids = append(failure.Kids, &peg.Fail{
+						Pos:  int(pos),
+						Want: "\"//\"",
+					})
+				}
+				goto fail5
 			}
-			goto fail4
-		}
-		pos += 2
-		// (!"\n" .)*
-		for {
-			pos7 := pos
-			// (!"\n" .)
-			// !"\n" .
-			// !"\n"
-			{
-				pos12 := pos
-				nkids13 := len(failure.Kids)
-				// "\n"
-				if len(parser.text[pos:]) < 1 || parser.text[pos:pos+1] != "\n" {
+			pos += 2
+			// (!"\n" .)*
+			for {
+				pos8 := pos
+				// (!"\n" .)
+				// !"\n" .
+				// !"\n"
+				{
+					pos13 := pos
+					nkids14 := len(failure.Kids)
+					// "\n"
+					if len(parser.text[pos:]) < 1 || parser.text[pos:pos+1] != "\n" {
+						if pos >= errPos {
+							failure.Kids = append(failure.Kids, &peg.Fail{
+								Pos:  int(pos),
+								Want: "\"\\n\"",
+							})
+						}
+						goto ok12
+					}
+					pos++
+					pos = pos13
+					failure.Kids = failure.Kids[:nkids14]
 					if pos >= errPos {
 						failure.Kids = append(failure.Kids, &peg.Fail{
 							Pos:  int(pos),
-							Want: "\"\\n\"",
+							Want: "!\"\\n\"",
 						})
 					}
-					goto ok11
+					goto fail10
+				ok12:
+					pos = pos13
+					failure.Kids = failure.Kids[:nkids14]
 				}
-				pos++
-				pos = pos12
-				failure.Kids = failure.Kids[:nkids13]
-				if pos >= errPos {
-					failure.Kids = append(failure.Kids, &peg.Fail{
-						Pos:  int(pos),
-						Want: "!\"\\n\"",
-					})
+				// .
+				if r, w := _next(parser, pos); w == 0 || r == '\uFFFD' {
+					if pos >= errPos {
+						failure.Kids = append(failure.Kids, &peg.Fail{
+							Pos:  int(pos),
+							Want: ".",
+						})
+					}
+					goto fail10
+				} else {
+					pos += w
 				}
-				goto fail9
-			ok11:
-				pos = pos12
-				failure.Kids = failure.Kids[:nkids13]
+				continue
+			fail10:
+				pos = pos8
+				break
 			}
-			// .
-			if r, w := _next(parser, pos); w == 0 || r == '\uFFFD' {
+			goto ok1
+		fail5:
+			pos = pos4
+			// "/*" (!"*/" .)* "*/"
+			// "/*"
+			if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "/*" {
 				if pos >= errPos {
 					failure.Kids = append(failure.Kids, &peg.Fail{
 						Pos:  int(pos),
-						Want: ".",
+						Want: "\"/*\"",
 					})
 				}
-				goto fail9
-			} else {
-				pos += w
-			}
-			continue
-		fail9:
-			pos = pos7
-			break
-		}
-		goto ok0
-	fail4:
-		pos = pos3
-		// "/*" (!"*/" .)* "*/"
-		// "/*"
-		if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "/*" {
-			if pos >= errPos {
-				failure.Kids = append(failure.Kids, &peg.Fail{
-					Pos:  int(pos),
-					Want: "\"/*\"",
-				})
+				goto fail16
 			}
-			goto fail15
-		}
-		pos += 2
-		// (!"*/" .)*
-		for {
-			pos18 := pos
-			// (!"*/" .)
-			// !"*/" .
-			// !"*/"
-			{
-				pos23 := pos
-				nkids24 := len(failure.Kids)
-				// "*/"
-				if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "*/" {
+			pos += 2
+			// (!"*/" .)*
+			for {
+				pos19 := pos
+				// (!"*/" .)
+				// !"*/" .
+				// !"*/"
+				{
+					pos24 := pos
+					nkids25 := len(failure.Kids)
+					// "*/"
+					if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "*/" {
+						if pos >= errPos {
+							failure.Kids = append(failure.Kids, &peg.Fail{
+								Pos:  int(pos),
+								Want: "\"*/\"",
+							})
+						}
+						goto ok23
+					}
+					pos += 2
+					pos = pos24
+					failure.Kids = failure.Kids[:nkids25]
 					if pos >= errPos {
 						failure.Kids = append(failure.Kids, &peg.Fail{
 							Pos:  int(pos),
-							Want: "\"*/\"",
+							Want: "!\"*/\"",
 						})
 					}
-					goto ok22
+					goto fail21
+				ok23:
+					pos = pos24
+					failure.Kids = failure.Kids[:nkids25]
 				}
-				pos += 2
-				pos = pos23
-				failure.Kids = failure.Kids[:nkids24]
-				if pos >= errPos {
-					failure.Kids = append(failure.Kids, &peg.Fail{
-						Pos:  int(pos),
-						Want: "!\"*/\"",
-					})
+				// .
+				if r, w := _next(parser, pos); w == 0 || r == '\uFFFD' {
+					if pos >= errPos {
+						failure.Kids = append(failure.Kids, &peg.Fail{
+							Pos:  int(pos),
+							Want: ".",
+						})
+					}
+					goto fail21
+				} else {
+					pos += w
 				}
-				goto fail20
-			ok22:
-				pos = pos23
-				failure.Kids = failure.Kids[:nkids24]
+				continue
+			fail21:
+				pos = pos19
+				break
 			}
-			// .
-			if r, w := _next(parser, pos); w == 0 || r == '\uFFFD' {
+			// "*/"
+			if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "*/" {
 				if pos >= errPos {
 					failure.Kids = append(failure.Kids, &peg.Fail{
 						Pos:  int(pos),
-						Want: ".",
+						Want: "\"*/\"",
 					})
 				}
-				goto fail20
-			} else {
-				pos += w
-			}
-			continue
-		fail20:
-			pos = pos18
-			break
-		}
-		// "*/"
-		if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "*/" {
-			if pos >= errPos {
-				failure.Kids = append(failure.Kids, &peg.Fail{
-					Pos:  int(pos),
-					Want: "\"*/\"",
-				})
+				goto fail16
 			}
-			goto fail15
+			pos += 2
+			goto ok1
+		fail16:
+			pos = pos4
+			goto fail
+		ok1:
 		}
-		pos += 2
-		goto ok0
-	fail15:
-		pos = pos3
-		goto fail
-	ok0:
+		labels[0] = parser.text[pos0:pos]
 	}
 	parser.fail[key] = failure
 	return pos, failure
@@ -18773,7 +18791,10 @@ fail:
 	return -1, failure
 }
 
-func _CmntAction(parser *_Parser, start int) (int, *string) {
+func _CmntAction(parser *_Parser, start int) (int, *struct{}) {
+	var labels [1]string
+	use(labels)
+	var label0 string
 	dp := parser.deltaPos[start][_Cmnt]
 	if dp < 0 {
 		return -1, nil
@@ -18781,132 +18802,152 @@ func _CmntAction(parser *_Parser, start int) (int, *string) {
 	key := _key{start: start, rule: _Cmnt}
 	n := parser.act[key]
 	if n != nil {
-		n := n.(string)
+		n := n.(struct{})
 		return start + int(dp-1), &n
 	}
-	var node string
+	var node struct{}
 	pos := start
-	// "//" (!"\n" .)*/"/*" (!"*/" .)* "*/"
+	// action
 	{
-		pos3 := pos
-		var node2 string
-		// "//" (!"\n" .)*
+		start0 := pos
+		// text:("//" (!"\n" .)*/"/*" (!"*/" .)* "*/")
 		{
-			var node5 string
-			// "//"
-			if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "//" {
-				goto fail4
-			}
-			node5 = parser.text[pos : pos+2]
-			pos += 2
-			node, node5 = node+node5, ""
-			// (!"\n" .)*
-			for {
-				pos7 := pos
-				var node8 string
-				// (!"\n" .)
-				// !"\n" .
+			pos1 := pos
+			// ("//" (!"\n" .)*/"/*" (!"*/" .)* "*/")
+			// "//" (!"\n" .)*/"/*" (!"*/" .)* "*/"
+			{
+				pos5 := pos
+				var node4 string
+				// "//" (!"\n" .)*
 				{
-					var node10 string
-					// !"\n"
-					{
-						pos12 := pos
-						// "\n"
-						if len(parser.text[pos:]) < 1 || parser.text[pos:pos+1] != "\n" {
-							goto ok11
-						}
-						pos++
-						pos = pos12
-						goto fail9
-					ok11:
-						pos = pos12
-						node10 = ""
+					var node7 string
+					// "//"
+					if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "//" {
+						goto fail6
 					}
-					node8, node10 = node8+node10, ""
-					// .
-					if r, w := _next(parser, pos); w == 0 || r == '\uFFFD' {
-						goto fail9
-					} else {
-						node10 = parser.text[pos : pos+w]
-						pos += w
+					node7 = parser.text[pos : pos+2]
+					pos += 2
+					label0, node7 = label0+node7, ""
+					// (!"\n" .)*
+					for {
+						pos9 := pos
+						var node10 string
+						// (!"\n" .)
+						// !"\n" .
+						{
+							var node12 string
+							// !"\n"
+							{
+								pos14 := pos
+								// "\n"
+								if len(parser.text[pos:]) < 1 || parser.text[pos:pos+1] != "\n" {
+									goto ok13
+								}
+								pos++
+								pos = pos14
+								goto fail11
+							ok13:
+								pos = pos14
+								node12 = ""
+							}
+							node10, node12 = node10+node12, ""
+							// .
+							if r, w := _next(parser, pos); w == 0 || r == '\uFFFD' {
+								goto fail11
+							} else {
+								node12 = parser.text[pos : pos+w]
+								pos += w
+							}
+							node10, node12 = node10+node12, ""
+						}
+						node7 += node10
+						continue
+					fail11:
+						pos = pos9
+						break
 					}
-					node8, node10 = node8+node10, ""
+					label0, node7 = label0+node7, ""
 				}
-				node5 += node8
-				continue
-			fail9:
-				pos = pos7
-				break
-			}
-			node, node5 = node+node5, ""
-		}
-		goto ok0
-	fail4:
-		node = node2
-		pos = pos3
-		// "/*" (!"*/" .)* "*/"
-		{
-			var node16 string
-			// "/*"
-			if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "/*" {
-				goto fail15
-			}
-			node16 = parser.text[pos : pos+2]
-			pos += 2
-			node, node16 = node+node16, ""
-			// (!"*/" .)*
-			for {
-				pos18 := pos
-				var node19 string
-				// (!"*/" .)
-				// !"*/" .
+				goto ok2
+			fail6:
+				label0 = node4
+				pos = pos5
+				// "/*" (!"*/" .)* "*/"
 				{
-					var node21 string
-					// !"*/"
-					{
-						pos23 := pos
-						// "*/"
-						if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "*/" {
-							goto ok22
+					var node18 string
+					// "/*"
+					if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "/*" {
+						goto fail17
+					}
+					node18 = parser.text[pos : pos+2]
+					pos += 2
+					label0, node18 = label0+node18, ""
+					// (!"*/" .)*
+					for {
+						pos20 := pos
+						var node21 string
+						// (!"*/" .)
+						// !"*/" .
+						{
+							var node23 string
+							// !"*/"
+							{
+								pos25 := pos
+								// "*/"
+								if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "*/" {
+									goto ok24
+								}
+								pos += 2
+								pos = pos25
+								goto fail22
+							ok24:
+								pos = pos25
+								node23 = ""
+							}
+							node21, node23 = node21+node23, ""
+							// .
+							if r, w := _next(parser, pos); w == 0 || r == '\uFFFD' {
+								goto fail22
+							} else {
+								node23 = parser.text[pos : pos+w]
+								pos += w
+							}
+							node21, node23 = node21+node23, ""
 						}
-						pos += 2
-						pos = pos23
-						goto fail20
-					ok22:
-						pos = pos23
-						node21 = ""
+						node18 += node21
+						continue
+					fail22:
+						pos = pos20
+						break
 					}
-					node19, node21 = node19+node21, ""
-					// .
-					if r, w := _next(parser, pos); w == 0 || r == '\uFFFD' {
-						goto fail20
-					} else {
-						node21 = parser.text[pos : pos+w]
-						pos += w
+					label0, node18 = label0+node18, ""
+					// "*/"
+					if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "*/" {
+						goto fail17
 					}
-					node19, node21 = node19+node21, ""
+					node18 = parser.text[pos : pos+2]
+					pos += 2
+					label0, node18 = label0+node18, ""
 				}
-				node16 += node19
-				continue
-			fail20:
-				pos = pos18
-				break
-			}
-			node, node16 = node+node16, ""
-			// "*/"
-			if len(parser.text[pos:]) < 2 || parser.text[pos:pos+2] != "*/" {
-				goto fail15
+				goto ok2
+			fail17:
+				label0 = node4
+				pos = pos5
+				goto fail
+			ok2:
 			}
-			node16 = parser.text[pos : pos+2]
-			pos += 2
-			node, node16 = node+node16, ""
+			labels[0] = parser.text[pos1:pos]
 		}
-		goto ok0
-	fail15:
-		node = node2
-		pos = pos3
-		goto fail
-	ok0:
+		node = func(
+			start, end int, text string) struct{} {
+			_p := parser.data.(*Parser)
+			_p.cmnts[start] = Comment{
+				Range: makeRange(parser, start, end),
+				Text:  text,
+			}
+			return struct{}{}
+		}(
+			start0, pos, label0)
 	}
 	parser.act[key] = node
 	return pos, &node
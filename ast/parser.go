@@ -6,6 +6,10 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/eaburns/pea/loc"
 	"github.com/eaburns/peggy/peg"
@@ -17,6 +21,9 @@ type Parser struct {
 	defs  []Def
 	mod   string
 	locs  *loc.Files
+	// cmnts are the comments of the file being parsed,
+	// keyed by their offset into the file.
+	cmnts map[int]Comment
 }
 
 // NewParser returns a new parser for the named module.
@@ -38,6 +45,11 @@ func (p *Parser) Mod() *Mod {
 
 // Parse parses a *File from an io.Reader.
 // The first argument is the file path or "" if unspecified.
+//
+// If there are syntax errors, the parser skips to the next definition
+// after each error, and all of the errors are returned.
+// The file is still added to the module
+// with the definitions that parsed successfully.
 func (p *Parser) Parse(path string, r io.Reader) error {
 	data, err := ioutil.ReadAll(r)
 	if err != nil {
@@ -45,19 +57,112 @@ func (p *Parser) Parse(path string, r io.Reader) error {
 	}
 	_p := _NewParser(string(data))
 	_p.data = p
-	if pos, perr := _FileAccepts(_p, 0); pos < 0 {
-		_, t := _FileFail(_p, 0, perr)
-		return parseError{path: path, loc: perr, text: _p.text, fail: t}
+	p.cmnts = make(map[int]Comment)
+	var file *File
+	var errs parseErrors
+	if pos, _ := _FileAccepts(_p, 0); pos < 0 {
+		file, errs = recoverFile(_p, path)
+	} else {
+		_, file = _FileAction(_p, 0)
 	}
-	_, file := _FileAction(_p, 0)
 	file.Path = path
+	file.Comments = comments(p.cmnts)
 	p.files = append(p.files, *file)
 	if p.locs != nil {
 		p.locs.Add(path, _p.text)
 	}
+	switch {
+	case len(errs) == 1:
+		return errs[0]
+	case len(errs) > 1:
+		return errs
+	}
 	return nil
 }
 
+// recoverFile parses a file that failed to parse
+// one import and definition at a time.
+// When a definition fails to parse,
+// its error is recorded, and parsing resumes
+// at the next line beginning with a definition keyword.
+func recoverFile(_p *_Parser, path string) (*File, parseErrors) {
+	var file File
+	var errs parseErrors
+	pos := 0
+	for {
+		dp, _ := _ImportAccepts(_p, pos)
+		if dp < 0 {
+			break
+		}
+		_, imp := _ImportAction(_p, pos)
+		file.Imports = append(file.Imports, *imp)
+		pos += dp
+	}
+	for {
+		ws, _ := __Accepts(_p, pos)
+		if pos+ws == len(_p.text) {
+			__Action(_p, pos)
+			break
+		}
+		dp, de := _DefAccepts(_p, pos)
+		if dp >= 0 {
+			_, def := _DefAction(_p, pos)
+			file.Defs = append(file.Defs, *def)
+			pos += dp
+			continue
+		}
+		perr := pos + de
+		_p.lastFail = perr
+		_, fail := _DefFail(_p, pos, perr)
+		errs = append(errs, parseError{path: path, loc: perr, text: _p.text, fail: fail})
+		pos = nextDef(_p.text, pos+ws)
+	}
+	return &file, errs
+}
+
+var defKeywords = []string{
+	"func", "Func",
+	"meth", "Meth",
+	"type", "Type",
+	"val", "Val",
+	"test",
+}
+
+// nextDef returns the offset of the next line after pos
+// that begins with a definition keyword,
+// or the length of the text if there is none.
+func nextDef(text string, pos int) int {
+	for {
+		i := strings.IndexByte(text[pos:], '\n')
+		if i < 0 {
+			return len(text)
+		}
+		pos += i + 1
+		for _, kw := range defKeywords {
+			if !strings.HasPrefix(text[pos:], kw) {
+				continue
+			}
+			r, _ := utf8.DecodeRuneInString(text[pos+len(kw):])
+			if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				return pos
+			}
+		}
+	}
+}
+
+func comments(cmnts map[int]Comment) []Comment {
+	offs := make([]int, 0, len(cmnts))
+	for off := range cmnts {
+		offs = append(offs, off)
+	}
+	sort.Ints(offs)
+	var cs []Comment
+	for _, off := range offs {
+		cs = append(cs, cmnts[off])
+	}
+	return cs
+}
+
 // ParseFile parses the source in the file specified by a path.
 func (p *Parser) ParseFile(path string) error {
 	f, err := os.Open(path)
@@ -77,8 +182,46 @@ type parseError struct {
 
 func (err parseError) Tree() *peg.Fail { return err.fail }
 
+// Loc returns the location of the error.
+// Unlike in the error message, columns count bytes,
+// as they do in the locations of AST nodes.
+func (err parseError) Loc() loc.Loc {
+	var files loc.Files
+	files.Add(err.path, err.text)
+	pos := peg.SimpleError(err.text, err.fail).Loc.Byte
+	return *files.Loc(loc.Range{pos, pos})
+}
+
+// Msg returns the error message without its location.
+func (err parseError) Msg() string {
+	return peg.SimpleError(err.text, err.fail).Message
+}
+
 func (err parseError) Error() string {
 	e := peg.SimpleError(err.text, err.fail)
 	e.FilePath = err.path
 	return e.Error()
 }
+
+// parseErrors are the errors of a file with more than one syntax error.
+type parseErrors []parseError
+
+// Errors returns the individual errors.
+func (errs parseErrors) Errors() []error {
+	es := make([]error, len(errs))
+	for i, err := range errs {
+		es[i] = err
+	}
+	return es
+}
+
+func (errs parseErrors) Error() string {
+	var s strings.Builder
+	for i, err := range errs {
+		if i > 0 {
+			s.WriteRune('\n')
+		}
+		s.WriteString(err.Error())
+	}
+	return s.String()
+}
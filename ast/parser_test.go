@@ -0,0 +1,74 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+package ast
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRecover(t *testing.T) {
+	tests := []struct {
+		src string
+		// errs are the locations of the expected errors.
+		errs []string
+		// defs are the selectors or names of the expected definitions.
+		defs []string
+	}{
+		{
+			src:  "func [f | 1]\nfunc [g | 2]",
+			defs: []string{"f", "g"},
+		},
+		{
+			src:  "func [f | 1 +]\nfunc [g | 2]",
+			errs: []string{"test.pea:1.14"},
+			defs: []string{"g"},
+		},
+		{
+			src:  "func [f | 1]\nfunc [g | )]\nType Pt {x: Int}\nfunc [h | ,]\ntest [t | 3]",
+			errs: []string{"test.pea:2.11", "test.pea:4.11"},
+			defs: []string{"f", "Pt", "t"},
+		},
+		{
+			src:  "import \"x\"\nfunc [f | 1]\nnonsense\nVal x := [5]\n",
+			errs: []string{"test.pea:3.1"},
+			defs: []string{"f", "x"},
+		},
+		{
+			src:  "func [f |\n\tx := := 1\n]\nfunc [g | 2]",
+			errs: []string{"test.pea:2.7"},
+			defs: []string{"g"},
+		},
+	}
+	for _, test := range tests {
+		p := NewParser("")
+		err := p.Parse("test.pea", strings.NewReader(test.src))
+		var errs []string
+		switch err := err.(type) {
+		case nil:
+		case interface{ Errors() []error }:
+			for _, e := range err.Errors() {
+				errs = append(errs, strings.SplitN(e.Error(), ": ", 2)[0])
+			}
+		default:
+			errs = append(errs, strings.SplitN(err.Error(), ": ", 2)[0])
+		}
+		if strings.Join(errs, ";") != strings.Join(test.errs, ";") {
+			t.Errorf("Parse(%q) errors=%v, want %v\n%v", test.src, errs, test.errs, err)
+		}
+		var defs []string
+		for _, def := range p.Mod().Files[0].Defs {
+			switch def := def.(type) {
+			case *Fun:
+				defs = append(defs, def.Sig.Sel)
+			case *Type:
+				defs = append(defs, def.Sig.Name)
+			case *Val:
+				defs = append(defs, def.Var.Name)
+			}
+		}
+		if strings.Join(defs, ";") != strings.Join(test.defs, ";") {
+			t.Errorf("Parse(%q) defs=%v, want %v", test.src, defs, test.defs)
+		}
+	}
+}
@@ -183,7 +183,7 @@ func buildTypeParms(parms []Var, s *strings.Builder) {
 
 func isOpType(s string) bool {
 	r, _ := utf8.DecodeRuneInString(s)
-	return unicode.IsPunct(r)
+	return r != '_' && unicode.IsPunct(r)
 }
 
 func buildTypeNameString(n *TypeName, s *strings.Builder) {
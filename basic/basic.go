@@ -6,10 +6,11 @@
 // to a high-level language like Go,
 // and being low-level enough to be easily convertable to LLVM.
 //
-// The representation of function bodies is a naive SSA form
-// which uses explicit allocations, loads, and stores for local variables.
-// A light optimization pass can conservatively lift locals to registers,
-// but only in cases where φ-nodes need not be inserted.
+// The representation of function bodies is an SSA form.
+// Function bodies are built using explicit allocations, loads, and stores
+// for local variables.
+// The optimization passes lift locals with simple types to registers,
+// inserting φ-nodes (Phis) where needed.
 //
 // Types
 //
@@ -86,6 +87,7 @@ import (
 	"math/big"
 	"strings"
 
+	"github.com/eaburns/pea/loc"
 	"github.com/eaburns/pea/types"
 )
 
@@ -99,8 +101,41 @@ type Mod struct {
 	Init  *Fun
 	Funs  []*Fun
 	NDefs int
+	// Inlines are the decisions made by Optimize
+	// whether to inline each Call, if ReportInlines is set.
+	// There is one Inline for each call site in the source of a Caller,
+	// with the last decision made for the site.
+	Inlines []Inline
+	// ReportInlines is whether Optimize records Inlines.
+	// It must be set before Optimize.
+	ReportInlines bool
+	// Checked is whether integer PlusOp, MinusOp, TimesOp,
+	// DivideOp, ModOp, and NumConvertOp Ops with a Msg
+	// panic if their result overflows or they divide by zero.
+	// It must be set before Optimize,
+	// which does not fold Ops that would panic.
+	Checked bool
+	// Debug is whether the Mod is built for debugging.
+	// It must be set before Optimize,
+	// which does not inline Calls or lift Allocs
+	// of a Debug Mod, so that its calls and variables
+	// remain visible to a debugger.
+	// As a result, some tail calls are not eliminated.
+	Debug bool
 
 	Mod *types.Mod
+
+	// inlineIndex is the index in Inlines of each reported call site.
+	inlineIndex map[inlineSite]int
+}
+
+// An inlineSite identifies a call site of an Inline:
+// the Caller and the Msg of the Call,
+// or the Call itself if it has no Msg.
+type inlineSite struct {
+	caller *Fun
+	msg    *types.Msg
+	call   *Call
 }
 
 // A String is the data of a string constant.
@@ -121,6 +156,19 @@ type Var struct {
 	Val *types.Val
 }
 
+// An Inline is a decision whether to inline a Call.
+type Inline struct {
+	// Caller is the Fun containing the Call.
+	Caller *Fun
+	// Call is the Call.
+	// If Inlined is true, it is no longer in Caller.
+	Call *Call
+	// Inlined is whether the Call was inlined.
+	Inlined bool
+	// Reason describes why the Call was or was not inlined.
+	Reason string
+}
+
 // A Fun is a code block.
 type Fun struct {
 	Mod *Mod
@@ -132,6 +180,9 @@ type Fun struct {
 	BBlks     []*BBlk
 	CanInline bool
 	CanFarRet bool
+	// Loc is the source location of the definition,
+	// or nil if it is unknown.
+	Loc *loc.Loc
 
 	Fun   *types.Fun
 	Block *types.Block
@@ -150,6 +201,11 @@ type Parm struct {
 	Value bool
 	// Self indicates that this is the self parameter of a method.
 	Self bool
+	// NoEscape indicates that the Fun does not retain the address
+	// passed for this reference parameter after it returns.
+	// It is computed by Optimize;
+	// false is always a safe, conservative value.
+	NoEscape bool
 
 	// Var is nil for the Ret parm or block literal self parm.
 	Var *types.Var
@@ -183,6 +239,8 @@ func (n *BBlk) addIn(in *BBlk) {
 	n.In = append(n.In, in)
 }
 
+// rmIn removes a BBlk from In
+// and removes its entry from each of the BBlk's Phis.
 func (n *BBlk) rmIn(in *BBlk) {
 	var i int
 	for _, b := range n.In {
@@ -192,11 +250,53 @@ func (n *BBlk) rmIn(in *BBlk) {
 		}
 	}
 	n.In = n.In[:i]
+	for _, phi := range n.phis() {
+		phi.rmIn(in)
+	}
+}
+
+// replaceIn replaces a BBlk in In with another,
+// including in the entries of the BBlk's Phis.
+func (n *BBlk) replaceIn(old, new *BBlk) {
+	for i, b := range n.In {
+		if b == old {
+			n.In[i] = new
+		}
+	}
+	for _, phi := range n.phis() {
+		for i, b := range phi.In {
+			if b == old {
+				phi.In[i] = new
+			}
+		}
+	}
+}
+
+// phis returns the Phis at the beginning of the BBlk.
+func (n *BBlk) phis() []*Phi {
+	var phis []*Phi
+	for _, s := range n.Stmts {
+		if s.deleted() {
+			continue
+		}
+		phi, ok := s.(*Phi)
+		if !ok {
+			break
+		}
+		phis = append(phis, phi)
+	}
+	return phis
 }
 
 // A Stmt is an instruction that does not produce a value.
 type Stmt interface {
 	Uses() []Val
+	// Loc returns the source location of the statement,
+	// or nil if it is unknown.
+	// Statements added by optimization passes
+	// may have unknown locations.
+	Loc() *loc.Loc
+	setLoc(*loc.Loc)
 	buildString(*strings.Builder) *strings.Builder
 
 	// delete marks the statement as deleted.
@@ -236,12 +336,15 @@ type Stmt interface {
 
 type stmt struct {
 	del bool
+	loc *loc.Loc
 }
 
-func (*stmt) Uses() []Val     { return nil }
-func (s *stmt) delete()       { s.del = true }
-func (s *stmt) deleted() bool { return s.del }
-func (*stmt) bugs() string    { return "" }
+func (*stmt) Uses() []Val         { return nil }
+func (s *stmt) Loc() *loc.Loc     { return s.loc }
+func (s *stmt) setLoc(l *loc.Loc) { s.loc = l }
+func (s *stmt) delete()           { s.del = true }
+func (s *stmt) deleted() bool     { return s.del }
+func (*stmt) bugs() string        { return "" }
 
 // A Comment is a no-op statement that adds a note to the output.
 type Comment struct {
@@ -630,6 +733,67 @@ type Load struct {
 
 func (n *Load) Uses() []Val { return []Val{n.Src} }
 
+// Phi is a φ-node: a value that depends on
+// the BBlk from which control entered the BBlk containing the Phi.
+// Phis are only at the beginning of a BBlk, before any other Stmts,
+// and there is exactly one entry for each BBlk in the containing BBlk.In.
+// The type of a Phi is always a simple type.
+type Phi struct {
+	val
+	// In and Args are parallel slices.
+	// If control entered from In[i], the value of the Phi is Args[i].
+	In   []*BBlk
+	Args []Val
+
+	// Var is the variable of the Alloc lifted into the Phi,
+	// or nil if the Phi did not originate from a variable.
+	Var *types.Var
+}
+
+func (n *Phi) Uses() []Val { return n.Args }
+
+// arg returns the value of the Phi when control enters from the BBlk,
+// or nil if there is no entry for the BBlk.
+func (n *Phi) arg(in *BBlk) Val {
+	for i, b := range n.In {
+		if b == in {
+			return n.Args[i]
+		}
+	}
+	return nil
+}
+
+// addIn adds an entry for a BBlk and updates the users of the value.
+func (n *Phi) addIn(in *BBlk, v Val) {
+	n.In = append(n.In, in)
+	n.Args = append(n.Args, v)
+	v.value().addUser(n)
+}
+
+// rmIn removes the entry for a BBlk and updates the users of its value.
+func (n *Phi) rmIn(in *BBlk) {
+	var i int
+	var rm Val
+	for j, b := range n.In {
+		if b == in {
+			rm = n.Args[j]
+			continue
+		}
+		n.In[i], n.Args[i] = b, n.Args[j]
+		i++
+	}
+	n.In, n.Args = n.In[:i], n.Args[:i]
+	if rm == nil {
+		return
+	}
+	for _, a := range n.Args {
+		if a == rm {
+			return
+		}
+	}
+	rm.value().rmUser(n)
+}
+
 // Alloc is an address of a newly allocated location of a given type.
 type Alloc struct {
 	val
@@ -688,8 +852,8 @@ func (n *Field) Uses() []Val { return []Val{n.Obj} }
 // EmptyType returns whether the type has zero-size.
 func EmptyType(typ *types.Type) bool {
 	if len(typ.Fields) > 0 {
-		for _, f := range typ.Fields {
-			if !EmptyType(f.Type()) {
+		for i := range typ.Fields {
+			if !EmptyType(varType(&typ.Fields[i])) {
 				return false
 			}
 		}
@@ -717,19 +881,30 @@ func pointerType(typ *types.Type) bool {
 	if len(typ.Cases) != 2 {
 		return false
 	}
-	return typ.Cases[0].Type() == nil && typ.Cases[1].Type() != nil ||
-		typ.Cases[0].Type() != nil && typ.Cases[1].Type() == nil
+	return varType(&typ.Cases[0]) == nil && varType(&typ.Cases[1]) != nil ||
+		varType(&typ.Cases[0]) != nil && varType(&typ.Cases[1]) == nil
 }
 
 func enumType(typ *types.Type) bool {
-	for _, c := range typ.Cases {
-		if c.Type() != nil {
+	for i := range typ.Cases {
+		if varType(&typ.Cases[i]) != nil {
 			return false
 		}
 	}
 	return len(typ.Cases) > 0
 }
 
+// varType returns the type of a field, case, or parameter.
+// The type checker sets the type of a Var,
+// but Vars of stub types, like those made by Parse,
+// only have the type of their TypeName.
+func varType(v *types.Var) *types.Type {
+	if typ := v.Type(); typ != nil || v.TypeName == nil {
+		return typ
+	}
+	return v.TypeName.Type
+}
+
 func enumTag(cas *types.Var) *big.Int {
 	orTyp := cas.Case
 	for i := range orTyp.Cases {
@@ -83,12 +83,14 @@ func (n *MakeSlice) bugs() (b string) {
 	defer recoverBug(&b)
 	bugIf(!isRefType(n.Dst),
 		"make slice to non-reference type %s", n.Dst.Type())
-	bugIf(refElemType(n.Dst).BuiltIn != types.ArrayType,
+	bugIf(refElemType(n.Dst).BuiltIn != types.ArrayType &&
+		refElemType(n.Dst).BuiltIn != types.StringType,
 		"make slice to non-array-reference type %s", refElemType(n.Dst))
 	bugIf(!isRefType(n.Ary),
 		"make slice from non-reference type %s", n.Ary.Type())
-	bugIf(refElemType(n.Ary).BuiltIn != types.ArrayType,
-		"make slice from non-array-reference type %s", refElemType(n.Ary))
+	bugIf(n.Dst.Type() != n.Ary.Type(),
+		"make slice type mismatch: dst %s != ary %s",
+		refElemType(n.Dst), refElemType(n.Ary))
 	bugIf(n.From.Type().BuiltIn != types.IntType,
 		"make slice non-Int start type %s", n.From.Type())
 	bugIf(n.To.Type().BuiltIn != types.IntType,
@@ -112,29 +114,32 @@ func (n *MakeAnd) bugs() (b string) {
 	andType := refElemType(n.Dst)
 	for i := range andType.Fields {
 		field := &andType.Fields[i]
-		bugIf(i >= len(n.Fields), "make and too few args")
-		arg := n.Fields[i]
+		var arg Val
+		if i < len(n.Fields) {
+			arg = n.Fields[i]
+		}
 		if arg == nil {
-			bugIf(!EmptyType(field.Type()) &&
-				// For block literals, we elide empty-type captures.
-				// But captures always have one extra level of &,
+			bugIf(!EmptyType(varType(field)) &&
+				// For block literals, we elide empty-type captures
+				// and the far-return location of an empty return type.
+				// But these always have one extra level of &,
 				// so we have to account for that in this check.
 				(andType.BuiltIn != types.BlockType ||
-					field.Type().BuiltIn != types.RefType ||
-					!EmptyType(field.Type().Args[0].Type)),
+					varType(field).BuiltIn != types.RefType ||
+					!EmptyType(varType(field).Args[0].Type)),
 				"make and field %d type mismatch: got nil, want %s",
-				i, field.Type())
+				i, varType(field))
 			continue
 		}
-		bugIf(EmptyType(field.Type()) && arg != nil,
+		bugIf(EmptyType(varType(field)) && arg != nil,
 			"make and field %d type mismatch: got %s, want nil",
 			i, arg.Type())
-		bugIf(SimpleType(field.Type()) && field.Type() != arg.Type(),
+		bugIf(SimpleType(varType(field)) && varType(field) != arg.Type(),
 			"make and field %d type mismatch: got %s, want %s",
-			i, arg.Type(), field.Type())
-		bugIf(!SimpleType(field.Type()) && field.Type().Ref() != arg.Type(),
+			i, arg.Type(), varType(field))
+		bugIf(!SimpleType(varType(field)) && varType(field).Ref() != arg.Type(),
 			"make and field %d type mismatch: got %s, want %s",
-			i, arg.Type(), field.Type().Ref())
+			i, arg.Type(), varType(field).Ref())
 	}
 	return ""
 }
@@ -147,21 +152,21 @@ func (n *MakeOr) bugs() (b string) {
 	bugIf(len(orType.Cases) <= n.Case,
 		"make or tag: %d, but only %d cases", n.Case, len(orType.Cases))
 	c := &orType.Cases[n.Case]
-	bugIf(c.TypeName != nil && !EmptyType(c.Type()) && n.Val == nil,
-		"make or type mismatch: got nil, want %s", c.Type())
+	bugIf(c.TypeName != nil && !EmptyType(varType(c)) && n.Val == nil,
+		"make or type mismatch: got nil, want %s", varType(c))
 	if n.Val == nil {
 		return ""
 	}
 	bugIf(c.TypeName == nil,
 		"make or type mismatch: got %s, want nil", n.Val.Type())
 	bugIf(c.TypeName != nil &&
-		SimpleType(c.Type()) &&
-		c.Type() != n.Val.Type(),
-		"make or type mismatch: got %s, want %s", n.Val.Type(), c.Type())
+		SimpleType(varType(c)) &&
+		varType(c) != n.Val.Type(),
+		"make or type mismatch: got %s, want %s", n.Val.Type(), varType(c))
 	bugIf(c.TypeName != nil &&
-		!SimpleType(c.Type()) &&
-		c.Type().Ref() != n.Val.Type(),
-		"make or type mismatch: got %s, want %s", n.Val.Type().Ref(), c.Type())
+		!SimpleType(varType(c)) &&
+		varType(c).Ref() != n.Val.Type(),
+		"make or type mismatch: got %s, want %s", n.Val.Type().Ref(), varType(c))
 	return ""
 }
 
@@ -212,17 +217,25 @@ func (n *VirtCall) bugs() (b string) {
 		// strip return value location
 		checkArgs = checkArgs[:len(checkArgs)-1]
 	}
-	bugIf(len(checkArgs) != len(virt.Parms),
+	// Empty-type arguments are elided.
+	var wantTypes []*types.Type
+	for i := range virt.Parms {
+		typ := varType(&virt.Parms[i])
+		switch {
+		case EmptyType(typ):
+			continue
+		case !SimpleType(typ):
+			typ = typ.Ref()
+		}
+		wantTypes = append(wantTypes, typ)
+	}
+	bugIf(len(checkArgs) != len(wantTypes),
 		"virtual call argument count mismatch: got %d, want %d",
-		len(checkArgs), len(virt.Parms))
+		len(checkArgs), len(wantTypes))
 	for i, a := range checkArgs {
-		wantType := virt.Parms[i].Type()
-		if !SimpleType(wantType) {
-			wantType = wantType.Ref()
-		}
-		bugIf(a.Type() != wantType,
+		bugIf(a.Type() != wantTypes[i],
 			"argument %d type mismatch: got %s, want %s",
-			i, a.Type(), wantType)
+			i, a.Type(), wantTypes[i])
 	}
 	return ""
 }
@@ -265,6 +278,19 @@ func (n *Load) bugs() (b string) {
 	return ""
 }
 
+func (n *Phi) bugs() (b string) {
+	defer recoverBug(&b)
+	bugIf(len(n.In) != len(n.Args),
+		"phi has %d BBlks and %d args", len(n.In), len(n.Args))
+	bugIf(!SimpleType(n.Type()),
+		"phi of a composite type %s", n.Type())
+	for i, arg := range n.Args {
+		bugIf(arg.Type() != n.Type(),
+			"phi arg %d type mismatch: %s != %s", i, arg.Type(), n.Type())
+	}
+	return ""
+}
+
 func (n *Index) bugs() (b string) {
 	defer recoverBug(&b)
 	bugIf(!isRefType(n.Ary),
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math/big"
 
+	"github.com/eaburns/pea/loc"
 	"github.com/eaburns/pea/types"
 )
 
@@ -176,6 +177,7 @@ func newFun(mod *Mod, hasSelf bool, parms []types.Var, ret *types.Type) *Fun {
 }
 
 func buildFunBody(f *Fun, parms []*Parm, locals []*types.Var, stmts []types.Stmt) {
+	f.Loc = funLoc(f)
 	b0 := newBBlk(f)
 	parmAllocs := make([]*Alloc, 0, len(parms))
 	for _, parm := range parms {
@@ -207,6 +209,73 @@ func buildFunBody(f *Fun, parms []*Parm, locals []*types.Var, stmts []types.Stmt
 	b1 := newBBlk(f)
 	buildStmts(f, b1, stmts)
 	addJmp(b0, b1)
+	// Statements not built for any particular types.Node,
+	// like the parameter and local Allocs,
+	// have the location of the Fun itself.
+	setLocs(f, stmtMark{b: b0}, f.Loc)
+}
+
+// funLoc returns the source location of a Fun's definition,
+// or nil if it is unknown.
+func funLoc(f *Fun) *loc.Loc {
+	switch {
+	case f.Block != nil:
+		return nodeLoc(f, f.Block)
+	case f.Fun != nil:
+		return nodeLoc(f, f.Fun)
+	case f.Val != nil:
+		return nodeLoc(f, f.Val)
+	default:
+		return nil
+	}
+}
+
+// nodeLoc returns the source location of the start of a types.Node,
+// or nil if it is unknown.
+// The end is not used, since a node at the end of a file
+// may end at the start of the next file.
+func nodeLoc(f *Fun, node types.Node) *loc.Loc {
+	n := types.NodeAST(node)
+	if n == nil || f.Mod.Mod.AST == nil || f.Mod.Mod.AST.Locs == nil {
+		return nil
+	}
+	r := n.GetRange()
+	return f.Mod.Mod.AST.Locs.Loc(loc.Range{r[0], r[0]})
+}
+
+// A stmtMark marks the Stmts of a Fun that are built so far:
+// those before the nth Stmt of a BBlk,
+// and those in the Fun's first nblks BBlks.
+type stmtMark struct {
+	b     *BBlk
+	n     int
+	nblks int
+}
+
+func markStmts(f *Fun, b *BBlk) stmtMark {
+	return stmtMark{b: b, n: len(b.Stmts), nblks: len(f.BBlks)}
+}
+
+// setLocs sets the location of the Stmts built since a stmtMark
+// that do not already have a location.
+// Nested types.Nodes are built, and their locations set, first,
+// so each Stmt has the location of the innermost Node that built it.
+func setLocs(f *Fun, m stmtMark, l *loc.Loc) {
+	if l == nil {
+		return
+	}
+	setStmtLocs(m.b.Stmts[m.n:], l)
+	for _, b := range f.BBlks[m.nblks:] {
+		setStmtLocs(b.Stmts, l)
+	}
+}
+
+func setStmtLocs(stmts []Stmt, l *loc.Loc) {
+	for _, s := range stmts {
+		if s.Loc() == nil {
+			s.setLoc(l)
+		}
+	}
 }
 
 func newBBlk(fun *Fun) *BBlk {
@@ -217,6 +286,7 @@ func newBBlk(fun *Fun) *BBlk {
 
 func buildStmts(f *Fun, b *BBlk, stmts []types.Stmt) *BBlk {
 	for i, stmt := range stmts {
+		m := markStmts(f, b)
 		addComment(b, "%T", stmt)
 
 		switch stmt := stmt.(type) {
@@ -238,6 +308,7 @@ func buildStmts(f *Fun, b *BBlk, stmts []types.Stmt) *BBlk {
 		default:
 			panic(fmt.Sprintf("impossible: %T", stmt))
 		}
+		setLocs(f, m, nodeLoc(f, stmt))
 	}
 	if n := len(b.Stmts); n == 0 || !isTerm(b.Stmts[n-1]) {
 		addRet(b)
@@ -315,6 +386,8 @@ func buildAssign(f *Fun, b *BBlk, typesAssign *types.Assign) *BBlk {
 // buildExpr builds the expression and returns its value and the new current BBlk.
 // The returned Val is nil if the expression resulted in an EmptyType value.
 func buildExpr(f *Fun, b *BBlk, expr types.Expr) (Val, *BBlk) {
+	m := markStmts(f, b)
+	defer func() { setLocs(f, m, nodeLoc(f, expr)) }()
 	switch expr := expr.(type) {
 	case *types.Call:
 		return buildCall(f, b, expr)
@@ -379,6 +452,7 @@ func buildCall(f *Fun, b *BBlk, call *types.Call) (Val, *BBlk) {
 
 	var val Val
 	for i := range call.Msgs {
+		m := markStmts(f, b)
 		switch msg := &call.Msgs[i]; {
 		case builtInMethOp[msg.Fun.BuiltIn] > 0:
 			val, b = buildOp(f, b, recv, msg)
@@ -399,6 +473,7 @@ func buildCall(f *Fun, b *BBlk, call *types.Call) (Val, *BBlk) {
 		default:
 			val, b = buildMsg(f, b, recv, msg)
 		}
+		setLocs(f, m, nodeLoc(f, &call.Msgs[i]))
 	}
 	return val, b
 }
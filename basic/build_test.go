@@ -196,11 +196,11 @@ func TestBuild(t *testing.T) {
 			want: `
 				function0
 					parms:
-						0 [i] Int
+						0 [i] Int (alloc $0)
 						1 Int&
 					0:
 						[in:] [out: 1]
-						$0 := alloc(Int)
+						$0 := alloc(Int) [i]
 						$1 := arg(0 [i])
 						store($0, $1)
 						jmp 1
@@ -247,7 +247,7 @@ func TestBuild(t *testing.T) {
 						[in:] [out: 1]
 						// We allocate a Nil, in case we need its address.
 						// In this case we don't; the opt pass will remove it.
-						$0 := alloc(Nil)
+						$0 := alloc(Nil) [n]
 						jmp 1
 					1:
 						[in: 0] [out:]
@@ -266,7 +266,7 @@ func TestBuild(t *testing.T) {
 						0 Int&
 					0:
 						[in:] [out: 1]
-						$0 := alloc(Int)
+						$0 := alloc(Int) [i]
 						jmp 1
 					1:
 						[in: 0] [out:]
@@ -290,7 +290,7 @@ func TestBuild(t *testing.T) {
 						0 String&
 					0:
 						[in:] [out: 1]
-						$0 := alloc(String)
+						$0 := alloc(String) [s]
 						$1 := alloc(String)
 						jmp 1
 					1:
@@ -312,11 +312,11 @@ func TestBuild(t *testing.T) {
 			want: `
 				function0
 					parms:
-						0 [self] #test Foo&
+						0 [self] #test Foo& (alloc $0)
 						1 Int&
 					0:
 						[in:] [out: 1]
-						$0 := alloc(#test Foo&)
+						$0 := alloc(#test Foo&) [self]
 						$1 := arg(0 [self])
 						store($0, $1)
 						jmp 1
@@ -340,11 +340,11 @@ func TestBuild(t *testing.T) {
 			want: `
 				function0
 					parms:
-						0 [self] #test Foo&
+						0 [self] #test Foo& (alloc $0)
 						1 String&
 					0:
 						[in:] [out: 1]
-						$0 := alloc(#test Foo&)
+						$0 := alloc(#test Foo&) [self]
 						$1 := arg(0 [self])
 						store($0, $1)
 						jmp 1
@@ -369,7 +369,7 @@ func TestBuild(t *testing.T) {
 						0 Int&
 					0:
 						[in:] [out: 1]
-						$0 := alloc(Int)
+						$0 := alloc(Int) [x]
 						jmp 1
 					1:
 						[in: 0] [out:]
@@ -537,7 +537,7 @@ func TestBuild(t *testing.T) {
 					parms:
 					0:
 						[in:] [out: 1]
-						$0 := alloc(Nil)
+						$0 := alloc(Nil) [n]
 						jmp 1
 					1:
 						[in: 0] [out:]
@@ -555,10 +555,10 @@ func TestBuild(t *testing.T) {
 			want: `
 				function0
 					parms:
-						0 [s] String&
+						0 [s] String& (alloc $0)
 					0:
 						[in:] [out: 1]
-						$0 := alloc(String&)
+						$0 := alloc(String&) [s]
 						$1 := arg(0 [s])
 						store($0, $1)
 						$3 := alloc(String)
@@ -581,10 +581,10 @@ func TestBuild(t *testing.T) {
 			want: `
 				function0
 					parms:
-						0 [s] String&
+						0 [s] String& (alloc $0)
 					0:
 						[in:] [out: 1]
-						$0 := alloc(String&)
+						$0 := alloc(String&) [s]
 						$1 := arg(0 [s])
 						store($0, $1)
 						jmp 1
@@ -743,11 +743,11 @@ func TestBuild(t *testing.T) {
 			want: `
 				function0
 					parms:
-						0 [i] Int
+						0 [i] Int (alloc $0)
 						1 Int&
 					0:
 						[in:] [out: 1]
-						$0 := alloc(Int)
+						$0 := alloc(Int) [i]
 						$1 := arg(0 [i])
 						store($0, $1)
 						jmp 1
@@ -770,11 +770,11 @@ func TestBuild(t *testing.T) {
 			want: `
 				function0
 					parms:
-						0 [i] Int
+						0 [i] Int (alloc $0)
 						1 Int&
 					0:
 						[in:] [out: 1]
-						$0 := alloc(Int)
+						$0 := alloc(Int) [i]
 						$1 := arg(0 [i])
 						store($0, $1)
 						jmp 1
@@ -796,11 +796,11 @@ func TestBuild(t *testing.T) {
 			want: `
 				function0
 					parms:
-						0 [i] Int
+						0 [i] Int (alloc $0)
 						1 Float&
 					0:
 						[in:] [out: 1]
-						$0 := alloc(Int)
+						$0 := alloc(Int) [i]
 						$1 := arg(0 [i])
 						store($0, $1)
 						jmp 1
@@ -1413,7 +1413,7 @@ func TestBuild(t *testing.T) {
 					parms:
 					0:
 						[in:] [out: 1]
-						$0 := alloc(Int #test ?)
+						$0 := alloc(Int #test ?) [intOpt]
 						$1 := alloc(Int #test ?)
 						$2 := alloc(#test $Block0)
 						$3 := alloc(Nil Fun)
@@ -1461,7 +1461,7 @@ func TestBuild(t *testing.T) {
 						0 Int&
 					0:
 						[in:] [out: 1]
-						$0 := alloc(Int #test ?)
+						$0 := alloc(Int #test ?) [intOpt]
 						$1 := alloc(Int #test ?)
 						$3 := alloc(#test $Block0)
 						$4 := alloc(Int Fun)
@@ -1516,7 +1516,7 @@ func TestBuild(t *testing.T) {
 						0 String&
 					0:
 						[in:] [out: 1]
-						$0 := alloc(Int #test ?)
+						$0 := alloc(Int #test ?) [intOpt]
 						$1 := alloc(Int #test ?)
 						$3 := alloc(#test $Block0)
 						$4 := alloc(String Fun)
@@ -1763,11 +1763,11 @@ func TestBuild(t *testing.T) {
 						far return
 				function0
 					parms:
-						0 [i] Int
+						0 [i] Int (alloc $0)
 						1 Int&
 					0:
 						[in:] [out: 1]
-						$0 := alloc(Int)
+						$0 := alloc(Int) [i]
 						$1 := arg(0 [i])
 						store($0, $1)
 						$3 := alloc(#test $Block0)
@@ -1821,7 +1821,7 @@ func TestBuild(t *testing.T) {
 						0 Int&
 					0:
 						[in:] [out: 1]
-						$0 := alloc(Int)
+						$0 := alloc(Int) [i]
 						$3 := alloc(#test $Block0)
 						$4 := alloc(Nil Fun)
 						jmp 1
@@ -1873,11 +1873,11 @@ func TestBuild(t *testing.T) {
 						far return
 				function0
 					parms:
-						0 [self] #test Point&
+						0 [self] #test Point& (alloc $0)
 						1 Int&
 					0:
 						[in:] [out: 1]
-						$0 := alloc(#test Point&)
+						$0 := alloc(#test Point&) [self]
 						$1 := arg(0 [self])
 						store($0, $1)
 						$5 := alloc(#test $Block0)
@@ -1931,12 +1931,12 @@ func TestBuild(t *testing.T) {
 				block1
 					parms:
 						0 #test $Block1&
-						1 [i] Int
+						1 [i] Int (alloc $1)
 						2 Nil Fun&
 					0:
 						[in:] [out: 1]
 						$0 := alloc(#test $Block1&)
-						$1 := alloc(Int)
+						$1 := alloc(Int) [i]
 						$2 := arg(0)
 						store($0, $2)
 						$3 := arg(1 [i])
@@ -2032,11 +2032,11 @@ func TestBuild(t *testing.T) {
 						return
 				function0
 					parms:
-						0 [i] Int
+						0 [i] Int (alloc $0)
 						1 Int&
 					0:
 						[in:] [out: 1]
-						$0 := alloc(Int)
+						$0 := alloc(Int) [i]
 						$1 := arg(0 [i])
 						store($0, $1)
 						$3 := alloc(#test $Block1)
@@ -2118,7 +2118,7 @@ func TestBuild(t *testing.T) {
 					parms:
 					0:
 						[in:] [out: 1]
-						$0 := alloc(Bool)
+						$0 := alloc(Bool) [b]
 						$1 := alloc(Bool)
 						$4 := alloc(#test $Block0)
 						$5 := alloc(Nil Fun)
@@ -2162,7 +2162,7 @@ func TestBuild(t *testing.T) {
 					parms:
 					0:
 						[in:] [out: 1]
-						$0 := alloc(#test Num)
+						$0 := alloc(#test Num) [n]
 						$1 := alloc(#test Num)
 						$4 := alloc(#test $Block0)
 						$5 := alloc(Nil Fun)
@@ -2512,8 +2512,8 @@ func TestBuild(t *testing.T) {
 					parms:
 					0:
 						[in:] [out: 1]
-						$0 := alloc(#test Empty)
-						$1 := alloc(#test Fooer)
+						$0 := alloc(#test Empty) [e]
+						$1 := alloc(#test Fooer) [_]
 						$2 := alloc(#test Fooer)
 						jmp 1
 					1:
@@ -2664,3 +2664,45 @@ func TestHasFarRet(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildLocs(t *testing.T) {
+	const src = `
+		func [foo: x Int ^Int |		// 2
+			y := x + 1.				// 3
+			^y * (bar: y)			// 4
+		]
+		func [bar: x Int ^Int | ^x]
+	`
+	p := ast.NewParser("#test")
+	if err := p.Parse("test.pea", strings.NewReader(src)); err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	typesMod, errs := types.Check(p.Mod(), types.Config{})
+	if len(errs) > 0 {
+		t.Fatalf("failed to check: %v", errs)
+	}
+	basicMod := Build(typesMod)
+	foo := findTestFun(basicMod, "function0")
+	if foo.Loc == nil || foo.Loc.Path != "test.pea" || foo.Loc.Line[0] != 2 {
+		t.Errorf("foo: Loc is %v, want test.pea:2", foo.Loc)
+	}
+	var got []string
+	for _, b := range foo.BBlks {
+		for _, s := range b.Stmts {
+			if s.Loc() == nil {
+				t.Errorf("%s has no location", s)
+				continue
+			}
+			switch s := s.(type) {
+			case *Op:
+				got = append(got, fmt.Sprintf("%s %d", opString[s.Code], s.Loc().Line[0]))
+			case *Call:
+				got = append(got, fmt.Sprintf("%s %d", s.Fun.Fun.Sig.Sel, s.Loc().Line[0]))
+			}
+		}
+	}
+	want := []string{"+ 3", "bar: 4", "* 4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -11,6 +11,7 @@ again:
 	propagateDeletes(f)
 	rmDeletes(f)
 	renumber(f)
+	rmTrivialPhis(f)
 	collapseChains(f)
 	if deleteEmptyBBlks(f) {
 		// We marked more statements as deleted after rmDeletes.
@@ -86,6 +87,15 @@ func unused(v Val) bool {
 	// Initialization of Allocs is not visible outside the function.
 	// So they can be remove if their only uses are initializations.
 	// Other Vals can only be removed if they have no uses whatsoever.
+	if phi, ok := v.(*Phi); ok {
+		// A Phi in a loop can use itself.
+		for _, u := range phi.Users() {
+			if u != phi {
+				return false
+			}
+		}
+		return true
+	}
 	alloc, ok := v.(*Alloc)
 	if !ok {
 		return len(v.Users()) == 0
@@ -100,9 +110,10 @@ func unused(v Val) bool {
 
 func deleteEmptyBBlks(f *Fun) bool {
 	changed := false
+	forwardToPhis(f)
 	sub := makeBBlkMap(len(f.BBlks))
 	for _, b := range f.BBlks {
-		if len(b.Stmts) == 1 && len(b.Out()) == 1 {
+		if len(b.Stmts) == 1 && len(b.Out()) == 1 && len(b.Out()[0].phis()) == 0 {
 			sub.add(b, b.Out()[0])
 		}
 	}
@@ -122,6 +133,108 @@ func deleteEmptyBBlks(f *Fun) bool {
 	return changed
 }
 
+// forwardToPhis redirects the In BBlks of empty BBlks
+// that jump to a BBlk with Phis directly to that BBlk,
+// adding the Phi entries for the redirected BBlks.
+// The empty BBlks are left with no In.
+//
+// An empty BBlk is not forwarded if one of its In
+// already jumps to the destination,
+// since a Phi has only one entry for each In BBlk.
+func forwardToPhis(f *Fun) {
+	for changed := true; changed; {
+		changed = false
+		for i, b := range f.BBlks {
+			if i == 0 || len(b.Stmts) != 1 || len(b.Out()) != 1 || len(b.In) == 0 {
+				continue
+			}
+			o := b.Out()[0]
+			phis := o.phis()
+			if o == b || len(phis) == 0 || sharesIn(b, o) {
+				continue
+			}
+			sub := makeBBlkMap(len(f.BBlks))
+			sub.add(b, o)
+			for _, p := range b.In {
+				for _, phi := range phis {
+					phi.addIn(p, phi.arg(b))
+				}
+				p.Stmts[len(p.Stmts)-1].(Term).subBBlk(sub)
+				o.addIn(p)
+			}
+			b.In = nil
+			o.rmIn(b)
+			changed = true
+		}
+	}
+}
+
+func sharesIn(b, o *BBlk) bool {
+	for _, in := range b.In {
+		if containsBBlk(o.In, in) {
+			return true
+		}
+	}
+	return false
+}
+
+// rmTrivialPhis removes Phis for which every entry,
+// besides those of the Phi itself, is the same value,
+// substituting the value for the Phi.
+// This includes all Phis of BBlks with a single In.
+func rmTrivialPhis(f *Fun) {
+	valMap := makeValMap(f.NVals)
+	var n int
+	for changed := true; changed; {
+		changed = false
+		for _, b := range f.BBlks {
+			for _, phi := range b.phis() {
+				v := trivialPhiVal(phi, valMap)
+				if v == nil {
+					continue
+				}
+				for _, arg := range phi.Args {
+					arg.value().rmUser(phi)
+				}
+				phi.delete()
+				valMap.add(phi, v)
+				changed = true
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return
+	}
+	for _, b := range f.BBlks {
+		var i int
+		for _, s := range b.Stmts {
+			if !s.deleted() {
+				b.Stmts[i] = s
+				i++
+			}
+		}
+		b.Stmts = b.Stmts[:i]
+	}
+	subVals(f.BBlks, valMap)
+}
+
+func trivialPhiVal(phi *Phi, valMap valMap) Val {
+	var v Val
+	for _, arg := range phi.Args {
+		arg = valMap.get(arg)
+		switch {
+		case arg == phi:
+			continue
+		case v == nil:
+			v = arg
+		case v != arg:
+			return nil
+		}
+	}
+	return v
+}
+
 func rmDeletes(f *Fun) bool {
 	changed := false
 	var bi int
@@ -157,8 +270,7 @@ func collapseChains(f *Fun) {
 			o := b.Out()[0]
 			b.Stmts = append(b.Stmts[:len(b.Stmts)-1], o.Stmts...)
 			for _, oo := range o.Out() {
-				oo.rmIn(o)
-				oo.addIn(b)
+				oo.replaceIn(o, b)
 			}
 			// Setting o.Stmts=nil marks it as deleted on the next iteration.
 			o.Stmts = nil
@@ -185,5 +297,19 @@ func renumber(f *Fun) {
 	f.NVals = iv
 	for _, b := range f.BBlks {
 		sort.Slice(b.In, func(i, j int) bool { return b.In[i].N < b.In[j].N })
+		for _, phi := range b.phis() {
+			sort.Sort(phiEntries{phi})
+		}
 	}
 }
+
+// phiEntries sorts the entries of a Phi by BBlk number.
+type phiEntries struct{ *Phi }
+
+func (p phiEntries) Len() int           { return len(p.In) }
+func (p phiEntries) Less(i, j int) bool { return p.In[i].N < p.In[j].N }
+
+func (p phiEntries) Swap(i, j int) {
+	p.In[i], p.In[j] = p.In[j], p.In[i]
+	p.Args[i], p.Args[j] = p.Args[j], p.Args[i]
+}
@@ -46,6 +46,11 @@ func copyBBlks(bs0 []*BBlk, nval int) []*BBlk {
 		for _, o := range term.Out() {
 			o.addIn(b1)
 		}
+		for _, phi := range b1.phis() {
+			for i := range phi.In {
+				phi.In[i] = bblkMap.get(phi.In[i])
+			}
+		}
 	}
 	return bs1
 }
@@ -116,6 +121,13 @@ func (n Load) shallowCopy() Stmt {
 	return &n
 }
 
+func (n Phi) shallowCopy() Stmt {
+	n.copyUsers()
+	n.In = append([]*BBlk{}, n.In...)
+	n.Args = append([]Val{}, n.Args...)
+	return &n
+}
+
 func (n Alloc) shallowCopy() Stmt {
 	n.copyUsers()
 	return &n
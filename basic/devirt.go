@@ -0,0 +1,133 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+package basic
+
+// devirtualize replaces each VirtCall
+// with a receiver initialized by a visible MakeVirt
+// with a Call to the corresponding Fun of the MakeVirt.
+//
+// VirtCalls to block literals are not replaced;
+// they are inlined by inlineBlockLits.
+func devirtualize(f *Fun) bool {
+	var n int
+	for _, b := range f.BBlks {
+		for i := 0; i < len(b.Stmts); i++ {
+			vc, ok := b.Stmts[i].(*VirtCall)
+			if !ok || vc.deleted() || vc.Index < 0 {
+				continue
+			}
+			mv := virtInit(vc)
+			if mv == nil {
+				continue
+			}
+			obj, pre := devirtObj(f, b, i, mv)
+			if obj == nil && mv.Obj != nil {
+				continue
+			}
+			n++
+			var args []Val
+			if obj != nil {
+				args = append(args, obj)
+			}
+			args = append(args, vc.Args[1:]...)
+			call := &Call{Fun: mv.Virts[vc.Index], Args: args, Msg: vc.Msg}
+			for _, arg := range vc.Args {
+				arg.value().rmUser(vc)
+			}
+			for _, arg := range args {
+				arg.value().addUser(call)
+			}
+			b.Stmts[i] = call
+			if pre != nil {
+				b.Stmts = append(b.Stmts[:i], append([]Stmt{pre}, b.Stmts[i:]...)...)
+				i++
+			}
+		}
+	}
+	return n > 0
+}
+
+// virtInit returns the MakeVirt initializing the receiver of the VirtCall,
+// following chains of single-store Copys.
+// It returns nil if there is no such MakeVirt,
+// if it initializes a block literal,
+// or if a receiver in the chain may be changed after it is initialized.
+func virtInit(vc *VirtCall) *MakeVirt {
+	var seen []Val
+	v := vc.Self
+	for !containsVal(seen, v) && readOnlyVirt(v) {
+		seen = append(seen, v)
+		switch init := singleStore(v).(type) {
+		case *MakeVirt:
+			if len(init.Virts) == 1 && init.Virts[0].Block != nil {
+				return nil
+			}
+			return init
+		case *Copy:
+			v = init.Src
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// readOnlyVirt returns whether the virtual value v
+// is stored at most once, and is otherwise only
+// loaded, copied, or the receiver of VirtCalls.
+func readOnlyVirt(v Val) bool {
+	var def Stmt
+	for _, u := range v.Users() {
+		if u.storesTo(v) {
+			if def != nil {
+				return false
+			}
+			def = u
+			continue
+		}
+		switch u := u.(type) {
+		case *Load, *Copy:
+			continue
+		case *VirtCall:
+			// The receiver is not passed to the virtual Fun,
+			// so the VirtCall cannot change it.
+			if u.Self == v && !containsVal(u.Args[1:], v) {
+				continue
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// devirtObj returns the Val to pass as the object of the MakeVirt
+// to a Call replacing the ith Stmt of the BBlk, a VirtCall.
+// If the Val must be defined just before the Call,
+// its definition is returned as the second result.
+//
+// The MakeVirt may not dominate the VirtCall,
+// or it may have executed again with a different object since.
+// So the object is only used if it does not change
+// during a call to the Fun: an Arg, a Global, or an Alloc of the 0th BBlk;
+// or if the MakeVirt is earlier in the same BBlk as the VirtCall.
+// Otherwise devirtObj returns nil, nil.
+func devirtObj(f *Fun, b *BBlk, i int, mv *MakeVirt) (Val, Stmt) {
+	switch obj := mv.Obj.(type) {
+	case nil:
+		return nil, nil
+	case *Arg:
+		arg := &Arg{val: newVal(f, obj.Type()), Parm: obj.Parm}
+		return arg, arg
+	case *Global:
+		global := &Global{val: newVal(f, obj.Type()), Val: obj.Val}
+		return global, global
+	case *Alloc:
+		if containsStmt(f.BBlks[0].Stmts, obj) {
+			return obj, nil
+		}
+	}
+	if containsStmt(b.Stmts[:i], mv) {
+		return mv.Obj, nil
+	}
+	return nil, nil
+}
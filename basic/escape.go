@@ -14,7 +14,7 @@ func moveAllocsToStack(f *Fun) bool {
 		for i, b := range f.BBlks {
 			for _, s := range b.Stmts {
 				alloc, ok := s.(*Alloc)
-				if !ok || alloc.Stack || escapes(alloc) {
+				if !ok || alloc.Stack || escapes(alloc, alloc) {
 					continue
 				}
 				n++
@@ -57,9 +57,151 @@ func addAllocToEnd(b *BBlk, a *Alloc) {
 	b.Stmts = s
 }
 
-func escapes(alloc *Alloc) bool {
-	for _, u := range alloc.Users() {
-		if u.storesTo(alloc) {
+// markNoEscapeParms sets NoEscape=true for the reference parameters
+// of the Mod's Funs whose addresses can be statically proven
+// not to escape the Fun.
+//
+// Calls are analyzed using the NoEscape of the callee's parameters,
+// so the Funs are marked bottom-up over the call graph:
+// each strongly connected component is marked
+// after all of the components that it calls.
+// Within a component, parameters are assumed not to escape,
+// and those that do are marked until nothing changes.
+//
+// Funs of other Mods are not marked,
+// so parameters passed to them are assumed to escape.
+func markNoEscapeParms(m *Mod) {
+	for _, scc := range callSCCs(m.Funs) {
+		markNoEscapeSCC(scc)
+	}
+}
+
+// markNoEscapeSCC marks the parameters of a set of Funs
+// that call each other,
+// assuming that the NoEscape of all other called Funs
+// is already marked.
+func markNoEscapeSCC(funs []*Fun) {
+	for _, f := range funs {
+		for _, p := range f.Parms {
+			p.NoEscape = p.Type.BuiltIn == types.RefType
+		}
+	}
+	for {
+		var changed bool
+		for _, f := range funs {
+			if markEscapingParms(f) {
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+}
+
+// markEscapingParms sets NoEscape=false for the parameters
+// whose addresses may escape the Fun,
+// and returns whether any parameter was changed.
+func markEscapingParms(f *Fun) bool {
+	var changed bool
+	for _, b := range f.BBlks {
+		for _, s := range b.Stmts {
+			a, ok := s.(*Arg)
+			if !ok || !a.Parm.NoEscape || !escapes(a, a) {
+				continue
+			}
+			a.Parm.NoEscape = false
+			changed = true
+		}
+	}
+	return changed
+}
+
+// callSCCs returns the strongly connected components
+// of the call graph of the defined Funs,
+// in postorder: each component comes after
+// all of the components that it calls.
+// Funs with no BBlks are not included.
+func callSCCs(funs []*Fun) [][]*Fun {
+	// This is Tarjan's algorithm,
+	// which finds the components in postorder.
+	type node struct {
+		index, low int
+		onStack    bool
+	}
+	nodes := make(map[*Fun]*node)
+	for _, f := range funs {
+		if len(f.BBlks) > 0 {
+			nodes[f] = nil
+		}
+	}
+	var index int
+	var sccs [][]*Fun
+	var stack []*Fun
+	var visit func(*Fun) *node
+	visit = func(f *Fun) *node {
+		n := &node{index: index, low: index, onStack: true}
+		index++
+		nodes[f] = n
+		stack = append(stack, f)
+		for _, g := range callees(f) {
+			m, ok := nodes[g]
+			switch {
+			case !ok:
+				continue
+			case m == nil:
+				m = visit(g)
+				if m.low < n.low {
+					n.low = m.low
+				}
+			case m.onStack && m.index < n.low:
+				n.low = m.index
+			}
+		}
+		if n.low == n.index {
+			var scc []*Fun
+			for {
+				g := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				nodes[g].onStack = false
+				scc = append(scc, g)
+				if g == f {
+					break
+				}
+			}
+			sccs = append(sccs, scc)
+		}
+		return n
+	}
+	for _, f := range funs {
+		if n, ok := nodes[f]; ok && n == nil {
+			visit(f)
+		}
+	}
+	return sccs
+}
+
+// callees returns the Funs called by f.
+func callees(f *Fun) []*Fun {
+	var fs []*Fun
+	for _, b := range f.BBlks {
+		for _, s := range b.Stmts {
+			if call, ok := s.(*Call); ok && !call.deleted() {
+				fs = append(fs, call.Fun)
+			}
+		}
+	}
+	return fs
+}
+
+// escapes returns whether the address of root may escape the frame
+// through the uses of v, which is either root
+// or the address of a field within root.
+//
+// root is either an Alloc or the Arg of a reference parameter.
+func escapes(root, v Val) bool {
+	for _, u := range v.Users() {
+		if u.storesTo(v) {
 			continue
 		}
 		switch u := u.(type) {
@@ -67,56 +209,38 @@ func escapes(alloc *Alloc) bool {
 			continue
 		case *Copy:
 			continue
-		case *MakeArray:
-			// This is an argument to MakeArray for a value-type array.
-			// The type is a reference, but MakeArray is required to copy it.
-			aryType := refElemType(u.Dst)
-			if aryType.BuiltIn == types.ArrayType &&
-				!SimpleType(aryType.Args[0].Type) {
+		case *MakeArray, *MakeAnd, *MakeOr:
+			if copiesArg(u, v) {
 				continue
 			}
-		case *MakeAnd:
-			i := findField(u, alloc)
-			typ := refElemType(u.Dst)
-			if i < len(typ.Fields) && !SimpleType(typ.Fields[i].Type()) {
-				// This is the argument to a MakeAnd value-type field.
-				// The type is a reference, but MakeAnd must copy it.
+		case *Store:
+			if _, ok := root.(*Alloc); !ok {
+				break
+			}
+			if alloc, ok := u.Dst.(*Alloc); ok && alloc.Stack {
 				continue
 			}
-		case *MakeOr:
-			cas := refElemType(u.Dst).Cases[u.Case]
-			if cas.Type() != nil && !SimpleType(cas.Type()) {
-				// This is the argument to a MakeOrvalue-type case.
-				// The type is a reference, but MakeOr must copy it.
+		case *Op:
+			// ArraySizeOp and UnionTagOp take the address
+			// of their argument, but result in an integer.
+			if u.Code == ArraySizeOp || u.Code == UnionTagOp {
 				continue
 			}
-		case *Store:
-			if alloc, ok := u.Dst.(*Alloc); ok && alloc.Stack {
+		case *Field:
+			// A Field is the address of a field within v.
+			if u.Obj == v && !escapes(root, u) {
 				continue
 			}
 		case *Call:
-			// If the alloc is only used as the return location of a call,
-			// it cannot possibly escape, since the called function
-			// only has access to this location in order to assign to it.
-			if u.Fun.Ret == nil {
-				// The function doesn't have a return value,
-				// so the alloc must be a non-return argument.
-				return true
-			}
-			// Check that our alloc isn't a non-final argument.
-			// The final argument is the return.
-			for _, arg := range u.Args[:len(u.Args)-1] {
-				if arg == alloc {
-					return true
-				}
+			if !escapesCall(u, v) {
+				continue
 			}
-			continue
 		case *Index:
 			// An Index that returns a non-reference
 			// does not expose the address of u, so it is non-escaping.
 			// This occurs, for example, for string indexing,
 			// which returns the byte value, not the address of the byte.
-			if u.Ary == alloc && !isRefType(u) {
+			if u.Ary == v && !isRefType(u) {
 				continue
 			}
 		}
@@ -125,6 +249,49 @@ func escapes(alloc *Alloc) bool {
 	return false
 }
 
+// copiesArg returns whether v is the argument to a value-type element,
+// field, or case of a MakeArray, MakeAnd, or MakeOr.
+// The type of v is a reference, but the Stmt must copy it.
+func copiesArg(s Stmt, v Val) bool {
+	switch s := s.(type) {
+	case *MakeArray:
+		aryType := refElemType(s.Dst)
+		return aryType.BuiltIn == types.ArrayType &&
+			!SimpleType(aryType.Args[0].Type)
+	case *MakeAnd:
+		i := findField(s, v)
+		typ := refElemType(s.Dst)
+		return i < len(typ.Fields) && !SimpleType(varType(&typ.Fields[i]))
+	case *MakeOr:
+		cas := refElemType(s.Dst).Cases[s.Case]
+		return varType(&cas) != nil && !SimpleType(varType(&cas))
+	default:
+		return false
+	}
+}
+
+// escapesCall returns whether a Val escapes through its arguments to a Call.
+//
+// If the Val is only used as the return location of a call,
+// it cannot possibly escape, since the called function
+// only has access to this location in order to assign to it.
+// Otherwise, it escapes unless passed only to NoEscape parameters.
+func escapesCall(call *Call, v Val) bool {
+	for i, arg := range call.Args {
+		switch {
+		case arg != v:
+			continue
+		case i < len(call.Fun.Parms):
+			if !call.Fun.Parms[i].NoEscape {
+				return true
+			}
+		case call.Fun.Ret == nil || i != len(call.Args)-1:
+			return true
+		}
+	}
+	return false
+}
+
 func findField(makeAnd *MakeAnd, v Val) int {
 	var i int
 	for i = range makeAnd.Fields {
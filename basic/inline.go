@@ -3,29 +3,102 @@
 package basic
 
 import (
+	"fmt"
+
 	"github.com/eaburns/pea/types"
 )
 
-func canInline(f *Fun) bool {
-	if f.CanFarRet {
+const (
+	// inlineBudget is the maximum cost of a Fun inlined at a Call.
+	inlineBudget = 40
+	// funParmInlineBudget is the maximum cost of a Fun
+	// with a Fun-type parameter inlined at a Call.
+	// Inlining it may allow further block-literal inlining,
+	// which can remove expensive virt calls.
+	funParmInlineBudget = 160
+	// growthBudget is the maximum total cost
+	// of the Funs inlined into a single Fun.
+	// It limits multi-level inlining.
+	growthBudget = 400
+)
+
+// inlineCost returns the cost of inlining a Fun:
+// the number of BBlks plus the number of statements
+// besides Comments and Args, which are substituted.
+func inlineCost(f *Fun) int {
+	cost := len(f.BBlks)
+	for _, b := range f.BBlks {
+		for _, s := range b.Stmts {
+			switch s.(type) {
+			case *Comment, *Arg:
+				continue
+			}
+			if !s.deleted() {
+				cost++
+			}
+		}
+	}
+	return cost
+}
+
+func canInline(f *Fun) bool { return cannotInline(f) == "" }
+
+// cannotInline returns why calls to f can never be inlined,
+// or the empty string if they can.
+func cannotInline(f *Fun) string {
+	switch {
+	case f.BBlks == nil:
+		return "no definition"
+	case f.CanFarRet:
 		// We do not inline functions that can far return,
 		// so codegen only needs to insert a far return catch
 		// in function premables, not internal to a function body.
-		return false
+		return "can far return"
+	case !copyable(f):
+		return "creates a block literal or initializes a module variable"
+	case callsFun(f, f):
+		return "recursive"
 	}
+	return ""
+}
+
+func callsFun(f, callee *Fun) bool {
 	for _, b := range f.BBlks {
 		for _, s := range b.Stmts {
-			if s.deleted() {
-				continue
-			}
-			switch s.(type) {
-			// TODO: disallow inline with VirtCall unless it's a Fun value.
-			case *Call:
-				return false
+			if call, ok := s.(*Call); ok && !call.deleted() && call.Fun == callee {
+				return true
 			}
 		}
 	}
-	return f.BBlks != nil
+	return false
+}
+
+// shouldInline returns whether to inline the Call into f
+// and the reason for the decision.
+// growth is the total cost already inlined into f.
+func shouldInline(f *Fun, call *Call, growth int) (bool, string) {
+	g := call.Fun
+	switch {
+	case g == f:
+		return false, "recursive"
+	case !g.CanInline:
+		if reason := cannotInline(g); reason != "" {
+			return false, reason
+		}
+		// g is a Fun in a cycle of calls that is not yet optimized.
+		return false, "not optimized"
+	}
+	cost, budget := inlineCost(g), inlineBudget
+	if hasFunParm(g) {
+		budget = funParmInlineBudget
+	}
+	switch {
+	case cost > budget:
+		return false, fmt.Sprintf("cost %d exceeds budget %d", cost, budget)
+	case growth+cost > growthBudget:
+		return false, fmt.Sprintf("cost %d exceeds remaining growth budget %d", cost, growthBudget-growth)
+	}
+	return true, fmt.Sprintf("cost %d", cost)
 }
 
 func inlineCalls(f *Fun) bool {
@@ -41,7 +114,7 @@ func inlineCalls(f *Fun) bool {
 	bblks := make([]*BBlk, 0, len(f.BBlks))
 	bblks = append(bblks, f.BBlks[0])
 
-	var n int
+	var n, growth int
 	todo := f.BBlks[1:]
 	for len(todo) > 0 {
 		b := todo[0]
@@ -54,11 +127,14 @@ func inlineCalls(f *Fun) bool {
 			if !ok {
 				continue
 			}
-			if !call.Fun.CanInline {
+			inline, reason := shouldInline(f, call, growth)
+			reportInline(f, call, inline, reason)
+			if !inline {
 				continue
 			}
 
 			n++
+			growth += inlineCost(call.Fun)
 			s.delete()
 			b0, b1 := splitBBlk(b, j)
 			bs := copyForInline(call.Fun, f, b1, nil, call.Args)
@@ -76,6 +152,32 @@ func inlineCalls(f *Fun) bool {
 	return n > 0
 }
 
+// reportInline records the decision whether to inline the Call into f
+// if the Mod of f reports Inlines.
+// A Call not inlined by one pass may be considered again by a later pass,
+// and a call site may be copied into f more than once by inlining,
+// so a later decision for the same call site replaces the earlier one.
+func reportInline(f *Fun, call *Call, inlined bool, reason string) {
+	m := f.Mod
+	if m == nil || !m.ReportInlines {
+		return
+	}
+	site := inlineSite{caller: f, msg: call.Msg}
+	if call.Msg == nil {
+		site.call = call
+	}
+	in := Inline{Caller: f, Call: call, Inlined: inlined, Reason: reason}
+	if i, ok := m.inlineIndex[site]; ok {
+		m.Inlines[i] = in
+		return
+	}
+	if m.inlineIndex == nil {
+		m.inlineIndex = make(map[inlineSite]int)
+	}
+	m.inlineIndex[site] = len(m.Inlines)
+	m.Inlines = append(m.Inlines, in)
+}
+
 func inlineBlockLits(f *Fun) bool {
 	// There can be no calls in the 0th bblock,
 	// and we need to make sure it's here to copy
@@ -169,8 +271,7 @@ loop:
 func splitBBlk(b0 *BBlk, i int) (*BBlk, *BBlk) {
 	b1 := &BBlk{N: b0.N}
 	for _, o := range b0.Out() {
-		o.rmIn(b0)
-		o.addIn(b1)
+		o.replaceIn(b0, b1)
 	}
 	b1.Stmts = b0.Stmts[i:]
 	b0.Stmts = b0.Stmts[:i:i]
@@ -65,3 +65,454 @@ func isParm(f *Fun, alloc *Alloc) bool {
 	}
 	return false
 }
+
+// mem2reg lifts Allocs of SimpleTypes to registers,
+// inserting Phis where different Stores reach a Load
+// along different paths.
+//
+// An Alloc is lifted if its only users are Loads
+// and Stores for which the Alloc is the Dst,
+// and a Store precedes each Load along every path to it.
+func mem2reg(f *Fun) bool {
+	var allocs []*Alloc
+	for _, b := range f.BBlks {
+		for _, s := range b.Stmts {
+			if alloc, ok := s.(*Alloc); ok && !alloc.deleted() && promotableAlloc(alloc) {
+				allocs = append(allocs, alloc)
+			}
+		}
+	}
+	if len(allocs) == 0 {
+		return false
+	}
+	dom := newDomTree(f.BBlks)
+	type loadVal struct {
+		load *Load
+		val  Val
+	}
+	var loads []loadVal
+	var lifted []*Alloc
+	for _, alloc := range allocs {
+		blockPhis, allocLoads, ok := dom.rename(alloc)
+		if !ok {
+			continue
+		}
+		lifted = append(lifted, alloc)
+		addPhis(f, alloc, blockPhis)
+		for load, val := range allocLoads {
+			loads = append(loads, loadVal{load: load, val: val})
+		}
+	}
+	if len(lifted) == 0 {
+		return false
+	}
+	valMap := makeValMap(f.NVals)
+	for _, lv := range loads {
+		lv.load.delete()
+		valMap.add(lv.load, lv.val)
+	}
+	for _, alloc := range lifted {
+		for _, u := range alloc.Users() {
+			u.delete()
+		}
+		alloc.delete()
+	}
+	subVals(f.BBlks, valMap)
+	return true
+}
+
+// addPhis numbers the Phis of a lifted Alloc, indexed by BBlk index,
+// and adds them to their BBlks and as users of their arguments.
+func addPhis(f *Fun, alloc *Alloc, phis []*Phi) {
+	// Phis can be arguments of each other,
+	// so they must all have vals before adding users.
+	for _, phi := range phis {
+		if phi != nil {
+			phi.val = newVal(f, refElemType(alloc))
+		}
+	}
+	for i, phi := range phis {
+		if phi == nil {
+			continue
+		}
+		for _, arg := range phi.Args {
+			arg.value().addUser(phi)
+		}
+		b := f.BBlks[i]
+		j := len(b.phis())
+		b.Stmts = append(b.Stmts[:j], append([]Stmt{phi}, b.Stmts[j:]...)...)
+	}
+}
+
+// promotableAlloc returns whether the Alloc is of a SimpleType,
+// and its only users are Loads and Stores for which it is the Dst.
+func promotableAlloc(alloc *Alloc) bool {
+	if !isRefType(alloc) || !SimpleType(refElemType(alloc)) {
+		return false
+	}
+	for _, u := range alloc.Users() {
+		switch u := u.(type) {
+		case *Load:
+			continue
+		case *Store:
+			if u.Dst == alloc && u.Val != alloc {
+				continue
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// rename computes the SSA values of an Alloc's Loads.
+// It returns the Phis needed, indexed by BBlk index,
+// and the value of each Load.
+// The Phis are not yet numbered, added to their BBlks,
+// or added as users of their arguments.
+// The last return is false if the Alloc cannot be lifted.
+func (t *domTree) rename(alloc *Alloc) ([]*Phi, map[*Load]Val, bool) {
+	defs, upExposed, ok := t.allocDefs(alloc)
+	if !ok {
+		return nil, nil, false
+	}
+	live := t.liveIn(defs, upExposed)
+	if live[0] {
+		// There is a path to a Load with no Store.
+		return nil, nil, false
+	}
+	r := &renamer{
+		t:     t,
+		alloc: alloc,
+		phis:  t.placePhis(alloc, defs, live),
+		loads: make(map[*Load]Val),
+	}
+	if !r.walk(0, nil) {
+		return nil, nil, false
+	}
+	for i, phi := range r.phis {
+		if phi != nil && len(phi.In) != len(t.bblks[i].In) {
+			// An In BBlk is unreachable.
+			return nil, nil, false
+		}
+	}
+	return r.phis, r.loads, true
+}
+
+// allocDefs returns, for each BBlk index, whether the BBlk defines the Alloc,
+// and whether a Load of the Alloc is reached from the BBlk's beginning
+// with no intervening definition.
+// The last return is false if an unreachable BBlk uses the Alloc.
+func (t *domTree) allocDefs(alloc *Alloc) ([]bool, []bool, bool) {
+	defs := make([]bool, len(t.bblks))
+	upExposed := make([]bool, len(t.bblks))
+	for i, b := range t.bblks {
+		var def bool
+		for _, s := range b.Stmts {
+			if s.deleted() {
+				continue
+			}
+			isDef, isLoad := allocAccess(s, alloc)
+			if !isDef && !isLoad {
+				continue
+			}
+			if !t.reachable[i] {
+				return nil, nil, false
+			}
+			if isLoad && !def {
+				upExposed[i] = true
+			}
+			if isDef {
+				def = true
+				defs[i] = true
+			}
+		}
+	}
+	return defs, upExposed, true
+}
+
+// allocAccess returns whether the Stmt is the Alloc or a Store to it,
+// and whether the Stmt is a Load from it.
+func allocAccess(s Stmt, alloc *Alloc) (bool, bool) {
+	switch s := s.(type) {
+	case *Alloc:
+		return s == alloc, false
+	case *Store:
+		return s.Dst == alloc, false
+	case *Load:
+		return false, s.Src == alloc
+	default:
+		return false, false
+	}
+}
+
+// liveIn returns, for each BBlk index, whether the Alloc is live into the BBlk.
+// The Alloc is live into a BBlk if there is a path from its beginning
+// to a Load with no intervening Store.
+func (t *domTree) liveIn(defs, upExposed []bool) []bool {
+	live := make([]bool, len(t.bblks))
+	var work []int
+	for i := range upExposed {
+		if upExposed[i] {
+			live[i] = true
+			work = append(work, i)
+		}
+	}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, p := range t.preds[i] {
+			if !live[p] && !defs[p] {
+				live[p] = true
+				work = append(work, p)
+			}
+		}
+	}
+	return live
+}
+
+// placePhis returns the Phis of the Alloc, indexed by BBlk index.
+// Phis are placed on the iterated dominance frontier of the Stores,
+// but only where the Alloc is live.
+func (t *domTree) placePhis(alloc *Alloc, defs, live []bool) []*Phi {
+	phis := make([]*Phi, len(t.bblks))
+	queued := make([]bool, len(t.bblks))
+	var work []int
+	for i := range defs {
+		if defs[i] {
+			queued[i] = true
+			work = append(work, i)
+		}
+	}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, j := range t.frontier[i] {
+			if phis[j] != nil || !live[j] {
+				continue
+			}
+			phis[j] = &Phi{Var: alloc.Var}
+			if !queued[j] {
+				queued[j] = true
+				work = append(work, j)
+			}
+		}
+	}
+	return phis
+}
+
+// A renamer computes the values of an Alloc's Loads
+// and the arguments of its Phis
+// by walking the dominator tree.
+type renamer struct {
+	t     *domTree
+	alloc *Alloc
+	phis  []*Phi
+	loads map[*Load]Val
+}
+
+// walk renames the Loads of the BBlk with index i
+// and the BBlks that it dominates,
+// given the current value of the Alloc, cur, at its beginning.
+// It returns false if the Alloc may be loaded before it is stored.
+func (r *renamer) walk(i int, cur Val) bool {
+	b := r.t.bblks[i]
+	if r.phis[i] != nil {
+		cur = r.phis[i]
+	}
+	for _, s := range b.Stmts {
+		if s.deleted() {
+			continue
+		}
+		var ok bool
+		if cur, ok = r.renameStmt(s, cur); !ok {
+			return false
+		}
+	}
+	for _, o := range b.Out() {
+		phi := r.phis[r.t.index[o]]
+		if phi == nil || containsBBlk(phi.In, b) {
+			continue
+		}
+		if cur == nil {
+			return false
+		}
+		phi.In = append(phi.In, b)
+		phi.Args = append(phi.Args, cur)
+	}
+	for _, k := range r.t.kids[i] {
+		if !r.walk(k, cur) {
+			return false
+		}
+	}
+	return true
+}
+
+// renameStmt returns the value of the Alloc after the Stmt,
+// given its value before, cur.
+// If the Stmt is a Load of the Alloc, its value is recorded.
+// It returns false if the Stmt loads the Alloc before it is stored.
+func (r *renamer) renameStmt(s Stmt, cur Val) (Val, bool) {
+	switch s := s.(type) {
+	case *Alloc:
+		if s == r.alloc {
+			return nil, true
+		}
+	case *Store:
+		if s.Dst == r.alloc {
+			return s.Val, true
+		}
+	case *Load:
+		if s.Src != r.alloc {
+			break
+		}
+		if cur == nil {
+			return nil, false
+		}
+		r.loads[s] = cur
+	}
+	return cur, true
+}
+
+// A domTree is the dominator tree of a Fun's BBlks.
+type domTree struct {
+	bblks     []*BBlk
+	index     map[*BBlk]int
+	reachable []bool
+	// preds are the indices of the reachable In BBlks.
+	preds [][]int
+	// idom is the index of the immediate dominator,
+	// or -1 for the first BBlk and unreachable BBlks.
+	idom []int
+	// kids are the indices of the immediately dominated BBlks.
+	kids [][]int
+	// frontier are the indices of the BBlks
+	// in the dominance frontier.
+	frontier [][]int
+}
+
+// newDomTree returns the dominator tree of the BBlks
+// computed with the algorithm of Cooper, Harvey, and Kennedy,
+// "A Simple, Fast Dominance Algorithm".
+func newDomTree(bblks []*BBlk) *domTree {
+	n := len(bblks)
+	t := &domTree{
+		bblks:     bblks,
+		index:     make(map[*BBlk]int, n),
+		reachable: make([]bool, n),
+		preds:     make([][]int, n),
+		idom:      make([]int, n),
+		kids:      make([][]int, n),
+		frontier:  make([][]int, n),
+	}
+	for i, b := range bblks {
+		t.index[b] = i
+	}
+
+	post := t.postorder()
+	for i, b := range bblks {
+		t.idom[i] = -1
+		if !t.reachable[i] {
+			continue
+		}
+		for _, p := range b.In {
+			if j, ok := t.index[p]; ok && t.reachable[j] {
+				t.preds[i] = append(t.preds[i], j)
+			}
+		}
+	}
+	t.computeIdoms(post)
+	t.computeFrontiers()
+	return t
+}
+
+// postorder marks the reachable BBlks
+// and returns their indices in postorder of a depth-first search.
+func (t *domTree) postorder() []int {
+	var post []int
+	var visit func(int)
+	visit = func(i int) {
+		t.reachable[i] = true
+		for _, o := range t.bblks[i].Out() {
+			if j, ok := t.index[o]; ok && !t.reachable[j] {
+				visit(j)
+			}
+		}
+		post = append(post, i)
+	}
+	visit(0)
+	return post
+}
+
+// computeIdoms computes t.idom
+// given the postorder of the reachable BBlks.
+func (t *domTree) computeIdoms(post []int) {
+	postNum := make([]int, len(t.bblks))
+	for k, i := range post {
+		postNum[i] = k
+	}
+	intersect := func(i, j int) int {
+		for i != j {
+			for postNum[i] < postNum[j] {
+				i = t.idom[i]
+			}
+			for postNum[j] < postNum[i] {
+				j = t.idom[j]
+			}
+		}
+		return i
+	}
+	t.idom[0] = 0
+	for changed := true; changed; {
+		changed = false
+		for k := len(post) - 1; k >= 0; k-- {
+			i := post[k]
+			if i == 0 {
+				continue
+			}
+			idom := -1
+			for _, p := range t.preds[i] {
+				switch {
+				case t.idom[p] < 0:
+					continue
+				case idom < 0:
+					idom = p
+				default:
+					idom = intersect(p, idom)
+				}
+			}
+			if t.idom[i] != idom {
+				t.idom[i] = idom
+				changed = true
+			}
+		}
+	}
+	t.idom[0] = -1
+}
+
+// computeFrontiers computes t.kids and t.frontier from t.idom.
+func (t *domTree) computeFrontiers() {
+	for i := range t.bblks {
+		if i > 0 && t.reachable[i] {
+			t.kids[t.idom[i]] = append(t.kids[t.idom[i]], i)
+		}
+		if len(t.preds[i]) < 2 {
+			continue
+		}
+		for _, p := range t.preds[i] {
+			for r := p; r != t.idom[i]; r = t.idom[r] {
+				if !containsInt(t.frontier[r], i) {
+					t.frontier[r] = append(t.frontier[r], i)
+				}
+			}
+		}
+	}
+}
+
+func containsInt(is []int, i int) bool {
+	for _, j := range is {
+		if j == i {
+			return true
+		}
+	}
+	return false
+}
@@ -9,9 +9,58 @@ import (
 // Optimize applies some simple optimizations.
 func Optimize(m *Mod) {
 	for _, f := range m.Funs {
-		optimize(f)
+		optimize(f, false)
+	}
+	markNoEscapeParms(m)
+	for _, f := range m.Funs {
+		stackAllocs(f, false)
+	}
+	optimizeVals(m, false)
+	rmDeletedFuns(m)
+}
+
+// OptimizeVerify is like Optimize,
+// but it Verifies each function after each optimization pass.
+// If a bug is found, OptimizeVerify stops and returns an error
+// describing the bug and the pass that introduced it.
+func OptimizeVerify(m *Mod) error {
+	for _, f := range m.Funs {
+		if bugs := optimize(f, true); len(bugs) > 0 {
+			return bugsError(bugs)
+		}
+	}
+	markNoEscapeParms(m)
+	for _, f := range m.Funs {
+		if bugs := stackAllocs(f, true); len(bugs) > 0 {
+			return bugsError(bugs)
+		}
+	}
+	if bugs := optimizeVals(m, true); len(bugs) > 0 {
+		return bugsError(bugs)
 	}
 	rmDeletedFuns(m)
+	return nil
+}
+
+// optimizeVals propagates the values of constant module-level Vals
+// into the Funs that use them, and re-runs sccp on those Funs.
+// If verify is true, each changed Fun is verified,
+// and optimizeVals returns early with the bugs of the first failed Fun.
+func optimizeVals(m *Mod, verify bool) []string {
+	for _, f := range propagateVals(m) {
+		sccp(f)
+		cleanUp(f)
+		if !verify {
+			continue
+		}
+		if bugs := verifyFun(f); len(bugs) > 0 {
+			for i := range bugs {
+				bugs[i] = "after propagateVals: " + bugs[i]
+			}
+			return bugs
+		}
+	}
+	return nil
 }
 
 func rmDeletedFuns(m *Mod) {
@@ -26,41 +75,109 @@ func rmDeletedFuns(m *Mod) {
 	m.Funs = m.Funs[:i]
 }
 
-func optimize(f *Fun) {
+// optimize optimizes the function.
+// If verify is true, the function is verified after each pass,
+// and optimize returns early with the bugs of the first failed pass.
+func optimize(f *Fun, verify bool) []string {
 	if len(f.BBlks) == 0 {
 		f.CanInline = f.BBlks != nil
-		return
+		return nil
 	}
-	var inlinedCall bool
-	if inlinedCall = inlineCalls(f); inlinedCall {
-		cleanUp(f)
+	var bugs []string
+	ok := func(pass string) bool {
+		if !verify {
+			return true
+		}
+		bugs = verifyFun(f)
+		for i := range bugs {
+			bugs[i] = "after " + pass + ": " + bugs[i]
+		}
+		return len(bugs) == 0
 	}
-	if inlineBlockLits(f) {
-		cleanUp(f)
+	if !ok("build") {
+		return bugs
 	}
-	// Lift allocs here helps in detecting return value tails.
-	// But we don't want to lift param allocs,
-	// because rmSelfTailCalls assumes they remain.
-	if liftAllocs(f, false) {
-		cleanUp(f)
+	var devirtualized bool
+	passes := []struct {
+		name string
+		pass func(*Fun) bool
+		// skipInDebug is whether the pass is skipped for Debug Mods.
+		// Debug Mods keep their Calls and variables
+		// for the debugger, so they are not inlined or lifted.
+		skipInDebug bool
+	}{
+		{"inlineCalls", inlineCalls, true},
+		{"inlineBlockLits", inlineBlockLits, true},
+		// Inlining may make the MakeVirt of a VirtCall visible.
+		// The devirtualized Calls may be inlined in turn,
+		// and then any block literals passed to them.
+		{"devirtualize", func(f *Fun) bool {
+			devirtualized = devirtualize(f)
+			return devirtualized
+		}, false},
+		{"inlineCalls after devirtualize", func(f *Fun) bool {
+			return devirtualized && inlineCalls(f)
+		}, true},
+		{"inlineBlockLits after devirtualize", func(f *Fun) bool {
+			return devirtualized && inlineBlockLits(f)
+		}, true},
+		// Lift allocs here helps in detecting return value tails.
+		// But we don't want to lift param allocs,
+		// because rmSelfTailCalls assumes they remain.
+		{"liftAllocs", func(f *Fun) bool { return liftAllocs(f, false) }, true},
+		{"forwardRets", forwardRets, false},
+		{"mergeTailCycles", mergeTailCycles, false},
+		{"rmSelfTailCalls", rmSelfTailCalls, false},
+		{"liftAllocs of parameters", func(f *Fun) bool { return liftAllocs(f, true) }, true},
+		{"mem2reg", mem2reg, true},
+		{"sccp", sccp, false},
 	}
-	if rmSelfTailCalls(f) {
-		cleanUp(f)
-	}
-	if liftAllocs(f, true) {
+	for _, p := range passes {
+		if p.skipInDebug && f.Mod.Debug || !p.pass(f) {
+			continue
+		}
 		cleanUp(f)
+		if !ok(p.name) {
+			return bugs
+		}
 	}
-	moveAllocsToStack(f)
 	// Unconditionally do a cleanUp pass at the end
 	// to ensure we cleanUp once even if
 	// none of the above passes triggered.
 	cleanUp(f)
+	if !ok("cleanUp") {
+		return bugs
+	}
 	if f.Block == nil && f.CanFarRet {
 		// We may have removed the far ret, so re-scan for it.
 		f.CanFarRet = canFarRet(f)
 	}
-	f.CanInline = canInline(f) && (hasFunParm(f) || !inlinedCall)
+	f.CanInline = canInline(f)
+	// mergeTailCycles of later Funs uses the NoEscape of f,
+	// so it is marked now, treating f as its own component.
+	// This is safe, since the Funs that f calls and
+	// that are not yet marked are assumed to escape.
+	// All Funs are marked again by markNoEscapeParms
+	// once they are all optimized.
+	markNoEscapeSCC([]*Fun{f})
+	return nil
+}
 
+// stackAllocs moves the non-escaping Allocs of an optimized function
+// to the stack.
+// It must be called after markNoEscapeParms,
+// since Allocs passed to NoEscape parameters do not escape.
+// If verify is true, the function is verified after moving the Allocs,
+// and stackAllocs returns the bugs, if any.
+func stackAllocs(f *Fun, verify bool) []string {
+	if len(f.BBlks) == 0 || !moveAllocsToStack(f) || !verify {
+		return nil
+	}
+	bugs := verifyFun(f)
+	for i := range bugs {
+		bugs[i] = "after moveAllocsToStack: " + bugs[i]
+	}
+	return bugs
 }
 
 func hasFunParm(f *Fun) bool {
@@ -74,6 +74,111 @@ func TestToDo(t *testing.T) {
 	}
 }
 
+// Test tail-call optimization of a function with a non-simple return type.
+func TestSelfTailCallStringReturn(t *testing.T) {
+	const src = `
+		func [foo: n Int ^String |
+			n = 0 ifTrue: [^"done"] ifFalse: [].
+			^foo: n - 1
+		]
+	`
+	mod, errs := compile(src)
+	if len(errs) > 0 {
+		t.Fatalf("failed to compile: %s", errs)
+	}
+	foo := findTestFunBySelector(mod, "foo:")
+	if s := foo.String(); strings.Contains(s, "BUG") {
+		t.Errorf("foo: a bug:\n%s", s)
+	}
+	if s := foo.String(); strings.Contains(s, "call") {
+		t.Errorf("foo: contains a call:\n%s\nexpected no call", s)
+	}
+}
+
+// Test that mutually recursive tail calls are merged into a loop
+// in the last function of the cycle to be optimized.
+func TestMutualTailCalls(t *testing.T) {
+	const src = `
+		func [even: n Int ^Bool |
+			n = 0 ifTrue: [^true] ifFalse: [].
+			^odd: n - 1
+		]
+		func [odd: n Int ^Bool |
+			n = 0 ifTrue: [^false] ifFalse: [].
+			^even: n - 1
+		]
+	`
+	mod, errs := compile(src)
+	if len(errs) > 0 {
+		t.Fatalf("failed to compile: %s", errs)
+	}
+	var calls int
+	for _, sel := range []string{"even:", "odd:"} {
+		f := findTestFunBySelector(mod, sel)
+		if s := f.String(); strings.Contains(s, "BUG") {
+			t.Errorf("%s a bug:\n%s", sel, s)
+		}
+		if strings.Contains(f.String(), "call") {
+			calls++
+		}
+	}
+	if calls != 1 {
+		t.Errorf("got %d functions with calls, expected 1:\n%s", calls, mod)
+	}
+}
+
+// Test that a virtual call on a value converted in the same function
+// is replaced by a direct call, which is then inlined.
+func TestDevirtualize(t *testing.T) {
+	const src = `
+		type Point {x: Int y: Int}
+		meth Point [sum ^Int | ^x + y]
+		type Summer {[sum ^Int]}
+		func [foo ^Int |
+			p Point := {x: 1 y: 2}.
+			s Summer := p.
+			^s sum
+		]
+	`
+	mod, errs := compile(src)
+	if len(errs) > 0 {
+		t.Fatalf("failed to compile: %s", errs)
+	}
+	foo := findTestFunBySelector(mod, "foo")
+	if s := foo.String(); strings.Contains(s, "BUG") {
+		t.Errorf("foo a bug:\n%s", s)
+	}
+	if s := foo.String(); strings.Contains(s, "call") {
+		t.Errorf("foo contains a call:\n%s\nexpected no call", s)
+	}
+}
+
+// Test that a virtual call is replaced by a direct call
+// when the conversion becomes visible after inlining.
+func TestDevirtualizeAfterInline(t *testing.T) {
+	const src = `
+		type Point {x: Int y: Int}
+		meth Point [sum ^Int | ^x + y]
+		type Summer {[sum ^Int]}
+		func [sum: s Summer ^Int | ^s sum]
+		func [foo ^Int |
+			p Point := {x: 1 y: 2}.
+			^sum: p
+		]
+	`
+	mod, errs := compile(src)
+	if len(errs) > 0 {
+		t.Fatalf("failed to compile: %s", errs)
+	}
+	foo := findTestFunBySelector(mod, "foo")
+	if s := foo.String(); strings.Contains(s, "BUG") {
+		t.Errorf("foo a bug:\n%s", s)
+	}
+	if s := foo.String(); strings.Contains(s, "call") {
+		t.Errorf("foo contains a call:\n%s\nexpected no call", s)
+	}
+}
+
 // Test that nested block literals are inlined.
 func TestInlineNestedBlocks(t *testing.T) {
 	// The call to Bool ifTrue in foo should be inlined;
@@ -217,6 +322,176 @@ func TestMakeOrRefCaseEscape(t *testing.T) {
 	}
 }
 
+// Tests that allocs passed to non-escaping parameters do not escape.
+func TestCallNoEscapeParmNoEscape(t *testing.T) {
+	const src = `
+		func [leaf: _ Int]
+		func [internal: s String | leaf: s byteSize]
+		func [foo | internal: "a"]
+	`
+	mod, errs := compile(src)
+	if len(errs) > 0 {
+		t.Fatalf("failed to compile: %s", errs)
+	}
+	foo := findTestFunBySelector(mod, "foo")
+	if s := foo.String(); strings.Contains(s, "BUG") {
+		t.Errorf("foo a bug:\n%s", s)
+	}
+	if s := foo.String(); !strings.Contains(s, "call") {
+		t.Errorf("foo contains no call:\n%s\nexpected a call", s)
+	}
+	if s := foo.String(); strings.Contains(s, "alloc(String)") {
+		t.Errorf("foo contains an alloc(String):\n%s\nexpected only alloca", s)
+	}
+}
+
+// Tests that allocs passed to escaping parameters do escape.
+func TestCallEscapeParmEscape(t *testing.T) {
+	const src = `
+		val kept String& := ["" ]
+		func [leaf]
+		func [internal: s String | kept := s. leaf]
+		func [foo | internal: "a"]
+	`
+	mod, errs := compile(src)
+	if len(errs) > 0 {
+		t.Fatalf("failed to compile: %s", errs)
+	}
+	foo := findTestFunBySelector(mod, "foo")
+	if s := foo.String(); strings.Contains(s, "BUG") {
+		t.Errorf("foo a bug:\n%s", s)
+	}
+	if s := foo.String(); !strings.Contains(s, "call") {
+		t.Errorf("foo contains no call:\n%s\nexpected a call", s)
+	}
+	if s := foo.String(); !strings.Contains(s, "alloc(String)") {
+		t.Errorf("foo contains no alloc(String):\n%s\nexpected an alloc", s)
+	}
+}
+
+// Tests that escape summaries do not depend on
+// the order of Funs or on recursion.
+func TestCallNoEscapeParmCallGraph(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		escape bool
+	}{
+		{
+			// Funs are built callees first,
+			// but the call to Point use: is only made visible
+			// by devirtualizing after inlining into foo,
+			// which is before Point use: in the Mod's Funs.
+			name: "caller first",
+			src: `
+				type Point {x: Int y: Int}
+				type User {[use: String]}
+				func [use: u User | u use: "a"]
+				func [foo | p Point := {x: 1 y: 2}. use: p]
+				meth Point [use: s String | leaf: s byteSize]
+				func [leaf: _ Int]
+			`,
+			escape: false,
+		},
+		{
+			name: "caller first escape",
+			src: `
+				type Point {x: Int y: Int}
+				type User {[use: String]}
+				func [use: u User | u use: "a"]
+				func [foo | p Point := {x: 1 y: 2}. use: p]
+				meth Point [use: s String | kept := s. leaf]
+				func [leaf]
+				val kept String& := [""]
+			`,
+			escape: true,
+		},
+		{
+			name: "recursive",
+			src: `
+				func [foo | internal: "a" n: 3]
+				func [internal: s String& n: n Int |
+					n > 0 ifTrue: [internal: s n: n - 1] ifFalse: [].
+					leaf: s byteSize
+				]
+				func [leaf: _ Int]
+			`,
+			escape: false,
+		},
+		{
+			name: "recursive escape",
+			src: `
+				func [foo | internal: "a" n: 3]
+				func [internal: s String& n: n Int |
+					n > 0 ifTrue: [internal: s n: n - 1] ifFalse: [].
+					n = 0 ifTrue: [kept := s] ifFalse: [].
+					leaf
+				]
+				func [leaf]
+				val kept String& := [""]
+			`,
+			escape: true,
+		},
+		{
+			name: "mutually recursive",
+			src: `
+				func [foo | even: "a" n: 3]
+				func [even: s String& n: n Int |
+					n > 0 ifTrue: [odd: s n: n - 1] ifFalse: [].
+					leaf: s byteSize
+				]
+				func [odd: s String& n: n Int |
+					n > 0 ifTrue: [even: s n: n - 1] ifFalse: [].
+					leaf: s byteSize
+				]
+				func [leaf: _ Int]
+			`,
+			escape: false,
+		},
+		{
+			name: "mutually recursive escape",
+			src: `
+				func [foo | even: "a" n: 3]
+				func [even: s String& n: n Int |
+					n > 0 ifTrue: [odd: s n: n - 1] ifFalse: [].
+					leaf
+				]
+				func [odd: s String& n: n Int |
+					n > 0 ifTrue: [even: s n: n - 1] ifFalse: [].
+					kept := s.
+					leaf
+				]
+				func [leaf]
+				val kept String& := [""]
+			`,
+			escape: true,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			mod, errs := compile(test.src)
+			if len(errs) > 0 {
+				t.Fatalf("failed to compile: %s", errs)
+			}
+			foo := findTestFunBySelector(mod, "foo")
+			s := foo.String()
+			if strings.Contains(s, "BUG") {
+				t.Errorf("foo a bug:\n%s", s)
+			}
+			if !strings.Contains(s, "call") {
+				t.Errorf("foo contains no call:\n%s\nexpected a call", s)
+			}
+			switch alloc := strings.Contains(s, "alloc(String)"); {
+			case test.escape && !alloc:
+				t.Errorf("foo contains no alloc(String):\n%s\nexpected an alloc", s)
+			case !test.escape && alloc:
+				t.Errorf("foo contains an alloc(String):\n%s\nexpected only alloca", s)
+			}
+		})
+	}
+}
+
 // Test that we don't inline a value call on a Fun
 // that is the receiver of a method.
 // A method can modify its receiver value,
@@ -256,8 +531,8 @@ func TestMethodMarksFunAsNonConst(t *testing.T) {
 	}
 }
 
-// Tests that we only inline leaf calls.
-func TestInlineOnlyLeaves(t *testing.T) {
+// Tests that calls are inlined through multiple levels.
+func TestInlineMultipleLevels(t *testing.T) {
 	const src = `
 		func [leaf ^String | ^"Hello, World"]
 		func [internal ^String | ^leaf]
@@ -271,13 +546,133 @@ func TestInlineOnlyLeaves(t *testing.T) {
 	if s := foo.String(); strings.Contains(s, "BUG") {
 		t.Errorf("foo a bug:\n%s", s)
 	}
+	if s := foo.String(); strings.Contains(s, "call") {
+		t.Errorf("foo contains a call:\n%s\nexpected no call", s)
+	}
+}
+
+// Tests that calls and variables of a Debug Mod are not inlined or lifted.
+func TestDebugNoInlineOrLift(t *testing.T) {
+	const src = `
+		func [leaf: x Int ^Int | ^x + 1]
+		func [foo ^Int |
+			y := leaf: 5.
+			^y
+		]
+	`
+	p := ast.NewParser("#test")
+	if err := p.Parse("", strings.NewReader(src)); err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	typesMod, errs := types.Check(p.Mod(), types.Config{})
+	if len(errs) > 0 {
+		t.Fatalf("failed to check: %v", errs)
+	}
+	mod := Build(typesMod)
+	mod.Debug = true
+	Optimize(mod)
+	foo := findTestFunBySelector(mod, "foo")
+	if s := foo.String(); strings.Contains(s, "BUG") {
+		t.Errorf("foo a bug:\n%s", s)
+	}
 	if s := foo.String(); !strings.Contains(s, "call") {
 		t.Errorf("foo contains no call:\n%s\nexpected a call", s)
 	}
+	var found bool
+	for _, b := range foo.BBlks {
+		for _, s := range b.Stmts {
+			if a, ok := s.(*Alloc); ok && a.Var != nil && a.Var.Name == "y" {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Errorf("foo has no alloc of y:\n%s", foo)
+	}
 }
 
-// Tests that we override the leaf-call restriction for inlining
-// in the case that the function has a Fun-type parameter.
+// Tests that a function costing more than the inlining budget is not inlined.
+func TestInlineOverBudget(t *testing.T) {
+	src := `
+		func [big: x Int ^Int |
+			` + strings.Repeat("x := x * 3 + 1.\n", inlineBudget) + `
+			^x
+		]
+		func [foo ^Int | ^big: 1]
+	`
+	mod, errs := compileReportInlines(src)
+	if len(errs) > 0 {
+		t.Fatalf("failed to compile: %s", errs)
+	}
+	foo := findTestFunBySelector(mod, "foo")
+	if s := foo.String(); strings.Contains(s, "BUG") {
+		t.Errorf("foo a bug:\n%s", s)
+	}
+	if s := foo.String(); !strings.Contains(s, "call") {
+		t.Errorf("foo contains no call:\n%s\nexpected a call", s)
+	}
+	var found bool
+	for _, in := range mod.Inlines {
+		if in.Caller == foo && !in.Inlined && strings.Contains(in.Reason, "exceeds budget") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no rejection of big: in foo in %v", mod.Inlines)
+	}
+}
+
+// Tests that a Call considered for inlining
+// both before and after devirtualization is reported once,
+// and that nothing is reported unless requested.
+func TestInlineReportOncePerCall(t *testing.T) {
+	src := `
+		func [big: x Int ^Int |
+			` + strings.Repeat("x := x * 3 + 1.\n", inlineBudget) + `
+			^x
+		]
+		type Point {x: Int y: Int}
+		meth Point [sum ^Int | ^x + y]
+		type Summer {[sum ^Int]}
+		func [foo ^Int |
+			p Point := {x: 1 y: 2}.
+			s Summer := p.
+			^s sum + (big: 1)
+		]
+	`
+	mod, errs := compile(src)
+	if len(errs) > 0 {
+		t.Fatalf("failed to compile: %s", errs)
+	}
+	if len(mod.Inlines) > 0 {
+		t.Errorf("got Inlines %v, expected none", mod.Inlines)
+	}
+
+	mod, errs = compileReportInlines(src)
+	if len(errs) > 0 {
+		t.Fatalf("failed to compile: %s", errs)
+	}
+	foo := findTestFunBySelector(mod, "foo")
+	var bigs, sums int
+	for _, in := range mod.Inlines {
+		if in.Caller != foo {
+			continue
+		}
+		switch in.Call.Fun.Fun.Sig.Sel {
+		case "big:":
+			bigs++
+		case "sum":
+			sums++
+		}
+	}
+	if bigs != 1 || sums != 1 {
+		t.Errorf("got %d big: and %d sum reports in foo, expected 1 of each: %v",
+			bigs, sums, mod.Inlines)
+	}
+}
+
+// Tests that we inline a function with a Fun-type parameter
+// along with the function that it calls.
 // Inlining this may allow further block-literal inlining,
 // which can remove expensive virt calls.
 func TestInlineFunParmFuncs(t *testing.T) {
@@ -348,6 +743,23 @@ func compile(src string) (*Mod, []error) {
 	return basicMod, nil
 }
 
+// compileReportInlines is like compile,
+// but the Mod records its Inlines.
+func compileReportInlines(src string) (*Mod, []error) {
+	p := ast.NewParser("#test")
+	if err := p.Parse("", strings.NewReader(src)); err != nil {
+		return nil, []error{err}
+	}
+	typesMod, errs := types.Check(p.Mod(), types.Config{})
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	basicMod := Build(typesMod)
+	basicMod.ReportInlines = true
+	Optimize(basicMod)
+	return basicMod, nil
+}
+
 func findTestFunBySelector(mod *Mod, sel string) *Fun {
 	for _, fun := range mod.Funs {
 		if fun.Block == nil && fun.Fun.Sig.Sel == sel {
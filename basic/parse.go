@@ -0,0 +1,1812 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+package basic
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/big"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/eaburns/pea/types"
+)
+
+// Parse returns a Mod parsed from the format printed by Mod.String.
+//
+// The printed format does not include type definitions,
+// so the types of the parsed Mod are stubs.
+// Built-in types are recognized by name.
+// The fields, cases, and virtual methods of other types
+// are only those that can be determined from their uses.
+// The types.Fun of each Fun is a stub made from its header comment;
+// it has a receiver, selector, and parameters, but no definition.
+// The Var of an Alloc is that of the parameter listed as held by it,
+// or a stub with the name printed after the Alloc.
+//
+// The type of a Val is given by the comment following its statement.
+// If there is no comment, the type is inferred from the statement.
+// This allows parsing text printed without comments,
+// as long as the types of the Vals are inferable.
+//
+// If ref is non-nil, Funs, Vars, and types that have the same name
+// as those in ref use the definitions from ref instead of stubs.
+// This allows the printed form of a Mod built from source
+// to be hand-edited and parsed back with its full type information.
+func Parse(r io.Reader, ref *Mod) (mod *Mod, err error) {
+	defer func() {
+		switch r := recover().(type) {
+		case nil:
+			return
+		case parseError:
+			mod, err = nil, r
+		default:
+			panic(r)
+		}
+	}()
+	p := newParser(ref)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		p.lines = append(p.lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	p.parseMod()
+	return p.mod, nil
+}
+
+type parseError struct {
+	line int
+	msg  string
+}
+
+func (err parseError) Error() string {
+	return fmt.Sprintf("%d: %s", err.line, err.msg)
+}
+
+type parser struct {
+	ref   *Mod
+	mod   *Mod
+	lines []string
+
+	// types are the types, by their typeKey.
+	types map[string]*types.Type
+	// modTypes are the types with a module,
+	// by the typeKey with the module removed.
+	modTypes map[string]*types.Type
+	// stubs are the types made by the parser.
+	// Fields, cases, and virtual methods are added to stubs
+	// as they are discovered from their uses.
+	stubs map[*types.Type]bool
+
+	strs map[int]*String
+	vars map[string]*Var
+	funs map[string]*Fun
+	// externs are the module variables of imported modules.
+	externs map[string]*types.Val
+
+	// fixups are called after all Funs are parsed.
+	fixups []func()
+}
+
+func newParser(ref *Mod) *parser {
+	p := &parser{
+		ref:      ref,
+		mod:      &Mod{},
+		types:    make(map[string]*types.Type),
+		modTypes: make(map[string]*types.Type),
+		stubs:    make(map[*types.Type]bool),
+		strs:     make(map[int]*String),
+		vars:     make(map[string]*Var),
+		funs:     make(map[string]*Fun),
+		externs:  make(map[string]*types.Val),
+	}
+	if ref == nil {
+		p.mod.Mod = &types.Mod{
+			IntType:  p.typ("", "Int", nil),
+			BoolType: p.typ("", "Bool", nil),
+			ByteType: p.typ("", "UInt8", nil),
+		}
+		return p
+	}
+	p.mod.Mod = ref.Mod
+	seen := make(map[*types.Type]bool)
+	p.addRefType(seen, ref.Mod.IntType)
+	p.addRefType(seen, ref.Mod.BoolType)
+	p.addRefType(seen, ref.Mod.ByteType)
+	for _, v := range ref.Vars {
+		p.addRefType(seen, v.Val.Var.Type())
+	}
+	for _, f := range ref.Funs {
+		for _, parm := range append(f.Parms, f.Ret) {
+			if parm != nil {
+				p.addRefType(seen, parm.Type)
+			}
+		}
+		for _, b := range f.BBlks {
+			for _, s := range b.Stmts {
+				if v, ok := s.(Val); ok {
+					p.addRefType(seen, v.Type())
+				}
+				switch s := s.(type) {
+				case *Switch:
+					p.addRefType(seen, s.OrType)
+				case *Global:
+					p.externs[s.Val.Var.Name] = s.Val
+				}
+			}
+		}
+	}
+	return p
+}
+
+// addRefType adds a type of the ref Mod and the types it references.
+func (p *parser) addRefType(seen map[*types.Type]bool, typ *types.Type) {
+	if typ == nil || seen[typ] {
+		return
+	}
+	seen[typ] = true
+	p.addType(typ)
+	for i := range typ.Args {
+		p.addRefType(seen, typ.Args[i].Type)
+	}
+	for i := range typ.Fields {
+		p.addRefType(seen, varType(&typ.Fields[i]))
+	}
+	for i := range typ.Cases {
+		p.addRefType(seen, varType(&typ.Cases[i]))
+	}
+}
+
+func (p *parser) errorf(line int, f string, vs ...interface{}) {
+	panic(parseError{line: line + 1, msg: fmt.Sprintf(f, vs...)})
+}
+
+// funText is the text of a Fun.
+type funText struct {
+	fun *Fun
+	// header is the line of the Fun's name.
+	header int
+	// comment is the header comment or the empty string.
+	comment string
+	// body is the line of the first BBlk.
+	body int
+	// end is the line after the last line of the Fun.
+	end int
+	// parmAllocs are the parameters held by Allocs,
+	// by the number of the Alloc's Val.
+	parmAllocs map[int]*Parm
+}
+
+func (p *parser) parseMod() {
+	funs := p.parseDefs()
+	for _, ft := range funs {
+		p.parseFunComment(ft)
+	}
+	if p.ref != nil {
+		p.useRefDefs()
+	}
+	for _, ft := range funs {
+		p.parseFunBody(ft)
+	}
+	for _, fix := range p.fixups {
+		fix()
+	}
+	for _, f := range p.mod.Funs {
+		setBlockFuns(f)
+	}
+	for _, f := range p.mod.Funs {
+		if f.Block == nil {
+			f.CanFarRet = canFarRet(f)
+		}
+	}
+	for _, v := range p.mod.Vars {
+		v.N = p.mod.NDefs
+		p.mod.NDefs++
+	}
+}
+
+// parseDefs parses the module-level Strings and Vars
+// and the headers of the Funs, whose funTexts are returned.
+func (p *parser) parseDefs() []*funText {
+	var funs []*funText
+	for i := 0; i < len(p.lines); {
+		line := p.lines[i]
+		switch {
+		case line == "":
+			i++
+		case strings.HasPrefix(line, "\t"):
+			p.errorf(i, "unexpected indentation")
+		case isDefName(line, "string"):
+			i = p.parseString(i)
+		case isDefName(strings.SplitN(line, " // ", 2)[0], "function") ||
+			isDefName(strings.SplitN(line, " // ", 2)[0], "block"):
+			var ft *funText
+			ft, i = p.parseFunHeader(i)
+			funs = append(funs, ft)
+		default:
+			i = p.parseVar(i)
+		}
+	}
+	return funs
+}
+
+// isDefName returns whether s is the name of a module-level def
+// with the given kind, for example, string5 or function3.
+func isDefName(s, kind string) bool {
+	if !strings.HasPrefix(s, kind) {
+		return false
+	}
+	_, err := strconv.Atoi(s[len(kind):])
+	return err == nil
+}
+
+func (p *parser) defN(s, kind string) int {
+	n, _ := strconv.Atoi(s[len(kind):])
+	if n >= p.mod.NDefs {
+		p.mod.NDefs = n + 1
+	}
+	return n
+}
+
+func (p *parser) parseString(i int) int {
+	n := p.defN(p.lines[i], "string")
+	if _, ok := p.strs[n]; ok {
+		p.errorf(i, "string%d redefined", n)
+	}
+	if i+1 >= len(p.lines) || !strings.HasPrefix(p.lines[i+1], "\t") {
+		p.errorf(i, "string%d has no data", n)
+	}
+	data, err := strconv.Unquote(strings.TrimPrefix(p.lines[i+1], "\t"))
+	if err != nil {
+		p.errorf(i+1, "bad string data: %s", err)
+	}
+	str := &String{Mod: p.mod, N: n, Data: data}
+	p.strs[n] = str
+	p.mod.Strings = append(p.mod.Strings, str)
+	return i + 2
+}
+
+func (p *parser) parseVar(i int) int {
+	fields := strings.SplitN(p.lines[i], " ", 2)
+	if len(fields) != 2 {
+		p.errorf(i, "expected a module variable name and type")
+	}
+	name := fields[0]
+	if _, ok := p.vars[name]; ok {
+		p.errorf(i, "variable %s redefined", name)
+	}
+	typ := p.parseType(i, fields[1])
+	v := &Var{
+		Mod: p.mod,
+		Val: &types.Val{
+			Var: types.Var{Name: name, TypeName: makeTypeName(typ)},
+		},
+	}
+	p.vars[name] = v
+	p.mod.Vars = append(p.mod.Vars, v)
+	return i + 1
+}
+
+func (p *parser) parseFunHeader(i int) (*funText, int) {
+	fields := strings.SplitN(p.lines[i], " // ", 2)
+	f := &Fun{Mod: p.mod}
+	kind := "function"
+	if strings.HasPrefix(fields[0], "block") {
+		kind = "block"
+		f.Block = &types.Block{}
+	}
+	f.N = p.defN(fields[0], kind)
+	if _, ok := p.funs[fields[0]]; ok {
+		p.errorf(i, "%s redefined", fields[0])
+	}
+	p.funs[fields[0]] = f
+	p.mod.Funs = append(p.mod.Funs, f)
+
+	ft := &funText{fun: f, header: i, parmAllocs: make(map[int]*Parm)}
+	if len(fields) == 2 {
+		ft.comment = fields[1]
+	}
+	i++
+	if i < len(p.lines) && strings.HasPrefix(p.lines[i], "\tcan inline: ") {
+		s := strings.TrimPrefix(p.lines[i], "\tcan inline: ")
+		canInline, err := strconv.ParseBool(s)
+		if err != nil {
+			p.errorf(i, "bad can inline value: %s", s)
+		}
+		f.CanInline = canInline
+		i++
+	}
+	i = p.parseParms(ft, i)
+	ft.body = i
+	for i < len(p.lines) && (p.lines[i] == "" || strings.HasPrefix(p.lines[i], "\t")) {
+		i++
+	}
+	ft.end = i
+	return ft, i
+}
+
+// parseParms parses the parms: section of a Fun beginning at line i
+// and returns the line following it.
+func (p *parser) parseParms(ft *funText, i int) int {
+	if i >= len(p.lines) || p.lines[i] != "\tparms:" {
+		p.errorf(i, "expected parms:")
+	}
+	i++
+	f := ft.fun
+	var parms []*Parm
+	for ; i < len(p.lines) && strings.HasPrefix(p.lines[i], "\t\t"); i++ {
+		parm, alloc := p.parseParm(i, strings.TrimPrefix(p.lines[i], "\t\t"))
+		if alloc >= 0 {
+			ft.parmAllocs[alloc] = parm
+		}
+		parms = append(parms, parm)
+	}
+	// The Ret parameter has no Var and is printed last,
+	// but a block self parameter also has no Var.
+	if n := len(parms); n > 0 && parms[n-1].Var == nil && (f.Block == nil || n > 1) {
+		f.Ret = parms[n-1]
+		parms = parms[:n-1]
+	}
+	f.Parms = parms
+	if f.Block != nil && len(f.Parms) > 0 {
+		f.Parms[0].Self = true
+		if typ := f.Parms[0].Type; typ.BuiltIn == types.RefType {
+			f.Block.BlockType = typ.Args[0].Type
+		}
+	}
+	return i
+}
+
+// parseParm returns the parsed Parm and the number of the Alloc holding it,
+// or -1 if there is none.
+func (p *parser) parseParm(i int, s string) (*Parm, int) {
+	var parm Parm
+	alloc := -1
+	if j := strings.LastIndex(s, " (alloc $"); j >= 0 && strings.HasSuffix(s, ")") {
+		n, err := strconv.Atoi(s[j+len(" (alloc $") : len(s)-1])
+		if err != nil {
+			p.errorf(i, "bad parameter alloc: %s", s[j+1:])
+		}
+		alloc = n
+		s = s[:j]
+	}
+	if strings.HasSuffix(s, " (value)") {
+		parm.Value = true
+		s = strings.TrimSuffix(s, " (value)")
+	}
+	fields := strings.SplitN(s, " ", 2)
+	n, err := strconv.Atoi(fields[0])
+	if err != nil || len(fields) != 2 {
+		p.errorf(i, "expected a parameter number and type")
+	}
+	parm.N = n
+	s = fields[1]
+	if strings.HasPrefix(s, "[") {
+		j := strings.Index(s, "] ")
+		if j < 0 {
+			p.errorf(i, "expected a parameter name and type")
+		}
+		parm.Var = &types.Var{Name: s[1:j]}
+		s = s[j+2:]
+	}
+	parm.Type = p.parseType(i, s)
+	if parm.Var != nil {
+		parm.Self = parm.N == 0 && parm.Var.Name == "self"
+		parm.Var.TypeName = makeTypeName(parm.Type)
+	}
+	return &parm, alloc
+}
+
+// parseFunComment parses the header comment of a Fun:
+// either init, val followed by a module variable name,
+// or the types.Fun.
+func (p *parser) parseFunComment(ft *funText) {
+	f := ft.fun
+	switch {
+	case ft.comment == "":
+		return
+	case ft.comment == "init":
+		if f.Block == nil {
+			p.mod.Init = f
+		}
+	case strings.HasPrefix(ft.comment, "val "):
+		name := strings.TrimPrefix(ft.comment, "val ")
+		v, ok := p.vars[name]
+		if !ok {
+			p.errorf(ft.header, "variable %s not found", name)
+		}
+		f.Val = v.Val
+		if f.Block == nil {
+			v.Init = f
+		}
+	default:
+		// Header types are printed from the TypeNames of the types.Fun,
+		// which can differ from the body types in their spacing,
+		// so they are parsed into their own stub types.
+		hp := &parser{
+			mod:      p.mod,
+			types:    make(map[string]*types.Type),
+			modTypes: make(map[string]*types.Type),
+			stubs:    make(map[*types.Type]bool),
+		}
+		f.Fun = hp.parseTypesFun(ft.header, ft.comment)
+	}
+}
+
+// useRefDefs replaces definitions with those of the same name in the ref Mod.
+func (p *parser) useRefDefs() {
+	for _, v := range p.ref.Vars {
+		if pv, ok := p.vars[v.Val.Var.Name]; ok {
+			pv.Val = v.Val
+			if v.Init != nil && pv.Init == nil {
+				pv.Init = p.funs[v.Init.name()]
+			}
+		}
+	}
+	if p.ref.Init != nil && p.mod.Init == nil {
+		p.mod.Init = p.funs[p.ref.Init.name()]
+	}
+	for _, f := range p.ref.Funs {
+		pf, ok := p.funs[f.name()]
+		if !ok {
+			continue
+		}
+		pf.Fun = f.Fun
+		pf.Block = f.Block
+		pf.Val = f.Val
+		for _, parm := range pf.Parms {
+			for _, refParm := range f.Parms {
+				if refParm.N == parm.N && refParm.Var != nil &&
+					parm.Var != nil && refParm.Var.Name == parm.Var.Name {
+					parm.Var = refParm.Var
+				}
+			}
+		}
+	}
+}
+
+// setBlockFuns sets the Block and BlockFun of the MakeAnds of block literals.
+func setBlockFuns(f *Fun) {
+	ands := make(map[Val]*MakeAnd)
+	for _, b := range f.BBlks {
+		for _, s := range b.Stmts {
+			if s, ok := s.(*MakeAnd); ok {
+				ands[s.Dst] = s
+			}
+		}
+	}
+	for _, b := range f.BBlks {
+		for _, s := range b.Stmts {
+			s, ok := s.(*MakeVirt)
+			if !ok || len(s.Virts) != 1 || s.Virts[0].Block == nil || s.Obj == nil {
+				continue
+			}
+			if and, ok := ands[s.Obj]; ok {
+				and.Block = s.Virts[0].Block
+				and.BlockFun = s.Virts[0]
+			}
+		}
+	}
+}
+
+// stmtText is the text of a single statement.
+type stmtText struct {
+	line    int
+	text    string
+	deleted bool
+
+	stmt Stmt
+	// parsing is true while the statement is being parsed;
+	// it is used to detect cyclic definitions.
+	parsing bool
+}
+
+type funParser struct {
+	*parser
+	fun   *Fun
+	bblks map[int]*BBlk
+	// defs are the statements defining each Val.
+	defs map[int]*stmtText
+	// parmAllocs are the parameters held by Allocs,
+	// by the number of the Alloc's Val.
+	parmAllocs map[int]*Parm
+}
+
+func (p *parser) parseFunBody(ft *funText) {
+	f := ft.fun
+	fp := &funParser{
+		parser:     p,
+		fun:        f,
+		bblks:      make(map[int]*BBlk),
+		defs:       make(map[int]*stmtText),
+		parmAllocs: ft.parmAllocs,
+	}
+	var bblks []*bblkText
+	for i := ft.body; i < ft.end; i++ {
+		line := p.lines[i]
+		switch {
+		case line == "" || strings.HasPrefix(line, "\t\t// BUG: "):
+			continue
+		case !strings.HasPrefix(line, "\t\t") && strings.HasSuffix(line, ":"):
+			var bt *bblkText
+			bt, i = fp.parseBBlkHeader(ft, i)
+			bblks = append(bblks, bt)
+		case len(bblks) == 0:
+			p.errorf(i, "expected a BBlk")
+		default:
+			bt := bblks[len(bblks)-1]
+			bt.stmts = append(bt.stmts, fp.addStmtText(i))
+		}
+	}
+	for _, bt := range bblks {
+		fp.parseIn(bt.line, bt.in, bt.bblk)
+		for _, st := range bt.stmts {
+			s := fp.parseStmt(st)
+			if st.deleted {
+				s.delete()
+			}
+			bt.bblk.Stmts = append(bt.bblk.Stmts, s)
+			for _, v := range s.Uses() {
+				v.value().addUser(s)
+			}
+		}
+	}
+}
+
+// A bblkText is the text of a BBlk.
+type bblkText struct {
+	bblk *BBlk
+	// line is the line of in.
+	line  int
+	in    string
+	stmts []*stmtText
+}
+
+// parseBBlkHeader parses the number and [in: ...] line of a BBlk
+// beginning at line i.
+// It returns the bblkText and the line of [in: ...].
+func (fp *funParser) parseBBlkHeader(ft *funText, i int) (*bblkText, int) {
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(fp.lines[i], "\t"), ":"))
+	if err != nil {
+		fp.errorf(i, "expected a BBlk number")
+	}
+	if _, ok := fp.bblks[n]; ok {
+		fp.errorf(i, "BBlk %d redefined", n)
+	}
+	b := &BBlk{N: n}
+	fp.bblks[n] = b
+	fp.fun.BBlks = append(fp.fun.BBlks, b)
+	i++
+	if i >= ft.end || !strings.HasPrefix(fp.lines[i], "\t\t[in:") {
+		fp.errorf(i, "expected [in: ...]")
+	}
+	return &bblkText{bblk: b, line: i, in: fp.lines[i]}, i
+}
+
+// addStmtText returns the stmtText of the statement on line i,
+// recording it as the definition of its Val, if any.
+func (fp *funParser) addStmtText(i int) *stmtText {
+	st := &stmtText{line: i, text: strings.TrimPrefix(fp.lines[i], "\t\t")}
+	if strings.HasPrefix(st.text, "ⓧ ") {
+		st.deleted = true
+		st.text = strings.TrimPrefix(st.text, "ⓧ ")
+	}
+	if n, ok := valNum(st.text); ok {
+		if _, ok := fp.defs[n]; ok {
+			fp.errorf(i, "$%d redefined", n)
+		}
+		fp.defs[n] = st
+		if n >= fp.fun.NVals {
+			fp.fun.NVals = n + 1
+		}
+	}
+	return st
+}
+
+// valNum returns the number of the Val defined by a statement, if any.
+func valNum(s string) (int, bool) {
+	i := strings.Index(s, " := ")
+	if !strings.HasPrefix(s, "$") || i < 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s[1:i])
+	return n, err == nil
+}
+
+func (fp *funParser) parseIn(i int, s string, b *BBlk) {
+	s = strings.TrimPrefix(s, "\t\t[in:")
+	j := strings.Index(s, "]")
+	if j < 0 {
+		fp.errorf(i, "expected ]")
+	}
+	for _, f := range strings.Fields(s[:j]) {
+		b.In = append(b.In, fp.bblk(i, f))
+	}
+}
+
+func (fp *funParser) bblk(i int, s string) *BBlk {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		fp.errorf(i, "expected a BBlk number, got %s", s)
+	}
+	b, ok := fp.bblks[n]
+	if !ok {
+		fp.errorf(i, "BBlk %d not found", n)
+	}
+	return b
+}
+
+func (fp *funParser) val(i int, s string) Val {
+	if !strings.HasPrefix(s, "$") {
+		fp.errorf(i, "expected a value, got %s", s)
+	}
+	n, err := strconv.Atoi(s[1:])
+	if err != nil {
+		fp.errorf(i, "expected a value, got %s", s)
+	}
+	st, ok := fp.defs[n]
+	if !ok {
+		fp.errorf(i, "$%d is not defined", n)
+	}
+	return fp.parseStmt(st).(Val)
+}
+
+func (fp *funParser) vals(i int, s, sep string) []Val {
+	var vals []Val
+	if s == "" {
+		return vals
+	}
+	for _, v := range strings.Split(s, sep) {
+		vals = append(vals, fp.val(i, v))
+	}
+	return vals
+}
+
+func (fp *funParser) parseStmt(st *stmtText) Stmt {
+	switch {
+	case st.stmt != nil:
+		return st.stmt
+	case st.parsing:
+		fp.errorf(st.line, "cyclic definition")
+	}
+	st.parsing = true
+	text := st.text
+	if strings.HasPrefix(text, "//") {
+		st.stmt = &Comment{Text: strings.TrimPrefix(strings.TrimPrefix(text, "//"), " ")}
+		return st.stmt
+	}
+	var comment string
+	if j := strings.Index(text, " // "); j >= 0 {
+		comment = text[j+len(" // "):]
+		text = strings.TrimRight(text[:j], " ")
+	}
+	if n, ok := valNum(text); ok {
+		text = text[strings.Index(text, " := ")+len(" := "):]
+		var typ *types.Type
+		if comment != "" {
+			typ = fp.parseType(st.line, comment)
+		}
+		if strings.HasPrefix(text, "phi(") {
+			return fp.parsePhi(st, n, typ, text)
+		}
+		st.stmt = fp.parseVal(st.line, n, typ, text)
+	} else {
+		st.stmt = fp.parseNonVal(st.line, text)
+	}
+	return st.stmt
+}
+
+// nonValParsers are the parsers of the statements that are not Vals,
+// by their op name.
+// It is set by init to break the initialization cycle
+// through the parsing of the Vals used by the statements.
+var nonValParsers map[string]func(fp *funParser, i int, text string) Stmt
+
+func init() {
+	nonValParsers = map[string]func(*funParser, int, string) Stmt{
+		"store(":  (*funParser).parseStore,
+		"copy(":   (*funParser).parseCopy,
+		"array(":  (*funParser).parseArray,
+		"slice(":  (*funParser).parseMakeSlice,
+		"string(": (*funParser).parseMakeString,
+		"and(":    (*funParser).parseMakeAnd,
+		"or(":     (*funParser).parseMakeOr,
+		"virt ":   (*funParser).parseVirtCall,
+		"virt(":   (*funParser).parseMakeVirt,
+		"call ":   (*funParser).parseCall,
+		"return":  (*funParser).parseRet,
+		"far ":    (*funParser).parseRet,
+		"panic(":  (*funParser).parsePanic,
+		"jmp ":    (*funParser).parseJmp,
+		"switch ": (*funParser).parseSwitch,
+	}
+	valParsers = map[string]func(*funParser, int, int, *types.Type, string) (Val, *types.Type){
+		"load(":   (*funParser).parseLoad,
+		"alloc(":  (*funParser).parseAlloc,
+		"alloca(": (*funParser).parseAlloc,
+		"arg(":    (*funParser).parseArg,
+		"global(": (*funParser).parseGlobal,
+		"size(":   (*funParser).parseSize,
+		"tag(":    (*funParser).parseTag,
+	}
+}
+
+// opName returns the name of the op of a statement's text:
+// the text up to and including the first ( or space,
+// or the entire text if there is neither.
+func opName(text string) string {
+	j := strings.IndexAny(text, "( ")
+	if j < 0 {
+		return text
+	}
+	return text[:j+1]
+}
+
+func (fp *funParser) parseNonVal(i int, text string) Stmt {
+	parse, ok := nonValParsers[opName(text)]
+	if !ok {
+		fp.errorf(i, "unknown statement: %s", text)
+	}
+	return parse(fp, i, text)
+}
+
+func (fp *funParser) parseStore(i int, text string) Stmt {
+	args := fp.args(i, text, "store(", ")", 2)
+	return &Store{Dst: fp.val(i, args[0]), Val: fp.val(i, args[1])}
+}
+
+func (fp *funParser) parseCopy(i int, text string) Stmt {
+	args := fp.args(i, text, "copy(", ")", 3)
+	return &Copy{Dst: fp.val(i, args[0]), Src: fp.val(i, args[1])}
+}
+
+// parseArray parses a MakeArray, with its elements in braces,
+// or a NewArray, with its size.
+func (fp *funParser) parseArray(i int, text string) Stmt {
+	args := fp.args(i, text, "array(", ")", 2)
+	dst := fp.val(i, args[0])
+	if !strings.Contains(text, "{") {
+		return &NewArray{Dst: dst, Size: fp.val(i, args[1])}
+	}
+	elems := fp.braces(i, args[1])
+	s := &MakeArray{Dst: dst}
+	if elems != "" {
+		for _, e := range strings.Split(elems, ", ") {
+			s.Args = append(s.Args, fp.val(i, strings.TrimPrefix(e, "*")))
+		}
+	}
+	return s
+}
+
+func (fp *funParser) parseMakeSlice(i int, text string) Stmt {
+	args := fp.args(i, text, "slice(", ")", 2)
+	var ary, from, to string
+	if _, err := fmt.Sscanf(strings.NewReplacer("[", " ", ":", " ", "]", " ").Replace(args[1]), "%s %s %s", &ary, &from, &to); err != nil {
+		fp.errorf(i, "expected $ary[$from:$to]")
+	}
+	return &MakeSlice{
+		Dst:  fp.val(i, args[0]),
+		Ary:  fp.val(i, ary),
+		From: fp.val(i, from),
+		To:   fp.val(i, to),
+	}
+}
+
+// parseMakeString parses a MakeString, of a module-level String,
+// or a NewString, of an array.
+func (fp *funParser) parseMakeString(i int, text string) Stmt {
+	args := fp.args(i, text, "string(", ")", 2)
+	dst := fp.val(i, args[0])
+	if !isDefName(args[1], "string") {
+		return &NewString{Dst: dst, Data: fp.val(i, args[1])}
+	}
+	n, _ := strconv.Atoi(strings.TrimPrefix(args[1], "string"))
+	str, ok := fp.strs[n]
+	if !ok {
+		fp.errorf(i, "%s not found", args[1])
+	}
+	return &MakeString{Dst: dst, Data: str}
+}
+
+func (fp *funParser) parseMakeVirt(i int, text string) Stmt {
+	j := strings.Index(text, "{")
+	if j < 0 {
+		fp.errorf(i, "expected {...}")
+	}
+	args := fp.args(i, text[:j], "virt(", ", ", -1)
+	s := &MakeVirt{Dst: fp.val(i, args[0])}
+	if len(args) == 2 {
+		s.Obj = fp.val(i, args[1])
+	}
+	for _, name := range strings.Split(fp.braces(i, strings.TrimSuffix(text[j:], ")")), ", ") {
+		s.Virts = append(s.Virts, fp.funNamed(i, name))
+	}
+	if virtType, ok := fp.refElem(s.Dst); ok {
+		fp.learnVirts(virtType, len(s.Virts))
+	}
+	return s
+}
+
+func (fp *funParser) parseCall(i int, text string) Stmt {
+	j := strings.Index(text, "(")
+	if j < 0 {
+		fp.errorf(i, "expected (")
+	}
+	args := fp.args(i, text[j:], "(", ")", -1)
+	return &Call{
+		Fun:  fp.funNamed(i, text[len("call "):j]),
+		Args: fp.vals(i, strings.Join(args, ", "), ", "),
+	}
+}
+
+func (fp *funParser) parseRet(i int, text string) Stmt {
+	switch text {
+	case "return":
+		return &Ret{}
+	case "far return":
+		return &Ret{Far: true}
+	default:
+		fp.errorf(i, "unknown statement: %s", text)
+		panic("impossible")
+	}
+}
+
+func (fp *funParser) parsePanic(i int, text string) Stmt {
+	args := fp.args(i, text, "panic(", ")", 1)
+	return &Panic{Arg: fp.val(i, args[0])}
+}
+
+func (fp *funParser) parseJmp(i int, text string) Stmt {
+	return &Jmp{Dst: fp.bblk(i, strings.TrimPrefix(text, "jmp "))}
+}
+
+// args returns the comma-separated arguments
+// between the prefix and suffix of the text.
+// If n >= 0, exactly n arguments are expected,
+// and the final argument is the remainder of the text.
+func (fp *funParser) args(i int, text, prefix, suffix string, n int) []string {
+	if !strings.HasPrefix(text, prefix) || !strings.HasSuffix(text, suffix) {
+		fp.errorf(i, "expected %s...%s", prefix, suffix)
+	}
+	text = strings.TrimSuffix(strings.TrimPrefix(text, prefix), suffix)
+	if text == "" && n <= 0 {
+		return nil
+	}
+	args := strings.SplitN(text, ", ", n)
+	if n >= 0 && len(args) != n {
+		fp.errorf(i, "expected %d arguments, got %d", n, len(args))
+	}
+	return args
+}
+
+func (fp *funParser) braces(i int, s string) string {
+	if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+		fp.errorf(i, "expected {...}")
+	}
+	return s[1 : len(s)-1]
+}
+
+func (fp *funParser) funNamed(i int, name string) *Fun {
+	f, ok := fp.funs[name]
+	if !ok {
+		fp.errorf(i, "%s not found", name)
+	}
+	return f
+}
+
+func (fp *funParser) parseMakeAnd(i int, text string) Stmt {
+	args := fp.args(i, text, "and(", ")", 2)
+	s := &MakeAnd{Dst: fp.val(i, args[0])}
+	andType, isRef := fp.refElem(s.Dst)
+	fields := strings.Fields(fp.braces(i, args[1]))
+	for j := 0; j < len(fields); j++ {
+		var name string
+		if strings.HasSuffix(fields[j], ":") {
+			name = strings.TrimSuffix(fields[j], ":")
+			if j++; j == len(fields) {
+				fp.errorf(i, "expected a value for field %s", name)
+			}
+		}
+		var field Val
+		var typ *types.Type
+		switch f := fields[j]; {
+		case f == "{}":
+			typ = fp.typ("", "Nil", nil)
+		case strings.HasPrefix(f, "*"):
+			field = fp.val(i, f[1:])
+			var ok bool
+			if typ, ok = fp.refElem(field); !ok {
+				fp.errorf(i, "field %s is not a reference", f)
+			}
+		default:
+			field = fp.val(i, f)
+			typ = field.Type()
+		}
+		if isRef {
+			fp.learnField(andType, len(s.Fields), name, typ)
+		}
+		s.Fields = append(s.Fields, field)
+	}
+	return s
+}
+
+func (fp *funParser) parseMakeOr(i int, text string) Stmt {
+	args := fp.args(i, text, "or(", ")", 2)
+	s := &MakeOr{Dst: fp.val(i, args[0])}
+	cas := fp.braces(i, args[1])
+	j := strings.Index(cas, "=")
+	if j < 0 {
+		fp.errorf(i, "expected {case=name}")
+	}
+	n, err := strconv.Atoi(cas[:j])
+	if err != nil {
+		fp.errorf(i, "bad case number %s", cas[:j])
+	}
+	s.Case = n
+	fields := strings.Fields(cas[j+1:])
+	if len(fields) == 0 || len(fields) > 2 {
+		fp.errorf(i, "expected {case=name} or {case=name $value}")
+	}
+	name := fields[0]
+	var typ *types.Type
+	switch {
+	case len(fields) == 2 && strings.HasPrefix(fields[1], "*"):
+		s.Val = fp.val(i, fields[1][1:])
+		var ok bool
+		if typ, ok = fp.refElem(s.Val); !ok {
+			fp.errorf(i, "case %s value is not a reference", name)
+		}
+	case len(fields) == 2:
+		s.Val = fp.val(i, fields[1])
+		typ = s.Val.Type()
+	case strings.HasSuffix(name, ":"):
+		typ = fp.typ("", "Nil", nil)
+	}
+	if orType, ok := fp.refElem(s.Dst); ok {
+		fp.learnCase(orType, n, name, typ)
+	}
+	return s
+}
+
+func (fp *funParser) parseVirtCall(i int, text string) Stmt {
+	text = strings.TrimPrefix(text, "virt call ")
+	j := strings.Index(text, "(")
+	if j < 0 {
+		fp.errorf(i, "expected (")
+	}
+	callee, args := text[:j], fp.args(i, text[j:], "(", ")", -1)
+	var sel string
+	if k := strings.Index(callee, " ["); k >= 0 {
+		sel = strings.TrimSuffix(callee[k+2:], "]")
+		callee = callee[:k]
+	}
+	k := strings.LastIndex(callee, ".")
+	if k < 0 {
+		fp.errorf(i, "expected $self.index")
+	}
+	index, err := strconv.Atoi(callee[k+1:])
+	if err != nil {
+		fp.errorf(i, "bad virtual method index %s", callee[k+1:])
+	}
+	s := &VirtCall{
+		Self:  fp.val(i, callee[:k]),
+		Index: index,
+		Args:  fp.vals(i, strings.Join(args, ", "), ", "),
+	}
+	if sel != "" {
+		s.Msg = &types.Msg{Sel: sel}
+	}
+	if virtType, ok := fp.refElem(s.Self); ok && len(s.Args) > 0 {
+		var parms []*types.Type
+		for _, a := range s.Args[1:] {
+			parms = append(parms, a.Type())
+		}
+		fp.learnVirt(virtType, index, sel, parms)
+	}
+	return s
+}
+
+func (fp *funParser) parseSwitch(i int, text string) Stmt {
+	text = strings.TrimPrefix(text, "switch ")
+	j := strings.Index(text, " ")
+	if j < 0 {
+		j = len(text)
+	}
+	s := &Switch{Val: fp.val(i, text[:j])}
+	var names []string
+	for text = text[j:]; text != ""; {
+		if !strings.HasPrefix(text, " [") {
+			fp.errorf(i, "expected [case BBlk]")
+		}
+		k := strings.Index(text, "]")
+		if k < 0 {
+			fp.errorf(i, "expected ]")
+		}
+		cas := text[2:k]
+		text = text[k+1:]
+		l := strings.LastIndex(cas, " ")
+		if l < 0 {
+			fp.errorf(i, "expected [case BBlk]")
+		}
+		names = append(names, cas[:l])
+		s.Dsts = append(s.Dsts, fp.bblk(i, cas[l+1:]))
+	}
+	if op, ok := s.Val.(*Op); ok && op.Code == UnionTagOp {
+		s.OrType, _ = fp.refElem(op.Args[0])
+	} else if len(s.Val.Type().Cases) > 0 || fp.stubs[s.Val.Type()] {
+		s.OrType = s.Val.Type()
+	}
+	if s.OrType == nil {
+		fp.errorf(i, "cannot determine the or-type of switch on $%d", s.Val.Num())
+	}
+	for j, name := range names {
+		var typ *types.Type
+		if strings.HasSuffix(name, ":") {
+			typ = fp.typ("", "Nil", nil)
+		}
+		fp.learnCase(s.OrType, j, name, typ)
+	}
+	return s
+}
+
+// valParsers are the parsers of the Vals that begin with an op name,
+// by their op name.
+// It is set by init to break the initialization cycle
+// through the parsing of the Vals used by the Vals.
+// Each returns the Val and its type inferred from the text.
+var valParsers map[string]func(fp *funParser, i, n int, typ *types.Type, text string) (Val, *types.Type)
+
+func (fp *funParser) parseVal(i, n int, typ *types.Type, text string) Val {
+	// inferred is the type of the Val if typ is nil.
+	var inferred *types.Type
+	var v Val
+	if parse, ok := valParsers[opName(text)]; ok {
+		v, inferred = parse(fp, i, n, typ, text)
+	} else {
+		v, inferred = fp.parseOpVal(i, typ, text)
+	}
+	if typ == nil {
+		typ = inferred
+	}
+	if typ == nil {
+		fp.errorf(i, "cannot infer the type of $%d", n)
+	}
+	*v.value() = val{n: n, typ: typ}
+	return v
+}
+
+func (fp *funParser) parseLoad(i, _ int, _ *types.Type, text string) (Val, *types.Type) {
+	args := fp.args(i, text, "load(", ")", 1)
+	s := &Load{Src: fp.val(i, args[0])}
+	inferred, _ := fp.refElem(s.Src)
+	return s, inferred
+}
+
+func (fp *funParser) parseAlloc(i, n int, _ *types.Type, text string) (Val, *types.Type) {
+	s := &Alloc{Stack: strings.HasPrefix(text, "alloca(")}
+	var name string
+	if j := strings.LastIndex(text, ") ["); j >= 0 && strings.HasSuffix(text, "]") {
+		name = strings.TrimSuffix(text[j+3:], "]")
+		text = text[:j+1]
+	}
+	j := strings.Index(text, "(")
+	elem := fp.parseType(i, text[j+1:len(text)-1])
+	if parm, ok := fp.parmAllocs[n]; ok {
+		s.Var = parm.Var
+	} else if name != "" {
+		s.Var = &types.Var{Name: name, TypeName: makeTypeName(elem)}
+	}
+	return s, fp.refType(elem)
+}
+
+func (fp *funParser) parseArg(i, _ int, _ *types.Type, text string) (Val, *types.Type) {
+	args := fp.args(i, text, "arg(", ")", 1)
+	fields := strings.SplitN(args[0], " ", 2)
+	parmN, err := strconv.Atoi(fields[0])
+	if err != nil {
+		fp.errorf(i, "bad parameter number %s", fields[0])
+	}
+	var name string
+	if len(fields) == 2 {
+		name = strings.TrimSuffix(strings.TrimPrefix(fields[1], "["), "]")
+	}
+	s := &Arg{Parm: fp.parm(i, parmN, name)}
+	return s, s.Parm.Type
+}
+
+func (fp *funParser) parseGlobal(i, _ int, typ *types.Type, text string) (Val, *types.Type) {
+	args := fp.args(i, text, "global(", ")", 1)
+	s := &Global{Val: fp.global(i, args[0], typ)}
+	return s, fp.refType(varType(&s.Val.Var))
+}
+
+func (fp *funParser) parseSize(i, _ int, _ *types.Type, text string) (Val, *types.Type) {
+	args := fp.args(i, text, "size(", ")", 1)
+	return &Op{Code: ArraySizeOp, Args: []Val{fp.val(i, args[0])}}, fp.mod.Mod.IntType
+}
+
+func (fp *funParser) parseTag(i, _ int, _ *types.Type, text string) (Val, *types.Type) {
+	args := fp.args(i, text, "tag(", ")", 1)
+	return &Op{Code: UnionTagOp, Args: []Val{fp.val(i, args[0])}}, fp.mod.Mod.ByteType
+}
+
+// parseOpVal parses the Vals that do not begin with an op name:
+// indices, fields, operators, literals, and conversions.
+func (fp *funParser) parseOpVal(i int, typ *types.Type, text string) (Val, *types.Type) {
+	switch {
+	case strings.HasPrefix(text, "$"):
+		j := strings.IndexAny(text, "[. ")
+		if j < 0 {
+			fp.errorf(i, "unknown value: %s", text)
+		}
+		x := fp.val(i, text[:j])
+		switch text[j] {
+		case '[':
+			return fp.parseIndex(i, x, text[j+1:])
+		case '.':
+			return fp.parseField(i, x, typ, text[j+1:])
+		default:
+			return fp.parseBinaryOp(i, x, text[j:])
+		}
+
+	case strings.HasPrefix(text, "-$"):
+		x := fp.val(i, text[1:])
+		return &Op{Code: NegOp, Args: []Val{x}}, x.Type()
+
+	case strings.HasPrefix(text, "!$"):
+		x := fp.val(i, text[1:])
+		return &Op{Code: BitwiseNotOp, Args: []Val{x}}, x.Type()
+
+	case len(text) > 0 && (text[0] == '-' || text[0] == '+' || unicode.IsDigit(rune(text[0]))):
+		return fp.parseLit(i, typ, text)
+
+	case strings.HasSuffix(text, ")") && strings.Contains(text, "($"):
+		j := strings.LastIndex(text, "($")
+		return &Op{Code: NumConvertOp, Args: []Val{fp.val(i, text[j+1:len(text)-1])}},
+			fp.parseType(i, text[:j])
+
+	default:
+		fp.errorf(i, "unknown value: %s", text)
+		panic("impossible")
+	}
+}
+
+// parseIndex parses an Index of ary, given the text following its [.
+func (fp *funParser) parseIndex(i int, ary Val, text string) (Val, *types.Type) {
+	s := &Index{Ary: ary, Index: fp.val(i, strings.TrimSuffix(text, "]"))}
+	elem, ok := fp.refElem(ary)
+	switch {
+	case ok && elem.BuiltIn == types.StringType:
+		return s, fp.mod.Mod.ByteType
+	case ok && elem.BuiltIn == types.ArrayType:
+		return s, fp.refType(elem.Args[0].Type)
+	default:
+		return s, nil
+	}
+}
+
+// parseBinaryOp parses a binary Op with the left operand x,
+// given the text following x.
+func (fp *funParser) parseBinaryOp(i int, x Val, text string) (Val, *types.Type) {
+	fields := strings.Fields(text)
+	if len(fields) != 2 {
+		fp.errorf(i, "expected $x op $y")
+	}
+	code, ok := binaryOps[fields[0]]
+	if !ok {
+		fp.errorf(i, "unknown operator %s", fields[0])
+	}
+	s := &Op{Code: code, Args: []Val{x, fp.val(i, fields[1])}}
+	if code >= EqOp && code <= GreaterEqOp {
+		return s, fp.mod.Mod.BoolType
+	}
+	return s, x.Type()
+}
+
+// parsePhi parses the text of a Phi.
+// The arguments of a Phi in a loop can depend on the Phi itself,
+// so st.stmt is set before the arguments are parsed.
+func (fp *funParser) parsePhi(st *stmtText, n int, typ *types.Type, text string) *Phi {
+	i := st.line
+	var name string
+	if j := strings.Index(text, ") ["); j >= 0 {
+		name = strings.TrimSuffix(text[j+3:], "]")
+		text = text[:j+1]
+	}
+	phi := &Phi{val: val{n: n, typ: typ}}
+	st.stmt = phi
+	for _, arg := range fp.args(i, text, "phi(", ")", -1) {
+		fields := strings.Split(arg, ": ")
+		if len(fields) != 2 {
+			fp.errorf(i, "expected BBlk: value, got %s", arg)
+		}
+		phi.In = append(phi.In, fp.bblk(i, fields[0]))
+		phi.Args = append(phi.Args, fp.val(i, fields[1]))
+	}
+	for _, arg := range phi.Args {
+		if phi.typ != nil {
+			break
+		}
+		phi.typ = arg.Type()
+	}
+	if phi.typ == nil {
+		fp.errorf(i, "cannot infer the type of $%d", n)
+	}
+	if name != "" {
+		phi.Var = &types.Var{Name: name, TypeName: makeTypeName(phi.typ)}
+	}
+	return phi
+}
+
+var binaryOps = map[string]OpCode{
+	"&":  BitwiseAndOp,
+	"|":  BitwiseOrOp,
+	"^":  BitwiseXOrOp,
+	">>": RightShiftOp,
+	"<<": LeftShiftOp,
+	"+":  PlusOp,
+	"-":  MinusOp,
+	"*":  TimesOp,
+	"/":  DivideOp,
+	"%":  ModOp,
+	"==": EqOp,
+	"!=": NeqOp,
+	"<":  LessOp,
+	"<=": LessEqOp,
+	">":  GreaterOp,
+	">=": GreaterEqOp,
+}
+
+// global returns the module variable with the given name.
+// Variables of imported modules are not printed,
+// so if the variable is not in the module,
+// it is looked up in the ref Mod,
+// or a stub is made using the typ of the Global.
+func (fp *funParser) global(i int, name string, typ *types.Type) *types.Val {
+	if v, ok := fp.vars[name]; ok {
+		return v.Val
+	}
+	if v, ok := fp.externs[name]; ok {
+		return v
+	}
+	if typ == nil || typ.BuiltIn != types.RefType {
+		fp.errorf(i, "variable %s not found", name)
+	}
+	elem, _ := fp.typeElem(typ)
+	v := &types.Val{Var: types.Var{Name: name, TypeName: makeTypeName(elem)}}
+	fp.externs[name] = v
+	return v
+}
+
+func (fp *funParser) parm(i, n int, name string) *Parm {
+	for _, p := range append(fp.fun.Parms, fp.fun.Ret) {
+		if p == nil || p.N != n {
+			continue
+		}
+		if name == "" && p.Var == nil || p.Var != nil && p.Var.Name == name {
+			return p
+		}
+	}
+	fp.errorf(i, "parameter %d not found", n)
+	panic("impossible")
+}
+
+// parseField parses the text of a Field following the $obj.
+func (fp *funParser) parseField(i int, obj Val, typ *types.Type, text string) (Val, *types.Type) {
+	var name string
+	if j := strings.Index(text, " ["); j >= 0 {
+		name = strings.TrimSuffix(text[j+2:], "]")
+		text = text[:j]
+	}
+	index, err := strconv.Atoi(text)
+	if err != nil {
+		fp.errorf(i, "bad field index %s", text)
+	}
+	s := &Field{Obj: obj, Index: index}
+	objType, ok := fp.refElem(obj)
+	if !ok {
+		fp.errorf(i, "field of non-reference $%d", obj.Num())
+	}
+	isCase := strings.HasSuffix(name, ":") || len(objType.Cases) > 0
+	if typ != nil {
+		if fieldType, ok := fp.typeElem(typ); ok && isCase {
+			fp.learnCase(objType, index, name, fieldType)
+		} else if ok {
+			fp.learnField(objType, index, name, fieldType)
+		}
+	}
+	fp.fixups = append(fp.fixups, func() {
+		if isCase {
+			s.Case = fieldVar(objType.Cases, index)
+		} else {
+			s.Field = fieldVar(objType.Fields, index)
+		}
+	})
+	var inferred *types.Type
+	vars := objType.Fields
+	if isCase {
+		vars = objType.Cases
+	}
+	if v := fieldVar(vars, index); v != nil && varType(v) != nil {
+		inferred = fp.refType(varType(v))
+	}
+	return s, inferred
+}
+
+// fieldVar returns the ith field or case Var, or nil if there is none.
+func fieldVar(vars []types.Var, i int) *types.Var {
+	if i >= len(vars) {
+		return nil
+	}
+	return &vars[i]
+}
+
+func (fp *funParser) parseLit(i int, typ *types.Type, text string) (Val, *types.Type) {
+	var name string
+	if j := strings.Index(text, " ["); j >= 0 {
+		name = strings.TrimSuffix(text[j+2:], "]")
+		text = text[:j]
+	}
+	isFloat := strings.ContainsAny(text, ".eInf")
+	if typ != nil {
+		switch typ.BuiltIn {
+		case types.FloatType, types.Float32Type, types.Float64Type:
+			isFloat = true
+		default:
+			isFloat = false
+		}
+	}
+	if isFloat {
+		f, ok := new(big.Float).SetString(text)
+		if !ok {
+			fp.errorf(i, "bad float literal %s", text)
+		}
+		return &FloatLit{Val: f}, fp.typ("", "Float", nil)
+	}
+	n, ok := new(big.Int).SetString(text, 10)
+	if !ok {
+		fp.errorf(i, "bad integer literal %s", text)
+	}
+	s := &IntLit{Val: n}
+	if name == "" {
+		return s, fp.mod.Mod.IntType
+	}
+	if typ == nil {
+		fp.errorf(i, "cannot infer the type of case %s", name)
+	}
+	fp.learnCase(typ, int(n.Int64()), name, nil)
+	fp.fixups = append(fp.fixups, func() {
+		if k := int(n.Int64()); k >= 0 && k < len(typ.Cases) {
+			s.Case = &typ.Cases[k]
+		}
+	})
+	return s, nil
+}
+
+// refElem returns the element type of a reference-typed Val.
+func (fp *funParser) refElem(v Val) (*types.Type, bool) {
+	return fp.typeElem(v.Type())
+}
+
+func (fp *funParser) typeElem(typ *types.Type) (*types.Type, bool) {
+	if typ.BuiltIn != types.RefType || len(typ.Args) != 1 {
+		return nil, false
+	}
+	return typ.Args[0].Type, true
+}
+
+func (p *parser) refType(typ *types.Type) *types.Type {
+	return p.typ("", "&", typeNames(typ))
+}
+
+// learnField adds or updates a field of a stub type.
+func (p *parser) learnField(typ *types.Type, i int, name string, fieldType *types.Type) {
+	if !p.stubs[typ] || len(typ.Cases) > 0 || i < 0 {
+		return
+	}
+	for len(typ.Fields) <= i {
+		typ.Fields = append(typ.Fields, types.Var{
+			TypeName: makeTypeName(p.typ("", "Nil", nil)),
+			Field:    typ,
+			Index:    len(typ.Fields),
+		})
+	}
+	f := &typ.Fields[i]
+	if name != "" {
+		f.Name = name
+	}
+	if fieldType != nil {
+		f.TypeName = makeTypeName(fieldType)
+	}
+}
+
+// learnCase adds or updates a case of a stub type.
+// The caseType is nil for an untyped case.
+func (p *parser) learnCase(typ *types.Type, i int, name string, caseType *types.Type) {
+	if !p.stubs[typ] || len(typ.Fields) > 0 || i < 0 {
+		return
+	}
+	for len(typ.Cases) <= i {
+		typ.Cases = append(typ.Cases, types.Var{Case: typ, Index: len(typ.Cases)})
+	}
+	c := &typ.Cases[i]
+	if name != "" {
+		c.Name = name
+	}
+	// Don't replace a learned type with the Nil placeholder.
+	if caseType != nil && (c.TypeName == nil || !(caseType.BuiltIn == types.NilType)) {
+		c.TypeName = makeTypeName(caseType)
+	}
+}
+
+// learnVirts ensures that a stub type has at least n virtual methods.
+func (p *parser) learnVirts(typ *types.Type, n int) {
+	if !p.stubs[typ] {
+		return
+	}
+	for len(typ.Virts) < n {
+		typ.Virts = append(typ.Virts, types.FunSig{})
+	}
+}
+
+// learnVirt adds or updates a virtual method of a stub type.
+func (p *parser) learnVirt(typ *types.Type, i int, sel string, parms []*types.Type) {
+	if !p.stubs[typ] || i < 0 {
+		return
+	}
+	p.learnVirts(typ, i+1)
+	virt := &typ.Virts[i]
+	if sel != "" {
+		virt.Sel = sel
+	}
+	if virt.Parms == nil {
+		virt.Parms = []types.Var{}
+		for _, parm := range parms {
+			virt.Parms = append(virt.Parms, types.Var{TypeName: makeTypeName(parm)})
+		}
+	}
+}
+
+// parseTypesFun parses a stub types.Fun from its string.
+func (p *parser) parseTypesFun(i int, s string) *types.Fun {
+	ts := &typeScanner{p: p, line: i, s: s}
+	fun := &types.Fun{}
+	fun.Def = fun
+	if ts.peek() != "[" {
+		recv := p.parseTypeTokens(ts)
+		fun.Recv = &types.Recv{
+			Mod:   recv.Mod,
+			Name:  recv.Name,
+			Arity: len(recv.Args),
+			Type:  recv.Type,
+		}
+		fun.Sig.Parms = append(fun.Sig.Parms, types.Var{Name: "self", TypeName: recv})
+	}
+	ts.expect("[")
+	if tok := ts.next(); isIdent(tok) && !strings.HasSuffix(tok, ":") {
+		fun.Sig.Sel = tok
+	} else {
+		for ; tok != "^" && tok != "]"; tok = ts.next() {
+			if !strings.HasSuffix(tok, ":") && isIdent(tok) {
+				p.errorf(i, "expected a keyword, got %s", tok)
+			}
+			fun.Sig.Sel += tok
+			name := ts.next()
+			if !isIdent(name) {
+				p.errorf(i, "expected a parameter name, got %s", name)
+			}
+			fun.Sig.Parms = append(fun.Sig.Parms, types.Var{
+				Name:     name,
+				TypeName: p.parseTypeTokens(ts),
+			})
+			if ts.peek() == "^" || ts.peek() == "]" {
+				ts.next()
+				break
+			}
+		}
+		ts.unread()
+	}
+	if ts.peek() == "^" {
+		ts.next()
+		fun.Sig.Ret = p.parseTypeTokens(ts)
+	}
+	ts.expect("]")
+	if ts.peek() != "" {
+		p.errorf(i, "unexpected %s", ts.peek())
+	}
+	return fun
+}
+
+func (p *parser) parseType(i int, s string) *types.Type {
+	ts := &typeScanner{p: p, line: i, s: s}
+	name := p.parseTypeTokens(ts)
+	if ts.peek() != "" {
+		p.errorf(i, "unexpected %s in type %s", ts.peek(), s)
+	}
+	return name.Type
+}
+
+func (p *parser) parseTypeTokens(ts *typeScanner) *types.TypeName {
+	args := p.parseTypeArgs(ts)
+	for isTypeName(ts.peek()) {
+		var mod string
+		if strings.HasPrefix(ts.peek(), "#") {
+			mod = ts.next()
+		}
+		spaced := strings.HasPrefix(ts.s, " ")
+		name := ts.next()
+		if !isTypeName(name) || strings.HasPrefix(name, "#") {
+			p.errorf(ts.line, "expected a type name, got %q", name)
+		}
+		// A type name beginning with _ is an operator type name,
+		// so it is printed with no space following its argument.
+		if j := strings.IndexRune(name[1:], '_'); j >= 0 && isIdent(name) {
+			ts.s = name[j+1:] + ts.s
+			name = name[:j+1]
+		}
+		// An operator type argument of an operator type
+		// is printed followed by a space only if it has a name.
+		if mod == "" && !spaced && len(args) == 1 && isOpName(args[0].Name) && isOpName(name) {
+			args[0].Name = ""
+		}
+		typ := p.typ(strings.TrimPrefix(mod, "#"), name, args)
+		args = []types.TypeName{{Mod: mod, Name: name, Args: args, Type: typ}}
+	}
+	if len(args) != 1 {
+		p.errorf(ts.line, "expected a type in %s", ts.s)
+	}
+	return &args[0]
+}
+
+// parseTypeArgs parses a parenthesized, comma-separated list of types, if any.
+func (p *parser) parseTypeArgs(ts *typeScanner) []types.TypeName {
+	if ts.peek() != "(" {
+		return nil
+	}
+	ts.next()
+	var args []types.TypeName
+	for {
+		args = append(args, *p.parseTypeTokens(ts))
+		if ts.peek() == ")" {
+			ts.next()
+			return args
+		}
+		ts.expect(",")
+	}
+}
+
+var builtInTypes = map[string]types.BuiltInType{
+	"Nil":     types.NilType,
+	"Bool":    types.BoolType,
+	"String":  types.StringType,
+	"Int":     types.IntType,
+	"Int8":    types.Int8Type,
+	"Int16":   types.Int16Type,
+	"Int32":   types.Int32Type,
+	"Int64":   types.Int64Type,
+	"UInt":    types.UIntType,
+	"UInt8":   types.UInt8Type,
+	"UInt16":  types.UInt16Type,
+	"UInt32":  types.UInt32Type,
+	"UInt64":  types.UInt64Type,
+	"Float":   types.FloatType,
+	"Float32": types.Float32Type,
+	"Float64": types.Float64Type,
+}
+
+// typ returns the type with the given module, name, and arguments.
+// If the type was seen before, the same *types.Type is returned.
+// Otherwise a new stub type is returned.
+//
+// Type arguments are printed without their module
+// if their TypeName had no module in the source,
+// so a type with an empty module matches
+// an otherwise-identical type with a module.
+func (p *parser) typ(mod, name string, args []types.TypeName) *types.Type {
+	key := typeKey(mod, name, args)
+	if t, ok := p.types[key]; ok {
+		return t
+	}
+	noModKey := typeKey("", name, args)
+	if t, ok := p.types[noModKey]; ok && mod != "" && p.stubs[t] &&
+		(t.BuiltIn == 0 || t.BuiltIn == types.BlockType) {
+		t.ModPath = mod
+		p.types[key] = t
+		return t
+	}
+	if t, ok := p.modTypes[noModKey]; ok && mod == "" {
+		return t
+	}
+	typ := &types.Type{
+		ModPath: mod,
+		Arity:   len(args),
+		Name:    name,
+		Args:    args,
+	}
+	typ.Def = typ
+	typ.BuiltIn = builtInType(mod, name, len(args))
+	if typ.BuiltIn == types.BoolType {
+		typ.Cases = []types.Var{
+			{Name: "true", Case: typ, Index: 0},
+			{Name: "false", Case: typ, Index: 1},
+		}
+	}
+	p.addType(typ)
+	p.stubs[typ] = true
+	return typ
+}
+
+// builtInType returns the BuiltInType of a type
+// with the given module, name, and number of arguments,
+// or zero if it is not a built-in type.
+func builtInType(mod, name string, nargs int) types.BuiltInType {
+	switch {
+	case strings.HasPrefix(name, "$Block"):
+		return types.BlockType
+	case mod != "":
+		return 0
+	case name == "&" && nargs == 1:
+		return types.RefType
+	case name == "Array" && nargs == 1:
+		return types.ArrayType
+	case name == "Fun" && nargs > 0:
+		return types.FunType
+	case nargs == 0:
+		return builtInTypes[name]
+	default:
+		return 0
+	}
+}
+
+func (p *parser) addType(typ *types.Type) {
+	key := typeKey(typ.ModPath, typ.Name, typ.Args)
+	if _, ok := p.types[key]; !ok {
+		p.types[key] = typ
+	}
+	if typ.ModPath != "" {
+		noModKey := typeKey("", typ.Name, typ.Args)
+		if _, ok := p.modTypes[noModKey]; !ok {
+			p.modTypes[noModKey] = typ
+		}
+	}
+}
+
+// typeKey returns a string identifying a type
+// by its module name as printed, its name, and its arguments.
+func typeKey(modPath, name string, args []types.TypeName) string {
+	var s strings.Builder
+	mod := modPath
+	if mod != "" {
+		mod = path.Base(mod)
+	}
+	fmt.Fprintf(&s, "%s %s", mod, name)
+	for i := range args {
+		fmt.Fprintf(&s, " %p", args[i].Type)
+	}
+	return s.String()
+}
+
+// typeNames returns TypeNames for types.
+func typeNames(typs ...*types.Type) []types.TypeName {
+	var names []types.TypeName
+	for _, t := range typs {
+		names = append(names, *makeTypeName(t))
+	}
+	return names
+}
+
+func makeTypeName(typ *types.Type) *types.TypeName {
+	name := &types.TypeName{Name: typ.Name, Args: typ.Args, Type: typ}
+	if typ.ModPath != "" {
+		name.Mod = "#" + path.Base(typ.ModPath)
+	}
+	return name
+}
+
+// typeScanner scans the tokens of types and function signatures.
+type typeScanner struct {
+	p    *parser
+	line int
+	s    string
+	prev string
+}
+
+func (ts *typeScanner) peek() string {
+	tok := ts.next()
+	ts.unread()
+	return tok
+}
+
+func (ts *typeScanner) unread() {
+	ts.s = ts.prev + ts.s
+	ts.prev = ""
+}
+
+func (ts *typeScanner) next() string {
+	trimmed := strings.TrimLeft(ts.s, " ")
+	if trimmed == "" {
+		ts.prev, ts.s = ts.s, ""
+		return ""
+	}
+	r, w := utf8.DecodeRuneInString(trimmed)
+	n := w
+	switch {
+	case strings.ContainsRune("()[],^", r):
+		break
+	case r == '#' || isIdentRune(r):
+		n = identLen(trimmed, w)
+	default:
+		for n < len(trimmed) {
+			r, w := utf8.DecodeRuneInString(trimmed[n:])
+			if r == ' ' || isIdentRune(r) || strings.ContainsRune("()[],^#", r) {
+				break
+			}
+			n += w
+		}
+	}
+	consumed := len(ts.s) - len(trimmed) + n
+	ts.prev, ts.s = ts.s[:consumed], ts.s[consumed:]
+	return trimmed[:n]
+}
+
+// identLen returns the length of the identifier,
+// module name, or keyword at the beginning of s,
+// given the width of its first rune.
+func identLen(s string, n int) int {
+	// Module names are printed with a leading #,
+	// so a module path beginning with # has two.
+	for n < len(s) && s[n] == '#' {
+		n++
+	}
+	for n < len(s) {
+		r, w := utf8.DecodeRuneInString(s[n:])
+		if !isIdentRune(r) {
+			break
+		}
+		n += w
+	}
+	if s[0] != '#' && n < len(s) && s[n] == ':' {
+		n++
+	}
+	return n
+}
+
+func (ts *typeScanner) expect(tok string) {
+	if got := ts.next(); got != tok {
+		ts.p.errorf(ts.line, "expected %s, got %q", tok, got)
+	}
+}
+
+func isOpName(name string) bool {
+	r, _ := utf8.DecodeRuneInString(name)
+	return unicode.IsPunct(r)
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isIdent(tok string) bool {
+	r, _ := utf8.DecodeRuneInString(tok)
+	return isIdentRune(r)
+}
+
+// isTypeName returns whether the token is a type name:
+// a module name, an identifier that is not a keyword, or an operator.
+func isTypeName(tok string) bool {
+	switch {
+	case tok == "" || strings.HasSuffix(tok, ":"):
+		return false
+	case strings.HasPrefix(tok, "#") || isIdent(tok):
+		return true
+	default:
+		return !strings.ContainsAny(tok, "()[],^")
+	}
+}
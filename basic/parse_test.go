@@ -0,0 +1,619 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+package basic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/eaburns/pea/ast"
+	"github.com/eaburns/pea/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{
+			name: "empty",
+			src:  "func [foo |]",
+		},
+		{
+			name: "ops and literals",
+			src: `
+				func [foo: i Int bar: f Float ^Bool |
+					j := (i + 5) * i neg xor: i not.
+					g := f + 2.5 + i asFloat.
+					^(j asFloat + g) < 10.0
+				]
+			`,
+		},
+		{
+			name: "strings, arrays, and slices",
+			src: `
+				func [foo: s String ^Int |
+					a Int Array := {1; 2; 3}.
+					b := a from: 1 to: 2.
+					c := s fromByte: 1 toByte: 2.
+					d UInt8 Array := newArray: 3 init: [:i | i asUInt8].
+					e := newString: d.
+					^(a at: 0) + b size + c byteSize + (s atByte: 0) asInt + e byteSize
+				]
+			`,
+		},
+		{
+			name: "and-types and or-types",
+			src: `
+				type Point {x: Int y: Int}
+				meth Point [getX ^Int | ^x]
+				meth Point [+ o Point ^Point | ^{x: x + o getX y: y}]
+				type Rect {min: Point max: Point}
+				type T? {none | some: T}
+				type Color {red | green | blue}
+				func [foo: r Rect ^Int |
+					s Rect := {min: {x: 1 y: 2} max: r max}.
+					i Int? := {some: s min x}.
+					c Color := {green}.
+					c ifRed: [] ifGreen: [] ifBlue: [].
+					^i ifNone: [0] ifSome: [:j | j]
+				]
+				meth Point [x ^Int | ^x]
+				meth Rect [min ^Point& | ^min]
+				meth Rect [max ^Point& | ^max]
+			`,
+		},
+		{
+			name: "virtual calls",
+			src: `
+				type Shape {[area ^Float] [scale: Float ^Shape]}
+				type Square {side: Float}
+				meth Square [area ^Float | ^side * side]
+				meth Square [scale: f Float ^Shape | s Square := {side: side * f}. ^s]
+				func [foo: sh Shape ^Float | ^(sh scale: 2.0) area]
+				func [bar ^Shape | s Square := {side: 1.0}. ^s]
+			`,
+		},
+		{
+			name: "blocks and far returns",
+			src: `
+				func [find: x Int in: a Int Array ^Int |
+					do: [:i | i = x ifTrue: [^i * 10] ifFalse: []] in: a.
+					^-1
+				]
+				func [do: f (Int, Nil) Fun in: a Int Array |
+					f value: (a at: 0)
+				]
+			`,
+		},
+		{
+			name: "loops",
+			src: `
+				func [fib: n Int a: a Int b: b Int ^Int |
+					^n = 0 ifTrue: [a] ifFalse: [fib: n - 1 a: b b: a + b]
+				]
+				func [count: n Int |
+					n > 0 ifTrue: [count: n - 1] ifFalse: []
+				]
+			`,
+		},
+		{
+			name: "self tail call with variable parameters",
+			src: `
+				type Point {x: Int y: Int}
+				meth Point [getX ^Int | ^x]
+				meth Point [+ o Point ^Point | ^{x: x + o getX y: y}]
+				func [sum: ps Point Array i: i Int acc: acc Point ^Point |
+					^i = ps size ifTrue: [acc] ifFalse: [
+						p := ps at: i.
+						sum: ps i: i + 1 acc: acc + p
+					]
+				]
+			`,
+		},
+		{
+			name: "float bounds",
+			src: `
+				func [min ^Float64 | x Float64 := 1.7976931348623157e308. ^x neg]
+			`,
+		},
+		{
+			name: "module variables",
+			src: `
+				val x := [y + 1]
+				val y := [41]
+				val s := ["hello"]
+				func [foo ^Int | ^x + s byteSize]
+			`,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			p := ast.NewParser("#test")
+			if err := p.Parse("", strings.NewReader(test.src)); err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			typesMod, errs := types.Check(p.Mod(), types.Config{})
+			if len(errs) > 0 {
+				t.Fatalf("failed to check: %v", errs)
+			}
+			built := Build(typesMod)
+			testRoundTrip(t, "built", built)
+			parsed, err := Parse(strings.NewReader(built.String()), built)
+			if err != nil {
+				t.Fatalf("failed to parse: %s", err)
+			}
+			Optimize(built)
+			testRoundTrip(t, "optimized", built)
+
+			// Optimizing the parsed Mod must give the same result
+			// as optimizing the Mod that it was parsed from.
+			if err := OptimizeVerify(parsed); err != nil {
+				t.Fatalf("failed to optimize the parsed Mod: %s", err)
+			}
+			if got, want := parsed.String(), built.String(); got != want {
+				t.Errorf("optimized parsed Mod differs: %s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func testRoundTrip(t *testing.T, name string, mod *Mod) {
+	t.Helper()
+	for _, ref := range []*Mod{nil, mod} {
+		want := mod.String()
+		parsed, err := Parse(strings.NewReader(want), ref)
+		if err != nil {
+			t.Fatalf("%s (ref=%v): failed to parse: %s\n%s", name, ref != nil, err, want)
+		}
+		if got := parsed.String(); got != want {
+			t.Errorf("%s (ref=%v): round trip differs: %s",
+				name, ref != nil, cmp.Diff(want, got))
+		}
+		if err := Verify(parsed); err != nil {
+			t.Errorf("%s (ref=%v): Verify failed: %s", name, ref != nil, err)
+		}
+	}
+}
+
+// Test that the types of Vals are inferred
+// when the text has no type comments.
+func TestParseInferTypes(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		// want is the type of each Val, by number.
+		want map[int]string
+	}{
+		{
+			name: "ops and strings",
+			src: `
+				string0
+					"hello"
+				function1 // [foo: x Int ^Int]
+					parms:
+						0 [x] Int
+						1 Int&
+					0:
+						[in:] [out: 1 2]
+						$0 := alloca(String)
+						string($0, string0)
+						$1 := size($0)
+						$2 := arg(0 [x])
+						$3 := $1 + $2
+						$10 := 10
+						$4 := $3 < $10
+						switch $4 [false 2] [true 1]
+					1:
+						[in: 0] [out:]
+						$5 := $0[$2]
+						$7 := Int($5)
+						$8 := arg(1)
+						store($8, $7)
+						return
+					2:
+						[in: 0] [out:]
+						$9 := arg(1)
+						store($9, $3)
+						return
+			`,
+			want: map[int]string{
+				0: "String&",
+				1: "Int",
+				3: "Int",
+				4: "Bool",
+				5: "UInt8",
+				7: "Int",
+			},
+		},
+		{
+			name: "fields learned from and",
+			src: `
+				function0 // [foo: f Float ^Float]
+					parms:
+						0 [f] Float
+						1 Float&
+					0:
+						[in:] [out:]
+						$0 := alloca(#main Point)
+						$1 := arg(0 [f])
+						$2 := 1
+						and($0, {x: $1 y: $2})
+						$3 := $0.0 [x]
+						$4 := load($3)
+						$5 := $0.1 [y]
+						$6 := load($5)
+						$7 := arg(1)
+						store($7, $4)
+						return
+			`,
+			want: map[int]string{
+				0: "#main Point&",
+				3: "Float&",
+				4: "Float",
+				5: "Int&",
+				6: "Int",
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			mod, err := Parse(strings.NewReader(trimLeadingTestIndent(test.src)), nil)
+			if err != nil {
+				t.Fatalf("failed to parse: %s", err)
+			}
+			if err := Verify(mod); err != nil {
+				t.Errorf("Verify failed: %s", err)
+			}
+			for _, f := range mod.Funs {
+				for _, b := range f.BBlks {
+					for _, s := range b.Stmts {
+						v, ok := s.(Val)
+						if !ok || test.want[v.Num()] == "" {
+							continue
+						}
+						if got := v.Type().String(); got != test.want[v.Num()] {
+							t.Errorf("$%d type is %s, want %s", v.Num(), got, test.want[v.Num()])
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+// Test optimization passes on IR written by hand.
+func TestParseOptimizePasses(t *testing.T) {
+	tests := []struct {
+		name string
+		pass func(*Fun)
+		src  string
+		want string
+	}{
+		{
+			name: "liftAllocs",
+			pass: func(f *Fun) { liftAllocs(f, true) },
+			src: `
+				function0 // [foo: x Int ^Int]
+					parms:
+						0 [x] Int
+						1 Int&
+					0:
+						[in:] [out: 1]
+						$0 := alloc(Int)
+						$1 := arg(0 [x])
+						store($0, $1)
+						jmp 1
+					1:
+						[in: 0] [out:]
+						$2 := load($0)
+						$3 := $2 * $2
+						$4 := arg(1)
+						store($4, $3)
+						return
+			`,
+			want: `
+				function0
+					parms:
+						0 [x] Int
+						1 Int&
+					0:
+						[in:] [out: 1]
+						$0 := arg(0 [x])
+						jmp 1
+					1:
+						[in: 0] [out:]
+						$1 := $0 * $0
+						$2 := arg(1)
+						store($2, $1)
+						return
+			`,
+		},
+		{
+			name: "mem2reg",
+			pass: func(f *Fun) { mem2reg(f) },
+			src: `
+				function0 // [foo: x Int ^Int]
+					parms:
+						0 [x] Int
+						1 Int&
+					0:
+						[in:] [out: 1]
+						$0 := alloc(Int)
+						$1 := arg(0 [x])
+						store($0, $1)
+						jmp 1
+					1:
+						[in: 0 2] [out: 3 2]
+						$2 := load($0)
+						$3 := 0
+						$4 := $2 > $3
+						switch $4 [false 3] [true 2]
+					2:
+						[in: 1] [out: 1]
+						$5 := load($0)
+						$6 := 1
+						$7 := $5 - $6
+						store($0, $7)
+						jmp 1
+					3:
+						[in: 1] [out:]
+						$8 := load($0)
+						$9 := arg(1)
+						store($9, $8)
+						return
+			`,
+			want: `
+				function0
+					parms:
+						0 [x] Int
+						1 Int&
+					0:
+						[in:] [out: 1]
+						$0 := arg(0 [x])
+						jmp 1
+					1:
+						[in: 0 2] [out: 3 2]
+						$1 := phi(0: $0, 2: $5)
+						$2 := 0
+						$3 := $1 > $2
+						switch $3 [false 3] [true 2]
+					2:
+						[in: 1] [out: 1]
+						$4 := 1
+						$5 := $1 - $4
+						jmp 1
+					3:
+						[in: 1] [out:]
+						$6 := arg(1)
+						store($6, $1)
+						return
+			`,
+		},
+		{
+			name: "sccp",
+			pass: func(f *Fun) { sccp(f) },
+			src: `
+				function0 // [foo: x Int& ^Int8]
+					parms:
+						0 [x] Int&
+						1 Int8&
+					0:
+						[in:] [out: 1 2]
+						$0 := 127 // Int8
+						$1 := 1 // Int8
+						$2 := $0 + $1 // Int8
+						$3 := 0
+						$4 := 5
+						$5 := $4 / $3
+						$6 := arg(0 [x])
+						store($6, $5)
+						$7 := 3
+						$8 := 4
+						$9 := $7 < $8
+						switch $9 [true 1] [false 2]
+					1:
+						[in: 0] [out: 3]
+						jmp 3
+					2:
+						[in: 0] [out: 3]
+						$10 := 0 // Int8
+						jmp 3
+					3:
+						[in: 1 2] [out:]
+						$11 := phi(1: $2, 2: $10)
+						$12 := arg(1)
+						store($12, $11)
+						return
+			`,
+			want: `
+				function0
+					parms:
+						0 [x] Int&
+						1 Int8&
+					0:
+						[in:] [out: 1]
+						$0 := 0
+						$1 := 5
+						$2 := $1 / $0
+						$3 := arg(0 [x])
+						store($3, $2)
+						jmp 1
+					1:
+						[in: 0] [out:]
+						$4 := -128
+						$5 := arg(1)
+						store($5, $4)
+						return
+			`,
+		},
+		{
+			name: "cleanUp trivial phis",
+			pass: func(*Fun) {},
+			src: `
+				function0 // [foo: x Int ^Int]
+					parms:
+						0 [x] Int
+						1 Int&
+					0:
+						[in:] [out: 1]
+						$0 := arg(0 [x])
+						jmp 1
+					1:
+						[in: 0 2] [out: 3 2]
+						$1 := phi(0: $0, 2: $1)
+						$2 := 0
+						$3 := $1 > $2
+						switch $3 [false 3] [true 2]
+					2:
+						[in: 1] [out: 1]
+						jmp 1
+					3:
+						[in: 1] [out:]
+						$4 := phi(1: $1)
+						$5 := arg(1)
+						store($5, $4)
+						return
+			`,
+			want: `
+				function0
+					parms:
+						0 [x] Int
+						1 Int&
+					0:
+						[in:] [out: 1]
+						$0 := arg(0 [x])
+						jmp 1
+					1:
+						[in: 0 1] [out: 2 1]
+						$1 := 0
+						$2 := $0 > $1
+						switch $2 [false 2] [true 1]
+					2:
+						[in: 1] [out:]
+						$3 := arg(1)
+						store($3, $0)
+						return
+			`,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			mod, err := Parse(strings.NewReader(trimLeadingTestIndent(test.src)), nil)
+			if err != nil {
+				t.Fatalf("failed to parse: %s", err)
+			}
+			f := mod.Funs[0]
+			test.pass(f)
+			cleanUp(f)
+			got := f.buildString(&strings.Builder{}, false).String()
+			want := trimLeadingTestIndent(test.want)
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("got:\n%s\nwant:\n%s\ndiff:\n%s", got, want, diff)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{
+			name: "missing parms",
+			src: `
+				function0
+					0:
+			`,
+			err: "2: expected parms:",
+		},
+		{
+			name: "undefined value",
+			src: `
+				function0
+					parms:
+					0:
+						[in:] [out:]
+						$1 := load($0)
+						return
+			`,
+			err: "5: $0 is not defined",
+		},
+		{
+			name: "cyclic definition",
+			src: `
+				function0
+					parms:
+					0:
+						[in:] [out:]
+						$0 := $1 + $1
+						$1 := $0 + $0
+						return
+			`,
+			err: "cyclic definition",
+		},
+		{
+			name: "unknown BBlk",
+			src: `
+				function0
+					parms:
+					0:
+						[in:] [out:]
+						jmp 1
+			`,
+			err: "5: BBlk 1 not found",
+		},
+		{
+			name: "unknown function",
+			src: `
+				function0
+					parms:
+					0:
+						[in:] [out:]
+						call function1()
+						return
+			`,
+			err: "5: function1 not found",
+		},
+		{
+			name: "uninferable type",
+			src: `
+				function0
+					parms:
+					0:
+						[in:] [out:]
+						$0 := alloc(Point)
+						$1 := $0.0 [x]
+						return
+			`,
+			err: "6: cannot infer the type of $1",
+		},
+		{
+			name: "bad type",
+			src: `
+				function0
+					parms:
+						0 [x] (Int, Float)
+					0:
+						[in:] [out:]
+						return
+			`,
+			err: "3: expected a type",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(trimLeadingTestIndent(test.src)), nil)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,765 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+package basic
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/eaburns/pea/types"
+)
+
+// sccp is sparse conditional constant propagation,
+// as described by Wegman and Zadeck,
+// "Constant Propagation with Conditional Branches".
+//
+// Ops with constant arguments are folded to literals,
+// Switches on constant values are replaced with Jmps,
+// and the statements of unreachable BBlks are deleted.
+// Folding follows the fixed-width semantics of the Op's type.
+// Ops that would panic or whose result is implementation dependent,
+// such as division by zero, are not folded.
+func sccp(f *Fun) bool {
+	s := &sccpState{
+		f:     f,
+		vals:  make([]lattice, f.NVals),
+		exec:  make(map[*BBlk]bool, len(f.BBlks)),
+		edges: make(map[[2]*BBlk]bool),
+		bblk:  make(map[Stmt]*BBlk),
+	}
+	for _, b := range f.BBlks {
+		for _, st := range b.Stmts {
+			s.bblk[st] = b
+		}
+	}
+	s.solve()
+	return s.rewrite()
+}
+
+// A lattice is the value of a Val during sccp.
+// The zero lattice is undefined:
+// the Val has not been found to be computed.
+type lattice struct {
+	// over is true if the Val is overdefined;
+	// it is not known to be a constant.
+	over bool
+	// If i is non-nil, the Val is the integer constant i.
+	i *big.Int
+	// If isFloat is true, the Val is the floating-point constant f.
+	// The constants of 32-bit floating-point types
+	// are exactly represented by the float64.
+	isFloat bool
+	f       float64
+}
+
+var overdefined = lattice{over: true}
+
+func (l lattice) constant() bool { return l.i != nil || l.isFloat }
+
+func (l lattice) eq(m lattice) bool {
+	switch {
+	case l.over || m.over:
+		return l.over == m.over
+	case l.i != nil || m.i != nil:
+		return l.i != nil && m.i != nil && l.i.Cmp(m.i) == 0
+	case l.isFloat || m.isFloat:
+		// Compare the bits to distinguish 0 and -0.
+		return l.isFloat && m.isFloat && math.Float64bits(l.f) == math.Float64bits(m.f)
+	default:
+		return true
+	}
+}
+
+func meet(l, m lattice) lattice {
+	switch {
+	case !l.over && !l.constant():
+		return m
+	case !m.over && !m.constant():
+		return l
+	case l.eq(m):
+		return l
+	default:
+		return overdefined
+	}
+}
+
+type sccpState struct {
+	f    *Fun
+	vals []lattice // indexed by Val.Num
+	exec map[*BBlk]bool
+	// edges are the executable edges, from the BBlk to its Out.
+	edges map[[2]*BBlk]bool
+	// bblk is the BBlk of each statement.
+	bblk map[Stmt]*BBlk
+
+	bblkWork []*BBlk
+	valWork  []Val
+}
+
+func (s *sccpState) solve() {
+	s.exec[s.f.BBlks[0]] = true
+	s.bblkWork = append(s.bblkWork, s.f.BBlks[0])
+	for len(s.bblkWork) > 0 || len(s.valWork) > 0 {
+		if n := len(s.bblkWork); n > 0 {
+			b := s.bblkWork[n-1]
+			s.bblkWork = s.bblkWork[:n-1]
+			for _, st := range b.Stmts {
+				s.visit(b, st)
+			}
+			continue
+		}
+		n := len(s.valWork)
+		v := s.valWork[n-1]
+		s.valWork = s.valWork[:n-1]
+		for _, u := range v.Users() {
+			if b := s.bblk[u]; b != nil && s.exec[b] {
+				s.visit(b, u)
+			}
+		}
+	}
+}
+
+func (s *sccpState) visit(b *BBlk, st Stmt) {
+	if st.deleted() {
+		return
+	}
+	switch st := st.(type) {
+	case *Phi:
+		s.visitPhi(b, st)
+	case *Op:
+		s.set(st, s.fold(st))
+	case *IntLit:
+		s.set(st, lattice{i: st.Val})
+	case *FloatLit:
+		if isFloat32(s.f.Mod.Mod, st.Type()) {
+			f, _ := st.Val.Float32()
+			s.set(st, lattice{isFloat: true, f: float64(f)})
+			break
+		}
+		f, _ := st.Val.Float64()
+		s.set(st, lattice{isFloat: true, f: f})
+	case Val:
+		s.set(st, overdefined)
+	case *Switch:
+		s.visitSwitch(b, st)
+	case Term:
+		for _, o := range st.Out() {
+			s.addEdge(b, o)
+		}
+	}
+}
+
+// visitPhi sets the value of a Phi of b
+// to the meet of its entries from executable edges.
+func (s *sccpState) visitPhi(b *BBlk, phi *Phi) {
+	var l lattice
+	for i, in := range phi.In {
+		if s.edges[[2]*BBlk{in, b}] {
+			l = meet(l, s.vals[phi.Args[i].Num()])
+		}
+	}
+	s.set(phi, l)
+}
+
+// visitSwitch adds the edges from b to the destinations of its Switch
+// that may be taken given the value of the Switch.
+func (s *sccpState) visitSwitch(b *BBlk, sw *Switch) {
+	if i, ok := s.switchDst(sw); ok {
+		s.addEdge(b, sw.Dsts[i])
+		return
+	}
+	if s.vals[sw.Val.Num()].over {
+		for _, o := range sw.Dsts {
+			s.addEdge(b, o)
+		}
+	}
+}
+
+func (s *sccpState) set(v Val, l lattice) {
+	if s.vals[v.Num()].eq(l) {
+		return
+	}
+	s.vals[v.Num()] = l
+	s.valWork = append(s.valWork, v)
+}
+
+func (s *sccpState) addEdge(b, o *BBlk) {
+	e := [2]*BBlk{b, o}
+	if s.edges[e] {
+		return
+	}
+	s.edges[e] = true
+	if !s.exec[o] {
+		s.exec[o] = true
+		s.bblkWork = append(s.bblkWork, o)
+		return
+	}
+	// The BBlk was already visited,
+	// but its Phis have a new executable entry.
+	for _, phi := range o.phis() {
+		s.visit(o, phi)
+	}
+}
+
+// switchDst returns the index of the Dst of a Switch on a constant.
+// The second return is false if the Switch Val is not constant.
+func (s *sccpState) switchDst(sw *Switch) (int, bool) {
+	l := s.vals[sw.Val.Num()]
+	if l.i == nil || !l.i.IsInt64() {
+		return 0, false
+	}
+	i := l.i.Int64()
+	if sw.Val.Type().BuiltIn == types.BoolType {
+		// Bool is {true|false}, but true=1 and false=0.
+		i = 1 - i
+	}
+	if i < 0 || i >= int64(len(sw.Dsts)) {
+		return 0, false
+	}
+	return int(i), true
+}
+
+func (s *sccpState) fold(op *Op) lattice {
+	if op.Code == ArraySizeOp || op.Code == UnionTagOp {
+		return overdefined
+	}
+	args := make([]lattice, len(op.Args))
+	for i, arg := range op.Args {
+		switch args[i] = s.vals[arg.Num()]; {
+		case args[i].over:
+			return overdefined
+		case !args[i].constant():
+			return lattice{}
+		}
+	}
+	var l lattice
+	var ok bool
+	m := s.f.Mod.Mod
+	checked := s.f.Mod.Checked && op.Msg != nil
+	switch x := args[0]; {
+	case op.Code == NumConvertOp:
+		l, ok = foldConvert(m, x, op.Type(), checked)
+	case x.isFloat:
+		l, ok = foldFloatOp(m, op.Code, op.Type(), args)
+	default:
+		l, ok = foldIntOp(m, op.Code, op.Args[0].Type(), args, checked)
+	}
+	if !ok {
+		return overdefined
+	}
+	return l
+}
+
+// foldIntOp returns the constant result of an integer Op.
+// If checked is true, arithmetic that overflows is not folded,
+// since it panics.
+func foldIntOp(m *types.Mod, code OpCode, typ *types.Type, args []lattice, checked bool) (lattice, bool) {
+	bits, signed, ok := intType(m, typ)
+	if !ok {
+		return lattice{}, false
+	}
+	x := args[0].i
+	if len(args) == 1 {
+		switch code {
+		case NegOp:
+			return lattice{i: wrapInt(new(big.Int).Neg(x), bits, signed)}, true
+		case BitwiseNotOp:
+			return lattice{i: wrapInt(new(big.Int).Not(x), bits, signed)}, true
+		}
+		return lattice{}, false
+	}
+	y := args[1].i
+	if y == nil {
+		return lattice{}, false
+	}
+	if c, ok := compareOp(code, x.Cmp(y)); ok {
+		return c, true
+	}
+	z, ok := intArith(code, x, y, bits)
+	if !ok {
+		return lattice{}, false
+	}
+	w := wrapInt(z, bits, signed)
+	if checked && code >= PlusOp && code <= ModOp && w.Cmp(z) != 0 {
+		return lattice{}, false // panics
+	}
+	return lattice{i: w}, true
+}
+
+// intArith returns the unwrapped result of a binary, non-comparison Op
+// on integers of the given number of bits.
+// The second return is false if the Op cannot be folded
+// or if it panics.
+func intArith(code OpCode, x, y *big.Int, bits int) (*big.Int, bool) {
+	z := new(big.Int)
+	switch code {
+	case BitwiseAndOp:
+		z.And(x, y)
+	case BitwiseOrOp:
+		z.Or(x, y)
+	case BitwiseXOrOp:
+		z.Xor(x, y)
+	case LeftShiftOp, RightShiftOp:
+		return shiftInt(code, x, y, bits)
+	case PlusOp:
+		z.Add(x, y)
+	case MinusOp:
+		z.Sub(x, y)
+	case TimesOp:
+		z.Mul(x, y)
+	case DivideOp, ModOp:
+		if y.Sign() == 0 {
+			return nil, false // panics
+		}
+		if code == DivideOp {
+			z.Quo(x, y)
+		} else {
+			z.Rem(x, y)
+		}
+	default:
+		return nil, false
+	}
+	return z, true
+}
+
+// shiftInt returns the unwrapped result of a shift Op
+// on integers of the given number of bits.
+// The second return is false for a negative shift count, which panics.
+func shiftInt(code OpCode, x, y *big.Int, bits int) (*big.Int, bool) {
+	if y.Sign() < 0 {
+		return nil, false // panics
+	}
+	n := uint(bits)
+	if y.IsUint64() && y.Uint64() < uint64(n) {
+		n = uint(y.Uint64())
+	}
+	if code == LeftShiftOp {
+		return new(big.Int).Lsh(x, n), true
+	}
+	return new(big.Int).Rsh(x, n), true
+}
+
+func foldFloatOp(m *types.Mod, code OpCode, typ *types.Type, args []lattice) (lattice, bool) {
+	x := args[0].f
+	if len(args) == 1 {
+		if code != NegOp {
+			return lattice{}, false
+		}
+		return lattice{isFloat: true, f: -x}, true
+	}
+	if !args[1].isFloat {
+		return lattice{}, false
+	}
+	y := args[1].f
+	var cmp int
+	switch {
+	case x < y:
+		cmp = -1
+	case x > y:
+		cmp = 1
+	}
+	if c, ok := compareOp(code, cmp); ok {
+		return c, true
+	}
+	var z float64
+	switch code {
+	case PlusOp:
+		z = x + y
+	case MinusOp:
+		z = x - y
+	case TimesOp:
+		z = x * y
+	case DivideOp:
+		z = x / y
+	default:
+		return lattice{}, false
+	}
+	return floatLattice(m, z, typ)
+}
+
+// compareOp returns the Bool result of a comparison Op
+// given the comparison of its arguments: -1, 0, or 1.
+// The second return is false if the OpCode is not a comparison.
+func compareOp(code OpCode, cmp int) (lattice, bool) {
+	var b bool
+	switch code {
+	case EqOp:
+		b = cmp == 0
+	case NeqOp:
+		b = cmp != 0
+	case LessOp:
+		b = cmp < 0
+	case LessEqOp:
+		b = cmp <= 0
+	case GreaterOp:
+		b = cmp > 0
+	case GreaterEqOp:
+		b = cmp >= 0
+	default:
+		return lattice{}, false
+	}
+	if b {
+		return lattice{i: big.NewInt(1)}, true
+	}
+	return lattice{i: big.NewInt(0)}, true
+}
+
+// foldConvert returns the constant result of a NumConvertOp.
+// If checked is true, integer conversions that overflow are not folded,
+// since they panic.
+func foldConvert(m *types.Mod, x lattice, typ *types.Type, checked bool) (lattice, bool) {
+	if isFloatType(typ) {
+		if x.isFloat {
+			return floatLattice(m, x.f, typ)
+		}
+		var f float64
+		switch {
+		case x.i.IsInt64() && isFloat32(m, typ):
+			f = float64(float32(x.i.Int64()))
+		case x.i.IsInt64():
+			f = float64(x.i.Int64())
+		case x.i.IsUint64() && isFloat32(m, typ):
+			f = float64(float32(x.i.Uint64()))
+		case x.i.IsUint64():
+			f = float64(x.i.Uint64())
+		default:
+			return lattice{}, false
+		}
+		return floatLattice(m, f, typ)
+	}
+	bits, signed, ok := intType(m, typ)
+	if !ok {
+		return lattice{}, false
+	}
+	if x.i != nil {
+		w := wrapInt(x.i, bits, signed)
+		if checked && w.Cmp(x.i) != 0 {
+			return lattice{}, false // panics
+		}
+		return lattice{i: w}, true
+	}
+	// Converting an out-of-range float to an integer
+	// is implementation dependent, so it is not folded.
+	i, _ := new(big.Float).SetFloat64(x.f).Int(nil)
+	if wrapInt(i, bits, signed).Cmp(i) != 0 {
+		return lattice{}, false
+	}
+	return lattice{i: i}, true
+}
+
+// floatLattice returns the constant lattice of a float64,
+// rounded to the precision of the type.
+// The second return is false if the value is not finite.
+func floatLattice(m *types.Mod, f float64, typ *types.Type) (lattice, bool) {
+	if isFloat32(m, typ) {
+		f = float64(float32(f))
+	}
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return lattice{}, false
+	}
+	return lattice{isFloat: true, f: f}, true
+}
+
+// intType returns the size in bits and signedness of an integer type.
+// The last return is false if the type is not an integer type.
+// Enum types have the integer type of their tag.
+// Int and UInt have the IntSize of the module.
+func intType(m *types.Mod, typ *types.Type) (int, bool, bool) {
+	if len(typ.Cases) > 0 && typ.Tag() != nil {
+		typ = typ.Tag()
+	}
+	switch typ.BuiltIn {
+	case types.IntType:
+		return m.IntSize, true, true
+	case types.UIntType:
+		return m.IntSize, false, true
+	case types.Int64Type:
+		return 64, true, true
+	case types.Int8Type:
+		return 8, true, true
+	case types.Int16Type:
+		return 16, true, true
+	case types.Int32Type:
+		return 32, true, true
+	case types.UInt64Type:
+		return 64, false, true
+	case types.UInt8Type, types.BoolType:
+		return 8, false, true
+	case types.UInt16Type:
+		return 16, false, true
+	case types.UInt32Type:
+		return 32, false, true
+	}
+	return 0, false, false
+}
+
+// wrapInt returns the integer truncated to the size of an integer type
+// with two's complement wrap around.
+func wrapInt(x *big.Int, bits int, signed bool) *big.Int {
+	mod := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	z := new(big.Int).And(x, new(big.Int).Sub(mod, big.NewInt(1)))
+	if signed && z.Bit(bits-1) == 1 {
+		z.Sub(z, mod)
+	}
+	return z
+}
+
+func isFloatType(typ *types.Type) bool {
+	switch typ.BuiltIn {
+	case types.FloatType, types.Float32Type, types.Float64Type:
+		return true
+	}
+	return false
+}
+
+// isFloat32 returns whether the type is Float32,
+// or Float with a FloatSize of 32 in the module.
+func isFloat32(m *types.Mod, typ *types.Type) bool {
+	return typ.BuiltIn == types.Float32Type ||
+		typ.BuiltIn == types.FloatType && m.FloatSize == 32
+}
+
+// rewrite replaces constant Vals with literals,
+// replaces Switches on constants with Jmps,
+// and deletes the statements of unexecuted BBlks.
+// It returns whether anything changed.
+func (s *sccpState) rewrite() bool {
+	var changed bool
+	var folded, lits []Val
+	for _, b := range s.f.BBlks {
+		if !s.exec[b] {
+			if deleteStmts(b) {
+				changed = true
+			}
+			continue
+		}
+		var stmts []Stmt
+		var phiLits []Stmt // literals for folded Phis
+		nPhis := len(b.Stmts)
+		for i, st := range b.Stmts {
+			if _, ok := st.(*Phi); !ok && nPhis > i {
+				nPhis = i
+				stmts = append(stmts, phiLits...)
+			}
+			switch st := st.(type) {
+			case *Phi, *Op:
+				v := st.(Val)
+				l := s.vals[v.Num()]
+				if v.deleted() || !l.constant() {
+					break
+				}
+				lit := s.literal(v.Type(), l)
+				lit.setLoc(st.Loc())
+				if _, ok := st.(*Phi); ok {
+					phiLits = append(phiLits, lit)
+				} else {
+					stmts = append(stmts, lit)
+				}
+				v.delete()
+				folded = append(folded, v)
+				lits = append(lits, lit)
+				changed = true
+			case *Switch:
+				if jmp := s.switchJmp(b, st); jmp != nil {
+					stmts = append(stmts, jmp)
+					changed = true
+					continue
+				}
+			}
+			stmts = append(stmts, st)
+		}
+		b.Stmts = stmts
+	}
+	// The literals have new Val numbers,
+	// so the valMap is made after they are all added.
+	valMap := makeValMap(s.f.NVals)
+	for i, v := range folded {
+		valMap.add(v, lits[i])
+	}
+	subVals(s.f.BBlks, valMap)
+	return changed
+}
+
+// deleteStmts deletes the Stmts of an unexecuted BBlk
+// and returns whether any were not already deleted.
+func deleteStmts(b *BBlk) bool {
+	var changed bool
+	for _, st := range b.Stmts {
+		if !st.deleted() {
+			st.delete()
+			changed = true
+		}
+	}
+	return changed
+}
+
+// switchJmp returns a Jmp to replace a Switch of b with a constant value,
+// or nil if the value is not constant.
+// The Switch is removed from the In of its other destinations
+// and as a user of its value.
+func (s *sccpState) switchJmp(b *BBlk, sw *Switch) *Jmp {
+	i, ok := s.switchDst(sw)
+	if !ok {
+		return nil
+	}
+	dst := sw.Dsts[i]
+	for _, o := range sw.Dsts {
+		if o != dst && containsBBlk(o.In, b) {
+			o.rmIn(b)
+		}
+	}
+	sw.Val.value().rmUser(sw)
+	jmp := &Jmp{Dst: dst}
+	jmp.setLoc(sw.Loc())
+	return jmp
+}
+
+func (s *sccpState) literal(typ *types.Type, l lattice) Val {
+	if l.isFloat {
+		return &FloatLit{val: newVal(s.f, typ), Val: big.NewFloat(l.f)}
+	}
+	return &IntLit{val: newVal(s.f, typ), Val: l.i}
+}
+
+// propagateVals replaces Loads of constant module-level Vals
+// with the literal value of the Val.
+// It returns the Funs that changed.
+func propagateVals(m *Mod) []*Fun {
+	lits := constVals(m)
+	if len(lits) == 0 {
+		return nil
+	}
+	var changed []*Fun
+	for _, f := range m.Funs {
+		var loads, newLits []Val
+		for _, b := range f.BBlks {
+			var stmts []Stmt
+			for _, s := range b.Stmts {
+				if load, ok := s.(*Load); ok && !load.deleted() {
+					if g, ok := load.Src.(*Global); ok && lits[g.Val] != nil {
+						lit := copyLit(f, lits[g.Val], load.Type())
+						stmts = append(stmts, lit)
+						load.delete()
+						loads = append(loads, load)
+						newLits = append(newLits, lit)
+					}
+				}
+				stmts = append(stmts, s)
+			}
+			b.Stmts = stmts
+		}
+		if len(loads) == 0 {
+			continue
+		}
+		valMap := makeValMap(f.NVals)
+		for i, load := range loads {
+			valMap.add(load, newLits[i])
+		}
+		subVals(f.BBlks, valMap)
+		changed = append(changed, f)
+	}
+	return changed
+}
+
+// constVals returns the literal values of private module-level Vals
+// that are initialized with a literal and never otherwise stored.
+// Public Vals may be stored by other modules,
+// so they are never constant.
+func constVals(m *Mod) map[*types.Val]Val {
+	lits := make(map[*types.Val]Val)
+	notConst := make(map[*types.Val]bool)
+	for _, f := range m.Funs {
+		for _, b := range f.BBlks {
+			for _, s := range b.Stmts {
+				g, ok := s.(*Global)
+				if !ok || !g.Val.Priv || notConst[g.Val] {
+					continue
+				}
+				for _, u := range g.Users() {
+					if _, ok := u.(*Load); ok {
+						continue
+					}
+					lit := initLit(g, u)
+					if lit == nil || lits[g.Val] != nil {
+						notConst[g.Val] = true
+						break
+					}
+					lits[g.Val] = lit
+				}
+			}
+		}
+	}
+	for v := range notConst {
+		delete(lits, v)
+	}
+	return lits
+}
+
+// valInitLit returns the literal returned by a module-level Val init Fun,
+// or nil if the Fun does anything other than return a literal.
+// initLit returns the literal that a user of a Global initializes it to:
+// the literal stored to it, or that returned by its Val initializer.
+// initLit returns nil if the user does not initialize the Global to a literal.
+func initLit(g *Global, u Stmt) Val {
+	switch u := u.(type) {
+	case *Store:
+		if u.Dst == g && isLit(u.Val) {
+			return u.Val
+		}
+	case *Call:
+		if u.Fun.Val == g.Val && u.Fun.Block == nil &&
+			len(u.Args) > 0 && u.Args[len(u.Args)-1] == g {
+			return valInitLit(u.Fun)
+		}
+	}
+	return nil
+}
+
+func valInitLit(f *Fun) Val {
+	if f.Ret == nil {
+		return nil
+	}
+	var lit Val
+	for _, b := range f.BBlks {
+		for _, s := range b.Stmts {
+			switch s := s.(type) {
+			case *IntLit, *FloatLit, *Jmp:
+				continue
+			case *Arg:
+				if s.Parm == f.Ret {
+					continue
+				}
+			case *Ret:
+				if !s.Far {
+					continue
+				}
+			case *Store:
+				if a, ok := s.Dst.(*Arg); ok && a.Parm == f.Ret && lit == nil && isLit(s.Val) {
+					lit = s.Val
+					continue
+				}
+			}
+			return nil
+		}
+	}
+	return lit
+}
+
+func isLit(v Val) bool {
+	switch v.(type) {
+	case *IntLit, *FloatLit:
+		return true
+	}
+	return false
+}
+
+func copyLit(f *Fun, lit Val, typ *types.Type) Val {
+	switch lit := lit.(type) {
+	case *IntLit:
+		return &IntLit{val: newVal(f, typ), Val: lit.Val, Int: lit.Int, Case: lit.Case}
+	case *FloatLit:
+		return &FloatLit{val: newVal(f, typ), Val: lit.Val, Float: lit.Float}
+	default:
+		panic("impossible")
+	}
+}
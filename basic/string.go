@@ -41,7 +41,7 @@ func (n *String) buildString(s *strings.Builder) *strings.Builder {
 }
 
 func (n *Var) buildString(s *strings.Builder) *strings.Builder {
-	fmt.Fprintf(s, "%s %s", n.Val.Var.Name, n.Val.Var.Type())
+	fmt.Fprintf(s, "%s %s", n.Val.Var.Name, varType(&n.Val.Var))
 	return s
 }
 
@@ -52,7 +52,15 @@ func (n *Fun) String() string {
 func (n *Fun) buildString(s *strings.Builder, comments bool) *strings.Builder {
 	s.WriteString(n.name())
 	if comments {
-		fmt.Fprintf(s, " // %s\n\tcan inline: %v", n.Fun, n.CanInline)
+		switch {
+		case n.Fun != nil:
+			fmt.Fprintf(s, " // %s", n.Fun)
+		case n.Val != nil:
+			fmt.Fprintf(s, " // val %s", n.Val.Var.Name)
+		default:
+			s.WriteString(" // init")
+		}
+		fmt.Fprintf(s, "\n\tcan inline: %v", n.CanInline)
 	}
 	s.WriteString("\n\tparms:")
 	for _, p := range append(n.Parms, n.Ret) {
@@ -67,6 +75,9 @@ func (n *Fun) buildString(s *strings.Builder, comments bool) *strings.Builder {
 		if p.Value {
 			s.WriteString(" (value)")
 		}
+		if a := parmAlloc(n, p); a != nil {
+			fmt.Fprintf(s, " (alloc $%d)", a.Num())
+		}
 	}
 	for _, b := range n.BBlks {
 		s.WriteRune('\n')
@@ -75,6 +86,42 @@ func (n *Fun) buildString(s *strings.Builder, comments bool) *strings.Builder {
 	return s
 }
 
+func (n Inline) String() string {
+	verb := "rejected"
+	if n.Inlined {
+		verb = "inlined"
+	}
+	return fmt.Sprintf("%s %s into %s: %s", verb, n.Call.Fun.desc(), n.Caller.desc(), n.Reason)
+}
+
+// desc returns a short description of the Fun.
+func (n *Fun) desc() string {
+	switch {
+	case n.Block != nil:
+		return n.name()
+	case n.Fun != nil:
+		return n.Fun.String()
+	case n.Val != nil:
+		return "val " + n.Val.Var.Name
+	default:
+		return "init"
+	}
+}
+
+// parmAlloc returns the Alloc holding a parameter's variable
+// or nil if there is none.
+func parmAlloc(f *Fun, p *Parm) *Alloc {
+	if p.Var == nil || len(f.BBlks) == 0 {
+		return nil
+	}
+	for _, s := range f.BBlks[0].Stmts {
+		if a, ok := s.(*Alloc); ok && !a.deleted() && a.Var == p.Var {
+			return a
+		}
+	}
+	return nil
+}
+
 func (n *Fun) name() string {
 	if n.Block != nil {
 		return fmt.Sprintf("block%d", n.N)
@@ -216,11 +263,11 @@ func (n *MakeAnd) buildString(s *strings.Builder) *strings.Builder {
 		}
 		field := andType.Fields[i]
 		var deref string
-		// field.Type() should never be nil,
+		// The field type should never be nil,
 		// except in tests when we construct an And-type,
 		// we cannot set it's unexported .typ field.
 		// For now, we just ignore it to unblock the tests.
-		if field.Type() != nil && !SimpleType(field.Type()) {
+		if typ := varType(&field); typ != nil && !SimpleType(typ) {
 			deref = "*"
 		}
 		if field.Name == "" {
@@ -240,11 +287,11 @@ func (n *MakeOr) buildString(s *strings.Builder) *strings.Builder {
 	s.WriteString(cas.Name)
 	if n.Val != nil {
 		var deref string
-		// cas.Type() should never be nil,
+		// The case type should never be nil,
 		// except in tests when we construct an Or-type,
 		// we cannot set it's unexported .typ field.
 		// For now, we just ignore it to unblock the tests.
-		if cas.Type() != nil && !SimpleType(cas.Type()) {
+		if typ := varType(&cas); typ != nil && !SimpleType(typ) {
 			deref = "*"
 		}
 		fmt.Fprintf(s, " %s$%d", deref, n.Val.Num())
@@ -340,13 +387,16 @@ func (n *IntLit) buildString(s *strings.Builder) *strings.Builder {
 }
 
 func (n *FloatLit) buildString(s *strings.Builder) *strings.Builder {
-	s.WriteString(n.Val.String())
+	// Use the shortest text that parses back to the same value;
+	// String's 10 digits can round past the bounds of a Float64.
+	s.WriteString(n.Val.Text('g', -1))
 	return s
 }
 
 var opString = map[OpCode]string{
 	BitwiseAndOp: "&",
 	BitwiseOrOp:  "|",
+	BitwiseXOrOp: "^",
 	BitwiseNotOp: "!",
 	RightShiftOp: ">>",
 	LeftShiftOp:  "<<",
@@ -390,12 +440,30 @@ func (n *Load) buildString(s *strings.Builder) *strings.Builder {
 	return s
 }
 
+func (n *Phi) buildString(s *strings.Builder) *strings.Builder {
+	s.WriteString("phi(")
+	for i, in := range n.In {
+		if i > 0 {
+			s.WriteString(", ")
+		}
+		fmt.Fprintf(s, "%d: $%d", in.N, n.Args[i].Num())
+	}
+	s.WriteRune(')')
+	if n.Var != nil {
+		fmt.Fprintf(s, " [%s]", n.Var.Name)
+	}
+	return s
+}
+
 func (n *Alloc) buildString(s *strings.Builder) *strings.Builder {
 	a := ""
 	if n.Stack {
 		a = "a"
 	}
 	fmt.Fprintf(s, "alloc%s(%s)", a, n.Type().Args[0].Type)
+	if n.Var != nil {
+		fmt.Fprintf(s, " [%s]", n.Var.Name)
+	}
 	return s
 }
 
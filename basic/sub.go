@@ -128,6 +128,12 @@ func (n *Load) subVals(sub valMap) {
 	sub1(sub, n, &n.Src)
 }
 
+func (n *Phi) subVals(sub valMap) {
+	for i := range n.Args {
+		sub1(sub, n, &n.Args[i])
+	}
+}
+
 func (n *Index) subVals(sub valMap) {
 	sub1(sub, n, &n.Ary)
 	sub1(sub, n, &n.Index)
@@ -173,12 +179,19 @@ func subBBlks(bs []*BBlk, sub bblkMap) {
 			continue
 		}
 		term := b.Stmts[len(b.Stmts)-1].(Term)
-		for _, o := range term.Out() {
-			o.rmIn(b)
-		}
+		// Only update the In of changed BBlks,
+		// since removing an In also removes its Phi entries.
+		outs := append([]*BBlk{}, term.Out()...)
 		term.subBBlk(sub)
+		for _, o := range outs {
+			if !containsBBlk(term.Out(), o) {
+				o.rmIn(b)
+			}
+		}
 		for _, o := range term.Out() {
-			o.addIn(b)
+			if !containsBBlk(outs, o) {
+				o.addIn(b)
+			}
 		}
 	}
 }
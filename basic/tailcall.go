@@ -15,41 +15,23 @@ func rmSelfTailCalls(f *Fun) bool {
 		b := todo[0]
 		todo = todo[1:]
 		for i, s := range b.Stmts {
-			if s.deleted() || !selfTailCall(f, b, i, s) {
+			if s.deleted() || !selfTailCall(f, b, i) {
+				continue
+			}
+			restart := b
+			if len(bblks) > 1 {
+				restart = bblks[1]
+			}
+			if len(restart.phis()) > 0 {
+				// The jump back to the restart BBlk
+				// would need an entry in each of its Phis.
 				continue
 			}
 			n++
 			s.delete()
 			call := s.(*Call)
 			b0, b1 := splitBBlk(b, i)
-			for j, arg := range call.Args {
-				var dst Val
-				var parm *Parm
-				if j >= len(f.Parms) {
-					continue
-				}
-				parm = f.Parms[j]
-				dst = findParm(f, b0, parm.Var)
-				if load, ok := arg.(*Load); ok && load.Src == dst && readOnly(dst) {
-					// Avoid adding stores to unchanged parameters.
-					continue
-				}
-				if parm.Value && !parm.Self {
-					// Remove the extra copy made for pass-by-value.
-					// The pass-by-value copy is read-only
-					// after we remove the function call,
-					// so propagating the single store Src
-					// is safe without an explicit read-only check.
-					copy := singleStore(arg).(*Copy)
-					copy.delete()
-					arg = copy.Src
-				}
-				if !parm.Value && SimpleType(arg.Type()) {
-					addStore(b, dst, arg)
-				} else {
-					addCopy(b, dst, arg)
-				}
-			}
+			storeTailArgs(f, b0, call)
 			if len(bblks) == 1 {
 				addJmp(b0, b0)
 			} else {
@@ -67,6 +49,43 @@ func rmSelfTailCalls(f *Fun) bool {
 	return n > 0
 }
 
+// storeTailArgs adds to b the stores of the arguments of a self tail Call
+// to the parameters of f, before jumping back to its beginning.
+func storeTailArgs(f *Fun, b *BBlk, call *Call) {
+	for j, arg := range call.Args {
+		var dst Val
+		var parm *Parm
+		if j >= len(f.Parms) {
+			continue
+		}
+		parm = f.Parms[j]
+		dst = findParm(f, b, parm.Var)
+		if load, ok := arg.(*Load); ok && load.Src == dst && readOnly(dst) {
+			// Avoid adding stores to unchanged parameters.
+			continue
+		}
+		if parm.Value && !parm.Self {
+			// Remove the extra copy made for pass-by-value.
+			// The pass-by-value copy is read-only
+			// after we remove the function call,
+			// so propagating the single store Src
+			// is safe without an explicit read-only check.
+			copy := singleStore(arg).(*Copy)
+			copy.delete()
+			arg = copy.Src
+		}
+		if a, ok := arg.(*Arg); ok && a.Parm == parm && isArg(dst) {
+			// The parameter is passed unchanged.
+			continue
+		}
+		if !parm.Value && SimpleType(arg.Type()) {
+			addStore(b, dst, arg)
+		} else {
+			addCopy(b, dst, arg)
+		}
+	}
+}
+
 func readOnly(v Val) bool {
 	var def Stmt
 	for _, u := range v.Users() {
@@ -88,38 +107,345 @@ func readOnly(v Val) bool {
 	return true
 }
 
-func selfTailCall(f *Fun, b *BBlk, i int, s Stmt) bool {
-	call, ok := s.(*Call)
-	if !ok || call.Fun != f {
-		return false
+func selfTailCall(f *Fun, b *BBlk, i int) bool {
+	call, _ := tailCall(f, b, i)
+	return call != nil && call.Fun == f
+}
+
+// tailCall returns the ith Stmt of the BBlk as a *Call
+// if the Call is followed only by copying its result, if any,
+// to the return parameter of f and returning.
+// The second return is the Stmts that copy the result:
+// a Load and Store for a SimpleType,
+// a Copy for any other type,
+// or nothing if the Call is passed the return parameter of f.
+// Otherwise tailCall returns nil, nil.
+func tailCall(f *Fun, b *BBlk, i int) (*Call, []Stmt) {
+	call, ok := b.Stmts[i].(*Call)
+	if !ok || call.deleted() || (f.Ret == nil) != (call.Fun.Ret == nil) {
+		return nil, nil
 	}
-	next, b, i := nextStmt(b, i)
-	switch next1 := next.(type) {
-	case *Ret:
-		return true
+	var res Val
+	if call.Fun.Ret != nil {
+		res = call.Args[len(call.Args)-1]
+	}
+	var copies []Stmt
+	for {
+		var s Stmt
+		s, b, i = nextStmt(b, i)
+		switch s := s.(type) {
+		case *Arg:
+			if f.Ret == nil || s.Parm != f.Ret {
+				return nil, nil
+			}
+		case *Load, *Store, *Copy:
+			if !copiesResult(f, s, res, copies) {
+				return nil, nil
+			}
+			copies = append(copies, s)
+		case *Ret:
+			if !returnsResult(f, s, res, copies) {
+				return nil, nil
+			}
+			return call, copies
+		default:
+			return nil, nil
+		}
+	}
+}
+
+// copiesResult returns whether the Stmt continues copying
+// the result of a Call, res, to the return parameter of f,
+// given the preceding Stmts of the copy.
+func copiesResult(f *Fun, s Stmt, res Val, copies []Stmt) bool {
+	switch s := s.(type) {
 	case *Load:
-		if len(next1.Users()) != 1 || len(call.Args) == 0 {
+		return res != nil && len(copies) == 0 && s.Src == res && len(s.Users()) == 1
+	case *Store:
+		return len(copies) == 1 && s.Val == copies[0] && isRetArg(f, s.Dst)
+	case *Copy:
+		return res != nil && len(copies) == 0 && s.Src == res && isRetArg(f, s.Dst)
+	default:
+		return false
+	}
+}
+
+// returnsResult returns whether returning with the Ret
+// returns the result of a Call, res, given the Stmts that copied it.
+func returnsResult(f *Fun, ret *Ret, res Val, copies []Stmt) bool {
+	if ret.Far || len(copies) == 0 && res != nil && !isRetArg(f, res) {
+		return false
+	}
+	if len(copies) == 1 {
+		// A Load of the result that is never stored.
+		if _, ok := copies[0].(*Load); ok {
 			return false
 		}
-		if call.Args[len(call.Args)-1] != next1.Src {
-			return false
+	}
+	return true
+}
+
+func isArg(v Val) bool {
+	_, ok := v.(*Arg)
+	return ok
+}
+
+func isRetArg(f *Fun, v Val) bool {
+	arg, ok := v.(*Arg)
+	return ok && f.Ret != nil && arg.Parm == f.Ret
+}
+
+// forwardRets passes the return parameter of f
+// as the return parameter of each tail Call
+// whose result is copied to it before returning,
+// and removes the copy.
+//
+// This is only done if the return parameter is never read,
+// so writing to it directly from the callee is not observable.
+func forwardRets(f *Fun) bool {
+	if f.Ret == nil || !retWriteOnly(f) {
+		return false
+	}
+	var n int
+	for _, b := range f.BBlks {
+		for i := 0; i < len(b.Stmts); i++ {
+			call, copies := tailCall(f, b, i)
+			// Copies in a following BBlk may be shared
+			// with paths that do not go through the Call,
+			// so they cannot be removed.
+			if call == nil || len(copies) == 0 || !inBBlk(b, copies) {
+				continue
+			}
+			n++
+			for _, s := range copies {
+				s.delete()
+			}
+			last := len(call.Args) - 1
+			if res := call.Args[last]; !containsVal(call.Args[:last], res) {
+				res.value().rmUser(call)
+			}
+			arg := &Arg{val: newVal(f, f.Ret.Type), Parm: f.Ret}
+			arg.addUser(call)
+			call.Args[last] = arg
+			b.Stmts = append(b.Stmts[:i], append([]Stmt{arg}, b.Stmts[i:]...)...)
+			i++
+		}
+	}
+	return n > 0
+}
+
+// retWriteOnly returns whether the return parameter of f
+// is only stored to or passed as the return parameter of a Call.
+func retWriteOnly(f *Fun) bool {
+	for _, b := range f.BBlks {
+		for _, s := range b.Stmts {
+			arg, ok := s.(*Arg)
+			if !ok || arg.deleted() || arg.Parm != f.Ret {
+				continue
+			}
+			for _, u := range arg.Users() {
+				if u.storesTo(arg) {
+					continue
+				}
+				call, ok := u.(*Call)
+				if !ok || call.Fun.Ret == nil {
+					return false
+				}
+				last := len(call.Args) - 1
+				if call.Args[last] != arg || containsVal(call.Args[:last], arg) {
+					return false
+				}
+			}
 		}
-		next2, b, i := nextStmt(b, i)
-		store, ok := next2.(*Store)
-		if !ok || store.Val != next {
+	}
+	return true
+}
+
+func inBBlk(b *BBlk, ss []Stmt) bool {
+	for _, s := range ss {
+		if !containsStmt(b.Stmts, s) {
 			return false
 		}
-		arg, ok := store.Dst.(*Arg)
-		if !ok || arg.Parm != f.Ret {
+	}
+	return true
+}
+
+func containsStmt(ss []Stmt, s Stmt) bool {
+	for _, t := range ss {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsVal(vs []Val, v Val) bool {
+	for _, w := range vs {
+		if w == v {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeTailCycles removes mutually recursive tail calls
+// by merging the other Funs of the cycle into f.
+//
+// Each Fun tail called by f that has a sequence of tail calls back to f
+// is copied into f at most once, with its parameters in Allocs.
+// Tail calls to a merged Fun are replaced by stores to the parameter Allocs
+// and a jump to the beginning of its copy.
+// Tail calls back to f within the copies are self tail calls,
+// removed later by rmSelfTailCalls.
+//
+// Only Funs that can be copied are merged,
+// which in practice means that they are already optimized.
+// Funs are optimized callee-first,
+// so the last Fun of a cycle to be optimized
+// becomes a loop over the whole cycle,
+// and the others reach it with a bounded number of calls.
+func mergeTailCycles(f *Fun) bool {
+	if f.Block == nil && f.Fun != nil && f.Fun.Test {
+		// Don't merge calls in tests,
+		// because we want panics to report line numbers
+		// within the body of the test source.
+		return false
+	}
+	// There can be no calls in the 0th bblock,
+	// and we need to make sure it's here to copy
+	// merged block0 allocs, so just copy it over now.
+	bblks := make([]*BBlk, 0, len(f.BBlks))
+	bblks = append(bblks, f.BBlks[0])
+
+	entries := make(map[*Fun]*tailEntry)
+	seen := make(map[*Fun]bool)
+	todo := f.BBlks[1:]
+	for len(todo) > 0 {
+		b := todo[0]
+		todo = todo[1:]
+		for j, s := range b.Stmts {
+			if s.deleted() {
+				continue
+			}
+			call, copies := tailCall(f, b, j)
+			if call == nil || len(copies) > 0 || !mergeable(f, call.Fun, seen) {
+				continue
+			}
+			var bs []*BBlk
+			e, ok := entries[call.Fun]
+			if !ok {
+				e, bs = newTailEntry(f, bblks[0], call.Fun)
+				entries[call.Fun] = e
+			}
+			s.delete()
+			b0, b1 := splitBBlk(b, j)
+			for k, parm := range e.parms {
+				addStore(b0, parm, call.Args[k])
+			}
+			addJmp(b0, e.bblk)
+			todo = append(append(bs, b1), todo...)
+			b = b0 // added to bblks after the break
+			break
+		}
+		b.N = len(bblks)
+		bblks = append(bblks, b)
+	}
+	f.BBlks = bblks
+	return len(entries) > 0
+}
+
+// A tailEntry is the beginning of a Fun merged by mergeTailCycles.
+type tailEntry struct {
+	bblk  *BBlk
+	parms []*Alloc
+}
+
+// newTailEntry returns the tailEntry of a copy of g merged into f
+// and the BBlks of the copy, beginning with the tailEntry BBlk.
+// The parameter Allocs are added to b0,
+// the 0th BBlk of f.
+func newTailEntry(f *Fun, b0 *BBlk, g *Fun) (*tailEntry, []*BBlk) {
+	e := &tailEntry{bblk: &BBlk{}}
+	term := b0.Stmts[len(b0.Stmts)-1]
+	b0.Stmts = b0.Stmts[:len(b0.Stmts)-1]
+	var args []Val
+	for _, p := range g.Parms {
+		alloc := &Alloc{val: newVal(f, p.Type.Ref())}
+		b0.Stmts = append(b0.Stmts, alloc)
+		e.parms = append(e.parms, alloc)
+		args = append(args, addLoad(f, e.bblk, alloc))
+	}
+	b0.Stmts = append(b0.Stmts, term)
+	if g.Ret != nil {
+		args = append(args, addArg(f, e.bblk, f.Ret))
+	}
+	bRet := &BBlk{}
+	addRet(bRet)
+	bs := copyForInline(g, f, bRet, nil, args)
+	f.NVals += g.NVals
+	moveAllocs(b0, bs[0])
+	addJmp(e.bblk, bs[0])
+	return e, append(append([]*BBlk{e.bblk}, bs...), bRet)
+}
+
+// mergeable returns whether mergeTailCycles can merge g into f.
+// seen holds the Funs already searched for tail calls to f.
+func mergeable(f, g *Fun, seen map[*Fun]bool) bool {
+	if g == f || g.Block != nil || g.CanFarRet || len(g.BBlks) == 0 {
+		return false
+	}
+	for _, p := range g.Parms {
+		if p.Value && !p.NoEscape {
+			// The copy made for a pass-by-value parameter
+			// would be shared by each iteration of the loop.
 			return false
 		}
-		next3, _, _ := nextStmt(b, i)
-		_, ok = next3.(*Ret)
-		return ok
-	case *Copy:
-		// TODO: tail-call elimination for non-simple return types
+	}
+	return copyable(g) && tailCallsTo(g, f, seen)
+}
+
+// copyable returns whether the BBlks of f can be copied into another Fun.
+// Block literals and module-level Val initializers
+// are assumed to be used in only one place,
+// so f cannot be copied if it creates a block literal
+// or calls a module-level Val initializer.
+func copyable(f *Fun) bool {
+	for _, b := range f.BBlks {
+		for _, s := range b.Stmts {
+			switch s := s.(type) {
+			case *MakeVirt:
+				if len(s.Virts) == 1 && s.Virts[0].Block != nil {
+					return false
+				}
+			case *Call:
+				if s.Fun.Val != nil {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// tailCallsTo returns whether there is a sequence of tail calls from src to dst.
+// seen holds the Funs already searched;
+// they are assumed to have no such sequence.
+func tailCallsTo(src, dst *Fun, seen map[*Fun]bool) bool {
+	if src == dst {
+		return true
+	}
+	if seen[src] {
 		return false
 	}
+	seen[src] = true
+	for _, b := range src.BBlks {
+		for i := range b.Stmts {
+			if call, _ := tailCall(src, b, i); call != nil && tailCallsTo(call.Fun, dst, seen) {
+				delete(seen, src)
+				return true
+			}
+		}
+	}
 	return false
 }
 
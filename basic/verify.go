@@ -0,0 +1,334 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+package basic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Verify returns an error describing any bugs found in the module,
+// or nil if there are none.
+//
+// In addition to the per-statement checks
+// shown as BUG comments by Mod.String,
+// Verify checks the invariants of each function as a whole:
+// every BBlk ends in, and only in, a terminal statement;
+// BBlk.In are the BBlks whose terminals jump to the BBlk;
+// each value is defined in the function before it is used,
+// and its definition dominates its uses;
+// Phis are only at the beginning of a BBlk,
+// they have exactly one entry for each BBlk in In,
+// and the definition of each entry's value dominates its BBlk;
+// there are no deleted statements;
+// and parameter types are simple types.
+//
+// A reported bug indicates a bug in the basic package, not the user input.
+func Verify(m *Mod) error {
+	var bugs []string
+	for _, f := range m.Funs {
+		bugs = append(bugs, verifyFun(f)...)
+	}
+	return bugsError(bugs)
+}
+
+func bugsError(bugs []string) error {
+	if len(bugs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(bugs, "\n"))
+}
+
+func verifyFun(f *Fun) []string {
+	if f.BBlks == nil {
+		return nil // a declaration
+	}
+	v := &verifier{f: f}
+	v.verifyParms()
+	if len(f.BBlks) == 0 {
+		v.bugf(nil, "no BBlks")
+		return v.bugs
+	}
+	v.bblkIndex = make(map[*BBlk]int, len(f.BBlks))
+	v.defs = make(map[Val]pos)
+	for i, b := range f.BBlks {
+		v.bblkIndex[b] = i
+		for j, s := range b.Stmts {
+			if val, ok := s.(Val); ok {
+				v.defs[val] = pos{bblk: i, stmt: j}
+			}
+		}
+	}
+	v.verifyEdges()
+	v.doms = dominators(f.BBlks, v.bblkIndex, v.preds)
+	for i, b := range f.BBlks {
+		for j, s := range b.Stmts {
+			v.verifyStmt(i, j, s)
+		}
+	}
+	return v.bugs
+}
+
+type verifier struct {
+	f    *Fun
+	bugs []string
+	// bblkIndex is the index of each BBlk in f.BBlks.
+	bblkIndex map[*BBlk]int
+	// defs is the position of the definition of each Val.
+	defs map[Val]pos
+	// preds are the BBlks that jump to each BBlk, by index.
+	preds [][]*BBlk
+	// doms are the dominators of each BBlk, by index.
+	doms [][]bool
+}
+
+type pos struct {
+	bblk int
+	stmt int
+}
+
+func (v *verifier) bugf(b *BBlk, format string, vs ...interface{}) {
+	var s strings.Builder
+	s.WriteString(v.f.name())
+	if b != nil {
+		fmt.Fprintf(&s, ": BBlk %d", b.N)
+	}
+	s.WriteString(": ")
+	fmt.Fprintf(&s, format, vs...)
+	v.bugs = append(v.bugs, s.String())
+}
+
+func (v *verifier) verifyParms() {
+	for _, p := range v.f.Parms {
+		if !SimpleType(p.Type) {
+			v.bugf(nil, "parameter %d has a composite type %s", p.N, p.Type)
+		}
+	}
+	if v.f.Ret != nil && !SimpleType(v.f.Ret.Type) {
+		v.bugf(nil, "return parameter has a composite type %s", v.f.Ret.Type)
+	}
+}
+
+// verifyEdges verifies the terminal statements of each BBlk,
+// computes v.preds, and checks them against the In of each BBlk.
+func (v *verifier) verifyEdges() {
+	v.preds = make([][]*BBlk, len(v.f.BBlks))
+	for _, b := range v.f.BBlks {
+		v.verifyTerm(b)
+		for _, o := range b.Out() {
+			i, ok := v.bblkIndex[o]
+			if !ok {
+				v.bugf(b, "jumps to BBlk %d, which is not in the function", o.N)
+				continue
+			}
+			if !containsBBlk(v.preds[i], b) {
+				v.preds[i] = append(v.preds[i], b)
+			}
+		}
+	}
+	for i, b := range v.f.BBlks {
+		for _, p := range v.preds[i] {
+			if !containsBBlk(b.In, p) {
+				v.bugf(b, "BBlk %d jumps here, but is not in In", p.N)
+			}
+		}
+		for _, in := range b.In {
+			if !containsBBlk(v.preds[i], in) {
+				v.bugf(b, "BBlk %d is in In, but does not jump here", in.N)
+			}
+		}
+	}
+}
+
+func (v *verifier) verifyTerm(b *BBlk) {
+	if len(b.Stmts) == 0 {
+		v.bugf(b, "no statements")
+		return
+	}
+	for i, s := range b.Stmts {
+		_, isTerm := s.(Term)
+		switch last := i == len(b.Stmts)-1; {
+		case last && !isTerm:
+			v.bugf(b, "ends in non-terminal statement %s", stmtString(s))
+		case !last && isTerm:
+			v.bugf(b, "terminal statement %s before the end", stmtString(s))
+		}
+	}
+}
+
+// verifyStmt verifies the jth statement of the ith BBlk.
+func (v *verifier) verifyStmt(i, j int, s Stmt) {
+	b := v.f.BBlks[i]
+	if s.deleted() {
+		v.bugf(b, "deleted statement %s", stmtString(s))
+	}
+	if bug := s.bugs(); bug != "" {
+		v.bugf(b, "%s: %s", stmtString(s), bug)
+	}
+	switch s := s.(type) {
+	case *Phi:
+		v.verifyPhi(b, j, s)
+	case *Arg:
+		if s.Parm != v.f.Ret && !containsParm(v.f.Parms, s.Parm) {
+			v.bugf(b, "%s: argument of a parameter not in the function", stmtString(s))
+		}
+	}
+	v.verifyUses(i, j, s)
+}
+
+// verifyUses verifies that the values used by the jth statement of the ith BBlk
+// are defined before and dominate their uses.
+func (v *verifier) verifyUses(i, j int, s Stmt) {
+	b := v.f.BBlks[i]
+	for k, u := range s.Uses() {
+		if u == s {
+			continue
+		}
+		if phi, ok := s.(*Phi); ok && k < len(phi.In) {
+			v.verifyPhiUse(b, phi, k, u)
+			continue
+		}
+		def, ok := v.defs[u]
+		switch {
+		case !ok:
+			v.bugf(b, "%s: uses $%d, which is not defined in the function",
+				stmtString(s), u.Num())
+		case def.bblk == i && def.stmt >= j:
+			v.bugf(b, "%s: uses $%d before it is defined",
+				stmtString(s), u.Num())
+		case v.doms[i] != nil && !v.doms[i][def.bblk]:
+			v.bugf(b, "%s: uses $%d, which is defined in BBlk %d and does not dominate the use",
+				stmtString(s), u.Num(), v.f.BBlks[def.bblk].N)
+		}
+	}
+}
+
+// verifyPhi verifies the placement and entries of the jth statement of b, a Phi.
+func (v *verifier) verifyPhi(b *BBlk, j int, phi *Phi) {
+	if j > 0 {
+		if _, ok := b.Stmts[j-1].(*Phi); !ok {
+			v.bugf(b, "%s: phi after a non-phi statement", stmtString(phi))
+		}
+	}
+	for k, in := range phi.In {
+		if !containsBBlk(b.In, in) {
+			v.bugf(b, "%s: BBlk %d is not in In", stmtString(phi), in.N)
+		}
+		if containsBBlk(phi.In[:k], in) {
+			v.bugf(b, "%s: BBlk %d has multiple entries", stmtString(phi), in.N)
+		}
+	}
+	for _, in := range b.In {
+		if !containsBBlk(phi.In, in) {
+			v.bugf(b, "%s: no entry for BBlk %d", stmtString(phi), in.N)
+		}
+	}
+}
+
+// verifyPhiUse verifies the value u of the kth entry of a Phi in b.
+// The value of a Phi entry must be available
+// at the end of the entry's BBlk.
+func (v *verifier) verifyPhiUse(b *BBlk, phi *Phi, k int, u Val) {
+	def, ok := v.defs[u]
+	if !ok {
+		v.bugf(b, "%s: uses $%d, which is not defined in the function",
+			stmtString(phi), u.Num())
+		return
+	}
+	in, ok := v.bblkIndex[phi.In[k]]
+	if ok && v.doms[in] != nil && !v.doms[in][def.bblk] {
+		v.bugf(b, "%s: uses $%d, which is defined in BBlk %d and does not dominate BBlk %d",
+			stmtString(phi), u.Num(), v.f.BBlks[def.bblk].N, phi.In[k].N)
+	}
+}
+
+// dominators returns, for each BBlk index,
+// the set of BBlk indices that dominate it.
+// The set is nil for BBlks unreachable from the first BBlk.
+func dominators(bblks []*BBlk, index map[*BBlk]int, preds [][]*BBlk) [][]bool {
+	reachable := reachableBBlks(bblks, index)
+	doms := make([][]bool, len(bblks))
+	for i := range bblks {
+		if !reachable[i] {
+			continue
+		}
+		doms[i] = make([]bool, len(bblks))
+		for j := range doms[i] {
+			doms[i][j] = i != 0 || j == 0
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := 1; i < len(bblks); i++ {
+			if !reachable[i] {
+				continue
+			}
+			for j := range bblks {
+				dom := j == i || predsDominated(doms, index, preds[i], j)
+				if doms[i][j] != dom {
+					doms[i][j] = dom
+					changed = true
+				}
+			}
+		}
+	}
+	return doms
+}
+
+// reachableBBlks returns, for each BBlk index,
+// whether the BBlk is reachable from the first BBlk.
+func reachableBBlks(bblks []*BBlk, index map[*BBlk]int) []bool {
+	reachable := make([]bool, len(bblks))
+	stack := []*BBlk{bblks[0]}
+	reachable[0] = true
+	for len(stack) > 0 {
+		b := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, o := range b.Out() {
+			if i, ok := index[o]; ok && !reachable[i] {
+				reachable[i] = true
+				stack = append(stack, o)
+			}
+		}
+	}
+	return reachable
+}
+
+// predsDominated returns whether the jth BBlk
+// dominates each of the reachable preds.
+func predsDominated(doms [][]bool, index map[*BBlk]int, preds []*BBlk, j int) bool {
+	for _, p := range preds {
+		if pi := index[p]; doms[pi] != nil && !doms[pi][j] {
+			return false
+		}
+	}
+	return true
+}
+
+func containsBBlk(bs []*BBlk, b *BBlk) bool {
+	for _, bb := range bs {
+		if bb == b {
+			return true
+		}
+	}
+	return false
+}
+
+func containsParm(ps []*Parm, p *Parm) bool {
+	for _, pp := range ps {
+		if pp == p {
+			return true
+		}
+	}
+	return false
+}
+
+func stmtString(s Stmt) string {
+	var str strings.Builder
+	if v, ok := s.(Val); ok {
+		fmt.Fprintf(&str, "$%d := ", v.Num())
+	}
+	s.buildString(&str)
+	return str.String()
+}
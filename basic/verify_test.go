@@ -0,0 +1,185 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+package basic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/eaburns/pea/ast"
+	"github.com/eaburns/pea/types"
+)
+
+func TestVerify(t *testing.T) {
+	// foo: compiles to BBlks:
+	// 	0: args; jmp 1
+	// 	1: $2 := $0 < 3; switch $2 [true 2] [false 3]
+	// 	2: $3 := 1; $4 := $0 + $3; jmp 4
+	// 	3: $5 := 1; $6 := $0 - $5; jmp 4
+	// 	4: $7 := phi(2: $4, 3: $6); store the result and return it
+	const src = `
+		func [foo: i Int ^Int | ^i < 3 ifTrue: [i + 1] ifFalse: [i - 1]]
+		func [bar: _ String |]
+	`
+	tests := []struct {
+		name   string
+		mutate func(foo, bar *Fun)
+		want   string
+	}{
+		{
+			name:   "ok",
+			mutate: func(*Fun, *Fun) {},
+			want:   "",
+		},
+		{
+			name: "missing terminal",
+			mutate: func(foo, _ *Fun) {
+				b := foo.BBlks[4]
+				b.Stmts = b.Stmts[:len(b.Stmts)-1]
+			},
+			want: "function0: BBlk 4: ends in non-terminal statement",
+		},
+		{
+			name: "early terminal",
+			mutate: func(foo, _ *Fun) {
+				b := foo.BBlks[2]
+				b.Stmts = append([]Stmt{&Ret{}}, b.Stmts...)
+			},
+			want: "function0: BBlk 2: terminal statement return before the end",
+		},
+		{
+			name: "missing In",
+			mutate: func(foo, _ *Fun) {
+				foo.BBlks[4].In = foo.BBlks[4].In[:1]
+			},
+			want: "function0: BBlk 4: BBlk 3 jumps here, but is not in In",
+		},
+		{
+			name: "extra In",
+			mutate: func(foo, _ *Fun) {
+				foo.BBlks[3].In = append(foo.BBlks[3].In, foo.BBlks[2])
+			},
+			want: "function0: BBlk 3: BBlk 2 is in In, but does not jump here",
+		},
+		{
+			name: "use before definition",
+			mutate: func(foo, _ *Fun) {
+				b := foo.BBlks[2]
+				b.Stmts[0], b.Stmts[1] = b.Stmts[1], b.Stmts[0]
+			},
+			want: "uses $3 before it is defined",
+		},
+		{
+			name: "definition does not dominate use",
+			mutate: func(foo, _ *Fun) {
+				foo.BBlks[3].Stmts[1].(*Op).Args[1] = foo.BBlks[2].Stmts[0].(Val)
+			},
+			want: "function0: BBlk 3: $6 := $0 - $3: uses $3, which is defined in BBlk 2 and does not dominate the use",
+		},
+		{
+			name: "phi definition does not dominate entry",
+			mutate: func(foo, _ *Fun) {
+				foo.BBlks[4].Stmts[0].(*Phi).Args[1] = foo.BBlks[2].Stmts[1].(Val)
+			},
+			want: "function0: BBlk 4: $7 := phi(2: $4, 3: $4): uses $4, which is defined in BBlk 2 and does not dominate BBlk 3",
+		},
+		{
+			name: "phi missing entry",
+			mutate: func(foo, _ *Fun) {
+				phi := foo.BBlks[4].Stmts[0].(*Phi)
+				phi.In = phi.In[:1]
+				phi.Args = phi.Args[:1]
+			},
+			want: "function0: BBlk 4: $7 := phi(2: $4): no entry for BBlk 3",
+		},
+		{
+			name: "phi after non-phi",
+			mutate: func(foo, _ *Fun) {
+				b := foo.BBlks[4]
+				b.Stmts[0], b.Stmts[1] = b.Stmts[1], b.Stmts[0]
+			},
+			want: "function0: BBlk 4: $7 := phi(2: $4, 3: $6): phi after a non-phi statement",
+		},
+		{
+			name: "use of value not in the function",
+			mutate: func(foo, bar *Fun) {
+				foo.BBlks[4].Stmts[2].(*Store).Val = &IntLit{val: val{n: 100}}
+			},
+			want: "uses $100, which is not defined in the function",
+		},
+		{
+			name: "deleted statement",
+			mutate: func(foo, _ *Fun) {
+				foo.BBlks[2].Stmts[0].delete()
+			},
+			want: "function0: BBlk 2: deleted statement $3 := 1",
+		},
+		{
+			name: "statement bug",
+			mutate: func(foo, _ *Fun) {
+				foo.BBlks[4].Stmts[2].(*Store).Val = foo.BBlks[1].Stmts[1].(Val)
+			},
+			want: "store type mismatch: dst Int != val Bool",
+		},
+		{
+			name: "composite parameter",
+			mutate: func(_, bar *Fun) {
+				bar.Parms[0].Type = bar.Parms[0].Type.Args[0].Type
+			},
+			want: "parameter 0 has a composite type String",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			mod, errs := compile(src)
+			if len(errs) > 0 {
+				t.Fatalf("failed to compile: %v", errs)
+			}
+			test.mutate(findTestFunBySelector(mod, "foo:"), findTestFunBySelector(mod, "bar:"))
+			var got string
+			if err := Verify(mod); err != nil {
+				got = err.Error()
+			}
+			if test.want == "" && got != "" || !strings.Contains(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOptimizeVerify(t *testing.T) {
+	const src = `
+		Meth Bool [ifTrue: f Nil Fun | self ifTrue: f ifFalse: []]
+		func [foo ^Int |
+			1 < 10 ifTrue: [^3].
+			^5
+		]
+
+		// Empty-type arguments are elided from virtual calls.
+		type Fooer {[foo: Nil]}
+		meth Int [foo: _ Nil |]
+		func [bar |
+			f Fooer := 42.
+			f foo: {}
+		]
+
+		// Strings can be sliced.
+		func [baz: s String ^String | ^s fromByte: 1 toByte: 2]
+
+		// Blocks in functions with an empty return type
+		// have no far-return location.
+		func [qux: b Bool | b ifTrue: [^{}] ifFalse: []]
+	`
+	p := ast.NewParser("#test")
+	if err := p.Parse("", strings.NewReader(src)); err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	typesMod, errs := types.Check(p.Mod(), types.Config{})
+	if len(errs) > 0 {
+		t.Fatalf("failed to check: %v", errs)
+	}
+	if err := OptimizeVerify(Build(typesMod)); err != nil {
+		t.Errorf("OptimizeVerify failed: %s", err)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+package gengo
+
+import (
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"regexp"
+	"strings"
+
+	"github.com/eaburns/pea/basic"
+	"github.com/eaburns/pea/types"
+)
+
+// A Debug basic.Mod is generated with readable names
+// for the variables of each function,
+// so that they can be inspected in a debugger, like delve.
+//
+// Each Alloc of a named variable addresses
+// a Go variable with the source variable's name,
+// and each by-value parameter is assigned
+// to a Go variable with the parameter's name.
+// Calls are commented with the name of the called function.
+
+// debugNames returns the Go names of the named variables of a Fun.
+// A name is the source variable name with _ appended
+// as needed to distinguish it from Go keywords,
+// from the other names,
+// and from the identifiers of the generated Go code, src.
+func debugNames(f *basic.Fun, src string) map[*types.Var]string {
+	used := goIdents(src)
+	names := make(map[*types.Var]string)
+	add := func(v *types.Var) {
+		if v == nil || v.Name == "" || v.Name == "_" || names[v] != "" {
+			return
+		}
+		name := v.Name
+		for used[name] || token.IsKeyword(name) || genNameRegexp.MatchString(name) {
+			name += "_"
+		}
+		used[name] = true
+		names[v] = name
+	}
+	for _, p := range f.Parms {
+		if p.Value {
+			add(p.Var)
+		}
+	}
+	for _, b := range f.BBlks {
+		for _, stmt := range b.Stmts {
+			if a, ok := stmt.(*basic.Alloc); ok {
+				add(a.Var)
+			}
+		}
+	}
+	return names
+}
+
+// goIdents returns the set of identifiers in Go source code.
+func goIdents(src string) map[string]bool {
+	idents := make(map[string]bool)
+	var sc scanner.Scanner
+	fset := token.NewFileSet()
+	sc.Init(fset.AddFile("", fset.Base(), len(src)), []byte(src), nil, 0)
+	for {
+		_, tok, lit := sc.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.IDENT {
+			idents[lit] = true
+		}
+	}
+	return idents
+}
+
+// genNameRegexp matches the names of generated
+// parameters, values, and labels.
+var genNameRegexp = regexp.MustCompile(`^[pxL][0-9]+$`)
+
+// genDebugVars generates the declarations
+// of the named variables of a Fun.
+func genDebugVars(f *basic.Fun, names map[*types.Var]string, ts typeSet, s *strings.Builder) {
+	for _, p := range f.Parms {
+		if name := names[p.Var]; p.Value && name != "" {
+			fmt.Fprintf(s, "\t%s := p%d\n\tuse(%s)\n", name, p.N, name)
+		}
+	}
+	for _, b := range f.BBlks {
+		for _, stmt := range b.Stmts {
+			a, ok := stmt.(*basic.Alloc)
+			if !ok || names[a.Var] == "" {
+				continue
+			}
+			fmt.Fprintf(s, "\tvar %s ", names[a.Var])
+			genTypeName(a.Type().Args[0].Type, ts, s)
+			s.WriteRune('\n')
+		}
+	}
+}
+
+// funComment returns a comment describing a Fun.
+func funComment(f *basic.Fun) string {
+	switch {
+	case f.Block != nil && f.Fun != nil:
+		return fmt.Sprintf("block in %s", f.Fun)
+	case f.Block != nil && f.Val != nil:
+		return fmt.Sprintf("block in %s", f.Val)
+	case f.Fun != nil:
+		return f.Fun.String()
+	case f.Val != nil:
+		return fmt.Sprintf("initializer of %s", f.Val)
+	default:
+		return "module initializer"
+	}
+}
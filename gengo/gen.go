@@ -6,37 +6,44 @@ package gengo
 import (
 	"fmt"
 	"io"
+	"path/filepath"
 	"sort"
 	"strings"
 
 	"github.com/eaburns/pea/basic"
+	"github.com/eaburns/pea/loc"
 	"github.com/eaburns/pea/types"
 )
 
 type typeSet map[*types.Type]bool
 
 var builtInTypes = map[types.BuiltInType]string{
-	types.NilType:    "struct{}",
-	types.StringType: "[]byte",
-	types.BoolType:   "uint8",
-	// TODO: handle different size Int.
-	types.IntType:   "int",
-	types.Int8Type:  "int8",
-	types.Int16Type: "int16",
-	types.Int32Type: "int32",
-	types.Int64Type: "int64",
-	// TODO: handle different size UInt.
-	types.UIntType:   "uint",
-	types.UInt8Type:  "uint8",
-	types.UInt16Type: "uint16",
-	types.UInt32Type: "uint32",
-	types.UInt64Type: "uint64",
-	// TODO: handle different size Float.
-	types.FloatType:   "float64",
+	types.NilType:     "struct{}",
+	types.StringType:  "[]byte",
+	types.BoolType:    "uint8",
+	types.Int8Type:    "int8",
+	types.Int16Type:   "int16",
+	types.Int32Type:   "int32",
+	types.Int64Type:   "int64",
+	types.UInt8Type:   "uint8",
+	types.UInt16Type:  "uint16",
+	types.UInt32Type:  "uint32",
+	types.UInt64Type:  "uint64",
 	types.Float32Type: "float32",
 	types.Float64Type: "float64",
 }
 
+// sizedTypes are the built-in types with a size
+// set by the types.Config of the module:
+// IntSize for Int and UInt, and FloatSize for Float.
+// Like user-defined types, they are named by mangleType;
+// their definitions are aliases of the Go type of the configured size.
+var sizedTypes = map[types.BuiltInType]bool{
+	types.IntType:   true,
+	types.UIntType:  true,
+	types.FloatType: true,
+}
+
 var numOps = map[basic.OpCode]string{
 	basic.BitwiseAndOp: "&",
 	basic.BitwiseOrOp:  "|",
@@ -69,6 +76,10 @@ var cmpOps = map[basic.OpCode]string{
 // the globally unique name of the defintion.
 // The following N bytes are the Go source of the definition,
 // always ending in a newline.
+//
+// Section names beginning with # are never written by WriteMod.
+// They are reserved for metadata added by the caller,
+// and they are ignored by the Merger.
 func WriteMod(w io.Writer, mod *basic.Mod) error {
 	ts := make(typeSet)
 	for _, str := range mod.Strings {
@@ -118,7 +129,7 @@ func WriteMod(w io.Writer, mod *basic.Mod) error {
 		for _, typ := range sorted {
 			done[typ] = true
 			var s strings.Builder
-			genTypeDef(typ, ts, &s)
+			genTypeDef(mod.Mod, typ, ts, &s)
 			name := mangleType(typ, new(strings.Builder))
 			_, err := fmt.Fprintf(w, "%d %s\n%s", s.Len(), name, s.String())
 			if err != nil {
@@ -152,7 +163,8 @@ func genTypeName(typ *types.Type, ts typeSet, s *strings.Builder) {
 		s.WriteString(builtInTypes[typ.Tag().BuiltIn])
 	case typ.BuiltIn == types.BlockType ||
 		typ.BuiltIn == types.FunType ||
-		typ.BuiltIn == 0:
+		typ.BuiltIn == 0 ||
+		sizedTypes[typ.BuiltIn]:
 		ts[typ] = true
 		mangleType(typ, s)
 	case builtInTypes[typ.BuiltIn] != "":
@@ -162,8 +174,10 @@ func genTypeName(typ *types.Type, ts typeSet, s *strings.Builder) {
 	}
 }
 
-func genTypeDef(typ *types.Type, ts typeSet, s *strings.Builder) {
+func genTypeDef(mod *types.Mod, typ *types.Type, ts typeSet, s *strings.Builder) {
 	switch {
+	case sizedTypes[typ.BuiltIn]:
+		genSizedTypeDef(mod, typ, s)
 	case len(typ.Virts) > 0:
 		genVirtTypeDef(typ, ts, s)
 	case len(typ.Cases) > 0:
@@ -178,6 +192,27 @@ func genTypeDef(typ *types.Type, ts typeSet, s *strings.Builder) {
 	}
 }
 
+func genSizedTypeDef(mod *types.Mod, typ *types.Type, s *strings.Builder) {
+	var goType string
+	switch {
+	case typ.BuiltIn == types.FloatType:
+		goType = fmt.Sprintf("float%d", mod.FloatSize)
+	case mod.IntSize == 64:
+		// Go source added to modules may use int and uint,
+		// instead of the mangled names of Int and UInt,
+		// for Int and UInt of the default size.
+		goType = "int"
+	default:
+		goType = fmt.Sprintf("int%d", mod.IntSize)
+	}
+	if typ.BuiltIn == types.UIntType {
+		goType = "u" + goType
+	}
+	s.WriteString("type ")
+	mangleType(typ, s)
+	fmt.Fprintf(s, " = %s\n", goType)
+}
+
 func genAndTypeDef(typ *types.Type, ts typeSet, s *strings.Builder) {
 	s.WriteString("type ")
 	mangleType(typ, s)
@@ -282,9 +317,10 @@ const catchFarRet = `	token := nextToken()
 `
 
 func genFunDef(f *basic.Fun, ts typeSet, s *strings.Builder) {
-	if f.Fun != nil && f.Block == nil {
-		fmt.Fprintf(s, "// %s\n", f.Fun)
+	if f.Mod.Debug || f.Fun != nil && f.Block == nil {
+		fmt.Fprintf(s, "// %s\n", funComment(f))
 	}
+	file := genFunLineDirective(f, s)
 	s.WriteString("func ")
 	mangleFun(f, s)
 	s.WriteRune('(')
@@ -298,7 +334,7 @@ func genFunDef(f *basic.Fun, ts typeSet, s *strings.Builder) {
 	default:
 		s.WriteString("var token retToken\n\tuse(token)\n")
 	}
-	genFunBody(f, ts, s)
+	genFunBody(f, file, ts, s)
 	s.WriteString("}\n")
 }
 
@@ -322,7 +358,13 @@ func genFunParms(f *basic.Fun, ts typeSet, s *strings.Builder) {
 	s.WriteRune('\n')
 }
 
-func genFunBody(f *basic.Fun, ts typeSet, s *strings.Builder) {
+func genFunBody(f *basic.Fun, file string, ts typeSet, s *strings.Builder) {
+	var names map[*types.Var]string
+	if f.Mod.Debug {
+		var stmts strings.Builder
+		genStmts(f, file, nil, ts, &stmts)
+		names = debugNames(f, s.String()+stmts.String())
+	}
 	for _, b := range f.BBlks {
 		for _, stmt := range b.Stmts {
 			v, ok := stmt.(basic.Val)
@@ -334,18 +376,109 @@ func genFunBody(f *basic.Fun, ts typeSet, s *strings.Builder) {
 			s.WriteRune('\n')
 		}
 	}
+	genDebugVars(f, names, ts, s)
+	genStmts(f, file, names, ts, s)
+}
+
+// genStmts generates the statements of a Fun.
+// Allocs of variables with names are the addresses
+// of the Go variables of those names.
+func genStmts(f *basic.Fun, file string, names map[*types.Var]string, ts typeSet, s *strings.Builder) {
+	l := f.Loc
 	for i, b := range f.BBlks {
 		if i > 0 {
 			fmt.Fprintf(s, "L%d:\n", b.N)
 		}
 		for _, stmt := range b.Stmts {
-			genStmt(f, stmt, ts, s)
+			if _, ok := stmt.(*basic.Phi); ok {
+				// Phis are assigned by the jumps into the BBlk.
+				continue
+			}
+			if sl := stmt.Loc(); sl != nil {
+				l = sl
+			}
+			s.WriteRune('\t')
+			file = genLineDirective(l, file, s)
+			if a, ok := stmt.(*basic.Alloc); ok && names[a.Var] != "" {
+				fmt.Fprintf(s, "x%d = &%s\n", a.Num(), names[a.Var])
+				continue
+			}
+			genStmt(f, b, stmt, ts, s)
 		}
 	}
 }
 
-func genStmt(f *basic.Fun, stmt basic.Stmt, ts typeSet, s *strings.Builder) {
-	s.WriteRune('\t')
+// genLineDirective generates a Go line directive
+// giving the source location of the following Go code,
+// and returns the file name of the directive.
+// The file name is omitted if it is the same as that of
+// the previous directive of the function, prev.
+//
+// Each statement is preceded by a directive,
+// so that Go compile errors, stack traces, profiles,
+// and runtime errors (see runtimePanicVal)
+// refer to its source location.
+// Multiple Go statements are generated per source line,
+// so these are /*line*/ directives on the same line as the statement;
+// a //line directive would only be correct for the first.
+// Statements with no location of their own
+// have the location of the nearest preceding statement.
+func genLineDirective(l *loc.Loc, prev string, s *strings.Builder) string {
+	if l == nil {
+		return prev
+	}
+	file := lineDirectiveFile(l)
+	if file == prev {
+		fmt.Fprintf(s, "/*line :%d:%d*/", l.Line[0], l.Col[0])
+	} else {
+		fmt.Fprintf(s, "/*line %s:%d:%d*/", file, l.Line[0], l.Col[0])
+	}
+	return file
+}
+
+// genFunLineDirective generates a //line directive
+// giving the source location of a Fun's definition
+// for the following Go function declaration,
+// and returns the file name of the directive.
+func genFunLineDirective(f *basic.Fun, s *strings.Builder) string {
+	if f.Loc == nil {
+		return ""
+	}
+	file := lineDirectiveFile(f.Loc)
+	fmt.Fprintf(s, "//line %s:%d:%d\n", file, f.Loc.Line[0], f.Loc.Col[0])
+	return file
+}
+
+// lineDirectiveFile returns the file name
+// to use in a line directive for a location.
+//
+// Relative file names in line directives are relative
+// to the directory of the Go source file,
+// so they are made absolute.
+// An empty file name means the previously recorded file,
+// so ?? is used instead, as the Go runtime does for unknown files.
+func lineDirectiveFile(l *loc.Loc) string {
+	if l.Path == "" {
+		return "??"
+	}
+	if abs, err := filepath.Abs(l.Path); err == nil {
+		return abs
+	}
+	return l.Path
+}
+
+// panicFile returns the file name of a Loc for a panicVal.
+// It is the same as the file name of the line directive,
+// so that panics and Go runtime errors print the same name,
+// but it is empty for an empty path.
+func panicFile(l *loc.Loc) string {
+	if l.Path == "" {
+		return ""
+	}
+	return lineDirectiveFile(l)
+}
+
+func genStmt(f *basic.Fun, b *basic.BBlk, stmt basic.Stmt, ts typeSet, s *strings.Builder) {
 	switch stmt := stmt.(type) {
 	case *basic.Comment:
 		fmt.Fprintf(s, "// %s", stmt.Text)
@@ -373,16 +506,19 @@ func genStmt(f *basic.Fun, stmt basic.Stmt, ts typeSet, s *strings.Builder) {
 		genPanic(f, stmt, s)
 	case *basic.Call:
 		genCall(f, stmt, s)
+		if f.Mod.Debug {
+			fmt.Fprintf(s, " // %s", funComment(stmt.Fun))
+		}
 	case *basic.VirtCall:
 		genVirtCall(stmt, s)
 	case *basic.Ret:
 		genRet(stmt, s)
 	case *basic.Jmp:
-		fmt.Fprintf(s, "goto L%d", stmt.Dst.N)
+		genGoto(b, stmt.Dst, s)
 	case *basic.Switch:
-		genSwitch(stmt, s)
+		genSwitch(b, stmt, s)
 	case basic.Val:
-		genVal(stmt, ts, s)
+		genVal(f, stmt, ts, s)
 	default:
 		panic(fmt.Sprintf("impossible type %T", stmt))
 	}
@@ -492,7 +628,7 @@ func genMakeVirt(stmt *basic.MakeVirt, ts typeSet, s *strings.Builder) {
 func genPanic(f *basic.Fun, stmt *basic.Panic, s *strings.Builder) {
 	loc := f.Mod.Mod.AST.Locs.Loc(stmt.Msg.AST.GetRange())
 	fmt.Fprintf(s, "panic(panicVal{msg: string(*x%d), file: %q, line: %d})",
-		stmt.Arg.Num(), loc.Path, loc.Line[0])
+		stmt.Arg.Num(), panicFile(loc), loc.Line[0])
 }
 
 func genCall(f *basic.Fun, stmt *basic.Call, s *strings.Builder) {
@@ -503,7 +639,7 @@ func genCall(f *basic.Fun, stmt *basic.Call, s *strings.Builder) {
 		// set the testFile and testLine.
 		loc := f.Mod.Mod.AST.Locs.Loc(stmt.Msg.AST.GetRange())
 		fmt.Fprintf(s, "func() {defer recoverTestLoc(%q, %d); ",
-			loc.Path, loc.Line[0])
+			panicFile(loc), loc.Line[0])
 		defer s.WriteString("}()")
 	}
 	mangleFun(stmt.Fun, s)
@@ -540,34 +676,68 @@ func genRet(stmt *basic.Ret, s *strings.Builder) {
 	}
 }
 
-func genSwitch(stmt *basic.Switch, s *strings.Builder) {
+func genSwitch(b *basic.BBlk, stmt *basic.Switch, s *strings.Builder) {
 	fmt.Fprintf(s, "switch x%d {", stmt.Val.Num())
-	if stmt.Val.Type().BuiltIn == types.BoolType {
-		// TODO: remove the hack to reverse bool 0/1.
-		for i, b := range stmt.Dsts {
-			fmt.Fprintf(s, "case %d: goto L%d; ", 1-i, b.N)
-		}
-	} else {
-		for i, b := range stmt.Dsts {
-			fmt.Fprintf(s, "case %d: goto L%d; ", i, b.N)
+	for i, dst := range stmt.Dsts {
+		if stmt.Val.Type().BuiltIn == types.BoolType {
+			// TODO: remove the hack to reverse bool 0/1.
+			fmt.Fprintf(s, "case %d: ", 1-i)
+		} else {
+			fmt.Fprintf(s, "case %d: ", i)
 		}
+		genGoto(b, dst, s)
+		s.WriteString("; ")
 	}
 	s.WriteRune('}')
 }
 
-func genVal(v basic.Val, ts typeSet, s *strings.Builder) {
+// genGoto generates a goto from b to dst,
+// preceded by a parallel assignment to the Phis of dst.
+func genGoto(b, dst *basic.BBlk, s *strings.Builder) {
+	var phis []*basic.Phi
+	for _, stmt := range dst.Stmts {
+		phi, ok := stmt.(*basic.Phi)
+		if !ok {
+			break
+		}
+		phis = append(phis, phi)
+	}
+	if len(phis) > 0 {
+		for i, phi := range phis {
+			if i > 0 {
+				s.WriteString(", ")
+			}
+			fmt.Fprintf(s, "x%d", phi.Num())
+		}
+		s.WriteString(" = ")
+		for i, phi := range phis {
+			if i > 0 {
+				s.WriteString(", ")
+			}
+			for j, in := range phi.In {
+				if in == b {
+					fmt.Fprintf(s, "x%d", phi.Args[j].Num())
+				}
+			}
+		}
+		s.WriteString("; ")
+	}
+	fmt.Fprintf(s, "goto L%d", dst.N)
+}
+
+func genVal(f *basic.Fun, v basic.Val, ts typeSet, s *strings.Builder) {
 	fmt.Fprintf(s, "x%d = ", v.Num())
 	switch v := v.(type) {
 	case *basic.IntLit:
-		t := builtInTypes[v.Type().BuiltIn]
-		fmt.Fprintf(s, "%s(%s)", t, v.Val.String())
+		genTypeName(v.Type(), ts, s)
+		fmt.Fprintf(s, "(%s)", v.Val.String())
 	case *basic.FloatLit:
-		t := builtInTypes[v.Type().BuiltIn]
+		genTypeName(v.Type(), ts, s)
 		// 39 digits of precision are needed to output the values of
 		// math.MaxFloat64 and math.SmallestNonzeroFloat64.
-		fmt.Fprintf(s, "%s(%.39e)", t, v.Val)
+		fmt.Fprintf(s, "(%.39e)", v.Val)
 	case *basic.Op:
-		genOp(v, s)
+		genOp(f, v, ts, s)
 	case *basic.Load:
 		fmt.Fprintf(s, "*x%d", v.Src.Num())
 	case *basic.Alloc:
@@ -597,17 +767,22 @@ func genVal(v basic.Val, ts typeSet, s *strings.Builder) {
 	}
 }
 
-func genOp(op *basic.Op, s *strings.Builder) {
+func genOp(f *basic.Fun, op *basic.Op, ts typeSet, s *strings.Builder) {
+	if isChecked(f, op) {
+		genCheckedOp(f, op, ts, s)
+		return
+	}
 	switch {
 	case op.Code == basic.ArraySizeOp:
-		fmt.Fprintf(s, "len(*x%d)", op.Args[0].Num())
+		genTypeName(op.Type(), ts, s)
+		fmt.Fprintf(s, "(len(*x%d))", op.Args[0].Num())
 
 	case op.Code == basic.UnionTagOp:
 		fmt.Fprintf(s, "(*x%d).tag", op.Args[0].Num())
 
 	case op.Code == basic.NumConvertOp:
-		fmt.Fprintf(s, "%s(x%d)",
-			builtInTypes[op.Type().BuiltIn], op.Args[0].Num())
+		genTypeName(op.Type(), ts, s)
+		fmt.Fprintf(s, "(x%d)", op.Args[0].Num())
 
 	case numOps[op.Code] != "":
 		c := numOps[op.Code]
@@ -629,3 +804,111 @@ func genOp(op *basic.Op, s *strings.Builder) {
 		panic("impossible")
 	}
 }
+
+// intTypes are the built-in integer types
+// mapped to whether they are signed.
+var intTypes = map[types.BuiltInType]bool{
+	types.IntType:    true,
+	types.Int8Type:   true,
+	types.Int16Type:  true,
+	types.Int32Type:  true,
+	types.Int64Type:  true,
+	types.UIntType:   false,
+	types.UInt8Type:  false,
+	types.UInt16Type: false,
+	types.UInt32Type: false,
+	types.UInt64Type: false,
+}
+
+// isChecked returns whether an Op is checked:
+// the Mod is basic.Mod.Checked, the Op has a Msg,
+// and it is integer arithmetic or a conversion to an integer type.
+func isChecked(f *basic.Fun, op *basic.Op) bool {
+	if !f.Mod.Checked || op.Msg == nil {
+		return false
+	}
+	if _, ok := intTypes[op.Type().BuiltIn]; !ok {
+		return false
+	}
+	switch op.Code {
+	case basic.PlusOp, basic.MinusOp, basic.TimesOp,
+		basic.DivideOp, basic.ModOp, basic.NumConvertOp:
+		return true
+	}
+	return false
+}
+
+// genCheckedOp generates a checked Op.
+// PlusOp, MinusOp, TimesOp, DivideOp, and NumConvertOp
+// panic if the result overflows,
+// and DivideOp and ModOp panic on division by zero.
+func genCheckedOp(f *basic.Fun, op *basic.Op, ts typeSet, s *strings.Builder) {
+	signed := intTypes[op.Type().BuiltIn]
+	x := op.Num()
+	l := op.Args[0].Num()
+	var r int
+	if len(op.Args) > 1 {
+		r = op.Args[1].Num()
+	}
+	var cond string
+	switch op.Code {
+	case basic.PlusOp:
+		fmt.Fprintf(s, "x%d + x%d", l, r)
+		cond = fmt.Sprintf("x%d < x%d", x, l)
+		if signed {
+			cond = fmt.Sprintf("(%s) != (x%d < 0)", cond, r)
+		}
+	case basic.MinusOp:
+		fmt.Fprintf(s, "x%d - x%d", l, r)
+		cond = fmt.Sprintf("x%d > x%d", x, l)
+		if signed {
+			cond = fmt.Sprintf("(%s) != (x%d < 0)", cond, r)
+		}
+	case basic.TimesOp:
+		fmt.Fprintf(s, "x%d * x%d", l, r)
+		cond = fmt.Sprintf("x%d/x%d != x%d", x, l, r)
+		if signed {
+			// MinInt * -1 is MinInt, and so is MinInt / -1.
+			cond += fmt.Sprintf(" || x%d == -1 && x%d < 0 && x%d < 0", l, r, x)
+		}
+		cond = fmt.Sprintf("x%d != 0 && (%s)", l, cond)
+	case basic.DivideOp, basic.ModOp:
+		s.WriteString("0; if ")
+		fmt.Fprintf(s, "x%d == 0 { ", r)
+		genPanicMsg(f, op.Msg, "integer divide by zero", s)
+		fmt.Fprintf(s, " }; x%d = x%d %s x%d", x, l, numOps[op.Code], r)
+		if op.Code == basic.DivideOp && signed {
+			// MinInt / -1 is MinInt.
+			cond = fmt.Sprintf("x%d == -1 && x%d < 0 && x%d < 0", r, l, x)
+		}
+	case basic.NumConvertOp:
+		genTypeName(op.Type(), ts, s)
+		fmt.Fprintf(s, "(x%d)", l)
+		src := op.Args[0].Type()
+		if _, ok := intTypes[src.BuiltIn]; ok {
+			var t strings.Builder
+			genTypeName(src, ts, &t)
+			cond = fmt.Sprintf("%s(x%d) != x%d || (x%d < 0) != (x%d < 0)",
+				t.String(), x, l, x, l)
+		} else {
+			// NaN is not equal to itself, so it fails the check too.
+			cond = fmt.Sprintf("float64(x%d) != math.Trunc(float64(x%d))", x, l)
+		}
+	default:
+		panic("impossible")
+	}
+	if cond == "" {
+		return
+	}
+	fmt.Fprintf(s, "; if %s { ", cond)
+	genPanicMsg(f, op.Msg, "integer overflow", s)
+	s.WriteString(" }")
+}
+
+// genPanicMsg generates a panic with a constant message
+// at the source location of a Msg.
+func genPanicMsg(f *basic.Fun, msg *types.Msg, text string, s *strings.Builder) {
+	loc := f.Mod.Mod.AST.Locs.Loc(msg.AST.GetRange())
+	fmt.Fprintf(s, "panic(panicVal{msg: %q, file: %q, line: %d})",
+		text, panicFile(loc), loc.Line[0])
+}
@@ -4,11 +4,18 @@ package gengo
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	goast "go/ast"
+	"go/parser"
+	"go/token"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 
@@ -598,6 +605,72 @@ func TestWriteMod(t *testing.T) {
 			`,
 			stdout: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
 		},
+		{
+			name: "tail-recursive loop swapping parameters",
+			src: `
+				func [main | fib: 10 a: 0 b: 1]
+
+				func [fib: n Int a: a Int b: b Int |
+					n = 0 ifTrue: [print: a] ifFalse: [fib: n - 1 a: b b: a + b]
+				]
+			`,
+			stdout: "55",
+		},
+		{
+			name: "constant folding",
+			src: `
+				val debug := [false]
+				val shift := [62]
+
+				func [main |
+					i Int8 := 127.
+					u UInt8 := 0.
+					s Int8 := -7.
+					b UInt := 1.
+					f Float32 := 0.1.
+					print: i + 1. print: " ".
+					print: u - 1. print: " ".
+					print: s >> 1. print: " ".
+					print: s / 2. print: " ".
+					print: s % 2. print: " ".
+					print: b << 63. print: " ".
+					print: f * 3.0. print: " ".
+					print: (i > 0 ifTrue: [1] ifFalse: [0]). print: " ".
+					debug ifTrue: [print: "debug"] ifFalse: [print: 1 << shift]
+				]
+			`,
+			stdout: "-128 255 -4 -3 -1 9223372036854775808 0.3 1 4611686018427387904",
+		},
+		{
+			name: "mutual tail calls",
+			src: `
+				func [main |
+					print: (even: 10000000). print: " ".
+					print: (a: 10000001)
+				]
+				func [even: n Int ^Bool |
+					n = 0 ifTrue: [^true] ifFalse: [].
+					^odd: n - 1
+				]
+				func [odd: n Int ^Bool |
+					n = 0 ifTrue: [^false] ifFalse: [].
+					^even: n - 1
+				]
+				func [a: n Int ^String |
+					n = 0 ifTrue: [^"a"] ifFalse: [].
+					^b: n - 1
+				]
+				func [b: n Int ^String |
+					n = 0 ifTrue: [^"b"] ifFalse: [].
+					^c: n - 1
+				]
+				func [c: n Int ^String |
+					n = 0 ifTrue: [^"c"] ifFalse: [].
+					^a: n - 1
+				]
+			`,
+			stdout: "true c",
+		},
 		{
 			name: "loop over virtual array",
 			src: `
@@ -1536,6 +1609,48 @@ func TestWriteMod(t *testing.T) {
 			},
 			stderr: "/test/bar:5: panic: oh no!\n",
 		},
+		{
+			name: "index out of range",
+			src: `												// 1
+				func [main |									// 2
+					a Int Array := {1}.							// 3
+					print: (a at: 5).							// 4
+				]
+			`,
+			stderr: ":4: panic: runtime error: index out of range [5] with length 1\n",
+		},
+		{
+			name: "divide by zero in imported function",
+			src: `
+				Import "/test/bar"
+				func [main | print: (bar: 0)]
+			`,
+			imports: [][2]string{
+				{"/test/bar", `
+					// padding to push the location of bar: later in the file.
+					Func [bar: x Int ^Int | ^1 / x]
+				`},
+			},
+			stderr: "/test/bar:3: panic: runtime error: integer divide by zero\n",
+		},
+		{
+			name: "runtime error re-panicked by a far return",
+			src: `												// 1
+				func [main | print: num]						// 2
+				func [num ^Int |								// 3
+					f := [^43].									// 4
+					true ifTrue: [f := [^42]] ifFalse: [].		// 5
+					value: f.									// 6
+					^44											// 7
+				]												// 8
+				func [value: f Nil Fun |						// 9
+					a Int Array := {}.							// 10
+					print: (a at: 0).							// 11
+					f value									// 12
+				]
+			`,
+			stderr: ":11: panic: runtime error: index out of range [0] with length 0\n",
+		},
 		{
 			// This is testing for regression of a bug
 			// causing imported, instantiated functions
@@ -1617,12 +1732,503 @@ func TestWriteMod(t *testing.T) {
 				t.Fatalf("failed to compile: %v", errs)
 			}
 			stdout, stderr, err := run(mods)
+			status := 0
+			if test.stderr != "" {
+				// Panics exit with status 2.
+				status = 2
+			}
+			if err := checkExit(err, status); err != nil {
+				t.Fatalf("failed to run: %v", err)
+			}
+			if stdout != test.stdout {
+				t.Errorf("stdout: got [%s], want [%s]", stdout, test.stdout)
+			}
+			if stderr != test.stderr {
+				t.Errorf("stderr: got [%s], want [%s]", stderr, test.stderr)
+			}
+		})
+	}
+}
+
+// TestWriteModExportImport tests programs with imports
+// read from types.Write export data instead of from source.
+func TestWriteModExportImport(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		imports [][2]string
+		stdout  string
+	}{
+		{
+			name: "imported ground func",
+			src: `
+				import "/test/ints"
+				func [main |
+					a Int Array := #ints oneTwoThree.
+					print: a size.
+					print: (#ints sum: a).
+				]
+			`,
+			imports: [][2]string{
+				{
+					"/test/ints",
+					`
+					Func [oneTwoThree ^Int Array | ^{1; 2; 3}]
+					Func [sum: a Int Array ^Int | ^sum: a from: 0]
+					func [sum: a Int Array from: i Int ^Int |
+						^i = a size
+							ifTrue: [0]
+							ifFalse: [(a at: i) + (sum: a from: i + 1)]
+					]
+					`,
+				},
+			},
+			stdout: "36",
+		},
+		{
+			name: "imported parameterized func",
+			src: `
+				import "/test/pair"
+				func [main |
+					a := #pair pair: 5.
+					print: (a at: 0) + (a at: 1).
+					print: "\n".
+					b := #pair pair: "hello".
+					print: (b at: 1).
+				]
+			`,
+			imports: [][2]string{
+				{
+					"/test/pair",
+					`
+					Func T [pair: t T ^T Array |
+						x T Array := {t; t}.
+						^x
+					]
+					`,
+				},
+			},
+			stdout: "10\nhello",
+		},
+		{
+			name: "imported parameterized type and methods",
+			src: `
+				import "/test/box"
+				func [main |
+					b Int #box Box := #box box: 4.
+					c := b #box map: [:i | i * 10].
+					print: c #box get.
+					print: "\n".
+					c #box get: 7.
+					print: c #box get.
+				]
+			`,
+			imports: [][2]string{
+				{
+					"/test/box",
+					`
+					Type T Box {val: T}
+					Func T [box: t T ^T Box | ^{val: t}]
+					Meth T Box [get ^T | ^val]
+					Meth T Box [get: t T | val := t]
+					Meth T Box [map: f (T, T) Fun ^T Box | ^{val: (f value: val)}]
+					`,
+				},
+			},
+			stdout: "40\n7",
+		},
+		{
+			name: "imported parameterized or-type",
+			src: `
+				Import "/test/opt"
+				func [main |
+					x Int? := {some: 3}.
+					print: (x getOr: 1).
+					y String? := {none}.
+					print: (y getOr: "none").
+				]
+			`,
+			imports: [][2]string{
+				{
+					"/test/opt",
+					`
+					Type T? {none | some: T}
+					Meth T? [getOr: t T ^T | ^self ifNone: [t] ifSome: [:s | s]]
+					`,
+				},
+			},
+			stdout: "3none",
+		},
+		{
+			name: "transitive parameterized imports",
+			src: `
+				import "/test/b"
+				func [main |
+					print: (#b twice: 21).
+				]
+			`,
+			imports: [][2]string{
+				{
+					"/test/a",
+					`
+					Func T [apply: f (T, T) Fun to: t T ^T | ^f value: t]
+					`,
+				},
+				{
+					"/test/b",
+					`
+					import "/test/a"
+					Func [twice: i Int ^Int | ^#a apply: [:j | j + j] to: i]
+					`,
+				},
+			},
+			stdout: "42",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			src := test.src + "\nfunc T [print: _ T]\n"
+			mods, errs := compileAllExported(src, test.imports...)
+			if len(errs) > 0 {
+				t.Fatalf("failed to compile: %v", errs)
+			}
+			stdout, _, err := run(mods)
 			if err != nil {
 				t.Fatalf("failed to run: %v", err)
 			}
 			if stdout != test.stdout {
 				t.Errorf("stdout: got [%s], want [%s]", stdout, test.stdout)
 			}
+		})
+	}
+}
+
+func TestWriteModSizes(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		intSize   int
+		floatSize int
+		src       string
+		stdout    string
+	}{
+		{
+			name: "default Int does not wrap at 32 bits",
+			src: `
+				func [main |
+					a Int Array := {2147483647}.
+					print: (a at: 0) + 1 < 0.
+				]
+			`,
+			stdout: "false",
+		},
+		{
+			name:    "32-bit Int wraps",
+			intSize: 32,
+			src: `
+				func [main |
+					a Int Array := {2147483647}.
+					print: (a at: 0) + 1 < 0.
+					x Int := 2147483647.
+					print: x + 1 < 0.
+				]
+			`,
+			stdout: "truetrue",
+		},
+		{
+			name:    "32-bit UInt wraps",
+			intSize: 32,
+			src: `
+				func [main |
+					a UInt Array := {4294967295}.
+					print: (a at: 0) + 1 = 0.
+					x UInt := 4294967295.
+					print: x + 1 = 0.
+				]
+			`,
+			stdout: "truetrue",
+		},
+		{
+			name:    "8-bit Int array size",
+			intSize: 8,
+			src: `
+				func [main |
+					a Int Array := {127; 1}.
+					print: a size = 2.
+					print: (a at: 0) + (a at: 1) < 0.
+				]
+			`,
+			stdout: "truetrue",
+		},
+		{
+			name: "default Float",
+			src: `
+				func [main |
+					a Float Array := {0.1}.
+					print: (a at: 0) + 0.2 = 0.3.
+				]
+			`,
+			stdout: "false",
+		},
+		{
+			name:      "32-bit Float",
+			floatSize: 32,
+			src: `
+				func [main |
+					a Float Array := {0.1}.
+					print: (a at: 0) + 0.2 = 0.3.
+					x Float := 0.1.
+					print: x + 0.2 = 0.3.
+				]
+			`,
+			stdout: "truetrue",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			p := ast.NewParser("main")
+			src := test.src + "\nfunc T [print: _ T]\n"
+			if err := p.Parse("", strings.NewReader(src)); err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			typesMod, errs := types.Check(p.Mod(), types.Config{
+				IntSize:   test.intSize,
+				FloatSize: test.floatSize,
+				Importer:  testImporter(nil),
+			})
+			if len(errs) > 0 {
+				t.Fatalf("failed to check: %v", errs)
+			}
+			mod := basic.Build(typesMod)
+			basic.Optimize(mod)
+			stdout, _, err := run([]*basic.Mod{mod})
+			if err != nil {
+				t.Fatalf("failed to run: %v", err)
+			}
+			if stdout != test.stdout {
+				t.Errorf("stdout: got [%s], want [%s]", stdout, test.stdout)
+			}
+		})
+	}
+}
+
+// TestWriteModSizesGoSrc tests building a module with Go source files,
+// which name the Go types of Int and UInt as Int and UInt,
+// with different Int sizes.
+func TestWriteModSizesGoSrc(t *testing.T) {
+	t.Parallel()
+	imports := [][2]string{
+		{"primitive", readSrc(t, "../lib/primitive/*.pea")},
+		// The posix tests are not included;
+		// they are in another file with its own imports.
+		{"os/posix", readSrc(t, "../lib/os/posix/posix.pea")},
+	}
+	const src = `
+		import "os/posix"
+		func [main |
+			buf Byte Array := {'h'; 'i'}.
+			#posix write: #posix STDOUT_FILENO buf: buf.
+			s := (#posix fstat: #posix STDOUT_FILENO) #posix ifErrno: [:e |
+				panic: (#posix strerror: e)
+			].
+			print: s #posix mode & #posix S_IFMT != 0.
+		]
+		func T [print: _ T]
+	`
+	for _, intSize := range []int{32, 64} {
+		intSize := intSize
+		t.Run(fmt.Sprintf("%d-bit Int", intSize), func(t *testing.T) {
+			t.Parallel()
+			var mods []*basic.Mod
+			for _, s := range append([][2]string{{"main", src}}, imports...) {
+				p := ast.NewParser(s[0])
+				if err := p.Parse("", strings.NewReader(s[1])); err != nil {
+					t.Fatalf("failed to parse %s: %v", s[0], err)
+				}
+				typesMod, errs := types.Check(p.Mod(), types.Config{
+					IntSize:  intSize,
+					Importer: testImporter(imports),
+				})
+				if len(errs) > 0 {
+					t.Fatalf("failed to check %s: %v", s[0], errs)
+				}
+				mod := basic.Build(typesMod)
+				basic.Optimize(mod)
+				mods = append(mods, mod)
+			}
+			goSrcs := []string{"../lib/os/posix/_posix.go"}
+			stdout, _, err := runGoSrc(mods, goSrcs, "")
+			if err != nil {
+				t.Fatalf("failed to run: %v", err)
+			}
+			if want := "hitrue"; stdout != want {
+				t.Errorf("stdout: got [%s], want [%s]", stdout, want)
+			}
+		})
+	}
+}
+
+// readSrc returns the concatenated source
+// of the files matching a glob pattern.
+func readSrc(t *testing.T, pattern string) string {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatalf("failed to glob %s: %v", pattern, err)
+	}
+	var s strings.Builder
+	for _, path := range paths {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", path, err)
+		}
+		s.Write(src)
+		s.WriteRune('\n')
+	}
+	return s.String()
+}
+
+func TestWriteModChecked(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		src    string
+		stdout string
+		stderr string
+	}{
+		{
+			name: "no overflow",
+			src: `
+				func [main |
+					a Int8 Array := {100; -100; 3}.
+					print: (a at: 0) + 27.
+					print: (a at: 1) - 28.
+					print: (a at: 1) * -1.
+					print: (a at: 1) / (a at: 2).
+					print: (a at: 1) % (a at: 2).
+					print: (a at: 0) asUInt8.
+					print: 127.5 asInt8.
+				]
+			`,
+			stdout: "127-128100-33-1100127",
+		},
+		{
+			name: "plus overflow",
+			src: `											// 1
+				func [main |								// 2
+					a Int8 Array := {100}.					// 3
+					print: (a at: 0) + 28.					// 4
+				]
+			`,
+			stderr: ":4: panic: integer overflow\n",
+		},
+		{
+			name: "minus overflow",
+			src: `											// 1
+				func [main |								// 2
+					a UInt Array := {0}.					// 3
+					print: (a at: 0) - 1.					// 4
+				]
+			`,
+			stderr: ":4: panic: integer overflow\n",
+		},
+		{
+			name: "times overflow",
+			src: `											// 1
+				func [main |								// 2
+					a Int8 Array := {-128}.					// 3
+					print: (a at: 0) * -1.					// 4
+				]
+			`,
+			stderr: ":4: panic: integer overflow\n",
+		},
+		{
+			name: "divide overflow",
+			src: `											// 1
+				func [main |								// 2
+					a Int Array := {-9223372036854775808}.	// 3
+					print: (a at: 0) / -1.					// 4
+				]
+			`,
+			stderr: ":4: panic: integer overflow\n",
+		},
+		{
+			name: "divide by zero",
+			src: `											// 1
+				func [main |								// 2
+					a Int Array := {0}.						// 3
+					print: 1 / (a at: 0).					// 4
+				]
+			`,
+			stderr: ":4: panic: integer divide by zero\n",
+		},
+		{
+			name: "mod by zero",
+			src: `											// 1
+				func [main |								// 2
+					a UInt8 Array := {1; 0}.				// 3
+					print: (a at: 0) % (a at: 1).			// 4
+				]
+			`,
+			stderr: ":4: panic: integer divide by zero\n",
+		},
+		{
+			name: "constant overflow is not folded",
+			src: `											// 1
+				func [main |								// 2
+					x Int8 := 127.							// 3
+					print: x + 1.							// 4
+				]
+			`,
+			stderr: ":4: panic: integer overflow\n",
+		},
+		{
+			name: "convert overflow",
+			src: `											// 1
+				func [main |								// 2
+					a Int Array := {-1}.					// 3
+					print: (a at: 0) asUInt.				// 4
+				]
+			`,
+			stderr: ":4: panic: integer overflow\n",
+		},
+		{
+			name: "convert float overflow",
+			src: `											// 1
+				func [main |								// 2
+					a Float Array := {1000.0}.				// 3
+					print: (a at: 0) asInt8.				// 4
+				]
+			`,
+			stderr: ":4: panic: integer overflow\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			typesMod, errs := check("main", test.src+"\nfunc T [print: _ T]\n")
+			if len(errs) > 0 {
+				t.Fatalf("failed to check: %v", errs)
+			}
+			mod := basic.Build(typesMod)
+			mod.Checked = true
+			basic.Optimize(mod)
+			stdout, stderr, err := run([]*basic.Mod{mod})
+			status := 0
+			if test.stderr != "" {
+				// Panics exit with status 2.
+				status = 2
+			}
+			if err := checkExit(err, status); err != nil {
+				t.Fatalf("failed to run: %v", err)
+			}
+			if stdout != test.stdout {
+				t.Errorf("stdout: got [%s], want [%s]", stdout, test.stdout)
+			}
 			if stderr != test.stderr {
 				t.Errorf("stderr: got [%s], want [%s]", stderr, test.stderr)
 			}
@@ -1630,6 +2236,304 @@ func TestWriteMod(t *testing.T) {
 	}
 }
 
+// TestPanicFileNames tests that panics and Go runtime errors
+// print the same, absolute, name of a relative source file.
+func TestPanicFileNames(t *testing.T) {
+	abs, err := filepath.Abs("relative.pea")
+	if err != nil {
+		t.Fatalf("failed to get absolute path: %v", err)
+	}
+	tests := []struct {
+		name   string
+		src    string
+		stderr string
+	}{
+		{
+			name: "panic",
+			src: `
+				func [main |
+					panic: "boo"
+				]
+			`,
+			stderr: abs + ":3: panic: boo\n",
+		},
+		{
+			name: "runtime error",
+			src: `
+				func [main |
+					a Int Array := {1}.
+					print: (a at: 5)
+				]
+			`,
+			stderr: abs + ":4: panic: runtime error: index out of range [5] with length 1\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			p := ast.NewParser("main")
+			src := test.src + "\nfunc T [print: _ T]\n"
+			if err := p.Parse("relative.pea", strings.NewReader(src)); err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			typesMod, errs := types.Check(p.Mod(), types.Config{})
+			if len(errs) > 0 {
+				t.Fatalf("failed to check: %v", errs)
+			}
+			mod := basic.Build(typesMod)
+			basic.Optimize(mod)
+			_, stderr, err := run([]*basic.Mod{mod})
+			if err := checkExit(err, 2); err != nil {
+				t.Fatalf("failed to run: %v", err)
+			}
+			if stderr != test.stderr {
+				t.Errorf("stderr: got [%s], want [%s]", stderr, test.stderr)
+			}
+		})
+	}
+}
+
+func TestWriteModDebug(t *testing.T) {
+	const src = `
+		func [main |
+			range := 3.
+			total := sum: {x: range y: 4}.
+			print: total.
+		]
+		type Point {x: Int y: Int}
+		meth Point [sum ^Int | ^x + y]
+		func [sum: p Point ^Int |
+			s := p sum.
+			^s
+		]
+		func T [print: _ T]
+	`
+	typesMod, errs := check("main", src)
+	if len(errs) > 0 {
+		t.Fatalf("failed to check: %v", errs)
+	}
+	mod := basic.Build(typesMod)
+	mod.Debug = true
+	basic.Optimize(mod)
+	var b bytes.Buffer
+	if err := WriteMod(&b, mod); err != nil {
+		t.Fatalf("failed to write mod: %v", err)
+	}
+	for _, want := range []string{
+		"var range_ __0_Int__\n",
+		"var total __0_Int__\n",
+		"= &total\n",
+		"var s __0_Int__\n",
+		"p := p0\n",
+		") // [sum: p Point ^Int]\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, b.String())
+		}
+	}
+	stdout, _, err := run([]*basic.Mod{mod})
+	if err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	if stdout != "7" {
+		t.Errorf("stdout: got [%s], want [7]", stdout)
+	}
+}
+
+func TestMergerTests(t *testing.T) {
+	const src = `
+		test [passes | print: "x"]
+		test [fails | panic: "oops"]
+		test [alsoPasses | ]
+		func T [print: _ T]
+	`
+	tests := []struct {
+		name   string
+		args   []string
+		stdout string
+		fail   bool
+	}{
+		{
+			name:   "default",
+			stdout: "xTest fails failed (Ns)\n\t:0: oops\nFAIL 1 of 3 failed (Ns)\n",
+			fail:   true,
+		},
+		{
+			name: "verbose",
+			args: []string{"-v"},
+			stdout: "xTest passes ok (Ns)\n" +
+				"Test fails failed (Ns)\n\t:0: oops\n" +
+				"Test alsoPasses ok (Ns)\n" +
+				"FAIL 1 of 3 failed (Ns)\n",
+			fail: true,
+		},
+		{
+			name:   "run passing",
+			args:   []string{"-v", "-run", "[pP]asses"},
+			stdout: "xTest passes ok (Ns)\nTest alsoPasses ok (Ns)\nok 2 passed (Ns)\n",
+		},
+		{
+			name:   "run none",
+			args:   []string{"-run", "nothing"},
+			stdout: "no tests to run\n",
+		},
+		{
+			name: "json",
+			args: []string{"-json"},
+			stdout: "run passes\n" +
+				"output passes x\n" +
+				"pass passes\n" +
+				"run fails\n" +
+				"output fails \t:0: oops\n\n" +
+				"fail fails\n" +
+				"run alsoPasses\n" +
+				"pass alsoPasses\n" +
+				"fail \n",
+			fail: true,
+		},
+	}
+	mods, errs := compileAll(src)
+	if len(errs) > 0 {
+		t.Fatalf("failed to compile: %v", errs)
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			stdout, _, err := runTestMod(mods, "main", test.args...)
+			if _, ok := err.(*exec.ExitError); err != nil && !ok {
+				t.Fatalf("failed to run: %v", err)
+			}
+			if fail := err != nil; fail != test.fail {
+				t.Errorf("failed=%v, want %v", fail, test.fail)
+			}
+			if len(test.args) > 0 && test.args[0] == "-json" {
+				stdout = jsonEventSummary(t, stdout)
+			}
+			stdout = durationRegexp.ReplaceAllString(stdout, "(Ns)")
+			if stdout != test.stdout {
+				t.Errorf("stdout: got [%s], want [%s]", stdout, test.stdout)
+			}
+		})
+	}
+}
+
+// TestMergerTestsPanic tests panics in a test main
+// that are not test failures.
+func TestMergerTestsPanic(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		args   []string
+		stdout string
+		stderr string
+	}{
+		{
+			name: "init panic",
+			src: `
+				val empty Int Array := [{}]
+				val x Int := [empty at: 1]
+				test [passes | print: x]
+			`,
+			stderr: ":3: panic: runtime error: index out of range [1] with length 0\n",
+		},
+		{
+			name: "init panic json",
+			src: `
+				val empty Int Array := [{}]
+				val x Int := [empty at: 1]
+				test [passes | print: x]
+			`,
+			args:   []string{"-json"},
+			stdout: "fail \n",
+			stderr: ":3: panic: runtime error: index out of range [1] with length 0\n",
+		},
+		{
+			name: "far return",
+			src: `
+				val f Nil Fun := [[]]
+				test [passes | ]
+				test [farReturn | print: foo. f value]
+				func [foo ^Int | f := [^42]. ^0]
+			`,
+			stdout: "0Test farReturn failed (Ns)\n\tfar return from a different stack\n",
+			stderr: "far return from a different stack\n",
+		},
+		{
+			name: "far return json",
+			src: `
+				val f Nil Fun := [[]]
+				test [passes | ]
+				test [farReturn | print: foo. f value]
+				func [foo ^Int | f := [^42]. ^0]
+			`,
+			args: []string{"-json"},
+			stdout: "run passes\n" +
+				"pass passes\n" +
+				"run farReturn\n" +
+				"output farReturn 0\n" +
+				"output farReturn \tfar return from a different stack\n\n" +
+				"fail farReturn\n" +
+				"fail \n",
+			stderr: "far return from a different stack\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			mods, errs := compileAll(test.src + "\nfunc T [print: _ T]\n")
+			if len(errs) > 0 {
+				t.Fatalf("failed to compile: %v", errs)
+			}
+			stdout, stderr, err := runTestMod(mods, "main", test.args...)
+			if err := checkExit(err, 2); err != nil {
+				t.Fatalf("failed to run: %v", err)
+			}
+			if len(test.args) > 0 && test.args[0] == "-json" {
+				stdout = jsonEventSummary(t, stdout)
+			}
+			stdout = durationRegexp.ReplaceAllString(stdout, "(Ns)")
+			if stdout != test.stdout {
+				t.Errorf("stdout: got [%s], want [%s]", stdout, test.stdout)
+			}
+			if stderr != test.stderr {
+				t.Errorf("stderr: got [%s], want [%s]", stderr, test.stderr)
+			}
+		})
+	}
+}
+
+var durationRegexp = regexp.MustCompile(`\([0-9.]+s\)`)
+
+// jsonEventSummary returns a line for each JSON event,
+// with the event's action, test, and output.
+func jsonEventSummary(t *testing.T, stdout string) string {
+	var s strings.Builder
+	dec := json.NewDecoder(strings.NewReader(stdout))
+	for dec.More() {
+		var event struct {
+			Action  string
+			Package string
+			Test    string
+			Output  string
+		}
+		if err := dec.Decode(&event); err != nil {
+			t.Fatalf("failed to decode event: %v", err)
+		}
+		if event.Package != "main" {
+			t.Errorf("got package %q, want main", event.Package)
+		}
+		fmt.Fprintf(&s, "%s %s", event.Action, event.Test)
+		if event.Output != "" {
+			fmt.Fprintf(&s, " %s", event.Output)
+		}
+		s.WriteRune('\n')
+	}
+	return s.String()
+}
+
 func check(modPath, src string, imports ...[2]string) (*types.Mod, []error) {
 	p := ast.NewParser(modPath)
 	if err := p.Parse("", strings.NewReader(src)); err != nil {
@@ -1650,6 +2554,154 @@ func compile(modPath, src string, imports ...[2]string) (*basic.Mod, []error) {
 	return basicMod, nil
 }
 
+func TestMergerDropsUnreachable(t *testing.T) {
+	const src = `
+		func [main | (make: 5) print]
+		func [make: x Int ^Used | ^{x: x y: "used"}]
+		type Used {x: Int y: String}
+		meth Used [print | print: x. print: y]
+		func [unused ^Unused | ^{x: 1 y: "unused"}]
+		type Unused {x: Int y: String}
+		test [unusedTest | print: 6]
+		func T [print: _ T]
+	`
+	mods, errs := compileAll(src)
+	if len(errs) > 0 {
+		t.Fatalf("failed to compile: %v", errs)
+	}
+	var out strings.Builder
+	merger, err := NewMerger(&out)
+	if err != nil {
+		t.Fatalf("failed to create merger: %v", err)
+	}
+	mergeMods(t, merger, mods)
+	reachable := map[string]bool{
+		"main":       true,
+		"unused":     false,
+		"unusedTest": false,
+	}
+	for _, f := range mods[0].Funs {
+		if f.Fun == nil || f.Block != nil {
+			continue
+		}
+		want, ok := reachable[f.Fun.Sig.Sel]
+		if !ok {
+			continue
+		}
+		name := FunName(f)
+		if got := strings.Contains(out.String(), "func "+name+"("); got != want {
+			t.Errorf("%s: defined=%v, want %v", f.Fun.Sig.Sel, got, want)
+		}
+		if f.Fun.Sig.Ret == nil {
+			continue
+		}
+		typ := f.Fun.Sig.Ret.Type
+		name = mangleType(typ, new(strings.Builder)).String()
+		if got := strings.Contains(out.String(), "type "+name+" "); got != want {
+			t.Errorf("%s: defined=%v, want %v", typ, got, want)
+		}
+	}
+	if !strings.Contains(out.String(), `"used"`) {
+		t.Errorf("reachable string is not defined")
+	}
+	if strings.Contains(out.String(), `"unused"`) {
+		t.Errorf("unreachable string is defined")
+	}
+}
+
+func TestLineDirectives(t *testing.T) {
+	const src = `
+		Import "/test/bar"
+		func [main |					// 3
+			a Int Array := {0}.			// 4
+			print: (bar: (a at: 0)).	// 5
+		]
+		func T [print: _ T]
+	`
+	const barSrc = `
+		Func [bar: x Int ^Int |		// 2
+			y := x + 1.				// 3
+			^y / x					// 4
+		]
+	`
+	mods, errs := compileAll(src, [2]string{"/test/bar", barSrc})
+	if len(errs) > 0 {
+		t.Fatalf("failed to compile: %v", errs)
+	}
+	var out strings.Builder
+	merger, err := NewMerger(&out)
+	if err != nil {
+		t.Fatalf("failed to create merger: %v", err)
+	}
+	merger.File = "/test/merged.go"
+	merger.includePrintForTests = true
+	mergeMods(t, merger, mods)
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "/test/merged.go", out.String(), 0)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	var mainFun *goast.FuncDecl
+	for _, decl := range file.Decls {
+		f, ok := decl.(*goast.FuncDecl)
+		if !ok {
+			continue
+		}
+		switch f.Name.Name {
+		case mainFunName:
+			mainFun = f
+		case "main":
+			// Positions after the generated code
+			// are reset to those of the merged file.
+			pos := fset.Position(f.Pos())
+			raw := fset.PositionFor(f.Pos(), false)
+			if pos.Filename != "/test/merged.go" || pos.Line != raw.Line {
+				t.Errorf("func main is at %s, want %s", pos, raw)
+			}
+		}
+	}
+	if mainFun == nil {
+		t.Fatalf("main is not defined")
+	}
+	if pos := fset.Position(mainFun.Pos()); pos.Line != 3 {
+		t.Errorf("main is at %s, want line 3", pos)
+	}
+	// bar: is inlined, but its code keeps its own location.
+	var got []string
+	goast.Inspect(mainFun.Body, func(n goast.Node) bool {
+		switch n := n.(type) {
+		case *goast.IndexExpr:
+			got = append(got, fmt.Sprintf("[] %d", fset.Position(n.Pos()).Line))
+		case *goast.BinaryExpr:
+			pos := fset.Position(n.Pos())
+			got = append(got, fmt.Sprintf("%s %s:%d", n.Op, pos.Filename, pos.Line))
+		}
+		return true
+	})
+	want := []string{"[] 5", "+ /test/bar:3", "/ /test/bar:4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v\n%s", got, want, out.String())
+	}
+}
+
+// mergeMods adds the Go source of each Mod to merger and finishes the merge.
+func mergeMods(t *testing.T, merger *Merger, mods []*basic.Mod) {
+	t.Helper()
+	for _, mod := range mods {
+		var b bytes.Buffer
+		if err := WriteMod(&b, mod); err != nil {
+			t.Fatalf("failed to write mod: %v", err)
+		}
+		if err := merger.Add(&b); err != nil {
+			t.Fatalf("failed to add mod: %v", err)
+		}
+	}
+	if err := merger.Done(); err != nil {
+		t.Fatalf("failed to merge: %v", err)
+	}
+}
+
 func compileAll(src string, imports ...[2]string) ([]*basic.Mod, []error) {
 	mod, errs := compile("main", src, imports...)
 	if len(errs) > 0 {
@@ -1666,8 +2718,51 @@ func compileAll(src string, imports ...[2]string) ([]*basic.Mod, []error) {
 	return mods, nil
 }
 
+// compileAllExported is like compileAll,
+// but imports are read from export data.
+func compileAllExported(src string, imports ...[2]string) ([]*basic.Mod, []error) {
+	var mods []*basic.Mod
+	srcs := append([][2]string{{"main", src}}, imports...)
+	for _, s := range srcs {
+		p := ast.NewParser(s[0])
+		if err := p.Parse("", strings.NewReader(s[1])); err != nil {
+			return nil, []error{err}
+		}
+		typesMod, errs := types.Check(p.Mod(), types.Config{
+			Importer: exportImporter(imports),
+		})
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		basicMod := basic.Build(typesMod)
+		basic.Optimize(basicMod)
+		mods = append(mods, basicMod)
+	}
+	return mods, nil
+}
+
 func run(mods []*basic.Mod) (string, string, error) {
-	f, err := ioutil.TempFile("", "gengo_test_*.go")
+	return runTestMod(mods, "")
+}
+
+// runTestMod is like run, but if testMod is non-empty,
+// it runs the tests of testMod with the given arguments.
+// The returned error is an *exec.ExitError
+// if the program exits with a non-zero status.
+func runTestMod(mods []*basic.Mod, testMod string, args ...string) (string, string, error) {
+	return runGoSrc(mods, nil, testMod, args...)
+}
+
+// runGoSrc is like runTestMod,
+// but the program is built with the given Go source files.
+func runGoSrc(mods []*basic.Mod, goSrcs []string, testMod string, args ...string) (string, string, error) {
+	dir, err := ioutil.TempDir("", "gengo_test_")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(dir)
+	files := []string{filepath.Join(dir, "main.go")}
+	f, err := os.Create(files[0])
 	if err != nil {
 		return "", "", err
 	}
@@ -1675,6 +2770,8 @@ func run(mods []*basic.Mod) (string, string, error) {
 	if err != nil {
 		return "", "", err
 	}
+	merger.TestMod = testMod
+	merger.File = f.Name()
 	merger.includePrintForTests = true
 	for _, mod := range mods {
 		var b bytes.Buffer
@@ -1685,26 +2782,59 @@ func run(mods []*basic.Mod) (string, string, error) {
 			return "", "", err
 		}
 	}
+	for i, goSrc := range goSrcs {
+		src, err := ioutil.ReadFile(goSrc)
+		if err != nil {
+			return "", "", err
+		}
+		if err := merger.AddGoSrc(bytes.NewReader(src)); err != nil {
+			return "", "", err
+		}
+		// The Go source files are copied to dir,
+		// since go build requires all files in one directory.
+		path := filepath.Join(dir, fmt.Sprintf("src%d.go", i))
+		if err := ioutil.WriteFile(path, src, 0666); err != nil {
+			return "", "", err
+		}
+		files = append(files, path)
+	}
 	if err := merger.Done(); err != nil {
 		return "", "", err
 	}
-	path := f.Name()
 	if err := f.Close(); err != nil {
 		return "", "", err
 	}
-	cmd := exec.Command("go", "run", path)
+	// Build and run the binary, instead of go run,
+	// so that the exit status is that of the program.
+	bin := filepath.Join(dir, "main")
+	build := append([]string{"build", "-o", bin}, files...)
+	if out, err := exec.Command("go", build...).CombinedOutput(); err != nil {
+		return "", "", fmt.Errorf("%v: %s", err, out)
+	}
+	cmd := exec.Command(bin, args...)
 	var stdOut, stdErr bytes.Buffer
 	cmd.Stdout = &stdOut
 	cmd.Stderr = &stdErr
-	runErr := cmd.Run()
-	rmErr := os.Remove(path)
-	if runErr != nil {
-		return "", "", runErr
-	}
-	if rmErr != nil {
-		return "", "", rmErr
+	err = cmd.Run()
+	return stdOut.String(), stdErr.String(), err
+}
+
+// checkExit returns an error if err is not
+// the result of running a program with the given exit status.
+func checkExit(err error, status int) error {
+	exitErr, ok := err.(*exec.ExitError)
+	switch {
+	case err == nil && status == 0:
+		return nil
+	case err == nil:
+		return fmt.Errorf("exit status 0, want %d", status)
+	case !ok:
+		return err
+	case exitErr.ExitCode() != status:
+		return fmt.Errorf("exit status %d, want %d", exitErr.ExitCode(), status)
+	default:
+		return nil
 	}
-	return stdOut.String(), stdErr.String(), nil
 }
 
 type testImporter [][2]string
@@ -1730,6 +2860,39 @@ func (imports testImporter) Import(cfg types.Config, locs *loc.Files, path strin
 	return nil, errors.New("not found")
 }
 
+// exportImporter imports by checking the source,
+// writing its export data, and reading it back.
+type exportImporter [][2]string
+
+func (imports exportImporter) Import(cfg types.Config, locs *loc.Files, path string) ([]types.Def, error) {
+	for i := range imports {
+		if imports[i][0] != path {
+			continue
+		}
+		src := imports[i][1]
+		p := ast.NewParser(path)
+		if err := p.Parse(path, strings.NewReader(src)); err != nil {
+			return nil, fmt.Errorf("failed to parse import: %s", err)
+		}
+		mod, errs := types.Check(p.Mod(), types.Config{Importer: imports})
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("failed to check import: %s", errs)
+		}
+		setMod(path, mod.Defs)
+		var buf bytes.Buffer
+		if err := types.Write(&buf, mod); err != nil {
+			return nil, fmt.Errorf("failed to write import: %s", err)
+		}
+		cfg.Trace = false
+		mod, err := types.Read(&buf, cfg, locs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read import: %s", err)
+		}
+		return mod.Defs, nil
+	}
+	return nil, errors.New("not found")
+}
+
 func setMod(path string, defs []types.Def) {
 	for _, def := range defs {
 		switch def := def.(type) {
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 
 	"github.com/eaburns/pea/basic"
@@ -41,6 +42,13 @@ func caseName(typ *types.Type, i int) string {
 	return escape(typ.Cases[i].Name, new(strings.Builder)).String()
 }
 
+// FunName returns the globally unique name of a Fun.
+// A Fun declared in one module and defined in another
+// have the same name.
+func FunName(f *basic.Fun) string {
+	return mangleFun(f, new(strings.Builder)).String()
+}
+
 func mangleFun(f *basic.Fun, s *strings.Builder) *strings.Builder {
 	switch {
 	case f.Block != nil:
@@ -67,7 +75,6 @@ func mangleFun(f *basic.Fun, s *strings.Builder) *strings.Builder {
 // which may differ from fun.ModPath,
 // which is the mod path of the Fun definition.
 func mangleTypesFun(modPath string, fun *types.Fun, s *strings.Builder) *strings.Builder {
-	typeConstraint := false
 	switch {
 	case fun.Test:
 		s.WriteRune('T')
@@ -76,12 +83,6 @@ func mangleTypesFun(modPath string, fun *types.Fun, s *strings.Builder) *strings
 	default:
 		s.WriteRune('M')
 		mangleType(fun.Recv.Type, s)
-		for _, p := range fun.Recv.Parms {
-			if len(p.Ifaces) > 0 {
-				typeConstraint = true
-				break
-			}
-		}
 	}
 	writeInt(len(fun.TArgs), s)
 	for _, arg := range fun.TArgs {
@@ -91,29 +92,21 @@ func mangleTypesFun(modPath string, fun *types.Fun, s *strings.Builder) *strings
 	mangleMod(fun.ModPath, s)
 	writeStr(fun.Sig.Sel, s)
 
-	if !typeConstraint {
-		for _, p := range fun.TParms {
-			if len(p.Ifaces) > 0 {
-				typeConstraint = true
-				break
+	if len(fun.Meths) > 0 {
+		// Instances with the same type arguments
+		// may differ in the methods satisfying their type constraints,
+		// so the methods are part of the name.
+		// Each is written as its length followed by its mangled name.
+		writeInt(len(fun.Meths), s)
+		for _, meth := range fun.Meths {
+			var m strings.Builder
+			if meth != nil {
+				mangleTypesFun(modPath, meth, &m)
 			}
+			writeInt(m.Len(), s)
+			s.WriteString(m.String())
 		}
 	}
-	if typeConstraint {
-		// If there is a type constraint on any of the type parameters,
-		// this function instance must be unique to its defining file.
-		//
-		// TODO: relax the unique Fun inst constraint
-		// by adding method sets to the mangled name.
-		for i, f := range fun.Def.Insts {
-			if f == fun {
-				writeInt(i, s)
-				mangleMod(fun.InstModPath, s)
-				return s
-			}
-		}
-		panic("impossible")
-	}
 	return s
 }
 
@@ -142,81 +135,374 @@ func demangleTestName(s string) (mod, name string, err error) {
 	return mod, name, err
 }
 
-func demangleFun(rr io.RuneReader) (string, error) {
-	var out strings.Builder
-	switch r, _, err := rr.ReadRune(); {
-	case err == io.EOF:
-		return "", errors.New("unexpected EOF")
-	case err != nil:
-		return "", err
-	case r == 'F':
-		out.WriteString("Func ")
-	case r == 'M':
-		out.WriteString("Meth ")
-		recvType, err := demangleType(rr)
+// A SymbolKind is the kind of definition named by a Symbol.
+type SymbolKind int
+
+const (
+	// FunSymbol is a function.
+	FunSymbol SymbolKind = iota
+	// MethSymbol is a method.
+	MethSymbol
+	// TestSymbol is a test.
+	TestSymbol
+	// BlockSymbol is the function of a block literal.
+	BlockSymbol
+	// ValSymbol is a module-level variable.
+	ValSymbol
+	// ValInitSymbol is the initializer of a module-level variable.
+	ValInitSymbol
+	// ModInitSymbol is the initializer of a module.
+	ModInitSymbol
+	// StringSymbol is a string literal.
+	StringSymbol
+	// TypeSymbol is a type.
+	TypeSymbol
+)
+
+// A Symbol is the description of a demangled name.
+type Symbol struct {
+	Kind SymbolKind
+
+	// Mod is the module path of the definition.
+	// For a TypeSymbol, it is the empty string;
+	// the module path is that of the Type.
+	Mod string
+
+	// InstMod is the path of the module instantiating
+	// the function containing a BlockSymbol.
+	// It is the empty string if the function is not an instance
+	// or if the block is in a Val initializer.
+	InstMod string
+
+	// Recv is the receiver type of a MethSymbol.
+	Recv *SymbolType
+
+	// TArgs are the type arguments of a
+	// FunSymbol, MethSymbol, or TestSymbol.
+	TArgs []SymbolType
+
+	// Sel is the selector of a FunSymbol, MethSymbol, or TestSymbol;
+	// the variable name of a ValSymbol or ValInitSymbol;
+	// or the block type name of a BlockSymbol.
+	Sel string
+
+	// Meths are the methods satisfying the type constraints
+	// of a FunSymbol, MethSymbol, or TestSymbol instance.
+	// An element may be nil.
+	Meths []*Symbol
+
+	// N is the number of a StringSymbol.
+	N int
+
+	// Type is the type named by a TypeSymbol.
+	Type *SymbolType
+}
+
+// A SymbolType is a type in a demangled name.
+type SymbolType struct {
+	Mod  string
+	Name string
+	Args []SymbolType
+}
+
+// Demangle returns a description of a name
+// from the Go code generated by WriteMod.
+// It returns an error if the name
+// is not a mangled Fun, Val, string, or type name.
+func Demangle(name string) (*Symbol, error) {
+	demanglers := []func(*strings.Reader) (*Symbol, error){
+		demangleFunSymbol,
+		demangleValInitSymbol,
+		demangleTypeSymbol,
+		demangleModSymbol,
+		demangleBlockSymbol,
+	}
+	for _, demangle := range demanglers {
+		rr := strings.NewReader(name)
+		if sym, err := demangle(rr); err == nil && rr.Len() == 0 {
+			return sym, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not a mangled name", name)
+}
+
+func demangleFunSymbol(rr *strings.Reader) (*Symbol, error) {
+	return demangleFun(rr)
+}
+
+func demangleValInitSymbol(rr *strings.Reader) (*Symbol, error) {
+	const prefix = "init__"
+	for _, r := range prefix {
+		if c, _, err := rr.ReadRune(); err != nil || c != r {
+			return nil, errors.New("expected " + prefix)
+		}
+	}
+	sym, err := demangleModSymbol(rr)
+	if err != nil {
+		return nil, err
+	}
+	if sym.Kind != ValSymbol {
+		return nil, errors.New("expected a Val")
+	}
+	sym.Kind = ValInitSymbol
+	return sym, nil
+}
+
+func demangleTypeSymbol(rr *strings.Reader) (*Symbol, error) {
+	typ, err := demangleType(rr)
+	if err != nil {
+		return nil, err
+	}
+	return &Symbol{Kind: TypeSymbol, Type: typ}, nil
+}
+
+// demangleModSymbol demangles a name beginning with a module path:
+// a module initializer, a string, a Val, or a block in a Val.
+func demangleModSymbol(rr *strings.Reader) (*Symbol, error) {
+	modPath, err := demangleMod(rr)
+	if err != nil {
+		return nil, err
+	}
+	rest := make([]byte, rr.Len())
+	if _, err := rr.Read(rest); err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch s := string(rest); {
+	case s == "init":
+		return &Symbol{Kind: ModInitSymbol, Mod: modPath}, nil
+	case strings.HasPrefix(s, "string") && isDigits(s[len("string"):]):
+		n, err := strconv.Atoi(s[len("string"):])
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		out.WriteString(recvType)
-		out.WriteRune(' ')
-	case r == 'T':
-		out.WriteString("test ")
+		return &Symbol{Kind: StringSymbol, Mod: modPath, N: n}, nil
+	}
+	rr = strings.NewReader(string(rest))
+	name, err := readName(rr)
+	switch {
+	case err != nil:
+		return nil, err
+	case rr.Len() > 0:
+		return nil, errors.New("unexpected characters after name")
+	case strings.HasPrefix(name, "$"):
+		// Block type names begin with $, and Val names cannot.
+		return &Symbol{Kind: BlockSymbol, Mod: modPath, Sel: name}, nil
 	default:
-		return "", fmt.Errorf("expected F or M, got %c", r)
+		return &Symbol{Kind: ValSymbol, Mod: modPath, Sel: name}, nil
 	}
+}
 
-	switch nargs, err := readInt(rr); {
-	case err != nil:
-		return "", err
-	case nargs == 1:
-		arg, err := demangleType(rr)
-		if err != nil {
-			return "", err
+// demangleBlockSymbol demangles the name of a block in a Fun.
+func demangleBlockSymbol(rr *strings.Reader) (*Symbol, error) {
+	modPath, err := demangleMod(rr)
+	if err != nil {
+		return nil, err
+	}
+	instModPath, err := demangleMod(rr)
+	if err != nil {
+		return nil, err
+	}
+	name, err := readName(rr)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(name, "$") {
+		return nil, errors.New("expected a block type name")
+	}
+	return &Symbol{Kind: BlockSymbol, Mod: modPath, InstMod: instModPath, Sel: name}, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
 		}
-		out.WriteString(arg)
-		out.WriteRune(' ')
-	case nargs > 1:
-		out.WriteRune('(')
-		for i := 0; i < nargs; i++ {
-			arg, err := demangleType(rr)
-			if err != nil {
-				return "", err
+	}
+	return len(s) > 0
+}
+
+func (sym *Symbol) String() string {
+	var s strings.Builder
+	switch {
+	case sym == nil:
+		return "<nil>"
+	case sym.Kind == BlockSymbol:
+		s.WriteString("block ")
+		writeQualified(sym.Mod, sym.Sel, &s)
+		if sym.InstMod != "" {
+			s.WriteString(" in ")
+			s.WriteString(sym.InstMod)
+		}
+	case sym.Kind == ValSymbol:
+		s.WriteString("Val ")
+		writeQualified(sym.Mod, sym.Sel, &s)
+	case sym.Kind == ValInitSymbol:
+		s.WriteString("initializer of Val ")
+		writeQualified(sym.Mod, sym.Sel, &s)
+	case sym.Kind == ModInitSymbol:
+		s.WriteString("initializer of module ")
+		s.WriteString(sym.Mod)
+	case sym.Kind == StringSymbol:
+		fmt.Fprintf(&s, "string %d of module %s", sym.N, sym.Mod)
+	case sym.Kind == TypeSymbol:
+		sym.Type.buildString(&s)
+	default:
+		buildFunString(sym, &s)
+	}
+	return s.String()
+}
+
+func buildFunString(sym *Symbol, s *strings.Builder) {
+	switch sym.Kind {
+	case FunSymbol:
+		s.WriteString("Func ")
+	case MethSymbol:
+		s.WriteString("Meth ")
+		sym.Recv.buildString(s)
+		s.WriteRune(' ')
+	case TestSymbol:
+		s.WriteString("test ")
+	}
+	buildTypeArgsString(sym.TArgs, s)
+	if len(sym.TArgs) > 0 {
+		s.WriteRune(' ')
+	}
+	writeQualified(sym.Mod, sym.Sel, s)
+	if len(sym.Meths) > 0 {
+		s.WriteString(" [")
+		for i, meth := range sym.Meths {
+			if i > 0 {
+				s.WriteString(", ")
 			}
+			s.WriteString(meth.String())
+		}
+		s.WriteRune(']')
+	}
+}
+
+func (typ *SymbolType) String() string {
+	var s strings.Builder
+	typ.buildString(&s)
+	return s.String()
+}
+
+func (typ *SymbolType) buildString(s *strings.Builder) {
+	buildTypeArgsString(typ.Args, s)
+	if len(typ.Args) > 0 {
+		s.WriteRune(' ')
+	}
+	writeQualified(typ.Mod, typ.Name, s)
+}
+
+func buildTypeArgsString(args []SymbolType, s *strings.Builder) {
+	switch {
+	case len(args) == 1:
+		args[0].buildString(s)
+	case len(args) > 1:
+		s.WriteRune('(')
+		for i := range args {
 			if i > 0 {
-				out.WriteString(", ")
+				s.WriteString(", ")
 			}
-			out.WriteString(arg)
+			args[i].buildString(s)
 		}
-		out.WriteString(") ")
+		s.WriteRune(')')
 	}
+}
 
-	switch modPath, err := demangleMod(rr); {
-	case err != nil:
-		return "", err
-	case modPath != "":
-		out.WriteString(modPath)
-		out.WriteRune(' ')
+func writeQualified(modPath, name string, s *strings.Builder) {
+	if modPath != "" {
+		s.WriteString(modPath)
+		s.WriteRune(' ')
 	}
+	s.WriteString(name)
+}
 
-	sel, err := readStr(rr)
+func demangleFun(rr io.RuneReader) (*Symbol, error) {
+	var sym Symbol
+	if err := demangleFunKind(rr, &sym); err != nil {
+		return nil, err
+	}
+	nargs, err := readInt(rr)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if sym.TArgs, err = demangleTypes(nargs, rr); err != nil {
+		return nil, err
+	}
+	if sym.Mod, err = demangleMod(rr); err != nil {
+		return nil, err
+	}
+	if sym.Sel, err = readName(rr); err != nil {
+		return nil, err
 	}
-	out.WriteString(sel)
-
 	switch n, err := readIntOrEOF(rr); {
 	case err == io.EOF:
 		break
 	case err != nil:
-		return "", err
+		return nil, err
 	default:
-		modPath, err := demangleMod(rr)
+		for i := 0; i < n; i++ {
+			meth, err := demangleMeth(rr)
+			if err != nil {
+				return nil, err
+			}
+			sym.Meths = append(sym.Meths, meth)
+		}
+	}
+	return &sym, nil
+}
+
+// demangleFunKind demangles the kind of a function symbol,
+// and its receiver type if it is a method.
+func demangleFunKind(rr io.RuneReader, sym *Symbol) error {
+	switch r, _, err := rr.ReadRune(); {
+	case err == io.EOF:
+		return errors.New("unexpected EOF")
+	case err != nil:
+		return err
+	case r == 'F':
+		sym.Kind = FunSymbol
+	case r == 'M':
+		sym.Kind = MethSymbol
+		recv, err := demangleType(rr)
 		if err != nil {
-			return "", err
+			return err
+		}
+		sym.Recv = recv
+	case r == 'T':
+		sym.Kind = TestSymbol
+	default:
+		return fmt.Errorf("expected F or M, got %c", r)
+	}
+	return nil
+}
+
+func demangleMeth(rr io.RuneReader) (*Symbol, error) {
+	n, err := readInt(rr)
+	if err != nil {
+		return nil, err
+	}
+	var m strings.Builder
+	for i := 0; i < n; i++ {
+		switch r, _, err := rr.ReadRune(); {
+		case err == io.EOF:
+			return nil, errors.New("unexpected EOF")
+		case err != nil:
+			return nil, err
+		default:
+			m.WriteRune(r)
 		}
-		fmt.Fprintf(&out, " %d %s", n, modPath)
 	}
-	return out.String(), nil
+	if n == 0 {
+		return nil, nil
+	}
+	mr := strings.NewReader(m.String())
+	meth, err := demangleFun(mr)
+	if err == nil && mr.Len() > 0 {
+		err = errors.New("unexpected characters after method")
+	}
+	return meth, err
 }
 
 func mangleType(typ *types.Type, s *strings.Builder) *strings.Builder {
@@ -229,49 +515,36 @@ func mangleType(typ *types.Type, s *strings.Builder) *strings.Builder {
 	return s
 }
 
-func demangleType(rr io.RuneReader) (string, error) {
+func demangleType(rr io.RuneReader) (*SymbolType, error) {
 	modPath, err := demangleMod(rr)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	arity, err := readInt(rr)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	name, err := readStr(rr)
+	name, err := readName(rr)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	var args []string
-	for i := 0; i < arity; i++ {
-		arg, err := demangleType(rr)
-		if err != nil {
-			return "", err
-		}
-		args = append(args, arg)
+	args, err := demangleTypes(arity, rr)
+	if err != nil {
+		return nil, err
 	}
-	var s strings.Builder
+	return &SymbolType{Mod: modPath, Name: name, Args: args}, nil
+}
 
-	switch {
-	case len(args) == 1:
-		s.WriteString(args[0])
-		s.WriteRune(' ')
-	case len(args) > 1:
-		s.WriteRune('(')
-		for i, arg := range args {
-			if i > 0 {
-				s.WriteString(", ")
-			}
-			s.WriteString(arg)
+func demangleTypes(n int, rr io.RuneReader) ([]SymbolType, error) {
+	var typs []SymbolType
+	for i := 0; i < n; i++ {
+		typ, err := demangleType(rr)
+		if err != nil {
+			return nil, err
 		}
-		s.WriteString(") ")
-	}
-	s.WriteString(modPath)
-	if len(modPath) > 0 {
-		s.WriteRune(' ')
+		typs = append(typs, *typ)
 	}
-	s.WriteString(name)
-	return s.String(), nil
+	return typs, nil
 }
 
 func mangleMod(modPath string, s *strings.Builder) *strings.Builder {
@@ -288,6 +561,18 @@ func writeStr(str string, s *strings.Builder) *strings.Builder {
 	return s
 }
 
+// readName reads a non-empty string.
+func readName(rr io.RuneReader) (string, error) {
+	switch str, err := readStr(rr); {
+	case err != nil:
+		return "", err
+	case str == "":
+		return "", errors.New("expected a name")
+	default:
+		return str, nil
+	}
+}
+
 func readStr(rr io.RuneReader) (string, error) {
 	var esc bool
 	var out strings.Builder
@@ -325,11 +610,13 @@ func readInt(rr io.RuneReader) (int, error) {
 }
 
 func readIntOrEOF(rr io.RuneReader) (int, error) {
-	var n int
+	var n, digits int
 	for {
 		switch r, _, err := rr.ReadRune(); {
 		case err != nil:
 			return 0, err
+		case r == '_' && digits == 0:
+			return 0, errors.New("expected a digit")
 		case r == '_':
 			return n, nil
 		case r < '0' || r > '9':
@@ -340,6 +627,7 @@ func readIntOrEOF(rr io.RuneReader) (int, error) {
 			}
 			n *= 10
 			n += int(r - '0')
+			digits++
 		}
 	}
 }
@@ -3,10 +3,13 @@
 package gengo
 
 import (
+	"bytes"
+	"fmt"
 	"regexp"
 	"strings"
 	"testing"
 
+	"github.com/eaburns/pea/basic"
 	"github.com/eaburns/pea/types"
 )
 
@@ -283,7 +286,7 @@ func TestMangleTypesFun(t *testing.T) {
 				meth Int [foo]
 				val intArray Int Array := [{}]
 			`,
-			want: "Meth Int Array /test/test foo 0 /test/test",
+			want: "Meth Int Array /test/test foo [Meth Int /test/test foo]",
 		},
 		{
 			src: `
@@ -292,7 +295,18 @@ func TestMangleTypesFun(t *testing.T) {
 				type Fooer {[foo]}
 				meth Int [foo]
 			`,
-			want: "Func Int /test/test foo: 0 /test/test",
+			want: "Func Int /test/test foo: [Meth Int /test/test foo]",
+		},
+		{
+			src: `
+				val test := [foo: intArray]
+				func (T Fooer) [foo: _ T]
+				type Fooer {[foo]}
+				meth (_ Fooer) Array [foo]
+				meth Int [foo]
+				val intArray Int Array := [{}]
+			`,
+			want: "Func Int Array /test/test foo: [Meth Int Array /test/test foo [Meth Int /test/test foo]]",
 		},
 	}
 	for _, test := range tests {
@@ -320,7 +334,7 @@ func TestMangleTypesFun(t *testing.T) {
 			if err != nil {
 				t.Fatalf("demangleType(%q)=_,%v, want no error", m, err)
 			}
-			if u != test.want {
+			if u.String() != test.want {
 				t.Errorf("%s demangled to %s, want %s", f, u, test.want)
 			}
 		})
@@ -399,7 +413,7 @@ func TestMangleType(t *testing.T) {
 			if err != nil {
 				t.Fatalf("demangleType(%q)=_,%v, want no error", m, err)
 			}
-			if u != test.want {
+			if u.String() != test.want {
 				t.Errorf("%s demangled to %s, want %s", typ, u, test.want)
 			}
 		})
@@ -472,3 +486,99 @@ func TestDeangleTestName(t *testing.T) {
 		}
 	}
 }
+
+func TestDemangle(t *testing.T) {
+	tests := []struct {
+		mangle string
+		want   string
+		err    bool
+	}{
+		{mangle: "F0_main__foo__", want: "Func main foo"},
+		{mangle: "F1___0_String__main__print_3A__", want: "Func String main print:"},
+		{
+			mangle: "M__1_Array____0_String__1___0_Int__main__foo_3Abar_3A__",
+			want:   "Meth String Array Int main foo:bar:",
+		},
+		{mangle: "T0_main__foo__", want: "test main foo"},
+		{mangle: "main_____24Block0__", want: "block main $Block0"},
+		{mangle: "main__other___24Block0__", want: "block main $Block0 in other"},
+		{mangle: "main___24Block1__", want: "block main $Block1"},
+		{mangle: "main__x__", want: "Val main x"},
+		{mangle: "init__main__x__", want: "initializer of Val main x"},
+		{mangle: "init__x__", want: "Val init x"},
+		{mangle: "main__init", want: "initializer of module main"},
+		{mangle: "main__string12", want: "string 12 of module main"},
+		{mangle: "__0_Int__", want: "Int"},
+		{
+			mangle: "_2Ftest_2Ftest__2_Pair____0_Int____0_String__",
+			want:   "(Int, String) /test/test Pair",
+		},
+		{mangle: "", err: true},
+		{mangle: "main", err: true},
+		{mangle: "____", err: true},
+		{mangle: "F0_", err: true},
+		{mangle: "F0_main__foo__extra", err: true},
+		{mangle: "main__x__y", err: true},
+		{mangle: "main__string", err: true},
+		{mangle: "main__y__x__", err: true},
+		{mangle: "__0_Int__0_", err: true},
+	}
+	for _, test := range tests {
+		sym, err := Demangle(test.mangle)
+		switch {
+		case test.err && err == nil:
+			t.Errorf("Demangle(%q)=%s, want error", test.mangle, sym)
+		case !test.err && err != nil:
+			t.Errorf("Demangle(%q)=_,%v, want %s", test.mangle, err, test.want)
+		case !test.err && sym.String() != test.want:
+			t.Errorf("Demangle(%q)=%s, want %s", test.mangle, sym, test.want)
+		}
+	}
+}
+
+func TestDemangleWriteMod(t *testing.T) {
+	const src = `
+		func [main | (make: "a") swap. show: "hello". print: (show: y)]
+		func [make: x String ^(String, String) Pair | ^{x: x y: "used"}]
+		type (X, Y) Pair {x: X y: Y}
+		meth (X, Y) Pair [swap ^(Y, X) Pair | ^{x: y y: x}]
+		func T [show: t T ^T | ^[:u T | u] value: t]
+		val y String := [[" world"] value]
+		test [foo | print: "6"]
+	`
+	typesMod, errs := check("main", src)
+	if len(errs) > 0 {
+		t.Fatalf("failed to check: %v", errs)
+	}
+	mod := basic.Build(typesMod)
+	// Debug disables inlining, so blocks are not inlined away.
+	mod.Debug = true
+	basic.Optimize(mod)
+	var b bytes.Buffer
+	if err := WriteMod(&b, mod); err != nil {
+		t.Fatalf("failed to write mod: %v", err)
+	}
+	kinds := make(map[SymbolKind]bool)
+	for b.Len() > 0 {
+		var n int
+		var name string
+		if _, err := fmt.Fscanf(&b, "%d %s\n", &n, &name); err != nil {
+			t.Fatalf("failed to read section header: %v", err)
+		}
+		b.Next(n)
+		sym, err := Demangle(name)
+		if err != nil {
+			t.Errorf("Demangle(%q)=_,%v, want no error", name, err)
+			continue
+		}
+		kinds[sym.Kind] = true
+	}
+	for _, kind := range []SymbolKind{
+		FunSymbol, MethSymbol, TestSymbol, BlockSymbol,
+		ValSymbol, ValInitSymbol, ModInitSymbol, StringSymbol, TypeSymbol,
+	} {
+		if !kinds[kind] {
+			t.Errorf("no section demangled to SymbolKind %d", kind)
+		}
+	}
+}
@@ -18,10 +18,19 @@ type Merger struct {
 	// to the current directory when run.
 	// These file can be read with go tool pprof.
 	Profile bool
-	w       io.Writer
-	seen    map[string]bool
-	inits   []string
-	tests   []testFun
+	// File is the name of the output file.
+	// If set, line directives reset the positions of the Go code
+	// following each definition that has line directives,
+	// so that they refer to the output file, not the Pea source.
+	File     string
+	w        io.Writer
+	seen     map[string]bool
+	sections []section
+	inits    []string
+	tests    []testFun
+	// goRefs are identifiers referenced by Go source files
+	// compiled along with the output.
+	goRefs map[string]bool
 
 	includePrintForTests bool
 }
@@ -31,15 +40,45 @@ type testFun struct {
 	Fun  string
 }
 
+// A section is the Go source of a definition,
+// named by the definition's globally unique name.
+type section struct {
+	name string
+	src  string
+}
+
+// mainFunName is the name of the main module's main function,
+// called by the mainTemplate.
+const mainFunName = "F0_main__main__"
+
 // NewMerger writes a source header to the io.Writer and returns a new Merger.
 func NewMerger(w io.Writer) (*Merger, error) {
 	if _, err := io.WriteString(w, header); err != nil {
 		return nil, err
 	}
-	return &Merger{w: w, seen: make(map[string]bool)}, nil
+	return &Merger{w: w, seen: make(map[string]bool), goRefs: make(map[string]bool)}, nil
+}
+
+// AddGoSrc adds Go source that is compiled along with the output,
+// such as the Go source files of a module.
+// The Go source is not written to the output,
+// but the definitions that it references are always kept.
+func (m *Merger) AddGoSrc(r io.Reader) error {
+	if m.seen == nil {
+		panic("Merger.AddGoSrc called after Merger.Done")
+	}
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	forEachIdent(string(src), func(name string) { m.goRefs[name] = true })
+	return nil
 }
 
 // Add adds the definitions from ant io.Reader to the output.
+// Definitions are not written until Done,
+// and only definitions reachable from the program entry points
+// are written at all.
 func (m *Merger) Add(r io.Reader) error {
 	if m.seen == nil {
 		panic("Merger.Add called after Merger.Done")
@@ -54,7 +93,7 @@ func (m *Merger) Add(r io.Reader) error {
 		if err != nil {
 			return err
 		}
-		if m.seen[name] {
+		if m.seen[name] || strings.HasPrefix(name, "#") {
 			if _, err := io.CopyN(ioutil.Discard, r, byteSize); err != nil {
 				return err
 			}
@@ -73,9 +112,11 @@ func (m *Merger) Add(r io.Reader) error {
 			m.inits = append(m.inits, name)
 		}
 		m.seen[name] = true
-		if _, err := io.CopyN(m.w, r, byteSize); err != nil {
+		var src strings.Builder
+		if _, err := io.CopyN(&src, r, byteSize); err != nil {
 			return err
 		}
+		m.sections = append(m.sections, section{name: name, src: src.String()})
 	}
 	return nil
 }
@@ -89,6 +130,28 @@ func (m *Merger) Done() error {
 	}
 	m.seen = nil
 
+	live := m.reachable()
+	line := strings.Count(header, "\n")
+	for _, sec := range m.sections {
+		if !live[sec.name] {
+			continue
+		}
+		if _, err := io.WriteString(m.w, sec.src); err != nil {
+			return err
+		}
+		line += strings.Count(sec.src, "\n")
+		if m.File == "" || !hasLineDirective(sec.src) {
+			continue
+		}
+		// The directive is on the next line,
+		// and it sets the position of the line after it.
+		line++
+		if _, err := fmt.Fprintf(m.w, "//line %s:%d\n", m.File, line+1); err != nil {
+			return err
+		}
+	}
+	m.sections = nil
+
 	if m.includePrintForTests {
 		if _, err := io.WriteString(m.w, printForTests); err != nil {
 			return err
@@ -101,21 +164,113 @@ func (m *Merger) Done() error {
 	}
 	return t.Execute(m.w, map[string]interface{}{
 		"Inits":   m.inits,
+		"Main":    mainFunName,
 		"Tests":   m.tests,
 		"Test":    m.TestMod != "",
+		"TestMod": m.TestMod,
 		"Profile": m.Profile,
 	})
 }
 
+// hasLineDirective returns whether Go source has a line directive.
+func hasLineDirective(src string) bool {
+	return strings.Contains(src, "//line ") || strings.Contains(src, "/*line ")
+}
+
+// reachable returns the names of the sections reachable
+// from the entry points of the program:
+// the module inits, either main or the tests of TestMod,
+// and the definitions referenced by Go source added with AddGoSrc.
+//
+// A section references another if the other's name
+// is an identifier in its source.
+// Since section names are the Go identifiers of their definitions,
+// this finds every reference, and possibly some extras,
+// for example, a field with the same name as a definition.
+func (m *Merger) reachable() map[string]bool {
+	index := make(map[string]int, len(m.sections))
+	for i, sec := range m.sections {
+		index[sec.name] = i
+	}
+	live := make(map[string]bool)
+	var work []int
+	mark := func(name string) {
+		if i, ok := index[name]; ok && !live[name] {
+			live[name] = true
+			work = append(work, i)
+		}
+	}
+	for _, name := range m.inits {
+		mark(name)
+	}
+	if m.TestMod == "" {
+		mark(mainFunName)
+	}
+	for _, test := range m.tests {
+		mark(test.Fun)
+	}
+	for name := range m.goRefs {
+		mark(name)
+	}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		forEachIdent(m.sections[i].src, mark)
+	}
+	return live
+}
+
+// forEachIdent calls f for each identifier in Go source.
+// Identifiers in comments and string literals are included.
+func forEachIdent(src string, f func(string)) {
+	start := -1
+	for i := 0; i <= len(src); i++ {
+		if i < len(src) && isIdentByte(src[i]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && !('0' <= src[start] && src[start] <= '9') {
+			f(src[start:i])
+		}
+		start = -1
+	}
+}
+
+func isIdentByte(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' || b == '_'
+}
+
 const header = `package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io/ioutil"
+	"math"
 	"os"
+	"regexp"
+	"runtime"
 	"runtime/pprof"
+	"strings"
 	"sync/atomic"
+	"time"
+)
+
+// These are used by the test main.
+var (
+	_ = json.NewEncoder
+	_ = flag.Parse
+	_ = ioutil.ReadAll
+	_ = regexp.Compile
+	_ = time.Now
 )
 
+// This is used by checked NumConvertOps.
+var _ = math.Trunc
+
 var tokenCounter int64
 
 type retToken int64
@@ -140,11 +295,59 @@ func recoverTestLoc(file string, line int) {
 		r.testFile = file
 		r.testLine = line
 		panic(r)
+	case runtime.Error:
+		p := runtimePanicVal(r)
+		p.testFile = file
+		p.testLine = line
+		panic(p)
 	default:
 		panic(r)
 	}
 }
 
+// runtimePanicVal returns a panicVal for a Go runtime error,
+// such as an index out of range, a nil dereference, or a divide by zero.
+// The panicVal is located at the Pea source of the Go code
+// that caused the error, given by the line directives
+// of the generated code.
+// It must be called by the deferred function that recovered the error.
+func runtimePanicVal(err runtime.Error) panicVal {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(1, pcs)
+	for n == len(pcs) {
+		pcs = make([]uintptr, 2*len(pcs))
+		n = runtime.Callers(1, pcs)
+	}
+	// The error is in the first non-runtime frame
+	// below the outermost runtime.gopanic.
+	// There are multiple runtime.gopanic frames
+	// if the error was recovered and re-panicked by a defer.
+	p := panicVal{msg: err.Error()}
+	var panicking bool
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		switch {
+		case frame.Function == "runtime.gopanic":
+			panicking = true
+		case panicking && !strings.HasPrefix(frame.Function, "runtime."):
+			p.file, p.line = frame.File, frame.Line
+			panicking = false
+		}
+		if !more {
+			break
+		}
+	}
+	// Line directive file names are absolute,
+	// like the file names of panicVals,
+	// but the generated code uses ?? for an empty file name,
+	// which is relative to the directory of the Go source file.
+	if p.file == "??" || strings.HasSuffix(p.file, "/??") {
+		p.file = ""
+	}
+	return p
+}
+
 func use(interface{}) {}
 
 func F0___print_3A__(x *[]byte) {
@@ -154,22 +357,148 @@ func F0___print_3A__(x *[]byte) {
 
 const mainTemplate = `
 {{if  .Test -}}
-var exitStatus = 0
+var (
+	testRun     = flag.String("run", "", "run only tests with names matching the regular expression")
+	testVerbose = flag.Bool("v", false, "print the result of each test, not just failures")
+	testJSON    = flag.Bool("json", false, "print a stream of JSON test events")
+	testStdout  = os.Stdout
+)
+
+type testCase struct {
+	name string
+	fun  func()
+}
+
+// testEvent is compatible with the events printed by go test -json.
+type testEvent struct {
+	Time    time.Time
+	Action  string
+	Package string
+	Test    string  ` + "`" + `json:",omitempty"` + "`" + `
+	Elapsed float64 ` + "`" + `json:",omitempty"` + "`" + `
+	Output  string  ` + "`" + `json:",omitempty"` + "`" + `
+}
+
+func emitTestEvent(action, name string, elapsed time.Duration, output string) {
+	json.NewEncoder(testStdout).Encode(testEvent{
+		Time:    time.Now(),
+		Action:  action,
+		Package: {{printf "%q" .TestMod}},
+		Test:    name,
+		Elapsed: elapsed.Seconds(),
+		Output:  output,
+	})
+}
+
+func runTests(tests []testCase) int {
+	re, err := regexp.Compile(*testRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bad -run regexp: %s\n", err)
+		return 2
+	}
+	start := time.Now()
+	var n, failed int
+	for _, test := range tests {
+		if !re.MatchString(test.name) {
+			continue
+		}
+		n++
+		if !runTest(test.name, test.fun) {
+			failed++
+		}
+	}
+	elapsed := time.Since(start)
+	switch {
+	case *testJSON && failed > 0:
+		emitTestEvent("fail", "", elapsed, "")
+	case *testJSON:
+		emitTestEvent("pass", "", elapsed, "")
+	case failed > 0:
+		fmt.Printf("FAIL %d of %d failed (%.3fs)\n", failed, n, elapsed.Seconds())
+	case n == 0:
+		fmt.Printf("no tests to run\n")
+	default:
+		fmt.Printf("ok %d passed (%.3fs)\n", n, elapsed.Seconds())
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
 
-func runTest(name string, test func()) {
-	fmt.Print("Test ", name, " ")
+func runTest(name string, test func()) (ok bool) {
+	var output chan string
+	if *testJSON {
+		emitTestEvent("run", name, 0, "")
+		output = captureStdout()
+	}
+	start := time.Now()
 	defer func() {
-		switch r := recover().(type) {
+		r := recover()
+		elapsed := time.Since(start)
+		if output != nil {
+			os.Stdout.Close()
+			os.Stdout = testStdout
+			if out := <-output; out != "" {
+				emitTestEvent("output", name, 0, out)
+			}
+		}
+		switch r := r.(type) {
 		case nil:
-			fmt.Println("ok")
+			ok = true
+			testResult(name, true, elapsed, "")
 		case panicVal:
-			exitStatus = 1
-			fmt.Printf("failed\n\t%s:%d: %s\n", r.testFile, r.testLine, r.msg)
+			msg := fmt.Sprintf("\t%s:%d: %s\n", r.testFile, r.testLine, r.msg)
+			testResult(name, false, elapsed, msg)
+		case runtime.Error:
+			p := runtimePanicVal(r)
+			msg := fmt.Sprintf("\t%s:%d: %s\n", p.file, p.line, p.msg)
+			testResult(name, false, elapsed, msg)
 		default:
+			// This is not a test failure, but a bug
+			// that ends the run after failing the test.
+			msg := fmt.Sprintf("\tpanic: %v\n", r)
+			if _, ok := r.(retToken); ok {
+				msg = "\tfar return from a different stack\n"
+			}
+			testResult(name, false, elapsed, msg)
 			panic(r)
 		}
 	}()
 	test()
+	return false
+}
+
+// captureStdout redirects os.Stdout to a pipe.
+// After os.Stdout is closed, the data written to it
+// is sent on the returned channel.
+func captureStdout() chan string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		panic("failed to create pipe: " + err.Error())
+	}
+	os.Stdout = w
+	output := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		r.Close()
+		output <- string(data)
+	}()
+	return output
+}
+
+func testResult(name string, ok bool, elapsed time.Duration, msg string) {
+	switch {
+	case *testJSON && ok:
+		emitTestEvent("pass", name, elapsed, "")
+	case *testJSON:
+		emitTestEvent("output", name, 0, msg)
+		emitTestEvent("fail", name, elapsed, "")
+	case ok && *testVerbose:
+		fmt.Printf("Test %s ok (%.3fs)\n", name, elapsed.Seconds())
+	case !ok:
+		fmt.Printf("Test %s failed (%.3fs)\n%s", name, elapsed.Seconds(), msg)
+	}
 }
 {{end -}}
 
@@ -179,14 +508,24 @@ func main() {
 		if r == nil {
 			return
 		}
+		{{if .Test -}}
+		if *testJSON {
+			emitTestEvent("fail", "", 0, "")
+		}
+		{{end -}}
 		switch r := r.(type) {
 		case retToken:
 			os.Stderr.WriteString("far return from a different stack\n")
 		case panicVal:
 			fmt.Fprintf(os.Stderr, "%s:%d: panic: %s\n", r.file, r.line, r.msg)
+		case runtime.Error:
+			p := runtimePanicVal(r)
+			fmt.Fprintf(os.Stderr, "%s:%d: panic: %s\n", p.file, p.line, p.msg)
 		default:
 			panic(r)
 		}
+		// Exit with the status of an unrecovered Go panic.
+		os.Exit(2)
 	}()
 
 	if {{.Profile}} {
@@ -207,16 +546,20 @@ func main() {
 		}()
 	}
 
+	{{if .Test -}}
+	flag.Parse()
+	{{end -}}
 	{{range .Inits -}}
 	{{.}}()
 	{{end -}}
 	{{if not .Test -}}
-		F0_main__main__()
+		{{.Main}}()
 	{{else -}}
-		{{range .Tests -}}
-		runTest({{printf "%q" .Name}}, {{.Fun}})
-		{{end -}}
-		os.Exit(exitStatus)
+		os.Exit(runTests([]testCase{
+			{{range .Tests -}}
+			{ {{- printf "%q" .Name}}, {{.Fun -}} },
+			{{end -}}
+		}))
 	{{end -}}
 }
 `
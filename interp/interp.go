@@ -0,0 +1,498 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+// Package interp is an interpreter for the basic representation.
+//
+// It is a reference implementation of the semantics of basic.Mod,
+// useful for running programs without generating and compiling Go,
+// and as an oracle against which to test the gengo output.
+//
+// # Values
+//
+// Simple values are represented by the same Go types used by gengo:
+// Int is int, Int8 is int8, Float is float64, Bool is uint8, and so on.
+// Or-types that consist only of non-typed cases
+// are represented by their tag type.
+//
+// Addresses are *interface{}.
+// Strings are []byte, and Arrays are []interface{}
+// with one element per array element.
+// Empty types are nil.
+// All other types are opaque to users of the package.
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/eaburns/pea/basic"
+	"github.com/eaburns/pea/gengo"
+	"github.com/eaburns/pea/loc"
+	"github.com/eaburns/pea/types"
+)
+
+// A GoFun is a Go implementation of a Fun.
+// The arguments are as described in the package comment;
+// non-simple parameters and the return value are passed by address.
+type GoFun func(args []interface{})
+
+// Interp interprets basic modules.
+type Interp struct {
+	// Stdout is where the built-in print: function writes.
+	// If nil, os.Stdout is used.
+	Stdout io.Writer
+
+	// Bind, if non-nil, returns the implementation
+	// of a Fun that is declared, but not defined
+	// by any of the interpreted modules.
+	// It returns nil if it has no implementation.
+	Bind func(*basic.Fun) GoFun
+
+	funs    map[string]*basic.Fun
+	bound   map[*basic.Fun]GoFun
+	globals map[globalKey]*interface{}
+	tokens  int64
+}
+
+type globalKey struct {
+	modPath string
+	name    string
+}
+
+type frame struct {
+	fun   *basic.Fun
+	args  []interface{}
+	vals  []interface{}
+	token farRet
+}
+
+// farRet is the Go panic value used to implement far returns.
+// It is the token of the frame to which the far return returns.
+type farRet int64
+
+// panicVal is the Go panic value used to implement the built-in panic: function.
+type panicVal struct {
+	msg  string
+	file string
+	line int
+}
+
+func (p *panicVal) Error() string {
+	if p.file == "" {
+		return fmt.Sprintf("panic: %s", p.msg)
+	}
+	return fmt.Sprintf("%s:%d: panic: %s", p.file, p.line, p.msg)
+}
+
+// msgLoc returns the source location of a Msg of a Fun,
+// or nil if the location is not known,
+// for example, if the Fun's Mod was read from its basic representation
+// and has no AST.
+func msgLoc(f *basic.Fun, msg *types.Msg) *loc.Loc {
+	if msg == nil || msg.AST == nil || f.Mod.Mod.AST == nil || f.Mod.Mod.AST.Locs == nil {
+		return nil
+	}
+	return f.Mod.Mod.AST.Locs.Loc(msg.AST.GetRange())
+}
+
+// Run runs the initialization of each module in order,
+// followed by the main function of the main module.
+//
+// The modules must contain the definitions of all called Funs
+// not handled by Bind, and their initialization
+// is run in the order of the mods slice,
+// so imported modules should come before their importers.
+//
+// If the program panics, the returned error describes the panic.
+func (in *Interp) Run(mods []*basic.Mod) (err error) {
+	in.funs = make(map[string]*basic.Fun)
+	in.bound = make(map[*basic.Fun]GoFun)
+	in.globals = make(map[globalKey]*interface{})
+	var main *basic.Fun
+	for _, mod := range mods {
+		for _, f := range mod.Funs {
+			if f.BBlks == nil {
+				continue
+			}
+			name := gengo.FunName(f)
+			if _, ok := in.funs[name]; !ok {
+				in.funs[name] = f
+			}
+			if main == nil && isMain(f) {
+				main = f
+			}
+		}
+	}
+	if main == nil {
+		return errors.New("no main function")
+	}
+
+	defer func() {
+		switch r := recover().(type) {
+		case nil:
+			return
+		case farRet:
+			err = errors.New("far return from a different stack")
+		case *panicVal:
+			err = r
+		default:
+			panic(r)
+		}
+	}()
+	for _, mod := range mods {
+		in.call(mod.Init, nil)
+	}
+	in.call(main, nil)
+	return nil
+}
+
+func isMain(f *basic.Fun) bool {
+	return f.Fun != nil &&
+		f.Block == nil &&
+		f.Fun.ModPath == "main" &&
+		f.Fun.Recv == nil &&
+		!f.Fun.Test &&
+		len(f.Fun.TArgs) == 0 &&
+		f.Fun.Sig.Sel == "main"
+}
+
+func (in *Interp) call(f *basic.Fun, args []interface{}) {
+	if f.BBlks == nil {
+		in.link(f)(args)
+		return
+	}
+	fr := &frame{
+		fun:  f,
+		args: args,
+		vals: make([]interface{}, f.NVals),
+	}
+	switch {
+	case f.CanFarRet:
+		in.tokens++
+		fr.token = farRet(in.tokens)
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if tok, ok := r.(farRet); !ok || tok != fr.token {
+				panic(r)
+			}
+		}()
+	case f.Block != nil:
+		fr.token = (*args[0].(*interface{})).(*and).token
+	}
+	var prev *basic.BBlk
+	for b := f.BBlks[0]; b != nil; {
+		b, prev = in.execBBlk(fr, prev, b), b
+	}
+}
+
+// link returns the implementation of a declared-only Fun.
+func (in *Interp) link(f *basic.Fun) GoFun {
+	if g, ok := in.bound[f]; ok {
+		return g
+	}
+	var g GoFun
+	if def, ok := in.funs[gengo.FunName(f)]; ok {
+		g = func(args []interface{}) { in.call(def, args) }
+	} else if in.Bind != nil {
+		g = in.Bind(f)
+	}
+	if g == nil && f.Fun != nil && f.Fun.ModPath == "" && f.Fun.Sig.Sel == "print:" {
+		g = in.print
+	}
+	if g == nil {
+		panic(fmt.Sprintf("undefined function %s", gengo.FunName(f)))
+	}
+	in.bound[f] = g
+	return g
+}
+
+func (in *Interp) print(args []interface{}) {
+	w := in.Stdout
+	if w == nil {
+		w = os.Stdout
+	}
+	w.Write((*args[0].(*interface{})).([]byte))
+}
+
+// execBBlk executes the statements of a BBlk,
+// entered from the prev BBlk,
+// and returns the next BBlk to execute,
+// or nil if the function returns.
+func (in *Interp) execBBlk(fr *frame, prev, b *basic.BBlk) *basic.BBlk {
+	// The Phis at the beginning of the BBlk are assigned in parallel,
+	// since the argument of one Phi can be another Phi of the BBlk.
+	var phis []interface{}
+	for _, stmt := range b.Stmts {
+		phi, ok := stmt.(*basic.Phi)
+		if !ok {
+			break
+		}
+		for i, p := range phi.In {
+			if p == prev {
+				phis = append(phis, fr.val(phi.Args[i]))
+			}
+		}
+	}
+	for i, v := range phis {
+		fr.vals[b.Stmts[i].(basic.Val).Num()] = v
+	}
+	for _, stmt := range b.Stmts[len(phis):] {
+		if term, ok := stmt.(basic.Term); ok {
+			return in.execTerm(fr, term)
+		}
+		in.exec(fr, stmt)
+	}
+	panic(fmt.Sprintf("BBlk %d has no terminal statement", b.N))
+}
+
+// execTerm executes a terminal statement
+// and returns the next BBlk to execute,
+// or nil if the function returns.
+func (in *Interp) execTerm(fr *frame, term basic.Term) *basic.BBlk {
+	switch term := term.(type) {
+	case *basic.Panic:
+		p := &panicVal{msg: string((*fr.addr(term.Arg)).([]byte))}
+		if l := msgLoc(fr.fun, term.Msg); l != nil {
+			p.file, p.line = l.Path, l.Line[0]
+		}
+		panic(p)
+	case *basic.Ret:
+		if term.Far {
+			panic(fr.token)
+		}
+		return nil
+	case *basic.Jmp:
+		return term.Dst
+	case *basic.Switch:
+		var i int
+		switch v := fr.val(term.Val).(type) {
+		case *interface{}:
+			if v != nil {
+				i = 1
+			}
+		default:
+			i = toInt(v)
+			if term.Val.Type().BuiltIn == types.BoolType {
+				i = 1 - i
+			}
+		}
+		return term.Dsts[i]
+	default:
+		panic(fmt.Sprintf("impossible type %T", term))
+	}
+}
+
+// exec executes a non-terminal statement.
+func (in *Interp) exec(fr *frame, stmt basic.Stmt) {
+	switch stmt := stmt.(type) {
+	case *basic.Comment:
+	case *basic.Store:
+		*fr.addr(stmt.Dst) = fr.val(stmt.Val)
+	case *basic.Copy:
+		assign(fr.addr(stmt.Dst), *fr.addr(stmt.Src))
+	case *basic.Call:
+		in.call(stmt.Fun, fr.values(stmt.Args))
+	case *basic.VirtCall:
+		v := (*fr.addr(stmt.Self)).(*virt)
+		args := make([]interface{}, 0, len(stmt.Args))
+		if v.obj != nil {
+			args = append(args, v.obj)
+		}
+		args = append(args, fr.values(stmt.Args[1:])...)
+		in.call(v.funs[stmt.Index], args)
+	case basic.Val:
+		fr.vals[stmt.Num()] = in.eval(fr, stmt)
+	default:
+		fr.make(stmt)
+	}
+}
+
+// make executes a statement that makes an object at its Dst.
+func (fr *frame) make(stmt basic.Stmt) {
+	switch stmt := stmt.(type) {
+	case *basic.MakeArray:
+		elmType := refElemType(stmt.Dst).Args[0].Type
+		ary := make([]interface{}, len(stmt.Args))
+		for i, arg := range stmt.Args {
+			ary[i] = fr.elem(elmType, arg)
+		}
+		*fr.addr(stmt.Dst) = ary
+	case *basic.NewArray:
+		elmType := refElemType(stmt.Dst).Args[0].Type
+		ary := make([]interface{}, toInt(fr.val(stmt.Size)))
+		for i := range ary {
+			ary[i] = zero(elmType)
+		}
+		*fr.addr(stmt.Dst) = ary
+	case *basic.MakeSlice:
+		fr.makeSlice(stmt)
+	case *basic.MakeString:
+		*fr.addr(stmt.Dst) = []byte(stmt.Data.Data)
+	case *basic.NewString:
+		data := (*fr.addr(stmt.Data)).([]interface{})
+		str := make([]byte, len(data))
+		for i, b := range data {
+			str[i] = b.(uint8)
+		}
+		*fr.addr(stmt.Dst) = str
+	case *basic.MakeAnd:
+		fr.makeAnd(stmt)
+	case *basic.MakeOr:
+		fr.makeOr(stmt)
+	case *basic.MakeVirt:
+		v := &virt{funs: stmt.Virts}
+		if stmt.Obj != nil {
+			v.obj = fr.addr(stmt.Obj)
+		}
+		*fr.addr(stmt.Dst) = v
+	default:
+		panic(fmt.Sprintf("impossible type %T", stmt))
+	}
+}
+
+func (fr *frame) makeSlice(stmt *basic.MakeSlice) {
+	from := toInt(fr.val(stmt.From))
+	to := toInt(fr.val(stmt.To))
+	switch ary := (*fr.addr(stmt.Ary)).(type) {
+	case []byte:
+		*fr.addr(stmt.Dst) = ary[from : to+1]
+	case []interface{}:
+		*fr.addr(stmt.Dst) = ary[from : to+1]
+	}
+}
+
+func (fr *frame) makeAnd(stmt *basic.MakeAnd) {
+	typ := refElemType(stmt.Dst)
+	a := &and{fields: make([]interface{}, len(stmt.Fields))}
+	for i, field := range stmt.Fields {
+		switch {
+		case field == nil:
+			continue
+		case i < len(typ.Fields):
+			a.fields[i] = fr.elem(typ.Fields[i].Type(), field)
+		default:
+			a.fields[i] = fr.val(field)
+		}
+	}
+	if stmt.BlockFun != nil {
+		a.token = fr.token
+	}
+	assign(fr.addr(stmt.Dst), a)
+}
+
+func (fr *frame) makeOr(stmt *basic.MakeOr) {
+	typ := refElemType(stmt.Dst)
+	if basic.SimpleType(typ) {
+		*fr.addr(stmt.Dst) = convert(stmt.Case, typ)
+		return
+	}
+	o := zero(typ).(*or)
+	o.tag = stmt.Case
+	if stmt.Val != nil {
+		o.cases[stmt.Case] = fr.elem(typ.Cases[stmt.Case].Type(), stmt.Val)
+	}
+	assign(fr.addr(stmt.Dst), o)
+}
+
+func (in *Interp) eval(fr *frame, v basic.Val) interface{} {
+	switch v := v.(type) {
+	case *basic.IntLit:
+		if isUnsigned(v.Type()) {
+			return convert(v.Val.Uint64(), v.Type())
+		}
+		return convert(v.Val.Int64(), v.Type())
+	case *basic.FloatLit:
+		f, _ := v.Val.Float64()
+		return convert(f, v.Type())
+	case *basic.Op:
+		return fr.op(v)
+	case *basic.Load:
+		return *fr.addr(v.Src)
+	case *basic.Alloc:
+		a := new(interface{})
+		*a = zero(refElemType(v))
+		return a
+	case *basic.Arg:
+		return fr.arg(v.Parm)
+	case *basic.Global:
+		return in.global(v.Val)
+	case *basic.Index:
+		return fr.index(v)
+	case *basic.Field:
+		return fr.field(v)
+	default:
+		panic(fmt.Sprintf("impossible type %T", v))
+	}
+}
+
+func (fr *frame) arg(parm *basic.Parm) interface{} {
+	if parm == fr.fun.Ret {
+		return fr.args[len(fr.fun.Parms)]
+	}
+	for i, p := range fr.fun.Parms {
+		if p == parm {
+			return fr.args[i]
+		}
+	}
+	panic("impossible")
+}
+
+func (fr *frame) index(v *basic.Index) interface{} {
+	i := toInt(fr.val(v.Index))
+	switch ary := (*fr.addr(v.Ary)).(type) {
+	case []byte:
+		return ary[i]
+	case []interface{}:
+		return &ary[i]
+	}
+	panic("impossible")
+}
+
+func (fr *frame) field(v *basic.Field) interface{} {
+	switch obj := (*fr.addr(v.Obj)).(type) {
+	case *and:
+		return &obj.fields[v.Index]
+	case *or:
+		return &obj.cases[v.Index]
+	}
+	panic("impossible")
+}
+
+func (in *Interp) global(v *types.Val) *interface{} {
+	key := globalKey{modPath: v.ModPath, name: v.Var.Name}
+	g, ok := in.globals[key]
+	if !ok {
+		g = new(interface{})
+		*g = zero(v.Var.Type())
+		in.globals[key] = g
+	}
+	return g
+}
+
+func (fr *frame) val(v basic.Val) interface{} { return fr.vals[v.Num()] }
+
+func (fr *frame) addr(v basic.Val) *interface{} { return fr.vals[v.Num()].(*interface{}) }
+
+func (fr *frame) values(vs []basic.Val) []interface{} {
+	args := make([]interface{}, len(vs))
+	for i, v := range vs {
+		args[i] = fr.val(v)
+	}
+	return args
+}
+
+// elem returns the value to store in a composite literal
+// for a Val of the given element type.
+// Non-simple elements are given by reference and are copied.
+func (fr *frame) elem(typ *types.Type, v basic.Val) interface{} {
+	if basic.SimpleType(typ) {
+		return fr.val(v)
+	}
+	return clone(*fr.addr(v))
+}
+
+func refElemType(v basic.Val) *types.Type { return v.Type().Args[0].Type }
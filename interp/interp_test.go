@@ -0,0 +1,412 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/eaburns/pea/ast"
+	"github.com/eaburns/pea/basic"
+	"github.com/eaburns/pea/loc"
+	"github.com/eaburns/pea/types"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		imports [][2]string
+		stdout  string
+		err     string
+	}{
+		{
+			name:   "empty",
+			src:    "func [main|]",
+			stdout: "",
+		},
+		{
+			name:   "literals",
+			src:    `func [main | print: 1. print: " ". print: 3.14. print: " ". print: "こんにちは"]`,
+			stdout: "1 3.14 こんにちは",
+		},
+		{
+			name:   "bool",
+			src:    `func [main | print: true. print: " ". print: false]`,
+			stdout: "true false",
+		},
+		{
+			name: "int ops",
+			src: `
+				func [main |
+					print: 12 & 10. print: " ".
+					print: 12 | 10. print: " ".
+					print: (12 xor: 10). print: " ".
+					print: 12 >> 2. print: " ".
+					print: 12 << 2. print: " ".
+					print: -12. print: " ".
+					print: 7 + 3. print: " ".
+					print: 7 - 3. print: " ".
+					print: 7 * 3. print: " ".
+					print: 7 / 3. print: " ".
+					print: 7 % 3. print: " ".
+					print: 7 = 3. print: " ".
+					print: 7 != 3. print: " ".
+					print: 7 < 3. print: " ".
+					print: 7 <= 7. print: " ".
+					print: 7 > 3. print: " ".
+					print: 7 >= 8.
+				]
+			`,
+			stdout: "8 14 6 3 48 -12 10 4 21 2 1 false true false true true false",
+		},
+		{
+			name: "int overflow wraps",
+			src: `
+				func [main |
+					i Int8 := 127.
+					print: i + 1. print: " ".
+					u UInt8 := 0.
+					print: u - 1.
+				]
+			`,
+			stdout: "-128 255",
+		},
+		{
+			name: "float ops and conversion",
+			src: `
+				func [main |
+					print: 1.5 + 2.25. print: " ".
+					print: 7 asFloat / 2.0. print: " ".
+					print: 3.99 asInt.
+				]
+			`,
+			stdout: "3.75 3.5 3",
+		},
+		{
+			name: "function arguments and return",
+			src: `
+				func [main | print: (greet: "world")]
+				func [greet: s String ^String | print: "hello ". ^s]
+			`,
+			stdout: "hello world",
+		},
+		{
+			name: "and-type fields",
+			src: `
+				type Point {x: Int y: Int}
+				type Rect {min: Point max: Point}
+				func [main |
+					r Rect := {min: {x: 1 y: 2} max: {x: 3 y: 4}}.
+					s := r.
+					s max x: 10.
+					print: r max x. print: " ". print: s max x.
+				]
+				meth Point [x: i Int | x := i]
+				meth Point [x ^Int | ^x]
+				meth Rect [max ^Point& | ^max]
+			`,
+			stdout: "3 10",
+		},
+		{
+			name: "strings and arrays",
+			src: `
+				func [main |
+					s := "hello".
+					print: s byteSize. print: " ".
+					print: (s atByte: 1). print: " ".
+					a Int Array := {1; 2; 3; 4}.
+					a at: 0 put: 5.
+					b := a from: 1 to: 2.
+					b at: 0 put: 6.
+					print: (a at: 0). print: (a at: 1). print: " ".
+					print: b size.
+				]
+			`,
+			stdout: "5 101 56 2",
+		},
+		{
+			name: "newArray and newString",
+			src: `
+				func [main |
+					ary UInt8 Array := newArray: 3 init: [:i | i asUInt8 + 97].
+					print: (newString: ary).
+				]
+			`,
+			stdout: "abc",
+		},
+		{
+			name: "module variables",
+			src: `
+				val x := [y + 1]
+				val y := [41]
+				func [main | print: x]
+			`,
+			stdout: "42",
+		},
+		{
+			name: "or-types",
+			src: `
+				type T? {none | some: T}
+				type Color {red | green | blue}
+				func [main |
+					x Int? := {some: 3}.
+					x ifNone: [print: "none"] ifSome: [:i | print: i].
+					y String? := {none}.
+					y ifNone: [print: "none"] ifSome: [:s | print: s].
+					c Color := {green}.
+					c ifRed: [print: "red"] ifGreen: [print: "green"] ifBlue: [print: "blue"].
+				]
+			`,
+			stdout: "3nonegreen",
+		},
+		{
+			name: "virtual",
+			src: `
+				type Shape {[area ^Float] [scale: Float ^Shape]}
+				type Square {side: Float}
+				meth Square [area ^Float | ^side * side]
+				meth Square [scale: f Float ^Shape | s Square := {side: side * f}. ^s]
+				func [main |
+					sh Shape := (side: 2.0) Square.
+					print: sh area. print: " ".
+					print: (sh scale: 2.0) area.
+				]
+				func [side: f Float ^Square | ^{side: f}]
+				meth Square [Square ^Square | ^self]
+			`,
+			stdout: "4 16",
+		},
+		{
+			name: "blocks capture variables",
+			src: `
+				func [main |
+					n := 0.
+					f := [:i Int | n := n + i].
+					1 to: 4 do: f.
+					print: n.
+				]
+				meth Int [to: e Int do: f (Int, Nil) Fun |
+					self <= e ifTrue: [
+						f value: self.
+						self + 1 to: e do: f.
+					] ifFalse: []
+				]
+			`,
+			stdout: "10",
+		},
+		{
+			name: "loop with phis",
+			src: `
+				func [main | fib: 10 a: 0 b: 1]
+				func [fib: n Int a: a Int b: b Int |
+					n = 0 ifTrue: [print: a] ifFalse: [fib: n - 1 a: b b: a + b]
+				]
+			`,
+			stdout: "55",
+		},
+		{
+			name: "far return",
+			src: `
+				func [main | print: (find: 3 in: {1; 2; 3; 4})]
+				func [find: x Int in: a Int Array ^Int |
+					do: [:i | i = x ifTrue: [^i * 10] ifFalse: []] in: a from: 0.
+					^-1
+				]
+				func [do: f (Int, Nil) Fun in: a Int Array from: i Int |
+					i < a size ifTrue: [
+						f value: (a at: i).
+						do: f in: a from: i + 1.
+					] ifFalse: []
+				]
+			`,
+			stdout: "30",
+		},
+		{
+			name: "far return on different stack",
+			src: `
+				val f Nil Fun := [[]]
+				func [main |
+					print: foo.
+					f value.
+				]
+				func [foo ^Int |
+					f := [^42].
+					^0.
+				]
+			`,
+			stdout: "0",
+			err:    "far return from a different stack",
+		},
+		{
+			name: "panic",
+			src: `									// 1
+				func [main |						// 2
+					foo.							// 3
+					print: "this is not printed".	// 4
+				]									// 5
+													// 6
+				func [foo |							// 7
+					panic: "boo"					// 8
+				]
+			`,
+			err: "main:8: panic: boo",
+		},
+		{
+			name: "imports",
+			src: `
+				import "/test/box"
+				func [main |
+					b Int #box Box := #box box: 4.
+					c := b #box map: [:i | i * 10].
+					print: c #box get.
+					print: " ".
+					print: #box answer.
+				]
+			`,
+			imports: [][2]string{
+				{
+					"/test/box",
+					`
+					Type T Box {val: T}
+					Func T [box: t T ^T Box | ^{val: t}]
+					Meth T Box [get ^T | ^val]
+					Meth T Box [map: f (T, T) Fun ^T Box | ^{val: (f value: val)}]
+					Val answer := [42]
+					`,
+				},
+			},
+			stdout: "40 42",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			src := test.src + "\nfunc T [print: _ T]\n"
+			mods, errs := compileAll(src, test.imports...)
+			if len(errs) > 0 {
+				t.Fatalf("failed to compile: %v", errs)
+			}
+			var stdout strings.Builder
+			in := Interp{Stdout: &stdout, Bind: bindPrint(&stdout)}
+			err := in.Run(mods)
+			var errStr string
+			if err != nil {
+				errStr = err.Error()
+			}
+			if errStr != test.err {
+				t.Errorf("error: got [%s], want [%s]", errStr, test.err)
+			}
+			if stdout.String() != test.stdout {
+				t.Errorf("stdout: got [%s], want [%s]", stdout.String(), test.stdout)
+			}
+		})
+	}
+}
+
+// TestRunPanicNoAST tests a panic in a Mod with no AST,
+// such as one read from its basic representation.
+func TestRunPanicNoAST(t *testing.T) {
+	src := "func [main | panic: \"boo\"]\nfunc T [print: _ T]\n"
+	mods, errs := compileAll(src)
+	if len(errs) > 0 {
+		t.Fatalf("failed to compile: %v", errs)
+	}
+	for _, mod := range mods {
+		mod.Mod.AST = nil
+	}
+	var stdout strings.Builder
+	in := Interp{Stdout: &stdout, Bind: bindPrint(&stdout)}
+	const want = "panic: boo"
+	if err := in.Run(mods); err == nil || err.Error() != want {
+		t.Errorf("error: got [%v], want [%s]", err, want)
+	}
+}
+
+// bindPrint binds the main module's
+//
+//	func T [print: _ T]
+//
+// assumed in tests.
+func bindPrint(w io.Writer) func(*basic.Fun) GoFun {
+	return func(f *basic.Fun) GoFun {
+		if f.Fun == nil || f.Fun.ModPath != "main" || f.Fun.Sig.Sel != "print:" {
+			return nil
+		}
+		typ := f.Parms[0].Type
+		return func(args []interface{}) {
+			x, typ := args[0], typ
+			if p, ok := x.(*interface{}); ok {
+				x, typ = *p, typ.Args[0].Type
+			}
+			switch {
+			case typ.BuiltIn == types.BoolType:
+				fmt.Fprintf(w, "%v", x == uint8(1))
+			case typ.BuiltIn == types.StringType:
+				fmt.Fprintf(w, "%s", x)
+			default:
+				fmt.Fprintf(w, "%v", x)
+			}
+		}
+	}
+}
+
+// compileAll returns the basic modules of the imports followed by the main module.
+func compileAll(src string, imports ...[2]string) ([]*basic.Mod, []error) {
+	var mods []*basic.Mod
+	srcs := append(imports, [2]string{"main", src})
+	for _, s := range srcs {
+		p := ast.NewParser(s[0])
+		if err := p.Parse(s[0], strings.NewReader(s[1])); err != nil {
+			return nil, []error{err}
+		}
+		typesMod, errs := types.Check(p.Mod(), types.Config{
+			Importer: testImporter(imports),
+		})
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		basicMod := basic.Build(typesMod)
+		basic.Optimize(basicMod)
+		mods = append(mods, basicMod)
+	}
+	return mods, nil
+}
+
+type testImporter [][2]string
+
+func (imports testImporter) Import(cfg types.Config, locs *loc.Files, path string) ([]types.Def, error) {
+	for i := range imports {
+		if imports[i][0] != path {
+			continue
+		}
+		p := ast.NewParserWithLocs(path, locs)
+		if err := p.Parse(path, strings.NewReader(imports[i][1])); err != nil {
+			return nil, fmt.Errorf("failed to parse import: %s", err)
+		}
+		cfg.Trace = false
+		mod, errs := types.Check(p.Mod(), cfg)
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("failed to check import: %s", errs)
+		}
+		setMod(path, mod.Defs)
+		return mod.Defs, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func setMod(path string, defs []types.Def) {
+	for _, def := range defs {
+		switch def := def.(type) {
+		case *types.Val:
+			def.ModPath = path
+		case *types.Fun:
+			def.ModPath = path
+		case *types.Type:
+			def.ModPath = path
+		}
+	}
+}
@@ -0,0 +1,348 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+package interp
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/eaburns/pea/basic"
+	"github.com/eaburns/pea/types"
+)
+
+// and is the value of an and-type.
+//
+// An and-type value is held by the location that contains it;
+// assignment copies the fields into the existing value
+// so that addresses of the fields remain valid.
+type and struct {
+	fields []interface{}
+	// token is the far-return token of a block literal.
+	token farRet
+}
+
+// or is the value of an or-type that is not a simple type.
+// Like an and, it is held by the location that contains it.
+type or struct {
+	tag   int
+	cases []interface{}
+}
+
+// virt is the value of a virtual type.
+type virt struct {
+	// obj is the address of the virtualized object,
+	// or nil if the object is an empty type.
+	obj  *interface{}
+	funs []*basic.Fun
+}
+
+var goTypes = map[types.BuiltInType]reflect.Type{
+	types.BoolType:    reflect.TypeOf(uint8(0)),
+	types.IntType:     reflect.TypeOf(int(0)),
+	types.Int8Type:    reflect.TypeOf(int8(0)),
+	types.Int16Type:   reflect.TypeOf(int16(0)),
+	types.Int32Type:   reflect.TypeOf(int32(0)),
+	types.Int64Type:   reflect.TypeOf(int64(0)),
+	types.UIntType:    reflect.TypeOf(uint(0)),
+	types.UInt8Type:   reflect.TypeOf(uint8(0)),
+	types.UInt16Type:  reflect.TypeOf(uint16(0)),
+	types.UInt32Type:  reflect.TypeOf(uint32(0)),
+	types.UInt64Type:  reflect.TypeOf(uint64(0)),
+	types.FloatType:   reflect.TypeOf(float64(0)),
+	types.Float32Type: reflect.TypeOf(float32(0)),
+	types.Float64Type: reflect.TypeOf(float64(0)),
+}
+
+// goType returns the Go type of a number type,
+// or of the tag of an or-type.
+func goType(typ *types.Type) reflect.Type {
+	if t, ok := goTypes[typ.BuiltIn]; ok {
+		return t
+	}
+	if len(typ.Cases) > 0 {
+		return goType(typ.Tag())
+	}
+	panic(fmt.Sprintf("impossible type %s", typ))
+}
+
+func isUnsigned(typ *types.Type) bool {
+	switch goType(typ).Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// convert converts a Go number to the Go type of a number type.
+func convert(x interface{}, typ *types.Type) interface{} {
+	return reflect.ValueOf(x).Convert(goType(typ)).Interface()
+}
+
+func toInt(x interface{}) int {
+	v := reflect.ValueOf(x)
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(v.Uint())
+	default:
+		return int(v.Int())
+	}
+}
+
+// zero returns the zero value of a type.
+func zero(typ *types.Type) interface{} {
+	switch {
+	case basic.EmptyType(typ):
+		return nil
+	case typ.BuiltIn == types.RefType:
+		return (*interface{})(nil)
+	case typ.BuiltIn == types.ArrayType:
+		return []interface{}(nil)
+	case typ.BuiltIn == types.StringType:
+		return []byte(nil)
+	case len(typ.Cases) > 0 && basic.SimpleType(typ):
+		return reflect.Zero(goType(typ)).Interface()
+	case len(typ.Virts) > 0:
+		return (*virt)(nil)
+	case len(typ.Cases) > 0:
+		o := &or{cases: make([]interface{}, len(typ.Cases))}
+		for i := range typ.Cases {
+			if t := typ.Cases[i].Type(); t != nil {
+				o.cases[i] = zero(t)
+			}
+		}
+		return o
+	case typ.BuiltIn == types.BlockType || typ.BuiltIn == 0:
+		a := &and{fields: make([]interface{}, len(typ.Fields))}
+		for i := range typ.Fields {
+			a.fields[i] = zero(typ.Fields[i].Type())
+		}
+		return a
+	default:
+		return reflect.Zero(goType(typ)).Interface()
+	}
+}
+
+// assign assigns a value to a location.
+// And-type and or-type values are copied into the location's existing value.
+func assign(dst *interface{}, x interface{}) {
+	switch d := (*dst).(type) {
+	case *and:
+		s := x.(*and)
+		if len(d.fields) != len(s.fields) {
+			d.fields = make([]interface{}, len(s.fields))
+		}
+		for i := range s.fields {
+			assign(&d.fields[i], s.fields[i])
+		}
+		d.token = s.token
+	case *or:
+		s := x.(*or)
+		d.tag = s.tag
+		for i := range s.cases {
+			assign(&d.cases[i], s.cases[i])
+		}
+	default:
+		*dst = clone(x)
+	}
+}
+
+// clone returns a copy of a value.
+// Arrays and Strings are copied shallowly.
+func clone(x interface{}) interface{} {
+	switch x := x.(type) {
+	case *and:
+		a := &and{fields: make([]interface{}, len(x.fields)), token: x.token}
+		for i, f := range x.fields {
+			a.fields[i] = clone(f)
+		}
+		return a
+	case *or:
+		o := &or{tag: x.tag, cases: make([]interface{}, len(x.cases))}
+		for i, c := range x.cases {
+			o.cases[i] = clone(c)
+		}
+		return o
+	default:
+		return x
+	}
+}
+
+func (fr *frame) op(op *basic.Op) interface{} {
+	switch op.Code {
+	case basic.ArraySizeOp:
+		return reflect.ValueOf(*fr.addr(op.Args[0])).Len()
+	case basic.UnionTagOp:
+		return convert((*fr.addr(op.Args[0])).(*or).tag, op.Type())
+	case basic.NumConvertOp:
+		return convert(fr.val(op.Args[0]), op.Type())
+	case basic.EqOp, basic.NeqOp, basic.LessOp, basic.LessEqOp, basic.GreaterOp, basic.GreaterEqOp:
+		if compare(op.Code, fr.val(op.Args[0]), fr.val(op.Args[1])) {
+			return uint8(1)
+		}
+		return uint8(0)
+	default:
+		x := reflect.ValueOf(fr.val(op.Args[0]))
+		var y reflect.Value
+		if len(op.Args) > 1 {
+			y = reflect.ValueOf(fr.val(op.Args[1]))
+		}
+		var z interface{}
+		switch x.Kind() {
+		case reflect.Float32, reflect.Float64:
+			z = floatOp(op.Code, x, y)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			z = uintOp(op.Code, x, y)
+		default:
+			z = intOp(op.Code, x, y)
+		}
+		return reflect.ValueOf(z).Convert(x.Type()).Interface()
+	}
+}
+
+func intOp(code basic.OpCode, xv, yv reflect.Value) int64 {
+	x := xv.Int()
+	if code == basic.NegOp {
+		return -x
+	}
+	if code == basic.BitwiseNotOp {
+		return ^x
+	}
+	if code == basic.LeftShiftOp || code == basic.RightShiftOp {
+		n := shiftCount(yv)
+		if code == basic.LeftShiftOp {
+			return x << n
+		}
+		return x >> n
+	}
+	y := yv.Int()
+	switch code {
+	case basic.BitwiseAndOp:
+		return x & y
+	case basic.BitwiseOrOp:
+		return x | y
+	case basic.BitwiseXOrOp:
+		return x ^ y
+	case basic.PlusOp:
+		return x + y
+	case basic.MinusOp:
+		return x - y
+	case basic.TimesOp:
+		return x * y
+	case basic.DivideOp:
+		return x / y
+	case basic.ModOp:
+		return x % y
+	}
+	panic(fmt.Sprintf("impossible op %d", code))
+}
+
+func uintOp(code basic.OpCode, xv, yv reflect.Value) uint64 {
+	x := xv.Uint()
+	if code == basic.NegOp {
+		return -x
+	}
+	if code == basic.BitwiseNotOp {
+		return ^x
+	}
+	if code == basic.LeftShiftOp || code == basic.RightShiftOp {
+		n := shiftCount(yv)
+		if code == basic.LeftShiftOp {
+			return x << n
+		}
+		return x >> n
+	}
+	y := yv.Uint()
+	switch code {
+	case basic.BitwiseAndOp:
+		return x & y
+	case basic.BitwiseOrOp:
+		return x | y
+	case basic.BitwiseXOrOp:
+		return x ^ y
+	case basic.PlusOp:
+		return x + y
+	case basic.MinusOp:
+		return x - y
+	case basic.TimesOp:
+		return x * y
+	case basic.DivideOp:
+		return x / y
+	case basic.ModOp:
+		return x % y
+	}
+	panic(fmt.Sprintf("impossible op %d", code))
+}
+
+func floatOp(code basic.OpCode, xv, yv reflect.Value) float64 {
+	x := xv.Float()
+	if code == basic.NegOp {
+		return -x
+	}
+	y := yv.Float()
+	switch code {
+	case basic.PlusOp:
+		return x + y
+	case basic.MinusOp:
+		return x - y
+	case basic.TimesOp:
+		return x * y
+	case basic.DivideOp:
+		return x / y
+	}
+	panic(fmt.Sprintf("impossible op %d", code))
+}
+
+func shiftCount(v reflect.Value) uint64 {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint()
+	default:
+		n := v.Int()
+		if n < 0 {
+			panic("negative shift amount")
+		}
+		return uint64(n)
+	}
+}
+
+func compare(code basic.OpCode, x, y interface{}) bool {
+	xv, yv := reflect.ValueOf(x), reflect.ValueOf(y)
+	switch xv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		a, b := xv.Float(), yv.Float()
+		return ordered(code, a < b, a == b, a > b)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		a, b := xv.Uint(), yv.Uint()
+		return ordered(code, a < b, a == b, a > b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		a, b := xv.Int(), yv.Int()
+		return ordered(code, a < b, a == b, a > b)
+	}
+	switch code {
+	case basic.EqOp:
+		return x == y
+	case basic.NeqOp:
+		return x != y
+	}
+	panic(fmt.Sprintf("impossible comparison of %T", x))
+}
+
+// ordered returns the result of a comparison
+// given whether the operands are less, equal, or greater.
+// All are false for an unordered, NaN operand.
+func ordered(code basic.OpCode, less, eq, greater bool) bool {
+	switch code {
+	case basic.EqOp:
+		return eq
+	case basic.NeqOp:
+		return !eq
+	case basic.LessOp:
+		return less
+	case basic.LessEqOp:
+		return less || eq
+	case basic.GreaterOp:
+		return greater
+	default:
+		return greater || eq
+	}
+}
@@ -20,6 +20,13 @@ const (
 	getTimeOfDayResultOKTag    = 1
 )
 
+// Int and UInt are the Go types of Pea's Int and UInt,
+// which are sized by the -intsize option of peac.
+type (
+	Int  = __0_Int__
+	UInt = __0_UInt__
+)
+
 type (
 	Dir                = os_2Fposix__0__5FDir__
 	OpenDirResult      = os_2Fposix__0_OpenDirResult__
@@ -30,36 +37,36 @@ type (
 	GetTimeOfDayResult = os_2Fposix__0_GetTimeOfDayResult__
 )
 
-func F0_os_2Fposix__STDIN_5FFILENO__(ret *int)  { *ret = int(unix.Stdin) }
-func F0_os_2Fposix__STDOUT_5FFILENO__(ret *int) { *ret = int(unix.Stdout) }
-func F0_os_2Fposix__STDERR_5FFILENO__(ret *int) { *ret = int(unix.Stderr) }
+func F0_os_2Fposix__STDIN_5FFILENO__(ret *Int)  { *ret = Int(unix.Stdin) }
+func F0_os_2Fposix__STDOUT_5FFILENO__(ret *Int) { *ret = Int(unix.Stdout) }
+func F0_os_2Fposix__STDERR_5FFILENO__(ret *Int) { *ret = Int(unix.Stderr) }
 
-func F0_os_2Fposix__EACCES__(ret *int)  { *ret = int(unix.EACCES) }
-func F0_os_2Fposix__EEXIST__(ret *int)  { *ret = int(unix.EEXIST) }
-func F0_os_2Fposix__EISDIR__(ret *int)  { *ret = int(unix.EISDIR) }
-func F0_os_2Fposix__ENOENT__(ret *int)  { *ret = int(unix.ENOENT) }
-func F0_os_2Fposix__ENOTDIR__(ret *int) { *ret = int(unix.ENOTDIR) }
+func F0_os_2Fposix__EACCES__(ret *Int)  { *ret = Int(unix.EACCES) }
+func F0_os_2Fposix__EEXIST__(ret *Int)  { *ret = Int(unix.EEXIST) }
+func F0_os_2Fposix__EISDIR__(ret *Int)  { *ret = Int(unix.EISDIR) }
+func F0_os_2Fposix__ENOENT__(ret *Int)  { *ret = Int(unix.ENOENT) }
+func F0_os_2Fposix__ENOTDIR__(ret *Int) { *ret = Int(unix.ENOTDIR) }
 
-func F0_os_2Fposix__strerror_3A__(errno int, ret *[]byte) {
+func F0_os_2Fposix__strerror_3A__(errno Int, ret *[]byte) {
 	if errno < 0 {
 		errno = -errno
 	}
 	*ret = []byte(unix.Errno(errno).Error())
 }
 
-func F0_os_2Fposix__O_5FRDONLY__(ret *int)    { *ret = unix.O_RDONLY }
-func F0_os_2Fposix__O_5FWRONLY__(ret *int)    { *ret = unix.O_WRONLY }
-func F0_os_2Fposix__O_5FRDWR__(ret *int)      { *ret = unix.O_RDWR }
-func F0_os_2Fposix__O_5FAPPEND__(ret *int)    { *ret = unix.O_APPEND }
-func F0_os_2Fposix__O_5FCREAT__(ret *int)     { *ret = unix.O_CREAT }
-func F0_os_2Fposix__O_5FEXCL__(ret *int)      { *ret = unix.O_EXCL }
-func F0_os_2Fposix__O_5FTRUNC__(ret *int)     { *ret = unix.O_TRUNC }
-func F0_os_2Fposix__O_5FDIRECTORY__(ret *int) { *ret = unix.O_DIRECTORY }
+func F0_os_2Fposix__O_5FRDONLY__(ret *Int)    { *ret = unix.O_RDONLY }
+func F0_os_2Fposix__O_5FWRONLY__(ret *Int)    { *ret = unix.O_WRONLY }
+func F0_os_2Fposix__O_5FRDWR__(ret *Int)      { *ret = unix.O_RDWR }
+func F0_os_2Fposix__O_5FAPPEND__(ret *Int)    { *ret = unix.O_APPEND }
+func F0_os_2Fposix__O_5FCREAT__(ret *Int)     { *ret = unix.O_CREAT }
+func F0_os_2Fposix__O_5FEXCL__(ret *Int)      { *ret = unix.O_EXCL }
+func F0_os_2Fposix__O_5FTRUNC__(ret *Int)     { *ret = unix.O_TRUNC }
+func F0_os_2Fposix__O_5FDIRECTORY__(ret *Int) { *ret = unix.O_DIRECTORY }
 
-func F0_os_2Fposix__open_3Amode_3Aperm_3A__(path *[]byte, mode int, perm int, ret *int) {
+func F0_os_2Fposix__open_3Amode_3Aperm_3A__(path *[]byte, mode Int, perm Int, ret *Int) {
 	cpath, ok := cstr(path)
 	if !ok {
-		*ret = -int(unix.EINVAL)
+		*ret = -Int(unix.EINVAL)
 	}
 retry:
 	fd, _, e := unix.Syscall(unix.SYS_OPEN, cpath, uintptr(mode), uintptr(perm))
@@ -67,22 +74,22 @@ retry:
 	case e == unix.EINTR:
 		goto retry
 	case e != 0:
-		*ret = -int(e)
+		*ret = -Int(e)
 	default:
-		*ret = int(fd)
+		*ret = Int(fd)
 	}
 }
 
-func F0_os_2Fposix__close_3A__(fd int, ret *int) {
+func F0_os_2Fposix__close_3A__(fd Int, ret *Int) {
 retry:
 	_, _, e := unix.Syscall(unix.SYS_CLOSE, uintptr(fd), 0, 0)
 	if e == unix.EINTR {
 		goto retry
 	}
-	*ret = -int(e)
+	*ret = -Int(e)
 }
 
-func F0_os_2Fposix__read_3Abuf_3A__(fd int, buf *[]byte, ret *int) {
+func F0_os_2Fposix__read_3Abuf_3A__(fd Int, buf *[]byte, ret *Int) {
 	bufP := uintptr(unsafe.Pointer(&(*buf)[0]))
 	bufLen := uintptr(len(*buf))
 retry:
@@ -91,13 +98,13 @@ retry:
 	case e == unix.EINTR:
 		goto retry
 	case e != 0:
-		*ret = -int(e)
+		*ret = -Int(e)
 	default:
-		*ret = int(n)
+		*ret = Int(n)
 	}
 }
 
-func F0_os_2Fposix__write_3Abuf_3A__(fd int, buf *[]byte, ret *int) {
+func F0_os_2Fposix__write_3Abuf_3A__(fd Int, buf *[]byte, ret *Int) {
 	bufP := uintptr(unsafe.Pointer(&(*buf)[0]))
 	bufLen := uintptr(len(*buf))
 retry:
@@ -106,83 +113,83 @@ retry:
 	case e == unix.EINTR:
 		goto retry
 	case e != 0:
-		*ret = -int(e)
+		*ret = -Int(e)
 	default:
-		*ret = int(n)
+		*ret = Int(n)
 	}
 }
 
-func F0_os_2Fposix__S_5FIFMT__(ret *uint)   { *ret = unix.S_IFMT }
-func F0_os_2Fposix__S_5FIFBLK__(ret *uint)  { *ret = unix.S_IFBLK }
-func F0_os_2Fposix__S_5FIFCHR__(ret *uint)  { *ret = unix.S_IFCHR }
-func F0_os_2Fposix__S_5FIFIFO__(ret *uint)  { *ret = unix.S_IFIFO }
-func F0_os_2Fposix__S_5FIFREG__(ret *uint)  { *ret = unix.S_IFREG }
-func F0_os_2Fposix__S_5FIFDIR__(ret *uint)  { *ret = unix.S_IFDIR }
-func F0_os_2Fposix__S_5FIFLNK__(ret *uint)  { *ret = unix.S_IFLNK }
-func F0_os_2Fposix__S_5FIFSOCK__(ret *uint) { *ret = unix.S_IFSOCK }
-func F0_os_2Fposix__S_5FIRWXU__(ret *uint)  { *ret = unix.S_IRWXU }
-func F0_os_2Fposix__S_5FIRUSR__(ret *uint)  { *ret = unix.S_IRUSR }
-func F0_os_2Fposix__S_5FIWUSR__(ret *uint)  { *ret = unix.S_IWUSR }
-func F0_os_2Fposix__S_5FIXUSR__(ret *uint)  { *ret = unix.S_IXUSR }
-func F0_os_2Fposix__S_5FIRWXG__(ret *uint)  { *ret = unix.S_IRWXG }
-func F0_os_2Fposix__S_5FIRGRP__(ret *uint)  { *ret = unix.S_IRGRP }
-func F0_os_2Fposix__S_5FIWGRP__(ret *uint)  { *ret = unix.S_IWGRP }
-func F0_os_2Fposix__S_5FIXGRP__(ret *uint)  { *ret = unix.S_IXGRP }
-func F0_os_2Fposix__S_5FIRWXO__(ret *uint)  { *ret = unix.S_IRWXO }
-func F0_os_2Fposix__S_5FIROTH__(ret *uint)  { *ret = unix.S_IROTH }
-func F0_os_2Fposix__S_5FIWOTH__(ret *uint)  { *ret = unix.S_IWOTH }
-func F0_os_2Fposix__S_5FIXOTH__(ret *uint)  { *ret = unix.S_IXOTH }
+func F0_os_2Fposix__S_5FIFMT__(ret *UInt)   { *ret = unix.S_IFMT }
+func F0_os_2Fposix__S_5FIFBLK__(ret *UInt)  { *ret = unix.S_IFBLK }
+func F0_os_2Fposix__S_5FIFCHR__(ret *UInt)  { *ret = unix.S_IFCHR }
+func F0_os_2Fposix__S_5FIFIFO__(ret *UInt)  { *ret = unix.S_IFIFO }
+func F0_os_2Fposix__S_5FIFREG__(ret *UInt)  { *ret = unix.S_IFREG }
+func F0_os_2Fposix__S_5FIFDIR__(ret *UInt)  { *ret = unix.S_IFDIR }
+func F0_os_2Fposix__S_5FIFLNK__(ret *UInt)  { *ret = unix.S_IFLNK }
+func F0_os_2Fposix__S_5FIFSOCK__(ret *UInt) { *ret = unix.S_IFSOCK }
+func F0_os_2Fposix__S_5FIRWXU__(ret *UInt)  { *ret = unix.S_IRWXU }
+func F0_os_2Fposix__S_5FIRUSR__(ret *UInt)  { *ret = unix.S_IRUSR }
+func F0_os_2Fposix__S_5FIWUSR__(ret *UInt)  { *ret = unix.S_IWUSR }
+func F0_os_2Fposix__S_5FIXUSR__(ret *UInt)  { *ret = unix.S_IXUSR }
+func F0_os_2Fposix__S_5FIRWXG__(ret *UInt)  { *ret = unix.S_IRWXG }
+func F0_os_2Fposix__S_5FIRGRP__(ret *UInt)  { *ret = unix.S_IRGRP }
+func F0_os_2Fposix__S_5FIWGRP__(ret *UInt)  { *ret = unix.S_IWGRP }
+func F0_os_2Fposix__S_5FIXGRP__(ret *UInt)  { *ret = unix.S_IXGRP }
+func F0_os_2Fposix__S_5FIRWXO__(ret *UInt)  { *ret = unix.S_IRWXO }
+func F0_os_2Fposix__S_5FIROTH__(ret *UInt)  { *ret = unix.S_IROTH }
+func F0_os_2Fposix__S_5FIWOTH__(ret *UInt)  { *ret = unix.S_IWOTH }
+func F0_os_2Fposix__S_5FIXOTH__(ret *UInt)  { *ret = unix.S_IXOTH }
 
-func F0_os_2Fposix__fstat_3A__(fd int, ret *StatResult) {
+func F0_os_2Fposix__fstat_3A__(fd Int, ret *StatResult) {
 	var stat unix.Stat_t
-	if err := unix.Fstat(fd, &stat); err != nil {
+	if err := unix.Fstat(int(fd), &stat); err != nil {
 		*ret = StatResult{
 			tag:      statResultErrnoTag,
-			errno_3A: int(err.(unix.Errno)),
+			errno_3A: Int(err.(unix.Errno)),
 		}
 	} else {
 		*ret = StatResult{
 			tag: statResultOKTag,
 			ok_3A: Stat{
-				mode: uint(stat.Mode),
+				mode: UInt(stat.Mode),
 				size: stat.Size,
 			},
 		}
 	}
 }
 
-func F0_os_2Fposix__unlink_3A__(path *[]byte, ret *int) {
+func F0_os_2Fposix__unlink_3A__(path *[]byte, ret *Int) {
 	cpath, ok := cstr(path)
 	if !ok {
-		*ret = -int(unix.EINVAL)
+		*ret = -Int(unix.EINVAL)
 	}
 retry:
 	_, _, e := unix.Syscall(unix.SYS_UNLINK, cpath, 0, 0)
 	if e == unix.EINTR {
 		goto retry
 	}
-	*ret = -int(e)
+	*ret = -Int(e)
 }
 
-func F0_os_2Fposix__fdOpenDir_3A__(fd int, ret *OpenDirResult) {
+func F0_os_2Fposix__fdOpenDir_3A__(fd Int, ret *OpenDirResult) {
 	var stat unix.Stat_t
-	if err := unix.Fstat(fd, &stat); err != nil {
+	if err := unix.Fstat(int(fd), &stat); err != nil {
 		*ret = OpenDirResult{
 			tag:      openDirResultErrnoTag,
-			errno_3A: int(err.(unix.Errno)),
+			errno_3A: Int(err.(unix.Errno)),
 		}
 		return
 	}
 	if stat.Mode&unix.S_IFMT != unix.S_IFDIR {
 		*ret = OpenDirResult{
 			tag:      openDirResultErrnoTag,
-			errno_3A: -int(unix.ENOTDIR),
+			errno_3A: -Int(unix.ENOTDIR),
 		}
 		return
 	}
 	*ret = OpenDirResult{
 		tag:   openDirResultOKTag,
-		ok_3A: &Dir{fd: int(fd), buf: make([]uint8, 0)},
+		ok_3A: &Dir{fd: Int(fd), buf: make([]uint8, 0)},
 	}
 }
 
@@ -191,13 +198,13 @@ func F0_os_2Fposix__readDir_3A__(dir *Dir, ret *ReadDirResult) {
 		dir.buf = make([]uint8, readDirBufSize)
 	}
 	if dir.p == dir.n {
-		n, err := unix.ReadDirent(dir.fd, dir.buf)
+		n, err := unix.ReadDirent(int(dir.fd), dir.buf)
 		switch {
 		case err != nil:
 			e := err.(unix.Errno)
 			*ret = ReadDirResult{
 				tag:      readDirResultErrnoTag,
-				errno_3A: int(e),
+				errno_3A: Int(e),
 			}
 			return
 		case n == 0:
@@ -208,51 +215,51 @@ func F0_os_2Fposix__readDir_3A__(dir *Dir, ret *ReadDirResult) {
 			return
 		default:
 			dir.p = 0
-			dir.n = int(n)
+			dir.n = Int(n)
 		}
 	}
 	names := make([]string, 0, 1)
 	n, _, names := unix.ParseDirent(dir.buf[dir.p:dir.n], 1, names)
-	dir.p += n
+	dir.p += Int(n)
 	*ret = ReadDirResult{
 		tag:   readDirResultOKTag,
 		ok_3A: []byte(names[0]),
 	}
 }
 
-func F0_os_2Fposix__closeDir_3A__(dir *Dir, ret *int) {
+func F0_os_2Fposix__closeDir_3A__(dir *Dir, ret *Int) {
 retry:
 	_, _, e := unix.Syscall(unix.SYS_CLOSE, uintptr(dir.fd), 0, 0)
 	if e == unix.EINTR {
 		goto retry
 	}
-	*ret = -int(e)
+	*ret = -Int(e)
 }
 
-func F0_os_2Fposix__mkdir_3Aperm_3A__(path *[]byte, perm int, ret *int) {
+func F0_os_2Fposix__mkdir_3Aperm_3A__(path *[]byte, perm Int, ret *Int) {
 	cpath, ok := cstr(path)
 	if !ok {
-		*ret = -int(unix.EINVAL)
+		*ret = -Int(unix.EINVAL)
 	}
 retry:
 	_, _, e := unix.Syscall(unix.SYS_MKDIR, cpath, uintptr(perm), 0)
 	if e == unix.EINTR {
 		goto retry
 	}
-	*ret = -int(e)
+	*ret = -Int(e)
 }
 
-func F0_os_2Fposix__rmdir_3A__(path *[]byte, ret *int) {
+func F0_os_2Fposix__rmdir_3A__(path *[]byte, ret *Int) {
 	cpath, ok := cstr(path)
 	if !ok {
-		*ret = -int(unix.EINVAL)
+		*ret = -Int(unix.EINVAL)
 	}
 retry:
 	_, _, e := unix.Syscall(unix.SYS_RMDIR, cpath, 0, 0)
 	if e == unix.EINTR {
 		goto retry
 	}
-	*ret = -int(e)
+	*ret = -Int(e)
 }
 
 func cstr(str *[]byte) (uintptr, bool) {
@@ -272,7 +279,7 @@ func F0_os_2Fposix__getTimeOfDay__(ret *GetTimeOfDayResult) {
 	if err != nil {
 		*ret = GetTimeOfDayResult{
 			tag:      getTimeOfDayResultErrnoTag,
-			errno_3A: int(err.(unix.Errno)),
+			errno_3A: Int(err.(unix.Errno)),
 		}
 		return
 	}
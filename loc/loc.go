@@ -38,6 +38,9 @@ type File struct {
 	Offs  int
 	Len   int
 	Lines []int
+	// Text is the text of the file.
+	// It is empty if the file was not added with Add.
+	Text string
 }
 
 // Len returns the total length of all files.
@@ -63,9 +66,33 @@ func (fs *Files) Add(path, text string) {
 		Offs:  offs,
 		Len:   len(text),
 		Lines: lines,
+		Text:  text,
 	})
 }
 
+// Line returns the text of a 1-based line of the file at a path,
+// without its trailing newline.
+// The second result is false if the line or its text is unknown.
+func (fs Files) Line(path string, n int) (string, bool) {
+	for _, f := range fs {
+		if f.Path != path || len(f.Text) != f.Len {
+			continue
+		}
+		if n < 1 || n > len(f.Lines)+1 {
+			return "", false
+		}
+		start, end := 0, f.Len
+		if n > 1 {
+			start = f.Lines[n-2] - f.Offs + 1
+		}
+		if n <= len(f.Lines) {
+			end = f.Lines[n-1] - f.Offs
+		}
+		return f.Text[start:end], true
+	}
+	return "", false
+}
+
 // Loc returns the Loc for a node in the module AST.
 func (fs Files) Loc(r Range) *Loc {
 	if fs == nil || r[0] < 0 || r[1] > fs.Len() {
@@ -4,6 +4,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -14,6 +15,8 @@ import (
 	"github.com/eaburns/pea/ast"
 	"github.com/eaburns/pea/basic"
 	"github.com/eaburns/pea/gengo"
+	"github.com/eaburns/pea/interp"
+	"github.com/eaburns/pea/loc"
 	"github.com/eaburns/pea/types"
 	"github.com/eaburns/peggy/peg"
 	"github.com/eaburns/pretty"
@@ -25,16 +28,25 @@ var (
 	printBasic = flag.Bool("basic", false, "print the basic representation")
 	printGo    = flag.Bool("go", false, "print go code")
 	runGo      = flag.Bool("rungo", false, "compiles to Go and runs")
+	runInterp  = flag.Bool("interp", false, "runs with the interpreter")
 	opt        = flag.Bool("opt", false, "optimize the basic representation")
+	verify     = flag.Bool("verify", false, "verify the basic representation after building and after each optimization pass")
+	inlineRpt  = flag.Bool("inline-report", false, "print which calls were inlined or rejected by -opt and why")
 	trace      = flag.Bool("trace", false, "enable tracing in the type checker")
 	modRoot    = flag.String("root", ".", "the module root directory")
+	jsonErrs   = flag.Bool("json", false, "print errors as a stream of JSON diagnostics")
+	readBasic  = flag.String("readbasic", "", "replace the basic representation with one read from the given file, in the format printed by -basic")
 )
 
+// locs are the locations of the source files,
+// used to print the source lines of errors.
+var locs = new(loc.Files)
+
 func main() {
 	flag.Parse()
 
 	pretty.Indent = "    "
-	parser := ast.NewParser("main")
+	parser := ast.NewParserWithLocs("main", locs)
 	if len(flag.Args()) == 0 {
 		if err := parser.Parse("", os.Stdin); err != nil {
 			die(err)
@@ -58,9 +70,7 @@ func main() {
 		Importer: &types.SourceImporter{Root: *modRoot},
 	})
 	if len(errs) > 0 {
-		for _, err := range errs {
-			fmt.Println(err)
-		}
+		printErrs(errs)
 		os.Exit(1)
 	}
 	if *printTypes {
@@ -72,8 +82,13 @@ func main() {
 	}
 
 	basicMod := basic.Build(typesMod)
-	if *opt {
-		basic.Optimize(basicMod)
+	if *readBasic != "" {
+		basicMod = parseBasic(*readBasic, basicMod)
+	}
+	basicMod.ReportInlines = *inlineRpt
+	optimize(basicMod)
+	if *inlineRpt {
+		printInlines(basicMod)
 	}
 	if *printBasic {
 		fmt.Println(basicMod.String())
@@ -85,6 +100,56 @@ func main() {
 	if *runGo {
 		run(basicMod)
 	}
+	if *runInterp {
+		if err := new(interp.Interp).Run([]*basic.Mod{basicMod}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// optimize optimizes and verifies a basic Mod as set by the -opt and -verify flags.
+func optimize(mod *basic.Mod) {
+	switch {
+	case *opt && *verify:
+		if err := basic.OptimizeVerify(mod); err != nil {
+			die(err)
+		}
+	case *opt:
+		basic.Optimize(mod)
+	case *verify:
+		if err := basic.Verify(mod); err != nil {
+			die(err)
+		}
+	}
+}
+
+// printInlines prints the inlining decisions of Optimize,
+// each prefixed by the location of its call.
+func printInlines(mod *basic.Mod) {
+	for _, in := range mod.Inlines {
+		if in.Call.Msg != nil && in.Call.Msg.AST != nil {
+			if l := locs.Loc(in.Call.Msg.AST.GetRange()); l != nil {
+				fmt.Printf("%s: ", l)
+			}
+		}
+		fmt.Println(in)
+	}
+}
+
+// parseBasic returns the basic Mod parsed from a file,
+// using the definitions of the Mod built from source.
+func parseBasic(path string, ref *basic.Mod) *basic.Mod {
+	f, err := os.Open(path)
+	if err != nil {
+		die(err)
+	}
+	defer f.Close()
+	mod, err := basic.Parse(f, ref)
+	if err != nil {
+		die(fmt.Errorf("%s:%s", path, err))
+	}
+	return mod
 }
 
 func writeGo(w io.Writer, mod *basic.Mod) {
@@ -127,11 +192,35 @@ func run(mod *basic.Mod) {
 	}
 }
 
+// printErrs prints errors with their source lines,
+// or their diagnostics as JSON if -json is set.
+func printErrs(errs []error) {
+	enc := json.NewEncoder(os.Stdout)
+	color := isTerminal(os.Stdout)
+	for _, err := range errs {
+		d := types.AsDiagnostic(err)
+		if *jsonErrs {
+			enc.Encode(d)
+		} else {
+			d.Render(os.Stdout, *locs, color)
+		}
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
 func die(err error) {
-	if pe, ok := err.(interface{ Tree() *peg.Fail }); ok {
+	if pe, ok := err.(interface{ Tree() *peg.Fail }); ok && !*jsonErrs {
 		peg.PrettyWrite(os.Stdout, pe.Tree())
 		fmt.Println("")
 	}
-	fmt.Println(err)
+	if es, ok := err.(interface{ Errors() []error }); ok {
+		printErrs(es.Errors())
+	} else {
+		printErrs([]error{err})
+	}
 	os.Exit(1)
 }
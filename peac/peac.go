@@ -4,18 +4,25 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"runtime/pprof"
-	"time"
+	"strings"
 
 	"github.com/eaburns/pea/ast"
 	"github.com/eaburns/pea/basic"
 	"github.com/eaburns/pea/gengo"
+	"github.com/eaburns/pea/interp"
+	"github.com/eaburns/pea/loc"
 	"github.com/eaburns/pea/mod"
 	"github.com/eaburns/pea/types"
 )
@@ -30,6 +37,15 @@ var (
 	cleanUp       = flag.Bool("cleanup", true, "remove temporary files")
 	cpuProfile    = flag.String("cpuprofile", "", "write cpu profile to file for the compiler")
 	profileBinary = flag.Bool("profile_binary", false, "whether the generated binary should emit profiler output")
+	jobs          = flag.Int("j", runtime.NumCPU(), "the number of modules to compile in parallel")
+	jsonErrs      = flag.Bool("json", false, "print errors as a stream of JSON diagnostics")
+	interpret     = flag.Bool("interp", false, "run the main module with the interpreter instead of building an executable")
+	verify        = flag.Bool("verify", false, "verify the basic representation after building and after each optimization pass")
+	intSize       = flag.Int("intsize", 64, "the bit size of Int and UInt: 8, 16, 32, or 64")
+	floatSize     = flag.Int("floatsize", 64, "the bit size of Float: 32 or 64")
+	checked       = flag.Bool("checked", false, "panic on integer overflow and division by zero")
+	debug         = flag.Bool("debug", false, "build for debugging: disable optimizations, use readable Go names, and keep the merged .go file")
+	inlineReport  = flag.Bool("inline-report", false, "print which calls were inlined or rejected and why in each module that is built")
 )
 
 func main() {
@@ -50,6 +66,8 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
+	checkFlags()
+
 	srcPath := flag.Args()[0]
 	root, err := mod.Load(srcPath, *modPath)
 	if err != nil {
@@ -58,89 +76,325 @@ func main() {
 	if err := root.LoadDeps(*modRoot); err != nil {
 		die("failed to load dependencies", err)
 	}
-	for _, m := range mod.TopologicalDeps([]*mod.Mod{root}) {
-		compile(m)
-	}
-	if *modPath == "main" || *test {
+	mods := mod.TopologicalDeps([]*mod.Mod{root})
+	checkGoSrcIntSize(mods)
+	compileAll(mods)
+	switch {
+	case *interpret:
+		run(mods)
+	case *modPath == "main" || *test:
 		link(root)
 	}
 }
 
-func compile(m *mod.Mod) {
-	objFile := objFile(m)
-	srcMod := lastModTime(m.SrcFiles)
-	// TODO: checking timestamps is insufficient to determine whether an object file is stale.
-	// We could delete a source file; it will have no timestamp change, but the object file is now stale.
-	// Instead, we should add the source file list to the object file and check that.
-	if !*force && srcMod.Before(modTime(objFile)) {
-		vprintf("ok %s\n", m.ModPath)
+// checkFlags dies if the flags have invalid values or are used in invalid combinations.
+func checkFlags() {
+	if *interpret && *test {
+		die("", errors.New("-interp cannot be used with -test"))
+	}
+	switch *intSize {
+	case 8, 16, 32, 64:
+	default:
+		die("", fmt.Errorf("bad -intsize %d: must be 8, 16, 32, or 64", *intSize))
+	}
+	if *floatSize != 32 && *floatSize != 64 {
+		die("", fmt.Errorf("bad -floatsize %d: must be 32 or 64", *floatSize))
+	}
+	if *interpret && (*intSize != 64 || *floatSize != 64) {
+		die("", errors.New("-interp cannot be used with -intsize or -floatsize other than 64"))
+	}
+	if *interpret && *checked {
+		die("", errors.New("-interp cannot be used with -checked"))
+	}
+}
+
+// checkGoSrcIntSize dies if any module has Go source files
+// and the -intsize is less than 32.
+// Go source files name the Go types of Int and UInt as Int and UInt,
+// but they may assign them constants, such as system call flags,
+// that overflow Int and UInt of fewer than 32 bits.
+func checkGoSrcIntSize(mods []*mod.Mod) {
+	if *intSize >= 32 {
 		return
 	}
-	vprintf("building %s\n", m.ModPath)
-	astMod := parse(m)
-	typesMod := check(astMod)
-	basicMod := basic.Build(typesMod)
-	basic.Optimize(basicMod)
-	writeObj(basicMod, objFile)
+	for _, m := range mods {
+		if len(m.GoSrcFiles) > 0 {
+			die("", fmt.Errorf("-intsize %d cannot be used with module %s: its Go source files require an -intsize of at least 32", *intSize, m.ModPath))
+		}
+	}
+}
+
+// compileAll compiles the modules using up to *jobs goroutines.
+// The modules must be in topological order.
+// A module is compiled only after all of its dependencies
+// have been compiled successfully.
+//
+// Verbose output of each module is printed in order,
+// so the output is the same regardless of the number of jobs.
+// If any module fails to compile, compileAll reports the error
+// of the first failed module and exits.
+func compileAll(mods []*mod.Mod) {
+	n := *jobs
+	if n < 1 {
+		n = 1
+	}
+	sem := make(chan struct{}, n)
+	results := make(map[*mod.Mod]*result, len(mods))
+	for _, m := range mods {
+		results[m] = &result{done: make(chan struct{})}
+	}
+	for _, m := range mods {
+		go func(m *mod.Mod) {
+			r := results[m]
+			defer close(r.done)
+			for _, d := range m.Deps {
+				if dr := results[d]; dr.wait() != nil {
+					r.err = errDepFailed
+					return
+				}
+			}
+			sem <- struct{}{}
+			r.err = compile(m, &r.out)
+			<-sem
+		}(m)
+	}
+	for _, m := range mods {
+		r := results[m]
+		err := r.wait()
+		os.Stdout.Write(r.out.Bytes())
+		if err != nil {
+			die("", err)
+		}
+	}
+}
+
+// errDepFailed is the error of a module that was not compiled,
+// because one of its dependencies failed to compile.
+// It is never reported, since the dependency is reported first.
+var errDepFailed = errors.New("dependency failed")
+
+// A result is the result of compiling a module.
+type result struct {
+	done chan struct{}
+	out  bytes.Buffer
+	err  error
+}
+
+func (r *result) wait() error {
+	<-r.done
+	return r.err
+}
+
+// compile compiles the module if it is stale,
+// writing verbose output to out.
+func compile(m *mod.Mod, out io.Writer) error {
+	objFile := objFile(m)
+	expFile := types.ExportFile(m)
+	stamp, err := objStamp(m)
+	if err != nil {
+		return err
+	}
+	if !*force && exists(expFile) && readObjStamp(objFile) == stamp {
+		vfprintf(out, "ok %s\n", m.ModPath)
+		return nil
+	}
+	vfprintf(out, "building %s\n", m.ModPath)
+	// The module is checked even if there are syntax errors
+	// in order to report type errors in the definitions that parsed.
+	astMod, errs := parse(m)
+	typesMod, checkErrs := check(astMod)
+	if errs = append(errs, checkErrs...); len(errs) > 0 {
+		return errList{errs: errs, locs: *astMod.Locs}
+	}
+	vfprintf(out, "writing %s\n", expFile)
+	if err := writeExport(typesMod, expFile); err != nil {
+		return err
+	}
+	basicMod, err := buildBasic(m, typesMod)
+	if err != nil {
+		return err
+	}
+	if *inlineReport {
+		writeInlines(out, basicMod, *astMod.Locs)
+	}
+	vfprintf(out, "writing %s\n", objFile)
+	return writeObj(basicMod, stamp, objFile)
 }
 
 func objFile(m *mod.Mod) string {
 	return filepath.Join(m.SrcDir, m.ModName+".peago")
 }
 
-func parse(m *mod.Mod) *ast.Mod {
+func parse(m *mod.Mod) (*ast.Mod, []error) {
 	p := ast.NewParser(m.ModPath)
+	var errs []error
 	for _, srcFile := range m.SrcFiles {
-		if err := p.ParseFile(srcFile); err != nil {
-			die("", err)
+		switch err := p.ParseFile(srcFile).(type) {
+		case nil:
+		case interface{ Errors() []error }:
+			errs = append(errs, err.Errors()...)
+		default:
+			errs = append(errs, err)
 		}
 	}
-	return p.Mod()
+	return p.Mod(), errs
 }
 
-func check(astMod *ast.Mod) *types.Mod {
+func check(astMod *ast.Mod) (*types.Mod, []error) {
 	typesMod, errs := types.Check(astMod, types.Config{
-		Importer: &types.SourceImporter{Root: *modRoot},
+		IntSize:   *intSize,
+		FloatSize: *floatSize,
+		Importer: &types.ExportImporter{
+			Root: *modRoot,
+			// Dependencies are always compiled before their dependants,
+			// so their export files are up-to-date.
+			Stale: func(*mod.Mod) bool { return false },
+		},
 	})
 	if len(errs) > 0 {
-		for _, err := range errs {
-			fmt.Fprintln(flag.CommandLine.Output(), err)
+		return nil, errs
+	}
+	return typesMod, nil
+}
+
+// buildBasic builds and optimizes the basic representation of a module.
+// If -verify is set, it is verified after building and after each optimization pass.
+func buildBasic(m *mod.Mod, typesMod *types.Mod) (*basic.Mod, error) {
+	basicMod := basic.Build(typesMod)
+	basicMod.Checked = *checked
+	basicMod.Debug = *debug
+	basicMod.ReportInlines = *inlineReport
+	if !*verify {
+		basic.Optimize(basicMod)
+		return basicMod, nil
+	}
+	if err := basic.OptimizeVerify(basicMod); err != nil {
+		return nil, fmt.Errorf("%s: failed to verify basic representation:\n%s", m.ModPath, err)
+	}
+	return basicMod, nil
+}
+
+// writeInlines writes the inlining decisions of Optimize,
+// each prefixed by the location of its call.
+func writeInlines(w io.Writer, basicMod *basic.Mod, locs loc.Files) {
+	for _, in := range basicMod.Inlines {
+		if in.Call.Msg != nil && in.Call.Msg.AST != nil {
+			if l := locs.Loc(in.Call.Msg.AST.GetRange()); l != nil {
+				fmt.Fprintf(w, "%s: ", l)
+			}
 		}
-		os.Exit(1)
+		fmt.Fprintln(w, in)
+	}
+}
+
+// errList is an error made of one or more errors, one per line.
+type errList struct {
+	errs []error
+	// locs are the locations of the source files
+	// to which the errors refer.
+	locs loc.Files
+}
+
+func (errs errList) Error() string {
+	var s strings.Builder
+	for i, err := range errs.errs {
+		if i > 0 {
+			s.WriteRune('\n')
+		}
+		s.WriteString(err.Error())
 	}
-	return typesMod
+	return s.String()
 }
 
-func writeObj(basicMod *basic.Mod, objFile string) {
-	vprintf("writing %s\n", objFile)
+func writeExport(typesMod *types.Mod, expFile string) error {
+	f, err := os.Create(expFile)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %s", err)
+	}
+	w := bufio.NewWriter(f)
+	if err := types.Write(w, typesMod); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write export file: %s", err)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to flush export file buffer: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close export file: %s", err)
+	}
+	return nil
+}
+
+func writeObj(basicMod *basic.Mod, stamp, objFile string) error {
 	f, err := os.Create(objFile)
 	if err != nil {
-		die("failed to create object file", err)
+		return fmt.Errorf("failed to create object file: %s", err)
 	}
 	w := bufio.NewWriter(f)
+	if err := writeObjStamp(w, stamp); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write object file: %s", err)
+	}
 	if err := gengo.WriteMod(w, basicMod); err != nil {
-		die("failed to write object file", err)
+		f.Close()
+		return fmt.Errorf("failed to write object file: %s", err)
 	}
 	if err := w.Flush(); err != nil {
-		die("failed to flush object file buffer", err)
+		f.Close()
+		return fmt.Errorf("failed to flush object file buffer: %s", err)
 	}
 	if err := f.Close(); err != nil {
-		die("failed to close object file", err)
+		return fmt.Errorf("failed to close object file: %s", err)
+	}
+	return nil
+}
+
+// run runs the modules with the interpreter.
+// The modules must be in topological order
+// and must have already been compiled,
+// so that their export files are up-to-date.
+func run(mods []*mod.Mod) {
+	var basicMods []*basic.Mod
+	for _, m := range mods {
+		astMod, errs := parse(m)
+		typesMod, checkErrs := check(astMod)
+		if errs = append(errs, checkErrs...); len(errs) > 0 {
+			die("", errList{errs: errs, locs: *astMod.Locs})
+		}
+		basicMod, err := buildBasic(m, typesMod)
+		if err != nil {
+			die("", err)
+		}
+		if *inlineReport {
+			writeInlines(os.Stdout, basicMod, *astMod.Locs)
+		}
+		basicMods = append(basicMods, basicMod)
+	}
+	vprintf("interpreting %s\n", *modPath)
+	if err := new(interp.Interp).Run(basicMods); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
 
 func link(m *mod.Mod) {
-	objFiles := objFiles(m)
 	binFile := binFile()
-	if !*force && lastModTime(objFiles).Before(modTime(binFile)) {
+	stamp := binStamp(m)
+	if !*force && readBinStamp(binFile) == stamp {
 		return
 	}
 
-	goFile := merge(objFiles)
+	goFile := merge(objFiles(m), goFiles(m))
 	objFile := binFile + ".o"
 
 	vprintf("compiling %s\n", objFile)
-	args := []string{"tool", "compile", "-o", objFile, goFile}
+	args := []string{"tool", "compile", "-o", objFile}
+	if *debug {
+		// Disable Go optimizations and inlining for the debugger.
+		args = append(args, "-N", "-l")
+	}
+	args = append(args, goFile)
 	args = append(args, goFiles(m)...)
 	cmd := exec.Command("go", args...)
 	cmd.Stdout = os.Stdout
@@ -150,7 +404,11 @@ func link(m *mod.Mod) {
 		die("failed to run go build", err)
 	}
 
-	if *cleanUp {
+	if *debug {
+		// The debugger shows the merged .go file
+		// for code without a line directive.
+		fmt.Fprintf(os.Stderr, "kept merged Go source %s\n", goFile)
+	} else if *cleanUp {
 		os.Remove(goFile)
 	}
 
@@ -166,6 +424,7 @@ func link(m *mod.Mod) {
 	if *cleanUp {
 		os.Remove(objFile)
 	}
+	writeBinStamp(binFile, stamp)
 }
 
 func objFiles(m *mod.Mod) []string {
@@ -225,8 +484,15 @@ func goFiles(m *mod.Mod) []string {
 	return goFiles
 }
 
-func merge(objFiles []string) string {
-	f, err := ioutil.TempFile(wd(), "*.go")
+func merge(objFiles, goFiles []string) string {
+	dir := wd()
+	if *debug {
+		// The merged file is kept, so it must not be left
+		// in a module directory, where it would be loaded
+		// as one of the module's Go source files.
+		dir = os.TempDir()
+	}
+	f, err := ioutil.TempFile(dir, "*.go")
 	if err != nil {
 		die("failed to make temp .go file", err)
 	}
@@ -240,17 +506,12 @@ func merge(objFiles []string) string {
 		merger.TestMod = *modPath
 	}
 	merger.Profile = *profileBinary
+	merger.File = goFile
 	for _, file := range objFiles {
-		f, err := os.Open(file)
-		if err != nil {
-			die("failed to open object file", err)
-		}
-		if err := merger.Add(bufio.NewReader(f)); err != nil {
-			die("failed to read peago", err)
-		}
-		if err := f.Close(); err != nil {
-			die("failed to close peago", err)
-		}
+		mergeFile(file, "peago", merger.Add)
+	}
+	for _, file := range goFiles {
+		mergeFile(file, "Go file", merger.AddGoSrc)
 	}
 	vprintf("merging %s\n", goFile)
 	if err := merger.Done(); err != nil {
@@ -265,25 +526,30 @@ func merge(objFiles []string) string {
 	return goFile
 }
 
-func lastModTime(files []string) time.Time {
-	var t time.Time
-	for _, file := range files {
-		if mt := modTime(file); mt.After(t) {
-			t = mt
-		}
+// mergeFile adds the file at a path to a merge with add.
+// The kind of the file is used in error messages.
+func mergeFile(path, kind string, add func(io.Reader) error) {
+	f, err := os.Open(path)
+	if err != nil {
+		die("failed to open "+kind, err)
+	}
+	if err := add(bufio.NewReader(f)); err != nil {
+		die("failed to read "+kind, err)
+	}
+	if err := f.Close(); err != nil {
+		die("failed to close "+kind, err)
 	}
-	return t
 }
 
-func modTime(file string) time.Time {
-	finfo, err := os.Stat(file)
+func exists(file string) bool {
+	_, err := os.Stat(file)
 	switch {
 	case os.IsNotExist(err):
-		return time.Time{}
+		return false
 	case err != nil:
-		die("failed to get mod time", err)
+		die("failed to stat file", err)
 	}
-	return finfo.ModTime()
+	return true
 }
 
 func wd() string {
@@ -295,8 +561,12 @@ func wd() string {
 }
 
 func vprintf(f string, vs ...interface{}) {
+	vfprintf(os.Stdout, f, vs...)
+}
+
+func vfprintf(w io.Writer, f string, vs ...interface{}) {
 	if *verbose {
-		fmt.Printf(f, vs...)
+		fmt.Fprintf(w, f, vs...)
 	}
 }
 
@@ -308,10 +578,49 @@ func usage() {
 }
 
 func die(s string, err error) {
+	out := flag.CommandLine.Output()
+	if *jsonErrs {
+		writeDiagnostics(out, s, err)
+		os.Exit(1)
+	}
+	if errs, ok := err.(errList); ok {
+		f, ok := out.(*os.File)
+		color := ok && isTerminal(f)
+		for _, err := range errs.errs {
+			d := types.AsDiagnostic(err)
+			d.Render(out, errs.locs, color)
+		}
+		os.Exit(1)
+	}
 	if s == "" {
-		fmt.Fprintln(flag.CommandLine.Output(), err)
+		fmt.Fprintln(out, err)
 	} else {
-		fmt.Fprintf(flag.CommandLine.Output(), "%s: %s\n", s, err)
+		fmt.Fprintf(out, "%s: %s\n", s, err)
 	}
 	os.Exit(1)
 }
+
+// writeDiagnostics writes the diagnostic of each error
+// as a stream of JSON objects, one per line.
+// If s is non-empty, it prefixes each diagnostic message.
+func writeDiagnostics(w io.Writer, s string, err error) {
+	errs := []error{err}
+	if el, ok := err.(errList); ok {
+		errs = el.errs
+	}
+	enc := json.NewEncoder(w)
+	for _, err := range errs {
+		d := types.AsDiagnostic(err)
+		if s != "" {
+			d.Msg = s + ": " + d.Msg
+		}
+		if err := enc.Encode(d); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to write diagnostic:", err)
+		}
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
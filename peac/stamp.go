@@ -0,0 +1,165 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/eaburns/pea/mod"
+	"github.com/eaburns/pea/types"
+)
+
+// stampSection is the name of the object file section holding its stamp.
+const stampSection = "#stamp"
+
+// A stamp describes the inputs used to build a file.
+// The file is up-to-date if the stamp of its current inputs
+// is the same as the stamp recorded when it was built.
+//
+// Stamps are text, with a line for each input.
+
+// objStamp returns the stamp of a module's object and export files.
+// It records the compiler version, the Int and Float sizes,
+// whether arithmetic is checked, whether it is built for debugging,
+// the path and hash of each source file,
+// and the module path and export file hash of each direct
+// or indirect dependency.
+func objStamp(m *mod.Mod) (string, error) {
+	var s strings.Builder
+	fmt.Fprintf(&s, "version %s\n", compilerVersion)
+	fmt.Fprintf(&s, "intsize %d\n", *intSize)
+	fmt.Fprintf(&s, "floatsize %d\n", *floatSize)
+	fmt.Fprintf(&s, "checked %v\n", *checked)
+	fmt.Fprintf(&s, "debug %v\n", *debug)
+	for _, srcFile := range m.SrcFiles {
+		h, err := hashFile(srcFile)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&s, "src %s %s\n", srcFile, h)
+	}
+	// Indirect dependencies are included,
+	// since the module may instantiate their generic definitions
+	// through those of its direct dependencies.
+	for _, d := range mod.TopologicalDeps([]*mod.Mod{m}) {
+		if d == m {
+			continue
+		}
+		h, err := hashFile(types.ExportFile(d))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&s, "dep %s %s\n", d.ModPath, h)
+	}
+	return s.String(), nil
+}
+
+// binStamp returns the stamp of the linked binary.
+// It records the compiler version, the test module if any,
+// whether profiling and debugging are enabled, and the path and hash
+// of each object and Go source file.
+func binStamp(m *mod.Mod) string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "version %s\n", compilerVersion)
+	if *test {
+		fmt.Fprintf(&s, "test %s\n", *modPath)
+	}
+	fmt.Fprintf(&s, "profile %v\n", *profileBinary)
+	fmt.Fprintf(&s, "debug %v\n", *debug)
+	for _, objFile := range objFiles(m) {
+		fmt.Fprintf(&s, "obj %s %s\n", objFile, mustHashFile(objFile))
+	}
+	for _, goFile := range goFiles(m) {
+		fmt.Fprintf(&s, "go %s %s\n", goFile, mustHashFile(goFile))
+	}
+	return s.String()
+}
+
+// readObjStamp returns the stamp of an object file
+// or the empty string if the file does not exist or has no stamp.
+func readObjStamp(objFile string) string {
+	f, err := os.Open(objFile)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var name string
+	var byteSize int64
+	if _, err := fmt.Fscanf(r, "%d %s\n", &byteSize, &name); err != nil || name != stampSection {
+		return ""
+	}
+	var s strings.Builder
+	if _, err := io.CopyN(&s, r, byteSize); err != nil {
+		return ""
+	}
+	return s.String()
+}
+
+// writeObjStamp writes the stamp as an object file section.
+func writeObjStamp(w io.Writer, stamp string) error {
+	_, err := fmt.Fprintf(w, "%d %s\n%s", len(stamp), stampSection, stamp)
+	return err
+}
+
+// binStampFile returns the path of the file holding a binary's stamp.
+func binStampFile(binFile string) string {
+	return binFile + ".peastamp"
+}
+
+// readBinStamp returns the stamp of a binary
+// or the empty string if the binary or its stamp file does not exist.
+func readBinStamp(binFile string) string {
+	if _, err := os.Stat(binFile); err != nil {
+		return ""
+	}
+	data, err := ioutil.ReadFile(binStampFile(binFile))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func writeBinStamp(binFile, stamp string) {
+	if err := ioutil.WriteFile(binStampFile(binFile), []byte(stamp), 0666); err != nil {
+		die("failed to write stamp file", err)
+	}
+}
+
+// compilerVersion is the hash of the running compiler executable.
+var compilerVersion = hashCompiler()
+
+func hashCompiler() string {
+	exe, err := os.Executable()
+	if err != nil {
+		die("failed to find the compiler executable", err)
+	}
+	return mustHashFile(exe)
+}
+
+func mustHashFile(file string) string {
+	h, err := hashFile(file)
+	if err != nil {
+		die("", err)
+	}
+	return h
+}
+
+func hashFile(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file for hashing: %s", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash file: %s", err)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
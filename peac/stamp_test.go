@@ -0,0 +1,78 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eaburns/pea/mod"
+)
+
+// Tests that a module is rebuilt when an indirect dependency changes,
+// since the module may instantiate the dependency's generic definitions.
+func TestCompileIndirectDepChange(t *testing.T) {
+	root, err := ioutil.TempDir("", "peac_test_")
+	if err != nil {
+		t.Fatalf("failed to make temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	*modRoot = root
+
+	writeSrc(t, root, "a/a.pea", `Func T [tag: _ T ^String | ^"A1"]`)
+	writeSrc(t, root, "b/b.pea", `
+		import "a"
+		Func T [wrap: t T ^String | ^#a tag: t]
+	`)
+	writeSrc(t, root, "main/main.pea", `
+		import "b"
+		func [main | #b wrap: 1]
+	`)
+	compileMain(t, root)
+	if obj := readObj(t, root); !strings.Contains(obj, `"A1"`) {
+		t.Fatalf("main object file does not contain A1:\n%s", obj)
+	}
+
+	writeSrc(t, root, "a/a.pea", `Func T [tag: _ T ^String | ^"A2"]`)
+	compileMain(t, root)
+	if obj := readObj(t, root); !strings.Contains(obj, `"A2"`) {
+		t.Errorf("main object file does not contain A2:\n%s", obj)
+	}
+}
+
+func writeSrc(t *testing.T, root, path, src string) {
+	path = filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatalf("failed to make directory: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(src), 0666); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+}
+
+func compileMain(t *testing.T, root string) {
+	m, err := mod.Load(filepath.Join(root, "main"), "main")
+	if err != nil {
+		t.Fatalf("failed to load module: %v", err)
+	}
+	if err := m.LoadDeps(root); err != nil {
+		t.Fatalf("failed to load dependencies: %v", err)
+	}
+	for _, d := range mod.TopologicalDeps([]*mod.Mod{m}) {
+		var out strings.Builder
+		if err := compile(d, &out); err != nil {
+			t.Fatalf("failed to compile %s: %v", d.ModPath, err)
+		}
+	}
+}
+
+func readObj(t *testing.T, root string) string {
+	data, err := ioutil.ReadFile(filepath.Join(root, "main", "main.peago"))
+	if err != nil {
+		t.Fatalf("failed to read object file: %v", err)
+	}
+	return string(data)
+}
@@ -0,0 +1,107 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+// The peademangle command demangles the Go names
+// of the definitions generated by peac.
+//
+// With no arguments, it copies the standard input to the standard output,
+// replacing each mangled name with its demangled form, like c++filt.
+// This makes Go panics, pprof output, and go tool compile errors
+// for Pea programs readable.
+// With arguments, it writes the demangled form of each argument
+// on a separate line of the standard output.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/eaburns/pea/gengo"
+)
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	out := bufio.NewWriter(os.Stdout)
+	if flag.NArg() > 0 {
+		for _, name := range flag.Args() {
+			fmt.Fprintln(out, demangle(name))
+		}
+	} else if err := filter(out, os.Stdin); err != nil {
+		die(err)
+	}
+	if err := out.Flush(); err != nil {
+		die(err)
+	}
+}
+
+// filter copies r to w, demangling each identifier.
+// The output is flushed after each line,
+// so that filter can follow a running program.
+func filter(w *bufio.Writer, r io.Reader) error {
+	in := bufio.NewReader(r)
+	for {
+		line, err := in.ReadString('\n')
+		if _, err := w.WriteString(demangleLine(line)); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		switch {
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+	}
+}
+
+func demangleLine(line string) string {
+	var s strings.Builder
+	for len(line) > 0 {
+		i := strings.IndexFunc(line, identRune)
+		if i < 0 {
+			s.WriteString(line)
+			break
+		}
+		s.WriteString(line[:i])
+		line = line[i:]
+		j := strings.IndexFunc(line, func(r rune) bool { return !identRune(r) })
+		if j < 0 {
+			j = len(line)
+		}
+		s.WriteString(demangle(line[:j]))
+		line = line[j:]
+	}
+	return s.String()
+}
+
+func identRune(r rune) bool {
+	return r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9'
+}
+
+// demangle returns the demangled form of name
+// or name itself if it is not a mangled name.
+func demangle(name string) string {
+	sym, err := gengo.Demangle(name)
+	if err != nil {
+		return name
+	}
+	return sym.String()
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	fmt.Fprintf(out, "%s [name ...]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func die(err error) {
+	fmt.Fprintln(flag.CommandLine.Output(), err)
+	os.Exit(1)
+}
@@ -0,0 +1,180 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+// The peafmt command formats pea source files.
+//
+// With no path arguments, it formats the standard input.
+// With a directory argument, it formats all .pea files
+// in the directory and its subdirectories.
+// By default, the formatted source is written to the standard output.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/eaburns/pea/ast"
+)
+
+var (
+	list  = flag.Bool("l", false, "list files whose formatting differs from peafmt's")
+	write = flag.Bool("w", false, "write the result to the source file instead of the standard output")
+	diff  = flag.Bool("d", false, "display diffs instead of rewriting files")
+)
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		if *write {
+			die(errors.New("cannot use -w with the standard input"))
+		}
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			die(err)
+		}
+		if err := format("<standard input>", src); err != nil {
+			die(err)
+		}
+		return
+	}
+	var failed bool
+	for _, path := range flag.Args() {
+		if err := formatPath(path); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// formatPath formats the file at path,
+// or all .pea files beneath path if it is a directory.
+// Errors formatting files beneath a directory are reported,
+// but they do not stop the walk.
+func formatPath(path string) error {
+	finfo, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !finfo.IsDir() {
+		return formatFile(path)
+	}
+	var failed bool
+	err = filepath.Walk(path, func(path string, finfo os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case finfo.IsDir() || !strings.HasSuffix(path, ".pea"):
+			return nil
+		}
+		if err := formatFile(path); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+		return nil
+	})
+	if err == nil && failed {
+		err = errors.New("failed to format " + path)
+	}
+	return err
+}
+
+func formatFile(path string) error {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return format(path, src)
+}
+
+func format(path string, src []byte) error {
+	res, err := ast.Format(path, src)
+	if err != nil {
+		return err
+	}
+	if !*list && !*write && !*diff {
+		_, err := os.Stdout.Write(res)
+		return err
+	}
+	if bytes.Equal(src, res) {
+		return nil
+	}
+	if *list {
+		fmt.Println(path)
+	}
+	if *write {
+		if err := ioutil.WriteFile(path, res, 0666); err != nil {
+			return err
+		}
+	}
+	if *diff {
+		d, err := diffSrc(path, src, res)
+		if err != nil {
+			return fmt.Errorf("failed to diff %s: %s", path, err)
+		}
+		os.Stdout.Write(d)
+	}
+	return nil
+}
+
+// diffSrc returns the unified diff of the source and formatted source
+// as computed by the diff command.
+func diffSrc(path string, src, res []byte) ([]byte, error) {
+	srcFile, err := writeTemp(src)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(srcFile)
+	resFile, err := writeTemp(res)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(resFile)
+	cmd := exec.Command("diff", "-u",
+		"--label", path+".orig", "--label", path,
+		srcFile, resFile)
+	d, err := cmd.Output()
+	if len(d) > 0 {
+		// diff exits with status 1 if the files differ.
+		return d, nil
+	}
+	return d, err
+}
+
+func writeTemp(data []byte) (string, error) {
+	f, err := ioutil.TempFile("", "peafmt")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	fmt.Fprintf(out, "%s [flags] [path ...]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func die(err error) {
+	fmt.Fprintln(flag.CommandLine.Output(), err)
+	os.Exit(1)
+}
@@ -0,0 +1,411 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/eaburns/pea/ast"
+	"github.com/eaburns/pea/loc"
+	"github.com/eaburns/pea/mod"
+	"github.com/eaburns/pea/types"
+)
+
+// A modCheck is the result of parsing and type-checking a module.
+type modCheck struct {
+	// paths are the source files of the module.
+	paths []string
+	// texts are the file texts by path.
+	// It holds the texts of the module source files,
+	// and of other files that were read for their locations.
+	texts map[string]string
+	locs  *loc.Files
+	// mod is the type-checked module or nil if there were errors.
+	mod *types.Mod
+	// imports are the definitions of imported modules by module path.
+	imports map[string][]types.Def
+	// diags are the diagnostics of each module source file.
+	diags map[string][]Diagnostic
+	// nodes are the type-checked nodes
+	// with source locations in the module.
+	nodes []node
+}
+
+// A node is a type-checked node and its source range.
+// The node is one of a types.Expr, a *types.Msg, or a *types.Var.
+type node struct {
+	rng  loc.Range
+	node interface{}
+}
+
+// checkMod parses and type-checks the module in a directory.
+// The module path is the directory path relative to root.
+// Imported modules are checked from their source files on disk,
+// but the module's own source files are taken from docs, if present.
+func checkMod(root, dir string, docs map[string]string) *modCheck {
+	c := &modCheck{
+		texts:   make(map[string]string),
+		locs:    new(loc.Files),
+		imports: make(map[string][]types.Def),
+		diags:   make(map[string][]Diagnostic),
+	}
+	modPath := modulePath(root, dir)
+	c.paths = srcFiles(dir, modPath, docs)
+	p := ast.NewParserWithLocs(modPath, c.locs)
+	for _, path := range c.paths {
+		c.diags[path] = []Diagnostic{}
+		text, ok := docs[path]
+		if !ok {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				c.addError(path, err)
+				continue
+			}
+			text = string(data)
+		}
+		c.texts[path] = text
+		switch err := p.Parse(path, strings.NewReader(text)).(type) {
+		case nil:
+		case interface{ Errors() []error }:
+			for _, err := range err.Errors() {
+				c.addError(path, err)
+			}
+		default:
+			c.addError(path, err)
+		}
+	}
+	if len(c.paths) == 0 {
+		return c
+	}
+	// The module is checked even if there were syntax errors
+	// in order to report type errors in the definitions that parsed.
+	cfg := types.Config{
+		Importer: &recordImporter{
+			Importer: &types.SourceImporter{Root: root},
+			defs:     c.imports,
+		},
+	}
+	m, errs := types.Check(p.Mod(), cfg)
+	for _, err := range errs {
+		c.addError(c.paths[0], err)
+	}
+	if m != nil {
+		c.mod = m
+		walkDefs(c, m.Defs)
+	}
+	return c
+}
+
+// modulePath returns the module path of a directory relative to root.
+// If the directory is not beneath root, the module path is its base name.
+func modulePath(root, dir string) string {
+	if root != "" {
+		if rel, err := filepath.Rel(root, dir); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.Base(dir)
+}
+
+// srcFiles returns the sorted source files of the module in dir.
+// These are the module's source files on disk
+// and any .pea documents in dir that are not yet on disk.
+func srcFiles(dir, modPath string, docs map[string]string) []string {
+	seen := make(map[string]bool)
+	var paths []string
+	if m, err := mod.Load(dir, modPath); err == nil {
+		for _, f := range m.SrcFiles {
+			path := filepath.Join(dir, filepath.Base(f))
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	for path := range docs {
+		if !seen[path] && filepath.Dir(path) == dir && strings.HasSuffix(path, ".pea") {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// recordImporter records the definitions of each imported module.
+type recordImporter struct {
+	types.Importer
+	defs map[string][]types.Def
+}
+
+func (ir *recordImporter) Import(cfg types.Config, locs *loc.Files, path string) ([]types.Def, error) {
+	defs, err := ir.Importer.Import(cfg, locs, path)
+	if err == nil {
+		ir.defs[path] = defs
+	}
+	return defs, err
+}
+
+// addError adds the diagnostic for an error.
+// If the error does not have a location in a module source file,
+// it is reported at the beginning of the file at path.
+func (c *modCheck) addError(path string, err error) {
+	d := types.AsDiagnostic(err)
+	msg := err.Error()
+	var rng Range
+	if text, ok := c.texts[d.Loc.Path]; ok {
+		path = d.Loc.Path
+		start := lineColOffset(text, d.Loc.Line[0], d.Loc.Col[0])
+		end := lineColOffset(text, d.Loc.Line[1], d.Loc.Col[1])
+		rng = Range{Start: position(text, start), End: position(text, end)}
+		msg = strings.TrimPrefix(d.Error(), d.Loc.String()+": ")
+	}
+	c.diags[path] = append(c.diags[path], Diagnostic{
+		Range:    rng,
+		Severity: errorSeverity,
+		Source:   "pea",
+		Message:  msg,
+	})
+}
+
+// lineColOffset returns the byte offset into text
+// of a 1-based line and 1-based byte column.
+func lineColOffset(text string, line, col int) int {
+	off := 0
+	for ; line > 1; line-- {
+		i := strings.IndexByte(text[off:], '\n')
+		if i < 0 {
+			return len(text)
+		}
+		off += i + 1
+	}
+	if off += col - 1; off > len(text) {
+		off = len(text)
+	}
+	return off
+}
+
+// position returns the Position of a byte offset into text.
+func position(text string, off int) Position {
+	if off > len(text) {
+		off = len(text)
+	}
+	var p Position
+	lineStart := strings.LastIndexByte(text[:off], '\n') + 1
+	p.Line = strings.Count(text[:lineStart], "\n")
+	p.Character = len(utf16.Encode([]rune(text[lineStart:off])))
+	return p
+}
+
+// offset returns the byte offset into text of a Position.
+func offset(text string, p Position) int {
+	off := 0
+	for line := p.Line; line > 0; line-- {
+		i := strings.IndexByte(text[off:], '\n')
+		if i < 0 {
+			return len(text)
+		}
+		off += i + 1
+	}
+	for n := 0; n < p.Character && off < len(text) && text[off] != '\n'; {
+		r, w := utf8.DecodeRuneInString(text[off:])
+		off += w
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return off
+}
+
+// offset returns the offset into c.locs of a byte offset into a file,
+// or -1 if the file is not in c.locs.
+func (c *modCheck) offset(path string, off int) int {
+	for _, f := range *c.locs {
+		if f.Path == path {
+			return f.Offs + off
+		}
+	}
+	return -1
+}
+
+// location returns the Location of a range of c.locs.
+func (c *modCheck) location(rng loc.Range) (Location, bool) {
+	for _, f := range *c.locs {
+		if rng[0] < f.Offs || rng[1] > f.Offs+f.Len {
+			continue
+		}
+		text, ok := c.texts[f.Path]
+		if !ok {
+			data, err := ioutil.ReadFile(f.Path)
+			if err != nil {
+				return Location{}, false
+			}
+			text = string(data)
+			c.texts[f.Path] = text
+		}
+		return Location{
+			URI: pathURI(f.Path),
+			Range: Range{
+				Start: position(text, rng[0]-f.Offs),
+				End:   position(text, rng[1]-f.Offs),
+			},
+		}, true
+	}
+	return Location{}, false
+}
+
+// find returns the inner-most node containing an offset, or nil.
+func (c *modCheck) find(off int) interface{} {
+	var found *node
+	for i := range c.nodes {
+		n := &c.nodes[i]
+		if n.rng[0] > off || n.rng[1] < off {
+			continue
+		}
+		if found == nil || n.rng[1]-n.rng[0] <= found.rng[1]-found.rng[0] {
+			found = n
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	return found.node
+}
+
+// findEnd returns the inner-most expression ending at an offset, or nil.
+func (c *modCheck) findEnd(off int) types.Expr {
+	var found *node
+	for i := range c.nodes {
+		n := &c.nodes[i]
+		if _, ok := n.node.(types.Expr); !ok || n.rng[1] != off {
+			continue
+		}
+		if found == nil || n.rng[1]-n.rng[0] <= found.rng[1]-found.rng[0] {
+			found = n
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	return found.node.(types.Expr)
+}
+
+// methods returns the methods with a receiver of the given type
+// that are accessible without a module tag from the file at path.
+// Methods defined on built-in types by the compiler are not included.
+func (c *modCheck) methods(path string, typ *types.Type) []*types.Fun {
+	if typ.BuiltIn == types.RefType {
+		typ = typ.Args[0].Type
+	}
+	var funs []*types.Fun
+	seen := make(map[string]bool)
+	add := func(defs []types.Def, priv bool) {
+		for _, def := range defs {
+			fun, ok := def.(*types.Fun)
+			if !ok || fun.Recv == nil || fun.Recv.Type == nil ||
+				fun.Recv.Type.Def != typ.Def || fun.Priv && !priv ||
+				seen[fun.Sig.Sel] {
+				continue
+			}
+			seen[fun.Sig.Sel] = true
+			funs = append(funs, fun)
+		}
+	}
+	add(c.mod.Defs, true)
+	for _, file := range c.mod.AST.Files {
+		if file.Path != path {
+			continue
+		}
+		for _, imp := range file.Imports {
+			if imp.All {
+				add(c.imports[imp.Path[1:len(imp.Path)-1]], false) // trim "
+			}
+		}
+	}
+	sort.Slice(funs, func(i, j int) bool { return funs[i].Sig.Sel < funs[j].Sig.Sel })
+	return funs
+}
+
+// walkDefs adds the nodes of the definitions to c.nodes.
+// Only nodes from the original source are added;
+// instances and built-in definitions are skipped.
+func walkDefs(c *modCheck, defs []types.Def) {
+	for _, def := range defs {
+		switch def := def.(type) {
+		case *types.Val:
+			walkStmts(c, def.Init)
+		case *types.Fun:
+			if def.Def != def || def.BuiltIn != 0 {
+				continue
+			}
+			for i := range def.Sig.Parms {
+				addNode(c, def.Sig.Parms[i].AST, &def.Sig.Parms[i])
+			}
+			walkStmts(c, def.Stmts)
+		}
+	}
+}
+
+func walkStmts(c *modCheck, stmts []types.Stmt) {
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *types.Ret:
+			walkExpr(c, stmt.Expr)
+		case *types.Assign:
+			addNode(c, stmt.Var.AST, stmt.Var)
+			walkExpr(c, stmt.Expr)
+		case types.Expr:
+			walkExpr(c, stmt)
+		}
+	}
+}
+
+func walkExpr(c *modCheck, expr types.Expr) {
+	switch expr := expr.(type) {
+	case *types.Call:
+		addNode(c, expr.AST, expr)
+		if expr.Recv != nil {
+			walkExpr(c, expr.Recv)
+		}
+		for i := range expr.Msgs {
+			msg := &expr.Msgs[i]
+			addNode(c, msg.AST, msg)
+			for _, arg := range msg.Args {
+				walkExpr(c, arg)
+			}
+		}
+	case *types.Convert:
+		walkExpr(c, expr.Expr)
+	case *types.Ctor:
+		addNode(c, expr.AST, expr)
+		for _, arg := range expr.Args {
+			walkExpr(c, arg)
+		}
+	case *types.Block:
+		addNode(c, expr.AST, expr)
+		for i := range expr.Parms {
+			addNode(c, expr.Parms[i].AST, &expr.Parms[i])
+		}
+		walkStmts(c, expr.Stmts)
+	case *types.Ident:
+		addNode(c, expr.AST, expr)
+	case *types.Int:
+		addNode(c, expr.AST, expr)
+	case *types.Float:
+		addNode(c, expr.AST, expr)
+	case *types.String:
+		addNode(c, expr.AST, expr)
+	}
+}
+
+// addNode adds a node to c.nodes
+// if its AST node is non-nil and has a source location.
+func addNode(c *modCheck, astNode ast.Node, n interface{}) {
+	if astNode == nil || reflect.ValueOf(astNode).IsNil() {
+		return
+	}
+	if rng := astNode.GetRange(); rng[0] >= 0 {
+		c.nodes = append(c.nodes, node{rng: rng, node: n})
+	}
+}
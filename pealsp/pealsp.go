@@ -0,0 +1,400 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+// The pealsp command is a Language Server Protocol server for pea.
+// It communicates with the editor over the standard input and output.
+//
+// The server publishes parse and type errors as diagnostics,
+// shows the type of an expression or the signature of a called method on hover,
+// jumps from a message send to the definition of its method
+// and from an identifier to the definition of its variable,
+// and completes the selectors of methods on the type of a receiver.
+//
+// A module is the directory of its source files,
+// and its module path is the directory relative to the root directory.
+// Open documents are checked using their unsaved text,
+// but imported modules are always read from disk.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/eaburns/pea/ast"
+	"github.com/eaburns/pea/types"
+)
+
+var root = flag.String("root", "", "the module root directory (default is the editor's workspace root)")
+
+func main() {
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+		fmt.Fprintf(out, "%s [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	s := newServer(*root, os.Stdout)
+	os.Exit(s.serve(bufio.NewReader(os.Stdin)))
+}
+
+type server struct {
+	root string
+	out  io.Writer
+	// docs are the texts of the open documents by file path.
+	docs     map[string]string
+	shutdown bool
+}
+
+func newServer(root string, out io.Writer) *server {
+	return &server{root: root, out: out, docs: make(map[string]string)}
+}
+
+// serve handles messages until the exit notification
+// or the end of the input, and returns the exit status.
+func (s *server) serve(r *bufio.Reader) int {
+	for {
+		data, err := readMessage(r)
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintln(os.Stderr, "failed to read message:", err)
+			}
+			return 1
+		}
+		var msg message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.replyError(nil, &rpcError{Code: parseErrorCode, Message: err.Error()})
+			continue
+		}
+		if msg.Method == "exit" {
+			if s.shutdown {
+				return 0
+			}
+			return 1
+		}
+		s.handle(&msg)
+	}
+}
+
+// handle handles a request or notification.
+// Requests are replied to; notifications are not.
+func (s *server) handle(msg *message) {
+	result, err := s.call(msg)
+	switch {
+	case msg.ID == nil && err != nil:
+		fmt.Fprintf(os.Stderr, "%s: %s\n", msg.Method, err)
+	case msg.ID == nil:
+		break
+	case err != nil:
+		rpcErr, ok := err.(*rpcError)
+		if !ok {
+			rpcErr = &rpcError{Code: internalErrorCode, Message: err.Error()}
+		}
+		s.replyError(msg.ID, rpcErr)
+	default:
+		s.send(response{JSONRPC: "2.0", ID: msg.ID, Result: result})
+	}
+}
+
+// call calls the method of a message and returns its result.
+// Unknown methods are an error, unless the message is a notification.
+func (s *server) call(msg *message) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		return s.initialize(msg.Params)
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		return nil, s.didOpen(msg.Params)
+	case "textDocument/didChange":
+		return nil, s.didChange(msg.Params)
+	case "textDocument/didSave":
+		return nil, s.didSave(msg.Params)
+	case "textDocument/didClose":
+		return nil, s.didClose(msg.Params)
+	case "textDocument/hover":
+		return s.hover(msg.Params)
+	case "textDocument/definition":
+		return s.definition(msg.Params)
+	case "textDocument/completion":
+		return s.completion(msg.Params)
+	default:
+		if msg.ID == nil {
+			return nil, nil
+		}
+		return nil, &rpcError{Code: methodNotFoundCode, Message: "method not found: " + msg.Method}
+	}
+}
+
+func (s *server) replyError(id *json.RawMessage, err *rpcError) {
+	s.send(errorResponse{JSONRPC: "2.0", ID: id, Error: err})
+}
+
+func (s *server) notify(method string, params interface{}) {
+	s.send(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *server) send(v interface{}) {
+	if err := writeMessage(s.out, v); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to write message:", err)
+	}
+}
+
+func unmarshalParams(data json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return &rpcError{Code: invalidParamsCode, Message: err.Error()}
+	}
+	return nil
+}
+
+func (s *server) initialize(data json.RawMessage) (interface{}, error) {
+	var params initializeParams
+	if err := unmarshalParams(data, &params); err != nil {
+		return nil, err
+	}
+	if s.root == "" && params.RootURI != "" {
+		path, err := uriPath(params.RootURI)
+		if err != nil {
+			return nil, &rpcError{Code: invalidParamsCode, Message: err.Error()}
+		}
+		s.root = path
+	}
+	return initializeResult{
+		Capabilities: serverCapabilities{
+			TextDocumentSync:   fullSync,
+			HoverProvider:      true,
+			DefinitionProvider: true,
+			CompletionProvider: completionOptions{TriggerCharacters: []string{" "}},
+		},
+	}, nil
+}
+
+func (s *server) didOpen(data json.RawMessage) error {
+	var params didOpenParams
+	if err := unmarshalParams(data, &params); err != nil {
+		return err
+	}
+	path, err := uriPath(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	s.docs[path] = params.TextDocument.Text
+	s.publishDiagnostics(path)
+	return nil
+}
+
+func (s *server) didChange(data json.RawMessage) error {
+	var params didChangeParams
+	if err := unmarshalParams(data, &params); err != nil {
+		return err
+	}
+	path, err := uriPath(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	for _, ch := range params.ContentChanges {
+		if ch.Range != nil {
+			return &rpcError{Code: invalidParamsCode, Message: "incremental changes are not supported"}
+		}
+		s.docs[path] = ch.Text
+	}
+	s.publishDiagnostics(path)
+	return nil
+}
+
+func (s *server) didSave(data json.RawMessage) error {
+	var params didSaveParams
+	if err := unmarshalParams(data, &params); err != nil {
+		return err
+	}
+	path, err := uriPath(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	s.publishDiagnostics(path)
+	return nil
+}
+
+func (s *server) didClose(data json.RawMessage) error {
+	var params didCloseParams
+	if err := unmarshalParams(data, &params); err != nil {
+		return err
+	}
+	path, err := uriPath(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	delete(s.docs, path)
+	s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         params.TextDocument.URI,
+		Diagnostics: []Diagnostic{},
+	})
+	return nil
+}
+
+// publishDiagnostics checks the module of the file at path
+// and publishes the diagnostics of each of its source files.
+func (s *server) publishDiagnostics(path string) {
+	c := checkMod(s.root, filepath.Dir(path), s.docs)
+	for _, p := range c.paths {
+		s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI:         pathURI(p),
+			Diagnostics: c.diags[p],
+		})
+	}
+}
+
+// check checks the module of the document at a position,
+// and returns the check and the offset of the position into its locs.
+// If the module has errors, the returned check is nil.
+func (s *server) check(params positionParams) (*modCheck, int, error) {
+	path, err := uriPath(params.TextDocument.URI)
+	if err != nil {
+		return nil, 0, err
+	}
+	c := checkMod(s.root, filepath.Dir(path), s.docs)
+	if c.mod == nil {
+		return nil, 0, nil
+	}
+	return c, c.offset(path, offset(c.texts[path], params.Position)), nil
+}
+
+func (s *server) hover(data json.RawMessage) (interface{}, error) {
+	var params positionParams
+	if err := unmarshalParams(data, &params); err != nil {
+		return nil, err
+	}
+	c, off, err := s.check(params)
+	if c == nil || err != nil {
+		return nil, err
+	}
+	var str string
+	switch n := c.find(off).(type) {
+	case *types.Msg:
+		if n.Fun == nil {
+			return nil, nil
+		}
+		str = n.Fun.String()
+	case *types.Var:
+		if n.Type() == nil {
+			return nil, nil
+		}
+		str = n.Name + " " + n.Type().String()
+	case *types.Ident:
+		if n.Type() == nil {
+			return nil, nil
+		}
+		str = n.Text + " " + n.Type().String()
+	case types.Expr:
+		if n.Type() == nil {
+			return nil, nil
+		}
+		str = n.Type().String()
+	default:
+		return nil, nil
+	}
+	return hover{
+		Contents: markupContent{
+			Kind:  "markdown",
+			Value: "```pea\n" + str + "\n```",
+		},
+	}, nil
+}
+
+func (s *server) definition(data json.RawMessage) (interface{}, error) {
+	var params positionParams
+	if err := unmarshalParams(data, &params); err != nil {
+		return nil, err
+	}
+	c, off, err := s.check(params)
+	if c == nil || err != nil {
+		return nil, err
+	}
+	var def ast.Node
+	switch n := c.find(off).(type) {
+	case *types.Msg:
+		if n.Fun != nil {
+			def = n.Fun.Def.AST
+		}
+	case *types.Ident:
+		if n.Var != nil {
+			def = n.Var.AST
+		}
+	case *types.Var:
+		def = n.AST
+	}
+	if def == nil || reflect.ValueOf(def).IsNil() {
+		return nil, nil
+	}
+	l, ok := c.location(def.GetRange())
+	if !ok {
+		return nil, nil
+	}
+	return []Location{l}, nil
+}
+
+// completion returns the methods on the type of the expression before the position
+// whose selectors begin with the partial identifier ending at the position.
+func (s *server) completion(data json.RawMessage) (interface{}, error) {
+	var params positionParams
+	if err := unmarshalParams(data, &params); err != nil {
+		return nil, err
+	}
+	path, err := uriPath(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	text, ok := s.docs[path]
+	if !ok {
+		return []completionItem{}, nil
+	}
+
+	// The partial selector is removed before checking,
+	// since it is likely not a valid method.
+	end := offset(text, params.Position)
+	start := end
+	for start > 0 {
+		r, w := utf8.DecodeLastRuneInString(text[:start])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		start -= w
+	}
+	prefix := text[start:end]
+	docs := make(map[string]string, len(s.docs))
+	for p, t := range s.docs {
+		docs[p] = t
+	}
+	docs[path] = text[:start] + text[end:]
+	c := checkMod(s.root, filepath.Dir(path), docs)
+	if c.mod == nil {
+		return []completionItem{}, nil
+	}
+
+	recvEnd := len(strings.TrimRightFunc(text[:start], unicode.IsSpace))
+	recv := c.findEnd(c.offset(path, recvEnd))
+	if recv == nil || recv.Type() == nil {
+		return []completionItem{}, nil
+	}
+	items := []completionItem{}
+	for _, fun := range c.methods(path, recv.Type()) {
+		if !strings.HasPrefix(fun.Sig.Sel, prefix) {
+			continue
+		}
+		items = append(items, completionItem{
+			Label:  fun.Sig.Sel,
+			Kind:   methodCompletion,
+			Detail: fun.String(),
+		})
+	}
+	return items, nil
+}
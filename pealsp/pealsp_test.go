@@ -0,0 +1,248 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testB = `Type Point {x: Int y: Int}
+
+Meth Point [sum ^Int | ^x + y]
+Meth Point [scale: k Int ^Point | ^{x: x * k y: y * k}]
+meth Point [secret]
+`
+
+const testA = `Import "b"
+
+func [origin ^Point | ^{x: 1 y: 2}]
+
+test [sum |
+	p := origin scale: 2.
+	p sum.
+]
+`
+
+func TestServer(t *testing.T) {
+	root, err := ioutil.TempDir("", "pealsp_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(root)
+	writeFile(t, filepath.Join(root, "b", "b.pea"), testB)
+	aPath := filepath.Join(root, "a", "a.pea")
+	writeFile(t, aPath, "")
+	aURI := pathURI(aPath)
+	bURI := pathURI(filepath.Join(root, "b", "b.pea"))
+
+	var out bytes.Buffer
+	s := newServer(root, &out)
+	doc := textDocumentIdentifier{URI: aURI}
+
+	s.handle(notify(t, "textDocument/didOpen", didOpenParams{
+		TextDocument: textDocumentItem{URI: aURI, Text: testA},
+	}))
+	if diags := readDiagnostics(t, &out); len(diags[aURI]) != 0 {
+		t.Errorf("got diagnostics %v, want none", diags[aURI])
+	}
+
+	at := func(line, col int) positionParams {
+		return positionParams{TextDocument: doc, Position: Position{Line: line, Character: col}}
+	}
+	tests := []struct {
+		method string
+		params positionParams
+		want   interface{}
+	}{
+		{
+			method: "textDocument/hover",
+			params: at(6, 1),
+			want:   hover{Contents: markupContent{Kind: "markdown", Value: "```pea\np #b Point&\n```"}},
+		},
+		{
+			method: "textDocument/hover",
+			params: at(6, 3),
+			want:   hover{Contents: markupContent{Kind: "markdown", Value: "```pea\nPoint [sum ^Int]\n```"}},
+		},
+		{
+			method: "textDocument/hover",
+			params: at(5, 21),
+			want:   hover{Contents: markupContent{Kind: "markdown", Value: "```pea\nInt\n```"}},
+		},
+		{
+			method: "textDocument/hover",
+			params: at(0, 0),
+			want:   nil,
+		},
+		{
+			method: "textDocument/definition",
+			params: at(6, 3),
+			want: []Location{{
+				URI:   bURI,
+				Range: Range{Start: Position{2, 0}, End: Position{2, 30}},
+			}},
+		},
+		{
+			method: "textDocument/definition",
+			params: at(5, 7),
+			want: []Location{{
+				URI:   aURI,
+				Range: Range{Start: Position{2, 0}, End: Position{2, 35}},
+			}},
+		},
+		{
+			method: "textDocument/definition",
+			params: at(6, 1),
+			want: []Location{{
+				URI:   aURI,
+				Range: Range{Start: Position{5, 1}, End: Position{5, 2}},
+			}},
+		},
+	}
+	for i, test := range tests {
+		var got interface{}
+		call(t, s, &out, i, test.method, test.params, &got)
+		if !jsonEq(t, got, test.want) {
+			t.Errorf("%s(%v)=%v, want %v", test.method, test.params.Position, got, test.want)
+		}
+	}
+
+	// Completion removes the partial selector before checking.
+	s.handle(notify(t, "textDocument/didChange", didChangeParams{
+		TextDocument:   doc,
+		ContentChanges: []contentChange{{Text: strings.Replace(testA, "p sum", "p s", 1)}},
+	}))
+	readDiagnostics(t, &out)
+	var items []completionItem
+	call(t, s, &out, 100, "textDocument/completion", at(6, 4), &items)
+	var labels []string
+	for _, item := range items {
+		labels = append(labels, item.Label)
+	}
+	if want := []string{"scale:", "sum"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("completion labels=%v, want %v", labels, want)
+	}
+
+	s.handle(notify(t, "textDocument/didChange", didChangeParams{
+		TextDocument:   doc,
+		ContentChanges: []contentChange{{Text: strings.Replace(testA, "p sum", "p sum: 5", 1)}},
+	}))
+	diags := readDiagnostics(t, &out)[aURI]
+	if len(diags) != 1 ||
+		diags[0].Range.Start != (Position{6, 3}) ||
+		!strings.Contains(diags[0].Message, "sum:") {
+		t.Errorf("got diagnostics %v, want one for sum: at 6:3", diags)
+	}
+
+	s.handle(notify(t, "textDocument/didChange", didChangeParams{
+		TextDocument:   doc,
+		ContentChanges: []contentChange{{Text: "func [f |"}},
+	}))
+	diags = readDiagnostics(t, &out)[aURI]
+	if len(diags) != 1 || diags[0].Range.Start != (Position{0, 9}) {
+		t.Errorf("got diagnostics %v, want one at 0:9", diags)
+	}
+}
+
+func writeFile(t *testing.T, path, text string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(text), 0666); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+}
+
+func notify(t *testing.T, method string, params interface{}) *message {
+	t.Helper()
+	data, err := json.Marshal(params)
+	if err != nil {
+		t.Fatalf("failed to marshal params: %s", err)
+	}
+	return &message{JSONRPC: "2.0", Method: method, Params: data}
+}
+
+// call sends a request to the server
+// and unmarshals the result of its response into result.
+func call(t *testing.T, s *server, out *bytes.Buffer, id int, method string, params, result interface{}) {
+	t.Helper()
+	msg := notify(t, method, params)
+	rawID := json.RawMessage(strings.TrimSpace(string(mustMarshal(t, id))))
+	msg.ID = &rawID
+	s.handle(msg)
+	data, err := readMessage(bufio.NewReader(out))
+	if err != nil {
+		t.Fatalf("failed to read response: %s", err)
+	}
+	var resp struct {
+		ID     int
+		Result json.RawMessage
+		Error  *rpcError
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %s", err)
+	}
+	if resp.Error != nil || resp.ID != id {
+		t.Fatalf("%s: got response %s", method, data)
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		t.Fatalf("failed to unmarshal result: %s", err)
+	}
+}
+
+// readDiagnostics returns the diagnostics published to out by URI.
+func readDiagnostics(t *testing.T, out *bytes.Buffer) map[string][]Diagnostic {
+	t.Helper()
+	diags := make(map[string][]Diagnostic)
+	r := bufio.NewReader(out)
+	for out.Len() > 0 || r.Buffered() > 0 {
+		data, err := readMessage(r)
+		if err != nil {
+			t.Fatalf("failed to read notification: %s", err)
+		}
+		var n struct {
+			Method string
+			Params publishDiagnosticsParams
+		}
+		if err := json.Unmarshal(data, &n); err != nil {
+			t.Fatalf("failed to unmarshal notification: %s", err)
+		}
+		if n.Method != "textDocument/publishDiagnostics" {
+			t.Fatalf("got notification %s, want textDocument/publishDiagnostics", n.Method)
+		}
+		diags[n.Params.URI] = n.Params.Diagnostics
+	}
+	return diags
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("failed to marshal: %s", err)
+	}
+	return data
+}
+
+// jsonEq returns whether two values have the same JSON encoding,
+// ignoring the order of object keys.
+func jsonEq(t *testing.T, a, b interface{}) bool {
+	t.Helper()
+	var aa, bb interface{}
+	if err := json.Unmarshal(mustMarshal(t, a), &aa); err != nil {
+		t.Fatalf("failed to unmarshal: %s", err)
+	}
+	if err := json.Unmarshal(mustMarshal(t, b), &bb); err != nil {
+		t.Fatalf("failed to unmarshal: %s", err)
+	}
+	return reflect.DeepEqual(aa, bb)
+}
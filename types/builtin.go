@@ -376,8 +376,8 @@ func makeBlockType(x *scope, blk *Block) *Type {
 	if fun := x.function(); fun != nil && fun.Sig.Ret != nil {
 		v := Var{
 			Name:  "",
-			Field: blk.Type(),
-			Index: len(typ.Fields) - 1,
+			Field: typ,
+			Index: len(typ.Fields),
 		}
 		if retType := fun.Sig.Ret.Type; retType != nil {
 			v.typ = retType.Ref()
@@ -484,7 +484,7 @@ func isAnyInt(typ *Type) bool {
 func disectIntType(cfg Config, typ *Type) (bool, int) {
 	switch typ.BuiltIn {
 	case IntType:
-		return true, cfg.IntSize
+		return true, cfg.IntSize - 1
 	case Int8Type:
 		return true, 7
 	case Int16Type:
@@ -206,3 +206,46 @@ func TestBlockType(t *testing.T) {
 		t.Errorf("got block type %s, want %s", got, want)
 	}
 }
+
+func TestBlockTypeReturnField(t *testing.T) {
+	t.Parallel()
+	src := `
+		func [foo: x Int ^Int |
+			y := 6.
+			[x + y] value.
+			[^x] value.
+			^y
+		]
+	`
+	p := ast.NewParser("/test/test")
+	if err := p.Parse("", strings.NewReader(src)); err != nil {
+		t.Fatalf("failed to parse source: %s", err)
+	}
+	mod, errs := Check(p.Mod(), Config{})
+	if len(errs) > 0 {
+		t.Fatalf("failed to check source: %v", errs)
+	}
+	foo := findTestFun(mod, "foo:")
+	for _, i := range []int{1, 2} {
+		recv := foo.Stmts[i].(*Call).Recv
+		for {
+			cvt, ok := recv.(*Convert)
+			if !ok {
+				break
+			}
+			recv = cvt.Expr
+		}
+		blk := recv.(*Block)
+		typ := blk.BlockType
+		if n := len(typ.Fields); n == 0 || typ.Fields[n-1].Name != "" {
+			t.Fatalf("%s: no return slot field", typ.fullString())
+		}
+		for j := range typ.Fields {
+			f := &typ.Fields[j]
+			if f.Field != typ || f.Index != j {
+				t.Errorf("%s: field %d has Field %v and Index %d, want %s and %d",
+					typ.fullString(), j, f.Field, f.Index, typ.Name, j)
+			}
+		}
+	}
+}
@@ -15,6 +15,9 @@ type Config struct {
 	// IntSize is the bit size of the Int, UInt, and Word alias types.
 	// It must be a valid int size: 8, 16, 32, or 64 (default=64).
 	IntSize int
+	// FloatSize is the bit size of the Float type.
+	// It must be a valid float size: 32 or 64 (default=64).
+	FloatSize int
 	// Importer is used for importing modules.
 	// The default importer reads packages from the local file system.
 	Importer Importer
@@ -38,8 +41,10 @@ func check(x *scope, astMod *ast.Mod) (_ *Mod, errs []checkError) {
 	isUniv := x.univ == nil
 
 	mod := &Mod{
-		AST:  astMod,
-		Path: astMod.Path,
+		AST:       astMod,
+		Path:      astMod.Path,
+		IntSize:   x.cfg.IntSize,
+		FloatSize: x.cfg.FloatSize,
 	}
 
 	// Checking happens in multiple passes.
@@ -216,7 +221,7 @@ func imports(x *scope, file *file) []checkError {
 		x.log("importing %s", p)
 		defs, err := x.cfg.Importer.Import(x.cfg, x.astMod.Locs, p)
 		if err != nil {
-			errs = append(errs, *x.err(astImp, err.Error()))
+			errs = append(errs, *x.err(astImp, ImportFailed, err.Error()))
 			continue
 		}
 		file.imports = append(file.imports, imp{
@@ -246,7 +251,7 @@ func checkDups(x *scope, defs []Def) (errs []checkError) {
 			id = def.Name
 			tid := fmt.Sprintf("(%d)%s", def.Arity, def.Name)
 			if prev, ok := seenTypes[tid]; ok {
-				err := x.err(def, "type %s redefined", tid)
+				err := x.err(def, Redefined, "type %s redefined", tid)
 				note(err, "previous definition is at %s", x.loc(prev))
 				errs = append(errs, *err)
 				continue
@@ -269,7 +274,7 @@ func checkDups(x *scope, defs []Def) (errs []checkError) {
 			panic(fmt.Sprintf("impossible type %T", def))
 		}
 		if prev, ok := seen[id]; ok {
-			err := x.err(def, "%s redefined", id)
+			err := x.err(def, Redefined, "%s redefined", id)
 			note(err, "previous definition is at %s", x.loc(prev))
 			errs = append(errs, *err)
 			continue
@@ -291,7 +296,7 @@ func checkDupMeths(x *scope, defs []Def) []checkError {
 		recv := fun.Recv.Type
 		key := recv.name() + " " + fun.Sig.Sel
 		if prev, ok := seen[key]; ok {
-			err := x.err(def, "method %s %s redefined", recv, fun.Sig.Sel)
+			err := x.err(def, Redefined, "method %s %s redefined", recv, fun.Sig.Sel)
 			note(err, "previous definition is at %s", x.loc(prev))
 			errs = append(errs, *err)
 		} else {
@@ -313,7 +318,7 @@ func checkInitCycles(x *scope, mod *Mod, defs []Def) (errs []checkError) {
 		defer x.tr("checkInitCycles(%s)", def)(&errs)
 		val, isVal := def.(*Val)
 		if isVal && onPath[val] {
-			err := x.err(val, "initialization cycle")
+			err := x.err(val, InitCycle, "initialization cycle")
 			for i := len(path) - 1; i >= 0; i-- {
 				var next string
 				if i == 0 {
@@ -377,7 +382,7 @@ func checkUnusedImports(x *scope) (errs []checkError) {
 			if imp.used {
 				continue
 			}
-			err := x.err(imp.ast, "%s imported and not used", imp.path)
+			err := x.err(imp.ast, UnusedImport, "%s imported and not used", imp.path)
 			errs = append(errs, *err)
 		}
 	}
@@ -486,7 +491,7 @@ func checkFun(x *scope, def *Fun) (errs []checkError) {
 		for i := range def.Recv.Parms {
 			tvar := &def.Recv.Parms[i]
 			if tvar.Name != "_" && !x.tvarUse[tvar] {
-				err := x.err(tvar, "%s defined and not used", tvar.Name)
+				err := x.err(tvar, UnusedTypeVar, "%s defined and not used", tvar.Name)
 				errs = append(errs, *err)
 			}
 		}
@@ -494,7 +499,7 @@ func checkFun(x *scope, def *Fun) (errs []checkError) {
 	for i := range def.TParms {
 		tvar := &def.TParms[i]
 		if !x.tvarUse[tvar] {
-			err := x.err(tvar, "%s defined and not used", tvar.Name)
+			err := x.err(tvar, UnusedTypeVar, "%s defined and not used", tvar.Name)
 			errs = append(errs, *err)
 		}
 	}
@@ -512,7 +517,7 @@ func checkFunRet(x *scope, fun *Fun) []checkError {
 		return errs
 	}
 	if n := len(fun.Stmts); n == 0 || !isRet(fun.Stmts[n-1]) {
-		err := x.err(fun, "missing return at the end of %s", fun.Sig.Sel)
+		err := x.err(fun, MissingReturn, "missing return at the end of %s", fun.Sig.Sel)
 		errs = append(errs, *err)
 	}
 	return errs
@@ -544,7 +549,7 @@ func checkRecv(x *scope, recv *Recv) (_ *scope, errs []checkError) {
 		x.typeVar = parm.Type
 	}
 	if isRef(recv.Type) {
-		err := x.err(recv, "invalid receiver type: cannot add a method to &")
+		err := x.err(recv, BadRecv, "invalid receiver type: cannot add a method to &")
 		errs = append(errs, *err)
 	}
 	return x, errs
@@ -589,7 +594,7 @@ func checkFields(x *scope, fields []Var) []checkError {
 	for i := range fields {
 		field := &fields[i]
 		if prev, ok := seen[field.Name]; ok {
-			err := x.err(field, "field %s redefined", field.Name)
+			err := x.err(field, Redefined, "field %s redefined", field.Name)
 			note(err, "previous definition at %s", x.loc(prev))
 			errs = append(errs, *err)
 		} else {
@@ -607,7 +612,7 @@ func checkCases(x *scope, cases []Var) []checkError {
 		cas := &cases[i]
 		lower := strings.ToLower(cas.Name)
 		if prev, ok := seen[lower]; ok {
-			err := x.err(cas, "case %s redefined", prev.Name)
+			err := x.err(cas, Redefined, "case %s redefined", prev.Name)
 			if prev.Name != cas.Name {
 				note(err, "cases cannot differ in only capitalization")
 			}
@@ -629,7 +634,7 @@ func checkVirts(x *scope, virts []FunSig) []checkError {
 	for i := range virts {
 		virt := &virts[i]
 		if prev, ok := seen[virt.Sel]; ok {
-			err := x.err(virt, "virtual method %s redefined", virt.Sel)
+			err := x.err(virt, Redefined, "virtual method %s redefined", virt.Sel)
 			note(err, "previous definition at %s", x.loc(prev))
 			errs = append(errs, *err)
 		} else {
@@ -668,7 +673,7 @@ func checkTypeName(x *scope, name *TypeName) (errs []checkError) {
 			if len(es) == 0 {
 				continue
 			}
-			err := x.err(arg, "type %s does not implement %s (%s)", arg.Type, parm.Type, iface)
+			err := x.err(arg, NotImplemented, "type %s does not implement %s (%s)", arg.Type, parm.Type, iface)
 			err.cause = es
 			errs = append(errs, *err)
 		}
@@ -738,7 +743,7 @@ func checkUnusedLocals(x *scope) []checkError {
 	var errs []checkError
 	for _, loc := range *x.locals() {
 		if loc.Name != "_" && loc.AST != nil && !x.localUse[loc] {
-			err := x.err(loc, "%s declared and not used", loc.Name)
+			err := x.err(loc, UnusedVar, "%s declared and not used", loc.Name)
 			errs = append(errs, *err)
 		}
 	}
@@ -766,7 +771,7 @@ func checkRet(x *scope, astRet *ast.Ret) (_ *Ret, errs []checkError) {
 
 	var want *Type
 	if fun := x.function(); fun == nil {
-		err := x.err(astRet, "return outside of a function or method")
+		err := x.err(astRet, MisplacedReturn, "return outside of a function or method")
 		errs = append(errs, *err)
 	} else if fun.Sig.Ret != nil {
 		want = fun.Sig.Ret.Type
@@ -800,7 +805,7 @@ func checkAssign(x *scope, astAss *ast.Assign) (_ *scope, _ []Stmt, errs []check
 		if ok {
 			got = len(astCall.Msgs)
 		}
-		err := x.err(astAss, "assignment count mismatch: got %d, want %d", got, len(vars))
+		err := x.err(astAss, AssignCount, "assignment count mismatch: got %d, want %d", got, len(vars))
 		errs = append(errs, *err)
 		expr, es := checkExpr(x, nil, astAss.Expr)
 		errs = append(errs, es...)
@@ -910,13 +915,13 @@ func checkAssignVars(x *scope, astAss *ast.Assign) (*scope, []*Var, []bool, []ch
 			}
 			markCapture(x, found)
 			if astVar.Type != nil {
-				err := x.err(astVar, "%s redefined", astVar.Name)
+				err := x.err(astVar, Redefined, "%s redefined", astVar.Name)
 				note(err, "previous definition at %s", x.loc(found))
 				errs = append(errs, *err)
 			}
 			vars[i] = found
 		case *Fun:
-			err := x.err(astVar, "assignment to a function")
+			err := x.err(astVar, AssignFun, "assignment to a function")
 			note(err, "%s is defined at %s", found.Sig.Sel, x.loc(found))
 			errs = append(errs, *err)
 			vars[i] = &Var{
@@ -991,7 +996,7 @@ func convertExpr(x *scope, want *Type, expr Expr) (_ Expr, err *checkError) {
 	if len(want.Virts) > 0 {
 		funs, es := findVirts(x, expr.ast(), haveBase, wantBase.Virts, false)
 		if len(es) > 0 {
-			err = x.err(expr.ast(), "type %s does not implement %s", have, want)
+			err = x.err(expr.ast(), NotImplemented, "type %s does not implement %s", have, want)
 			err.cause = es
 			return expr, err
 		}
@@ -1001,7 +1006,7 @@ func convertExpr(x *scope, want *Type, expr Expr) (_ Expr, err *checkError) {
 		return &Convert{Expr: expr, Virts: funs, typ: want}, nil
 	}
 
-	err = x.err(expr, "type mismatch: have %s, want %s", have, want)
+	err = x.err(expr, TypeMismatch, "type mismatch: have %s, want %s", have, want)
 	if have.Var != nil && want.Var != nil && have.Name == want.Name {
 		if have.AST != nil {
 			note(err, "have type %s defined at %s", have, x.loc(have))
@@ -1096,7 +1101,7 @@ func findVirt(x *scope, loc ast.Node, recv *Type, want *FunSig, allowConvert boo
 	if w := x.loc(want.AST); w != nil {
 		wantWhere = fmt.Sprintf(" from %s", w)
 	}
-	err := x.err(loc, "wrong type for method %s", want.Sel)
+	err := x.err(loc, WrongMethodType, "wrong type for method %s", want.Sel)
 	err.notes = []string{
 		fmt.Sprintf("have %s%s", funSig, gotWhere),
 		fmt.Sprintf("want %s%s", want, wantWhere),
@@ -1284,7 +1289,7 @@ func findFunInst(x *scope, loc ast.Node, infer, recv *Type, mod *ast.ModTag, sel
 			}
 		}
 		if fun == nil {
-			err := x.err(loc, "method %s %s not found", recv, sel)
+			err := x.err(loc, NotFound, "method %s %s not found", recv, sel)
 			return nil, append(errs, *err)
 		}
 	} else {
@@ -1328,11 +1333,11 @@ func checkCtor(x *scope, infer *Type, astCtor *ast.Ctor) (_ Expr, errs []checkEr
 	ctor := &Ctor{AST: astCtor}
 	switch {
 	case typ == nil:
-		errs = append(errs, *x.err(ctor, "cannot infer constructor type"))
+		errs = append(errs, *x.err(ctor, CannotInfer, "cannot infer constructor type"))
 	case typ.Alias != nil:
 		panic("impossible alias")
 	case typ.Priv && x.defFiles[typ.Def] == nil && !isBuiltInType(typ):
-		errs = append(errs, *x.err(ctor, "cannot construct unexported type %s", typ))
+		errs = append(errs, *x.err(ctor, BadCtor, "cannot construct unexported type %s", typ))
 	case isAry(typ):
 		errs = append(errs, checkAryCtor(x, typ, ctor)...)
 	case isRef(typ):
@@ -1347,9 +1352,9 @@ func checkCtor(x *scope, infer *Type, astCtor *ast.Ctor) (_ Expr, errs []checkEr
 	case typ.Cases != nil:
 		errs = append(errs, checkOrCtor(x, typ, ctor)...)
 	case typ.Virts != nil:
-		errs = append(errs, *x.err(astCtor, "cannot construct virtual type %s", typ))
+		errs = append(errs, *x.err(astCtor, BadCtor, "cannot construct virtual type %s", typ))
 	case isBuiltInType(typ) && !isNil(typ):
-		errs = append(errs, *x.err(astCtor, "cannot construct built-in type %s", typ))
+		errs = append(errs, *x.err(astCtor, BadCtor, "cannot construct built-in type %s", typ))
 	default:
 		errs = append(errs, checkAndCtor(x, typ, ctor)...)
 	}
@@ -1376,13 +1381,13 @@ func checkOrCtor(x *scope, orType *Type, ctor *Ctor) (errs []checkError) {
 
 	sel, arg, ok := disectOrCtorArg(ctor.AST)
 	if !ok {
-		err := x.err(ctor, "malformed %s constructor", orType)
+		err := x.err(ctor, BadCtor, "malformed %s constructor", orType)
 		return append(errs, *err)
 	}
 
 	ctor.Case = findCase(orType, sel)
 	if ctor.Case == nil {
-		err := x.err(ctor, "case %s not found", sel)
+		err := x.err(ctor, NotFound, "case %s not found", sel)
 		errs = append(errs, *err)
 		var es []checkError
 		ctor.Args, es = checkExprs(x, ctor.AST.Args)
@@ -1433,7 +1438,7 @@ func checkAndCtor(x *scope, andType *Type, ctor *Ctor) (errs []checkError) {
 	}
 	call, ok := ctor.AST.Args[0].(*ast.Call)
 	if !ok || len(ctor.AST.Args) > 1 || call.Recv != nil || len(call.Msgs) != 1 {
-		err := x.err(ctor, "malformed %s constructor", andType)
+		err := x.err(ctor, BadCtor, "malformed %s constructor", andType)
 		return append(errs, *err)
 	}
 
@@ -1443,12 +1448,12 @@ func checkAndCtor(x *scope, andType *Type, ctor *Ctor) (errs []checkError) {
 		fieldName := fieldNames[i]
 		field := findField(andType, fieldName)
 		if field < 0 {
-			err := x.err(astArg, "unknown field: %s", fieldName)
+			err := x.err(astArg, BadCtor, "unknown field: %s", fieldName)
 			errs = append(errs, *err)
 			continue
 		}
 		if prev := astArgs[field]; prev != nil {
-			err := x.err(astArg, "duplicate field: %s", fieldName)
+			err := x.err(astArg, BadCtor, "duplicate field: %s", fieldName)
 			note(err, "previous at %s", x.loc(prev))
 			errs = append(errs, *err)
 			continue
@@ -1460,7 +1465,7 @@ func checkAndCtor(x *scope, andType *Type, ctor *Ctor) (errs []checkError) {
 	for i := range andType.Fields {
 		field := &andType.Fields[i]
 		if astArgs[i] == nil {
-			err := x.err(ctor, "missing field: %s", field.Name)
+			err := x.err(ctor, BadCtor, "missing field: %s", field.Name)
 			errs = append(errs, *err)
 			continue
 		}
@@ -1508,7 +1513,7 @@ func checkBlock(x *scope, infer *Type, astBlock *ast.Block) (_ *Block, errs []ch
 		parm.Name = astParm.Name
 		if astParm.Type == nil {
 			if parmInfer[i] == nil {
-				err := x.err(parm, "cannot infer block parameter type")
+				err := x.err(parm, CannotInfer, "cannot infer block parameter type")
 				errs = append(errs, *err)
 			}
 			parm.typ = parmInfer[i]
@@ -1536,7 +1541,7 @@ func checkBlock(x *scope, infer *Type, astBlock *ast.Block) (_ *Block, errs []ch
 	errs = append(errs, es...)
 
 	if len(blk.Parms) >= MaxValueParms {
-		err := x.err(astBlock, "too many block parameters: got %d, max %d",
+		err := x.err(astBlock, TooManyBlockParms, "too many block parameters: got %d, max %d",
 			len(astBlock.Parms), MaxValueParms)
 		errs = append(errs, *err)
 		return blk, errs
@@ -1660,7 +1665,7 @@ func checkIdent(x *scope, infer *Type, astIdent *ast.Ident) (_ Expr, errs []chec
 		case msg.Fun == nil:
 			return call, errs
 		case msg.Fun.Test:
-			err := x.err(astIdent, "tests cannot be called")
+			err := x.err(astIdent, CalledTest, "tests cannot be called")
 			errs = append(errs, *err)
 			return call, errs
 		case msg.Fun.Sig.Ret == nil:
@@ -1700,12 +1705,12 @@ func checkIntBounds(x *scope, n interface{}, t *Type, i *big.Int) *checkError {
 	signed, bits := disectIntType(x.cfg, t)
 	x.log("signed=%v, bits=%v", signed, bits)
 	if !signed && i.Cmp(&big.Int{}) < 0 {
-		return x.err(n, "type %s cannot represent %s: negative unsigned", t, i)
+		return x.err(n, Unrepresentable, "type %s cannot represent %s: negative unsigned", t, i)
 	}
 	min := big.NewInt(-(1 << uint(bits)))
 	x.log("val=%v, val.BitLen()=%d, min=%v", i, i.BitLen(), min)
 	if i.BitLen() > bits && (!signed || i.Cmp(min) != 0) {
-		return x.err(n, "type %s cannot represent %s: overflow", t, i)
+		return x.err(n, Unrepresentable, "type %s cannot represent %s: overflow", t, i)
 	}
 	return nil
 }
@@ -1721,7 +1726,7 @@ func checkFloat(x *scope, infer *Type, AST ast.Expr, text string) (_ Expr, errs
 	case isAnyInt(infer):
 		var i big.Int
 		if _, acc := n.Val.Int(&i); acc != big.Exact {
-			err := x.err(AST, "type %s cannot represent %s: truncation", infer.name(), text)
+			err := x.err(AST, Unrepresentable, "type %s cannot represent %s: truncation", infer.name(), text)
 			errs = append(errs, *err)
 		}
 		expr, es := checkInt(x, infer, AST, i.String())
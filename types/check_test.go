@@ -4177,6 +4177,38 @@ func TestIntLit(t *testing.T) {
 		overflowTest("Int64", "-9223372036854775809"),
 		overflowTest("Int64", "9223372036854775808"),
 		overflowTest("Int64", "100000000000000000000000"),
+		{
+			name: "Int ok",
+			src: `
+				val a Int := [-9223372036854775808]
+				val b Int := [9223372036854775807]
+			`,
+			err: "",
+		},
+		overflowTest("Int", "-9223372036854775809"),
+		overflowTest("Int", "9223372036854775808"),
+		{
+			name: "32-bit Int ok",
+			src: `
+				val a Int := [-2147483648]
+				val b Int := [2147483647]
+				val c UInt := [4294967295]
+			`,
+			err:     "",
+			intSize: 32,
+		},
+		{
+			name:    "32-bit Int overflow",
+			src:     "val x Int := [2147483648]",
+			err:     "Int cannot represent 2147483648: overflow",
+			intSize: 32,
+		},
+		{
+			name:    "32-bit UInt overflow",
+			src:     "val x UInt := [4294967296]",
+			err:     "UInt cannot represent 4294967296: overflow",
+			intSize: 32,
+		},
 		{
 			name: "UInt8 ok",
 			src: `
@@ -4610,6 +4642,7 @@ type errorTest struct {
 	imports [][2]string
 	err     string // regexp, "" means no error
 	trace   bool
+	intSize int // Config.IntSize; 0 is the default
 }
 
 func (test errorTest) run(t *testing.T) {
@@ -4625,6 +4658,7 @@ func (test errorTest) run(t *testing.T) {
 	cfg := Config{
 		Importer: testImporter(test.imports),
 		Trace:    test.trace,
+		IntSize:  test.intSize,
 	}
 	switch _, errs := Check(astMod, cfg); {
 	case test.err == "" && len(errs) == 0:
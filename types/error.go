@@ -4,14 +4,281 @@ package types
 
 import (
 	"fmt"
+	"io"
 	"sort"
 	"strings"
 
 	"github.com/eaburns/pea/loc"
 )
 
+// A Diagnostic is an error found by the type checker.
+// The errors returned by Check are all *Diagnostic.
+type Diagnostic struct {
+	Loc      loc.Loc
+	Code     Code
+	Severity Severity
+	Msg      string
+	// Notes are additional lines of explanation.
+	Notes []string `json:",omitempty"`
+	// Causes are the errors that led to this one.
+	Causes []Diagnostic `json:",omitempty"`
+}
+
+// Error returns the error message prefixed by its location, if any,
+// followed by the notes and causes, each on an indented line.
+func (d *Diagnostic) Error() string {
+	var s strings.Builder
+	buildError(&s, "", d)
+	return s.String()
+}
+
+func buildError(s *strings.Builder, ident string, d *Diagnostic) {
+	s.WriteString(ident)
+	if d.Loc != (loc.Loc{}) {
+		s.WriteString(d.Loc.String())
+		s.WriteString(": ")
+	}
+	s.WriteString(d.Msg)
+	ident2 := ident + "	"
+	for _, n := range d.Notes {
+		s.WriteRune('\n')
+		s.WriteString(ident2)
+		s.WriteString(n)
+	}
+	for i := range d.Causes {
+		s.WriteRune('\n')
+		buildError(s, ident2, &d.Causes[i])
+	}
+}
+
+// Render writes the Diagnostic like Error,
+// but each location is followed by its lines of source text from files,
+// with the located span underlined by a caret and tildes.
+// Of a span of more than one line,
+// only the first and last lines are written.
+// If color is true, ANSI terminal escape codes are used
+// to highlight the locations and their spans.
+func (d *Diagnostic) Render(w io.Writer, files loc.Files, color bool) error {
+	var s strings.Builder
+	renderError(&s, "", d, files, color)
+	_, err := io.WriteString(w, s.String())
+	return err
+}
+
+const (
+	boldColor  = "\x1b[1m"
+	redColor   = "\x1b[1;31m"
+	resetColor = "\x1b[0m"
+)
+
+func renderError(s *strings.Builder, ident string, d *Diagnostic, files loc.Files, color bool) {
+	s.WriteString(ident)
+	if d.Loc != (loc.Loc{}) {
+		if color {
+			s.WriteString(boldColor)
+		}
+		s.WriteString(d.Loc.String())
+		s.WriteString(":")
+		if color {
+			s.WriteString(resetColor)
+		}
+		s.WriteString(" ")
+	}
+	s.WriteString(d.Msg)
+	s.WriteRune('\n')
+	ident2 := ident + "	"
+	// Lines between the first and last are elided.
+	first, last := d.Loc.Line[0], d.Loc.Line[1]
+	if renderLine(s, ident2, d, first, files, color) && last > first {
+		if last > first+1 {
+			s.WriteString(ident2)
+			s.WriteString("...\n")
+		}
+		renderLine(s, ident2, d, last, files, color)
+	}
+	for _, n := range d.Notes {
+		s.WriteString(ident2)
+		s.WriteString(n)
+		s.WriteRune('\n')
+	}
+	for i := range d.Causes {
+		renderError(s, ident2, &d.Causes[i], files, color)
+	}
+}
+
+// renderLine writes line n of the source of a Diagnostic
+// followed by a line underlining the part of it in the Diagnostic's span.
+// It returns false if the line is not in the source.
+func renderLine(s *strings.Builder, ident string, d *Diagnostic, n int, files loc.Files, color bool) bool {
+	line, ok := files.Line(d.Loc.Path, n)
+	if !ok {
+		return false
+	}
+	start := len(line) - len(strings.TrimLeft(line, " \t"))
+	end := len(line)
+	if n == d.Loc.Line[0] {
+		start = d.Loc.Col[0] - 1
+	}
+	if n == d.Loc.Line[1] {
+		end = d.Loc.Col[1] - 1
+	}
+	if start > len(line) {
+		start = len(line)
+	}
+	if end < start {
+		end = start
+	}
+	s.WriteString(ident)
+	s.WriteString(line)
+	s.WriteRune('\n')
+	s.WriteString(ident)
+	writeUnderline(s, line, start, end, n == d.Loc.Line[0], color)
+	s.WriteRune('\n')
+	return true
+}
+
+// writeUnderline writes a line that underlines
+// the bytes of line from start to end.
+// Tabs before start are copied, so the underline aligns
+// regardless of the tab width.
+// If caret is true, the underline begins with a ^.
+func writeUnderline(s *strings.Builder, line string, start, end int, caret, color bool) {
+	for _, r := range line[:start] {
+		if r == '\t' {
+			s.WriteRune('\t')
+		} else {
+			s.WriteRune(' ')
+		}
+	}
+	if color {
+		s.WriteString(redColor)
+	}
+	if caret {
+		s.WriteRune('^')
+	}
+	for i := range line[start:end] {
+		if i > 0 || !caret {
+			s.WriteRune('~')
+		}
+	}
+	if color {
+		s.WriteString(resetColor)
+	}
+}
+
+// AsDiagnostic returns the Diagnostic of an error.
+// If the error is not a *Diagnostic,
+// but has a location and message, as do syntax errors
+// returned by ast.Parser, its Code is Syntax.
+// Otherwise the Diagnostic has only a message.
+func AsDiagnostic(err error) Diagnostic {
+	switch err := err.(type) {
+	case *Diagnostic:
+		return *err
+	case interface {
+		Loc() loc.Loc
+		Msg() string
+	}:
+		return Diagnostic{Loc: err.Loc(), Code: Syntax, Severity: SeverityError, Msg: err.Msg()}
+	default:
+		return Diagnostic{Severity: SeverityError, Msg: err.Error()}
+	}
+}
+
+// A Severity is the severity of a Diagnostic.
+type Severity string
+
+// SeverityError is the Severity of an error.
+const SeverityError Severity = "error"
+
+// A Code identifies the kind of a Diagnostic.
+// Codes are stable; they are not changed
+// when the wording of an error message changes.
+type Code string
+
+const (
+	// Syntax is the Code of syntax errors from the ast package.
+	Syntax Code = "syntax"
+
+	// ImportFailed is the Code of errors for imports that cannot be read or checked.
+	ImportFailed Code = "import-failed"
+
+	// UnusedImport is the Code of errors for imports that are not used.
+	UnusedImport Code = "unused-import"
+
+	// Redefined is the Code of errors for definitions whose name is already defined.
+	Redefined Code = "redefined"
+
+	// InitCycle is the Code of errors for module-level values whose initialization depends on themselves.
+	InitCycle Code = "init-cycle"
+
+	// AliasCycle is the Code of errors for type aliases that are defined in terms of themselves.
+	AliasCycle Code = "alias-cycle"
+
+	// NotFound is the Code of errors for identifiers, types, and functions that are not defined.
+	NotFound Code = "not-found"
+
+	// Ambiguous is the Code of errors for references that match more than one definition.
+	Ambiguous Code = "ambiguous"
+
+	// UnusedTypeVar is the Code of errors for type variables that are not used.
+	UnusedTypeVar Code = "unused-type-var"
+
+	// UnusedVar is the Code of errors for variables that are not used.
+	UnusedVar Code = "unused-var"
+
+	// BadTypeVarName is the Code of errors for illegal names of function type variables.
+	BadTypeVarName Code = "bad-type-var-name"
+
+	// BadRecv is the Code of errors for receiver types to which methods cannot be added.
+	BadRecv Code = "bad-receiver"
+
+	// TooManyCases is the Code of errors for or-types with more cases than fit in a tag.
+	TooManyCases Code = "too-many-cases"
+
+	// MissingReturn is the Code of errors for functions with a result type that can end without returning.
+	MissingReturn Code = "missing-return"
+
+	// MisplacedReturn is the Code of errors for returns outside of a function or method.
+	MisplacedReturn Code = "misplaced-return"
+
+	// AssignCount is the Code of errors for assignments with a different number of variables and values.
+	AssignCount Code = "assign-count"
+
+	// AssignFun is the Code of errors for assignments to a function.
+	AssignFun Code = "assign-function"
+
+	// TypeMismatch is the Code of errors for expressions with a type other than the expected type.
+	TypeMismatch Code = "type-mismatch"
+
+	// NotImplemented is the Code of errors for types that do not implement a virtual type or constraint.
+	NotImplemented Code = "not-implemented"
+
+	// WrongMethodType is the Code of errors for methods with a type other than that of the selector.
+	WrongMethodType Code = "wrong-method-type"
+
+	// CannotInfer is the Code of errors for types and type parameters that cannot be inferred.
+	CannotInfer Code = "cannot-infer"
+
+	// CannotUnify is the Code of errors for types that cannot be unified with a type pattern.
+	CannotUnify Code = "cannot-unify"
+
+	// BadCtor is the Code of errors for constructors of types that cannot be constructed.
+	BadCtor Code = "bad-constructor"
+
+	// TooManyBlockParms is the Code of errors for blocks with more parameters than are allowed.
+	TooManyBlockParms Code = "too-many-block-parms"
+
+	// CalledTest is the Code of errors for calls to tests.
+	CalledTest Code = "called-test"
+
+	// Unrepresentable is the Code of errors for literals that cannot be represented by their type.
+	Unrepresentable Code = "unrepresentable"
+)
+
 type checkError struct {
 	loc   loc.Loc
+	code  Code
 	msg   string
 	notes []string
 	cause []checkError
@@ -22,32 +289,30 @@ func note(err *checkError, f string, vs ...interface{}) {
 }
 
 func (err *checkError) Error() string {
-	var s strings.Builder
-	buildError(&s, "", err)
-	return s.String()
+	d := err.diagnostic()
+	return d.Error()
 }
 
-func buildError(s *strings.Builder, ident string, err *checkError) {
-	s.WriteString(ident)
-	s.WriteString(err.loc.String())
-	s.WriteString(": ")
-	s.WriteString(err.msg)
-	ident2 := ident + "	"
-	for _, n := range err.notes {
-		s.WriteRune('\n')
-		s.WriteString(ident2)
-		s.WriteString(n)
+func (err *checkError) diagnostic() Diagnostic {
+	d := Diagnostic{
+		Loc:      err.loc,
+		Code:     err.code,
+		Severity: SeverityError,
+		Msg:      err.msg,
+		Notes:    err.notes,
 	}
 	for i := range err.cause {
-		s.WriteRune('\n')
-		buildError(s, ident2, &err.cause[i])
+		d.Causes = append(d.Causes, err.cause[i].diagnostic())
 	}
+	return d
 }
 
 func convertErrors(cerrs []checkError) []error {
 	var errs []error
-	for i := range sortErrors(cerrs) {
-		errs = append(errs, &cerrs[i])
+	cerrs = sortErrors(cerrs)
+	for i := range cerrs {
+		d := cerrs[i].diagnostic()
+		errs = append(errs, &d)
 	}
 	return errs
 }
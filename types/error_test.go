@@ -0,0 +1,119 @@
+// Copyright © 2020 The Pea Authors under an MIT-style license.
+
+package types
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eaburns/pea/ast"
+	"github.com/eaburns/pea/loc"
+)
+
+func TestDiagnostic(t *testing.T) {
+	const src = `
+		func [f | x := 5]
+		func [g ^Int | ^"hello"]
+	`
+	p := ast.NewParser("/test/test")
+	if err := p.Parse("test.pea", strings.NewReader(src)); err != nil {
+		t.Fatalf("failed to parse source: %s", err)
+	}
+	_, errs := Check(p.Mod(), Config{})
+	var got []Diagnostic
+	for _, err := range errs {
+		d, ok := err.(*Diagnostic)
+		if !ok {
+			t.Fatalf("got error type %T, want *Diagnostic", err)
+		}
+		got = append(got, *d)
+	}
+	want := []Diagnostic{
+		{
+			Loc:      loc.Loc{Path: "test.pea", Line: [2]int{2, 2}, Col: [2]int{13, 14}},
+			Code:     UnusedVar,
+			Severity: SeverityError,
+			Msg:      "x declared and not used",
+		},
+		{
+			Loc:      loc.Loc{Path: "test.pea", Line: [2]int{3, 3}, Col: [2]int{19, 26}},
+			Code:     TypeMismatch,
+			Severity: SeverityError,
+			Msg:      "type mismatch: have String, want Int",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestAsDiagnosticSyntax(t *testing.T) {
+	p := ast.NewParser("/test/test")
+	err := p.Parse("test.pea", strings.NewReader("func [f | 1]\nfunc [g | )]"))
+	if err == nil {
+		t.Fatalf("got nil, want a syntax error")
+	}
+	d := AsDiagnostic(err)
+	want := loc.Loc{Path: "test.pea", Line: [2]int{2, 2}, Col: [2]int{11, 11}}
+	if d.Code != Syntax || d.Loc != want || d.Msg == "" {
+		t.Errorf("got %+v, want a Syntax diagnostic at %s", d, want)
+	}
+}
+
+func TestRender(t *testing.T) {
+	const src = "func [f |\n\tx := 5]\nfunc [g ^Int | ^\"hello\"]\n"
+	p := ast.NewParser("/test/test")
+	if err := p.Parse("test.pea", strings.NewReader(src)); err != nil {
+		t.Fatalf("failed to parse source: %s", err)
+	}
+	astMod := p.Mod()
+	_, errs := Check(astMod, Config{})
+	var s strings.Builder
+	for _, err := range errs {
+		d := AsDiagnostic(err)
+		if err := d.Render(&s, *astMod.Locs, false); err != nil {
+			t.Fatalf("failed to render: %s", err)
+		}
+	}
+	const want = "test.pea:2.2-2.3: x declared and not used\n" +
+		"		x := 5]\n" +
+		"		^\n" +
+		"test.pea:3.17-3.24: type mismatch: have String, want Int\n" +
+		"	func [g ^Int | ^\"hello\"]\n" +
+		"	                ^~~~~~~\n"
+	if s.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", s.String(), want)
+	}
+}
+
+func TestRenderMultiLine(t *testing.T) {
+	const src = "func [f ^Int |\n\tx := 1.\n\ty := 2.\n\tx + y.\n]\nfunc [g ^Int |\n]\n"
+	p := ast.NewParser("/test/test")
+	if err := p.Parse("test.pea", strings.NewReader(src)); err != nil {
+		t.Fatalf("failed to parse source: %s", err)
+	}
+	astMod := p.Mod()
+	_, errs := Check(astMod, Config{})
+	var s strings.Builder
+	for _, err := range errs {
+		d := AsDiagnostic(err)
+		if err := d.Render(&s, *astMod.Locs, false); err != nil {
+			t.Fatalf("failed to render: %s", err)
+		}
+	}
+	const want = "test.pea:1.1-5.2: missing return at the end of f\n" +
+		"	func [f ^Int |\n" +
+		"	^~~~~~~~~~~~~~\n" +
+		"	...\n" +
+		"	]\n" +
+		"	~\n" +
+		"test.pea:6.1-7.2: missing return at the end of g\n" +
+		"	func [g ^Int |\n" +
+		"	^~~~~~~~~~~~~~\n" +
+		"	]\n" +
+		"	~\n"
+	if s.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", s.String(), want)
+	}
+}
@@ -6,8 +6,13 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"math/big"
+	"reflect"
 	"sort"
 	"strings"
+
+	"github.com/eaburns/pea/ast"
+	"github.com/eaburns/pea/loc"
 )
 
 type tag int
@@ -17,9 +22,52 @@ const (
 	funTag
 	typeTag
 	varTag
+	// The ext tags are for definitions of other modules.
+	// They are written by name, and read by looking up
+	// the definition in the module's imported definitions.
+	extValTag
+	extFunTag
+	extTypeTag
+)
+
+const (
+	retTag = iota + 1
+	assignTag
+	convertTag
+	callTag
+	ctorTag
+	blockTag
+	identTag
+	intTag
+	floatTag
+	stringTag
+)
+
+const (
+	noAST = iota
+	callAST
+	msgAST
+	identAST
+	ctorAST
+	blockAST
+	intAST
+	floatAST
+	runeAST
+	stringAST
+)
+
+const (
+	localVarRef = iota
+	valVarRef
+	fieldVarRef
 )
 
 // Write writes an exported module.
+//
+// Definitions from other modules are written by name;
+// the module is not self-contained.
+// It must be read with the same imports available
+// as when it was written.
 func Write(w io.Writer, m *Mod) (err error) {
 	defer func() {
 		x := recover()
@@ -34,8 +82,13 @@ func Write(w io.Writer, m *Mod) (err error) {
 	}()
 	writeString(w, m.Path)
 	objs := &outObjs{
+		mod:     m.Path,
 		num:     make(map[interface{}]int),
 		written: make(map[interface{}]bool),
+		files:   make(map[int]int),
+	}
+	if m.AST != nil {
+		objs.locs = m.AST.Locs
 	}
 	writeInt(w, len(m.Defs))
 	for _, def := range m.Defs {
@@ -44,6 +97,8 @@ func Write(w io.Writer, m *Mod) (err error) {
 	writeInt(w, getTypeNum(objs, m.IntType))
 	writeInt(w, getTypeNum(objs, m.BoolType))
 	writeInt(w, getTypeNum(objs, m.ByteType))
+	writeInt(w, m.IntSize)
+	writeInt(w, m.FloatSize)
 
 	for {
 		var todo []interface{}
@@ -68,7 +123,16 @@ func Write(w io.Writer, m *Mod) (err error) {
 }
 
 // Read reads an exported module.
-func Read(r io.Reader) (m *Mod, err error) {
+//
+// Definitions of other modules are looked up
+// by importing them with cfg.Importer.
+// If locs is non-nil, the module's source file locations
+// are appended to it, and the AST nodes of read statements
+// have their locations set accordingly.
+//
+// It is an error if the module was written
+// with a different IntSize or FloatSize than cfg.
+func Read(r io.Reader, cfg Config, locs *loc.Files) (m *Mod, err error) {
 	defer func() {
 		x := recover()
 		if x == nil {
@@ -81,7 +145,14 @@ func Read(r io.Reader) (m *Mod, err error) {
 		}
 	}()
 	m = &Mod{Path: readString(r)}
-	var objs inObjs
+	x := newDefaultState(cfg, &ast.Mod{Path: m.Path, Locs: locs})
+	objs := inObjs{
+		mod:  m.Path,
+		cfg:  x.cfg,
+		locs: locs,
+		nums: make(map[interface{}]int),
+		ext:  make(map[interface{}]bool),
+	}
 	n := readInt(r)
 	for i := 0; i < n; i++ {
 		m.Defs = append(m.Defs, readObj(r, &objs).(Def))
@@ -89,6 +160,12 @@ func Read(r io.Reader) (m *Mod, err error) {
 	patchType(&objs, readInt(r), &m.IntType)
 	patchType(&objs, readInt(r), &m.BoolType)
 	patchType(&objs, readInt(r), &m.ByteType)
+	m.IntSize = readInt(r)
+	m.FloatSize = readInt(r)
+	if m.IntSize != x.cfg.IntSize || m.FloatSize != x.cfg.FloatSize {
+		return nil, fmt.Errorf("%s: written with IntSize %d and FloatSize %d, want %d and %d",
+			m.Path, m.IntSize, m.FloatSize, x.cfg.IntSize, x.cfg.FloatSize)
+	}
 
 	for {
 		n := readInt(r)
@@ -100,11 +177,34 @@ func Read(r io.Reader) (m *Mod, err error) {
 		}
 	}
 	applyPatches(&objs)
+	for _, f := range objs.fixups {
+		f()
+	}
 	return m, nil
 }
 
+// isExt returns whether the object is a definition of a module other than mod.
+// Type variables, block types, and instances are never ext;
+// they are always written in full.
+func isExt(mod string, obj interface{}) bool {
+	switch obj := obj.(type) {
+	case *Val:
+		return obj.ModPath != mod
+	case *Fun:
+		return obj.Def == obj && obj.ModPath != mod
+	case *Type:
+		return obj.Def == obj && obj.Var == nil && obj.BuiltIn != BlockType && obj.ModPath != mod
+	default:
+		return false
+	}
+}
+
 // writeDef writes an object.
 func writeObj(w io.Writer, objs *outObjs, obj interface{}) {
+	if isExt(objs.mod, obj) {
+		writeExt(w, objs, obj)
+		return
+	}
 	switch obj := obj.(type) {
 	case *Val:
 		writeInt(w, valTag)
@@ -136,11 +236,130 @@ func readObj(r io.Reader, objs *inObjs) interface{} {
 		var v Var
 		readVar(r, objs, &v)
 		return &v
+	case extValTag, extFunTag, extTypeTag:
+		return readExt(r, objs, tag)
 	default:
 		panic(fmt.Sprintf("impossible obj tag: %d", tag))
 	}
 }
 
+// writeExt writes the tag, the object number, and the ModPath
+// of a definition from a different module,
+// then the following fields to identify the definition:
+// 	for Val:
+// 		Var.Name
+// 	for Fun:
+// 		a bool for whether Recv is set
+// 		Recv Type's ModPath, Arity, and Name if Recv is set
+// 		Sig.Sel
+// 	for Type:
+// 		Arity
+// 		Name
+func writeExt(w io.Writer, objs *outObjs, obj interface{}) {
+	switch obj := obj.(type) {
+	case *Val:
+		writeInt(w, extValTag)
+		writeInt(w, getValNum(objs, obj))
+		writeString(w, obj.ModPath)
+		writeString(w, obj.Var.Name)
+	case *Fun:
+		writeInt(w, extFunTag)
+		writeInt(w, getFunNum(objs, obj))
+		writeString(w, obj.ModPath)
+		writeBool(w, obj.Recv != nil)
+		if obj.Recv != nil {
+			writeString(w, obj.Recv.Type.ModPath)
+			writeInt(w, obj.Recv.Type.Arity)
+			writeString(w, obj.Recv.Type.Name)
+		}
+		writeString(w, obj.Sig.Sel)
+	case *Type:
+		writeInt(w, extTypeTag)
+		writeInt(w, getTypeNum(objs, obj))
+		writeString(w, obj.ModPath)
+		writeInt(w, obj.Arity)
+		writeString(w, obj.Name)
+	default:
+		panic(fmt.Sprintf("impossible type: %T", obj))
+	}
+	objs.written[obj] = true
+}
+
+func readExt(r io.Reader, objs *inObjs, tag int) interface{} {
+	n := readInt(r)
+	modPath := readString(r)
+	var obj interface{}
+	switch tag {
+	case extValTag:
+		name := readString(r)
+		obj = findExtVal(objs, modPath, name)
+	case extFunTag:
+		var recvMod, recvName string
+		recvArity := -1
+		if readBool(r) {
+			recvMod = readString(r)
+			recvArity = readInt(r)
+			recvName = readString(r)
+		}
+		sel := readString(r)
+		obj = findExtFun(objs, modPath, recvMod, recvArity, recvName, sel)
+	case extTypeTag:
+		arity := readInt(r)
+		name := readString(r)
+		obj = findExtType(objs, modPath, arity, name)
+	}
+	objs.add(n, obj)
+	objs.ext[obj] = true
+	return obj
+}
+
+func findExtVal(objs *inObjs, modPath, name string) *Val {
+	for _, def := range objs.importDefs(modPath) {
+		if val, ok := def.(*Val); ok && val.Var.Name == name {
+			return val
+		}
+	}
+	panic(ioError{fmt.Errorf("%s: value %s not found", modPath, name)})
+}
+
+// findExtFun returns the Fun definition from the modPath module.
+// If recvArity is -1, the Fun is a function, not a method.
+func findExtFun(objs *inObjs, modPath, recvMod string, recvArity int, recvName, sel string) *Fun {
+	for _, def := range objs.importDefs(modPath) {
+		switch fun, ok := def.(*Fun); {
+		case !ok || fun.Sig.Sel != sel:
+			continue
+		case fun.Recv == nil && recvArity < 0:
+			return fun
+		case fun.Recv != nil && fun.Recv.Type != nil &&
+			fun.Recv.Type.ModPath == recvMod &&
+			fun.Recv.Type.Arity == recvArity &&
+			fun.Recv.Type.Name == recvName:
+			return fun
+		}
+	}
+	panic(ioError{fmt.Errorf("%s: function %s not found", modPath, sel)})
+}
+
+func findExtType(objs *inObjs, modPath string, arity int, name string) *Type {
+	if t := findTypeInDefs(arity, name, objs.importDefs(modPath)); t != nil {
+		return t
+	}
+	panic(ioError{fmt.Errorf("%s: type (%d)%s not found", modPath, arity, name)})
+}
+
+// findExtInst returns an existing instance of a type definition from another module
+// with the given type key or nil if there is no such instance.
+func findExtInst(objs *inObjs, modPath string, arity int, name, key string) *Type {
+	def := findExtType(objs, modPath, arity, name)
+	for _, inst := range def.Insts {
+		if buildTypeKey(inst, new(strings.Builder)).String() == key {
+			return inst
+		}
+	}
+	return nil
+}
+
 // writeVal writes the Val number, then the following fields of Val:
 // 	Priv
 // 	Mod
@@ -181,15 +400,22 @@ func readVal(r io.Reader, objs *inObjs) *Val {
 // 	Sig
 // 	the number of locals
 // 	Locals
-// 	the number of Stmts or -1 for a declaration (Stmts==nil)
+// 	the number of Stmts or -1 if Stmts are not written
 // 	Stmts
 // 	BuiltIn
 // It does not write:
 // 	Insts; they are used only private to this module.
+//
+// Stmts are only written for parameterized definitions
+// of the module being written,
+// since importing modules must instantiate them.
+// All other Funs are read as declarations (Stmts==nil);
+// their definitions are compiled with the defining module.
 func writeFun(w io.Writer, objs *outObjs, f *Fun) {
 	writeInt(w, getFunNum(objs, f))
 	writeInt(w, getFunNum(objs, f.Def))
 	writeBool(w, f.Priv)
+	writeBool(w, f.Test)
 	writeString(w, f.ModPath)
 	writeString(w, f.InstModPath)
 	writeBool(w, f.Recv != nil)
@@ -209,7 +435,7 @@ func writeFun(w io.Writer, objs *outObjs, f *Fun) {
 	for _, l := range f.Locals {
 		writeVar(w, objs, l)
 	}
-	if f.Stmts == nil {
+	if f.Stmts == nil || f.Def != f || f.ModPath != objs.mod || isGroundFun(f) {
 		writeInt(w, -1)
 	} else {
 		writeInt(w, len(f.Stmts))
@@ -226,6 +452,7 @@ func readFun(r io.Reader, objs *inObjs) *Fun {
 	n := readInt(r)
 	patchFun(objs, readInt(r), &f.Def)
 	f.Priv = readBool(r)
+	f.Test = readBool(r)
 	f.ModPath = readString(r)
 	f.InstModPath = readString(r)
 	if readBool(r) {
@@ -383,16 +610,597 @@ func readFunSig(r io.Reader, objs *inObjs, f *Fun, s *FunSig) {
 	}
 }
 
+// writeStmt writes a statement tag, followed by the statement:
+// 	for Ret:
+// 		Expr
+// 	for Assign:
+// 		Var reference
+// 		Expr
+// 	for Convert:
+// 		Expr
+// 		Ref
+// 		the number of Virts or -1 if nil
+// 		Virts fun numbers
+// 		typ type number
+// 	for Call:
+// 		AST
+// 		a bool for whether Recv is set
+// 		Recv if set
+// 		the number of Msgs
+// 		Msgs
+// 	for Ctor:
+// 		AST
+// 		the number of Args or -1 if nil
+// 		Args
+// 		Case or -1 if nil
+// 		typ type number
+// 	for Block:
+// 		AST
+// 		the number of Parms
+// 		Parms
+// 		the number of Locals
+// 		Locals
+// 		the number of Stmts
+// 		Stmts
+// 		the number of Captures
+// 		Captures Var references
+// 		BlockType type number or -1 if nil
+// 		typ type number or -1 if nil
+// 	for Ident:
+// 		AST
+// 		Text
+// 		Var reference
+// 		Capture
+// 		typ type number or -1 if nil
+// 	for Int and Float:
+// 		AST
+// 		Val
+// 		typ type number or -1 if nil
+// 	for String:
+// 		AST
+// 		Data
+// 		typ type number or -1 if nil
+// A nil Stmt is written as tag 0.
+// The AST of Ret and Assign is not written.
 func writeStmt(w io.Writer, objs *outObjs, s Stmt) {
-	// TODO: implement writeStmt
+	switch s := s.(type) {
+	case nil:
+		writeInt(w, 0)
+	case *Ret:
+		writeInt(w, retTag)
+		writeStmt(w, objs, s.Expr)
+	case *Assign:
+		writeInt(w, assignTag)
+		writeVarRef(w, objs, s.Var)
+		writeStmt(w, objs, s.Expr)
+	case *Convert:
+		writeInt(w, convertTag)
+		writeConvert(w, objs, s)
+	case *Call:
+		writeInt(w, callTag)
+		writeCall(w, objs, s)
+	case *Ctor:
+		writeInt(w, ctorTag)
+		writeCtor(w, objs, s)
+	case *Block:
+		writeInt(w, blockTag)
+		writeBlock(w, objs, s)
+	case *Ident:
+		writeInt(w, identTag)
+		writeAST(w, objs, s.AST)
+		writeString(w, s.Text)
+		writeVarRef(w, objs, s.Var)
+		writeBool(w, s.Capture)
+		writeOptType(w, objs, s.typ)
+	case *Int:
+		writeInt(w, intTag)
+		writeAST(w, objs, s.AST)
+		writeString(w, s.Val.String())
+		writeOptType(w, objs, s.typ)
+	case *Float:
+		writeInt(w, floatTag)
+		writeFloat(w, objs, s)
+	case *String:
+		writeInt(w, stringTag)
+		writeAST(w, objs, s.AST)
+		writeString(w, s.Data)
+		writeOptType(w, objs, s.typ)
+	default:
+		panic(fmt.Sprintf("impossible type: %T", s))
+	}
 }
 
 func readStmt(r io.Reader, objs *inObjs) Stmt {
-	// TODO: implement readStmt
-	return nil
+	switch tag := readInt(r); tag {
+	case 0:
+		return nil
+	case retTag:
+		return &Ret{Expr: readExpr(r, objs)}
+	case assignTag:
+		var s Assign
+		readVarRef(r, objs, &s.Var)
+		s.Expr = readExpr(r, objs)
+		return &s
+	case convertTag:
+		return readConvert(r, objs)
+	case callTag:
+		return readCall(r, objs)
+	case ctorTag:
+		return readCtor(r, objs)
+	case blockTag:
+		return readBlock(r, objs)
+	case identTag:
+		var s Ident
+		s.AST, _ = readAST(r, objs).(*ast.Ident)
+		s.Text = readString(r)
+		readVarRef(r, objs, &s.Var)
+		s.Capture = readBool(r)
+		readOptType(r, objs, &s.typ)
+		return &s
+	case intTag:
+		return readIntLit(r, objs)
+	case floatTag:
+		return readFloatLit(r, objs)
+	case stringTag:
+		var s String
+		s.AST, _ = readAST(r, objs).(*ast.String)
+		s.Data = readString(r)
+		readOptType(r, objs, &s.typ)
+		return &s
+	default:
+		panic(ioError{fmt.Errorf("bad statement tag: %d", tag)})
+	}
+}
+
+func writeConvert(w io.Writer, objs *outObjs, s *Convert) {
+	writeStmt(w, objs, s.Expr)
+	writeInt(w, s.Ref)
+	if s.Virts == nil {
+		writeInt(w, -1)
+	} else {
+		writeInt(w, len(s.Virts))
+		for _, f := range s.Virts {
+			writeInt(w, getFunNum(objs, f))
+		}
+	}
+	writeOptType(w, objs, s.typ)
+}
+
+func readConvert(r io.Reader, objs *inObjs) *Convert {
+	var s Convert
+	s.Expr = readExpr(r, objs)
+	s.Ref = readInt(r)
+	if nvirts := readInt(r); nvirts >= 0 {
+		s.Virts = make([]*Fun, nvirts)
+		for i := range s.Virts {
+			patchFun(objs, readInt(r), &s.Virts[i])
+		}
+	}
+	readOptType(r, objs, &s.typ)
+	return &s
+}
+
+func writeCall(w io.Writer, objs *outObjs, s *Call) {
+	writeAST(w, objs, s.AST)
+	writeBool(w, s.Recv != nil)
+	if s.Recv != nil {
+		writeStmt(w, objs, s.Recv)
+	}
+	writeInt(w, len(s.Msgs))
+	for i := range s.Msgs {
+		writeMsg(w, objs, &s.Msgs[i])
+	}
+}
+
+func readCall(r io.Reader, objs *inObjs) *Call {
+	var s Call
+	s.AST = readAST(r, objs)
+	if readBool(r) {
+		s.Recv = readExpr(r, objs)
+	}
+	if nmsgs := readInt(r); nmsgs > 0 {
+		s.Msgs = make([]Msg, nmsgs)
+		for i := range s.Msgs {
+			readMsg(r, objs, &s.Msgs[i])
+		}
+	}
+	return &s
+}
+
+func writeCtor(w io.Writer, objs *outObjs, s *Ctor) {
+	writeAST(w, objs, s.AST)
+	writeExprs(w, objs, s.Args)
+	if s.Case == nil {
+		writeInt(w, -1)
+	} else {
+		writeInt(w, *s.Case)
+	}
+	writeOptType(w, objs, s.typ)
+}
+
+func readCtor(r io.Reader, objs *inObjs) *Ctor {
+	var s Ctor
+	s.AST, _ = readAST(r, objs).(*ast.Ctor)
+	s.Args = readExprs(r, objs)
+	if c := readInt(r); c >= 0 {
+		s.Case = &c
+	}
+	readOptType(r, objs, &s.typ)
+	return &s
+}
+
+func readIntLit(r io.Reader, objs *inObjs) *Int {
+	var s Int
+	s.AST, _ = readAST(r, objs).(ast.Expr)
+	s.Val = new(big.Int)
+	if _, ok := s.Val.SetString(readString(r), 10); !ok {
+		panic(ioError{fmt.Errorf("bad int literal")})
+	}
+	readOptType(r, objs, &s.typ)
+	return &s
+}
+
+func writeFloat(w io.Writer, objs *outObjs, s *Float) {
+	writeAST(w, objs, s.AST)
+	bs, err := s.Val.GobEncode()
+	if err != nil {
+		panic(ioError{err})
+	}
+	writeString(w, string(bs))
+	writeOptType(w, objs, s.typ)
+}
+
+func readFloatLit(r io.Reader, objs *inObjs) *Float {
+	var s Float
+	s.AST, _ = readAST(r, objs).(ast.Expr)
+	s.Val = new(big.Float)
+	if err := s.Val.GobDecode([]byte(readString(r))); err != nil {
+		panic(ioError{err})
+	}
+	readOptType(r, objs, &s.typ)
+	return &s
+}
+
+func writeBlock(w io.Writer, objs *outObjs, s *Block) {
+	writeAST(w, objs, s.AST)
+	writeInt(w, len(s.Parms))
+	for i := range s.Parms {
+		writeVar(w, objs, &s.Parms[i])
+	}
+	writeInt(w, len(s.Locals))
+	for _, l := range s.Locals {
+		writeVar(w, objs, l)
+	}
+	writeInt(w, len(s.Stmts))
+	for _, stmt := range s.Stmts {
+		writeStmt(w, objs, stmt)
+	}
+	writeInt(w, len(s.Captures))
+	for _, c := range s.Captures {
+		writeVarRef(w, objs, c)
+	}
+	writeOptType(w, objs, s.BlockType)
+	writeOptType(w, objs, s.typ)
+}
+
+func readBlock(r io.Reader, objs *inObjs) *Block {
+	var s Block
+	s.AST, _ = readAST(r, objs).(*ast.Block)
+	if nparms := readInt(r); nparms > 0 {
+		s.Parms = make([]Var, nparms)
+		for i := range s.Parms {
+			readVar(r, objs, &s.Parms[i])
+			s.Parms[i].BlkParm = &s
+			s.Parms[i].Index = i
+		}
+	}
+	if nlocals := readInt(r); nlocals > 0 {
+		s.Locals = make([]*Var, nlocals)
+		for i := range s.Locals {
+			var l Var
+			readVar(r, objs, &l)
+			l.Local = &s.Locals
+			l.Index = i
+			s.Locals[i] = &l
+		}
+	}
+	if nstmts := readInt(r); nstmts > 0 {
+		s.Stmts = make([]Stmt, nstmts)
+		for i := range s.Stmts {
+			s.Stmts[i] = readStmt(r, objs)
+		}
+	}
+	if ncaps := readInt(r); ncaps > 0 {
+		s.Captures = make([]*Var, ncaps)
+		for i := range s.Captures {
+			readVarRef(r, objs, &s.Captures[i])
+		}
+	}
+	readOptType(r, objs, &s.BlockType)
+	readOptType(r, objs, &s.typ)
+	return &s
+}
+
+// writeMsg writes the following fields of Msg:
+// 	AST
+// 	Mod
+// 	Sel
+// 	the number of Args or -1 if nil
+// 	Args
+// 	Fun number or -1 if nil
+// 	typ type number or -1 if nil
+func writeMsg(w io.Writer, objs *outObjs, m *Msg) {
+	writeAST(w, objs, m.AST)
+	writeString(w, m.Mod)
+	writeString(w, m.Sel)
+	writeExprs(w, objs, m.Args)
+	if m.Fun == nil {
+		writeInt(w, -1)
+	} else {
+		writeInt(w, getFunNum(objs, m.Fun))
+	}
+	writeOptType(w, objs, m.typ)
+}
+
+func readMsg(r io.Reader, objs *inObjs, m *Msg) {
+	m.AST = readAST(r, objs)
+	m.Mod = readString(r)
+	m.Sel = readString(r)
+	m.Args = readExprs(r, objs)
+	if n := readInt(r); n >= 0 {
+		patchFun(objs, n, &m.Fun)
+	}
+	readOptType(r, objs, &m.typ)
+}
+
+func readExpr(r io.Reader, objs *inObjs) Expr {
+	expr, _ := readStmt(r, objs).(Expr)
+	return expr
+}
+
+func writeExprs(w io.Writer, objs *outObjs, exprs []Expr) {
+	if exprs == nil {
+		writeInt(w, -1)
+		return
+	}
+	writeInt(w, len(exprs))
+	for _, expr := range exprs {
+		writeStmt(w, objs, expr)
+	}
+}
+
+func readExprs(r io.Reader, objs *inObjs) []Expr {
+	n := readInt(r)
+	if n < 0 {
+		return nil
+	}
+	exprs := make([]Expr, n)
+	for i := range exprs {
+		exprs[i] = readExpr(r, objs)
+	}
+	return exprs
+}
+
+func writeOptType(w io.Writer, objs *outObjs, t *Type) {
+	if t == nil {
+		writeInt(w, -1)
+	} else {
+		writeInt(w, getTypeNum(objs, t))
+	}
+}
+
+func readOptType(r io.Reader, objs *inObjs, t **Type) {
+	if n := readInt(r); n >= 0 {
+		patchType(objs, n, t)
+	}
+}
+
+// writeVarRef writes a reference to a Var
+// that is written elsewhere.
+// The reference is one of:
+// 	valVarRef followed by the Val number
+// 	fieldVarRef followed by the Field type number and the Index
+// 	localVarRef followed by the Var number
+func writeVarRef(w io.Writer, objs *outObjs, v *Var) {
+	switch {
+	case v.Val != nil:
+		writeInt(w, valVarRef)
+		writeInt(w, getValNum(objs, v.Val))
+	case v.Field != nil:
+		writeInt(w, fieldVarRef)
+		writeInt(w, getTypeNum(objs, v.Field))
+		writeInt(w, v.Index)
+	default:
+		writeInt(w, localVarRef)
+		writeInt(w, getVarNum(objs, v))
+	}
+}
+
+func readVarRef(r io.Reader, objs *inObjs, v **Var) {
+	switch kind := readInt(r); kind {
+	case valVarRef:
+		var val *Val
+		patchVal(objs, readInt(r), &val)
+		objs.fixups = append(objs.fixups, func() { *v = &val.Var })
+	case fieldVarRef:
+		var typ *Type
+		patchType(objs, readInt(r), &typ)
+		i := readInt(r)
+		objs.fixups = append(objs.fixups, func() { *v = &typ.Fields[i] })
+	case localVarRef:
+		patchVar(objs, readInt(r), v)
+	default:
+		panic(ioError{fmt.Errorf("bad var reference kind: %d", kind)})
+	}
+}
+
+// writeAST writes a stub of an AST node:
+// 	the AST node kind, or noAST if nil
+// 	the Range
+// 	for Msg and Ident, a bool for whether Mod is set, and Mod.Text
+// Only the information needed by later passes is kept.
+func writeAST(w io.Writer, objs *outObjs, n ast.Node) {
+	if n == nil || reflect.ValueOf(n).IsNil() {
+		writeInt(w, noAST)
+		return
+	}
+	kind := astKind(n)
+	writeInt(w, kind)
+	writeRange(w, objs, n.GetRange())
+	var mod *ast.ModTag
+	switch n := n.(type) {
+	case *ast.Msg:
+		mod = n.Mod
+	case *ast.Ident:
+		mod = n.Mod
+	default:
+		return
+	}
+	writeBool(w, mod != nil)
+	if mod != nil {
+		writeRange(w, objs, mod.Range)
+		writeString(w, mod.Text)
+	}
+}
+
+func astKind(n ast.Node) int {
+	switch n.(type) {
+	case *ast.Call:
+		return callAST
+	case *ast.Msg:
+		return msgAST
+	case *ast.Ident:
+		return identAST
+	case *ast.Ctor:
+		return ctorAST
+	case *ast.Block:
+		return blockAST
+	case *ast.Int:
+		return intAST
+	case *ast.Float:
+		return floatAST
+	case *ast.Rune:
+		return runeAST
+	case *ast.String:
+		return stringAST
+	default:
+		panic(fmt.Sprintf("impossible type: %T", n))
+	}
+}
+
+func readAST(r io.Reader, objs *inObjs) ast.Node {
+	kind := readInt(r)
+	if kind == noAST {
+		return nil
+	}
+	rng := readRange(r, objs)
+	var mod *ast.ModTag
+	if (kind == msgAST || kind == identAST) && readBool(r) {
+		mod = &ast.ModTag{Range: readRange(r, objs)}
+		mod.Text = readString(r)
+	}
+	switch kind {
+	case callAST:
+		return &ast.Call{Range: rng}
+	case msgAST:
+		return &ast.Msg{Range: rng, Mod: mod}
+	case identAST:
+		return &ast.Ident{Range: rng, Mod: mod}
+	case ctorAST:
+		return &ast.Ctor{Range: rng}
+	case blockAST:
+		return &ast.Block{Range: rng}
+	case intAST:
+		return &ast.Int{Range: rng}
+	case floatAST:
+		return &ast.Float{Range: rng}
+	case runeAST:
+		return &ast.Rune{Range: rng}
+	case stringAST:
+		return &ast.String{Range: rng}
+	default:
+		panic(ioError{fmt.Errorf("bad AST kind: %d", kind)})
+	}
+}
+
+// writeRange writes the index of the Range's file or -1 if unknown,
+// and the start and end offsets relative to the file.
+// The first time a file is referenced its index is followed by:
+// 	Path
+// 	Len
+// 	the number of Lines
+// 	Lines relative to the file
+func writeRange(w io.Writer, objs *outObjs, rng loc.Range) {
+	file := -1
+	if objs.locs != nil {
+		for i, f := range *objs.locs {
+			if f.Offs <= rng[0] && rng[1] <= f.Offs+f.Len {
+				file = i
+				break
+			}
+		}
+	}
+	if file < 0 {
+		writeInt(w, -1)
+		return
+	}
+	f := (*objs.locs)[file]
+	n, ok := objs.files[file]
+	if !ok {
+		n = len(objs.files)
+		objs.files[file] = n
+	}
+	writeInt(w, n)
+	if !ok {
+		writeString(w, f.Path)
+		writeInt(w, f.Len)
+		writeInt(w, len(f.Lines))
+		for _, l := range f.Lines {
+			writeInt(w, l-f.Offs)
+		}
+	}
+	writeInt(w, rng[0]-f.Offs)
+	writeInt(w, rng[1]-f.Offs)
+}
+
+func readRange(r io.Reader, objs *inObjs) loc.Range {
+	n := readInt(r)
+	if n < 0 {
+		return loc.Range{}
+	}
+	if n == len(objs.files) {
+		f := loc.File{Path: readString(r), Len: readInt(r)}
+		if nlines := readInt(r); nlines > 0 {
+			f.Lines = make([]int, nlines)
+			for i := range f.Lines {
+				f.Lines[i] = readInt(r)
+			}
+		}
+		offs := -1
+		if objs.locs != nil {
+			offs = objs.locs.Len()
+			f.Offs = offs
+			for i := range f.Lines {
+				f.Lines[i] += offs
+			}
+			*objs.locs = append(*objs.locs, f)
+		}
+		objs.files = append(objs.files, offs)
+	}
+	if n >= len(objs.files) {
+		panic(ioError{fmt.Errorf("bad file number: %d", n)})
+	}
+	start, end := readInt(r), readInt(r)
+	offs := objs.files[n]
+	if offs < 0 {
+		return loc.Range{}
+	}
+	return loc.Range{offs + start, offs + end}
 }
 
 // writeType writes the Type number, then the following fields of Type:
+// 	key
+// 	a bool for whether the type is ground
 // 	Def type number
 // 	Priv
 // 	Mod
@@ -418,6 +1226,7 @@ func readStmt(r io.Reader, objs *inObjs) Stmt {
 func writeType(w io.Writer, objs *outObjs, t *Type) {
 	writeInt(w, getTypeNum(objs, t))
 	writeString(w, buildTypeKey(t, new(strings.Builder)).String())
+	writeBool(w, isGroundType(t))
 	writeInt(w, getTypeNum(objs, t.Def))
 	writeBool(w, t.Priv)
 	writeString(w, t.ModPath)
@@ -488,9 +1297,8 @@ func buildTypeKey(n *Type, s *strings.Builder) *strings.Builder {
 func readType(r io.Reader, objs *inObjs) *Type {
 	var t Type
 	n := readInt(r)
-	// key, eventually to be used to deduplicate types
-	// in the face of multiple imports.
-	readString(r)
+	key := readString(r)
+	ground := readBool(r)
 	patchType(objs, readInt(r), &t.Def)
 	t.Priv = readBool(r)
 	t.ModPath = readString(r)
@@ -539,9 +1347,48 @@ func readType(r io.Reader, objs *inObjs) *Type {
 		patchType(objs, tagNum, &t.tagType)
 	}
 	addType(objs, n, &t)
+	if ground && t.ModPath != objs.mod {
+		return useExtInst(objs, &t, key)
+	}
 	return &t
 }
 
+// useExtInst returns an equivalent instance of t
+// already existing in the importing module if there is one,
+// otherwise it returns t.
+// Ground instances of other modules' types
+// must be the same object as in the importing module,
+// so t and its sub-objects are replaced
+// with the corresponding objects of the existing instance.
+func useExtInst(objs *inObjs, t *Type, key string) *Type {
+	inst := findExtInst(objs, t.ModPath, t.Arity, t.Name, key)
+	if inst == nil {
+		return t
+	}
+	objs.replace(t, inst)
+	for i := range t.Parms {
+		if i < len(inst.Parms) {
+			objs.replace(t.Parms[i].Type, inst.Parms[i].Type)
+		}
+	}
+	replaceVars(objs, t.Fields, inst.Fields)
+	replaceVars(objs, t.Cases, inst.Cases)
+	for i := range t.Virts {
+		if i < len(inst.Virts) {
+			replaceVars(objs, t.Virts[i].Parms, inst.Virts[i].Parms)
+		}
+	}
+	return inst
+}
+
+func replaceVars(objs *inObjs, old, new []Var) {
+	for i := range old {
+		if i < len(new) {
+			objs.replace(&old[i], &new[i])
+		}
+	}
+}
+
 // writeVar writes the following fields of Var:
 // 	the Var's object number
 // 	Name
@@ -584,8 +1431,12 @@ func readVar(r io.Reader, objs *inObjs, v *Var) {
 }
 
 type outObjs struct {
+	mod     string
 	num     map[interface{}]int
 	written map[interface{}]bool
+	locs    *loc.Files
+	// files maps indices into locs to written file numbers.
+	files map[int]int
 }
 
 func (objs *outObjs) getNum(v interface{}) int {
@@ -603,6 +1454,20 @@ func getTypeNum(objs *outObjs, t *Type) int { return objs.getNum(t) }
 func getVarNum(objs *outObjs, v *Var) int   { return objs.getNum(v) }
 
 type inObjs struct {
+	mod  string
+	cfg  Config
+	locs *loc.Files
+	// files is the offset in locs of each read file,
+	// or -1 if locs is nil.
+	files []int
+	// nums maps read objects to their object numbers.
+	nums map[interface{}]int
+	// ext is the set of objects from other modules.
+	// They are not added to their Def's Insts.
+	ext map[interface{}]bool
+	// fixups are called after applyPatches.
+	fixups []func()
+
 	nobjs int
 	// patches has an entry for each object number
 	// containing a slice of pointers to pointers to that object.
@@ -617,11 +1482,28 @@ type inObjs struct {
 func (objs *inObjs) add(n int, o interface{}) {
 	objs.ensure(n)
 	objs.objs[n] = o
+	objs.nums[o] = n
+}
+
+// replace replaces the object old with the ext object new.
+func (objs *inObjs) replace(old, new interface{}) {
+	if n, ok := objs.nums[old]; ok {
+		objs.add(n, new)
+		objs.ext[new] = true
+	}
+}
+
+func (objs *inObjs) importDefs(modPath string) []Def {
+	defs, err := objs.cfg.Importer.Import(objs.cfg, objs.locs, modPath)
+	if err != nil {
+		panic(ioError{err})
+	}
+	return defs
 }
 
 func (objs *inObjs) patch(n int, pp interface{}) {
 	switch pp.(type) {
-	case **Var, **Fun, **Type, *TypeName, **TypeName:
+	case **Val, **Var, **Fun, **Type, *TypeName, **TypeName:
 		break
 	default:
 		panic(fmt.Sprintf("bad patch type: %T", pp))
@@ -676,19 +1558,10 @@ func applyPatches(objs *inObjs) {
 				*p.(**Fun) = obj
 			}
 		case *Type:
-			types = append(types, obj)
-			for _, p := range objs.patches[i] {
-				switch p := p.(type) {
-				case **Type:
-					*p = obj
-				case *TypeName:
-					*p = *makeTypeName(obj)
-				case **TypeName:
-					*p = makeTypeName(obj)
-				default:
-					panic(fmt.Sprintf("impossible type: %T", p))
-				}
+			if !objs.ext[obj] {
+				types = append(types, obj)
 			}
+			applyTypePatches(obj, objs.patches[i])
 		case *Var:
 			for _, p := range objs.patches[i] {
 				*p.(**Var) = obj
@@ -702,6 +1575,21 @@ func applyPatches(objs *inObjs) {
 	}
 }
 
+func applyTypePatches(typ *Type, patches []interface{}) {
+	for _, p := range patches {
+		switch p := p.(type) {
+		case **Type:
+			*p = typ
+		case *TypeName:
+			*p = *makeTypeName(typ)
+		case **TypeName:
+			*p = makeTypeName(typ)
+		default:
+			panic(fmt.Sprintf("impossible type: %T", p))
+		}
+	}
+}
+
 type ioError struct {
 	err error
 }
@@ -208,6 +208,39 @@ func TestExportImport(t *testing.T) {
 				]
 			`,
 		},
+		{
+			name: "parameterized fun statements",
+			src: `
+				func T [foo: t T ^T Array |
+					x T Array := {t; t}.
+					y T := t.
+					f := [:a T :b Int | z := b + 1. x at: z put: a. y := a].
+					f value: t value: 1.
+					"hello" byteSize.
+					3.5 + 1.0.
+					x := {y; t}.
+					^x
+				]
+			`,
+		},
+		{
+			name: "parameterized meth statements",
+			src: `
+				type T? { none | some: T }
+				type T Pair {x: T y: T}
+				meth T Pair [swap ^T Pair | ^{x: y y: x}]
+				meth T Pair [first ^T? | ^{some: x}]
+				meth T Pair [nothing ^T? | ^{none}]
+				meth T Pair [fold: f (T, T, T) Fun ^T | ^f value: x value: y]
+			`,
+		},
+		{
+			name: "parameterized val reference",
+			src: `
+				val v := [5]
+				func T [foo: t T ^Int | ^v + 1]
+			`,
+		},
 	}
 	for _, test := range tests {
 		test := test
@@ -225,7 +258,7 @@ func TestExportImport(t *testing.T) {
 			if err := Write(&buf, mod); err != nil {
 				t.Fatalf("failed to write the mod: %v", err)
 			}
-			got, err := Read(&buf)
+			got, err := Read(&buf, Config{}, nil)
 			if err != nil {
 				t.Fatalf("failed to read the mod: %v", err)
 			}
@@ -234,9 +267,16 @@ func TestExportImport(t *testing.T) {
 				cmpopts.IgnoreFields(Mod{}, "SortedVals"),
 				cmpopts.IgnoreFields(Val{}, "Locals", "Init"),
 				cmpopts.IgnoreFields(Fun{}, "Insts"),
-				// TODO: implement exporting/importing statements.
-				cmpopts.IgnoreFields(Fun{}, "Stmts"),
+				// Only Stmts of parameterized definitions are exported.
+				ignoreUnexportedStmts(mod.Path),
 				cmpopts.IgnoreFields(Type{}, "Insts"),
+				// Parameters of substituted instances
+				// may point to the FunParm of their definition,
+				// but they are read pointing to the instance.
+				cmpopts.IgnoreFields(Var{}, "FunParm"),
+				cmpopts.EquateEmpty(),
+				compareExtByName(mod.Path),
+				ignoreBlockFieldTypeNames(),
 				// Outgoing Mod is set to whether the user typed it.
 				// Incoming Mod is set to the type's mod.
 				cmpopts.IgnoreFields(TypeName{}, "Mod"),
@@ -251,6 +291,50 @@ func TestExportImport(t *testing.T) {
 	}
 }
 
+// ignoreUnexportedStmts ignores Fun.Stmts
+// except for parameterized definitions of the module.
+func ignoreUnexportedStmts(modPath string) cmp.Option {
+	return cmp.FilterPath(func(p cmp.Path) bool {
+		if len(p) < 2 || p[len(p)-1].String() != ".Stmts" {
+			return false
+		}
+		v, _ := p[len(p)-2].Values()
+		f, ok := v.Addr().Interface().(*Fun)
+		return ok && (f.Def != f || f.ModPath != modPath || isGroundFun(f))
+	}, cmp.Ignore())
+}
+
+// compareExtByName compares definitions of other modules by name,
+// since that is how they are exported.
+func compareExtByName(modPath string) cmp.Option {
+	return cmp.Options{
+		cmp.FilterValues(func(x, y *Fun) bool {
+			return x != nil && y != nil && isExt(modPath, x) && isExt(modPath, y)
+		}, cmp.Comparer(func(x, y *Fun) bool {
+			return x.ModPath == y.ModPath && x.String() == y.String()
+		})),
+		cmp.FilterValues(func(x, y *Type) bool {
+			return x != nil && y != nil && isExt(modPath, x) && isExt(modPath, y)
+		}, cmp.Comparer(func(x, y *Type) bool {
+			return x.ModPath == y.ModPath && x.String() == y.String()
+		})),
+	}
+}
+
+// ignoreBlockFieldTypeNames ignores the TypeName of block type fields.
+// Block type fields use the captured variable's TypeName,
+// but the field is a reference to the variable's type.
+func ignoreBlockFieldTypeNames() cmp.Option {
+	return cmp.FilterPath(func(p cmp.Path) bool {
+		if len(p) < 2 || p[len(p)-1].String() != ".TypeName" {
+			return false
+		}
+		v, _ := p[len(p)-2].Values()
+		vr, ok := v.Interface().(Var)
+		return ok && vr.Field != nil && vr.Field.BuiltIn == BlockType
+	}, cmp.Ignore())
+}
+
 func TestBuildTypeKey(t *testing.T) {
 	tests := []struct {
 		name string
@@ -71,7 +71,7 @@ func aliasCycle(x *scope, typ *Type) *checkError {
 		if typ != t {
 			continue
 		}
-		err := x.err(t, "type alias cycle")
+		err := x.err(t, AliasCycle, "type alias cycle")
 		for ; i < len(x.aliasStack); i++ {
 			alias := x.aliasStack[i]
 			// alias loops can only occur in the current package,
@@ -122,7 +122,7 @@ func gatherFun(x *scope, def *Fun) (errs []checkError) {
 	for i := range def.TParms {
 		tvar := &def.TParms[i]
 		if tvar.Name == "_" {
-			err := x.err(tvar, "illegal function type variable name")
+			err := x.err(tvar, BadTypeVarName, "illegal function type variable name")
 			errs = append(errs, *err)
 			continue
 		}
@@ -272,7 +272,7 @@ func gatherType(x *scope, def *Type) (errs []checkError) {
 		case n < 4294967296:
 			def.tagType = builtInType(x, "UInt32")
 		default:
-			errs = append(errs, *x.err(def, "too many cases"))
+			errs = append(errs, *x.err(def, TooManyCases, "too many cases"))
 		}
 	case astType.Virts != nil:
 		def.Virts, es = gatherFunSigs(x, astType.Virts)
@@ -282,7 +282,7 @@ func gatherType(x *scope, def *Type) (errs []checkError) {
 	for i := range def.Parms {
 		tvar := &def.Parms[i]
 		if tvar.Name != "_" && !x.tvarUse[tvar] {
-			err := x.err(tvar, "%s defined and not used", tvar.Name)
+			err := x.err(tvar, UnusedTypeVar, "%s defined and not used", tvar.Name)
 			errs = append(errs, *err)
 		}
 	}
@@ -3,7 +3,9 @@
 package types
 
 import (
+	"bufio"
 	"fmt"
+	"os"
 	"path/filepath"
 
 	"github.com/eaburns/pea/ast"
@@ -79,6 +81,87 @@ func (ir *SourceImporter) Import(cfg Config, locs *loc.Files, modPath string) ([
 	return checkedMod.Defs, nil
 }
 
+// ExportImporter imports modules from their export data file,
+// falling back to their source code
+// if the export data is missing or out of date.
+type ExportImporter struct {
+	// Root is the root directory prepended to module paths.
+	Root string
+
+	// Stale, if non-nil, reports whether the export data
+	// of a module is out of date.
+	// If Stale is nil, the export data is out of date
+	// if any source file is newer than the export data file.
+	Stale func(*mod.Mod) bool
+}
+
+// ExportFile returns the path to the export data file of a module.
+func ExportFile(m *mod.Mod) string {
+	return filepath.Join(m.SrcDir, m.ModName+".peaexp")
+}
+
+// Import implemements the Importer interface.
+func (ir *ExportImporter) Import(cfg Config, locs *loc.Files, modPath string) ([]Def, error) {
+	path := filepath.Join(ir.Root, modPath)
+	m, err := mod.Load(path, modPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %s", path, err)
+	}
+	if defs, err := readExport(cfg, locs, m, ir.Stale); err == nil {
+		return defs, nil
+	}
+	src := SourceImporter{Root: ir.Root}
+	return src.Import(cfg, locs, modPath)
+}
+
+func readExport(cfg Config, locs *loc.Files, m *mod.Mod, stale func(*mod.Mod) bool) ([]Def, error) {
+	if stale == nil {
+		stale = newerSrc
+	}
+	expFile := ExportFile(m)
+	if stale(m) {
+		return nil, fmt.Errorf("%s is stale", expFile)
+	}
+	f, err := os.Open(expFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var nlocs int
+	if locs != nil {
+		nlocs = len(*locs)
+	}
+	cfg.Trace = false // don't trace imports
+	typesMod, err := Read(bufio.NewReader(f), cfg, locs)
+	if err != nil {
+		if locs != nil {
+			*locs = (*locs)[:nlocs]
+		}
+		return nil, fmt.Errorf("failed to read %s: %s", expFile, err)
+	}
+	if typesMod.Path != m.ModPath {
+		return nil, fmt.Errorf("%s has module path %s, expected %s",
+			expFile, typesMod.Path, m.ModPath)
+	}
+	return typesMod.Defs, nil
+}
+
+// newerSrc returns whether the export data file of a module
+// is missing or older than any of its source files.
+func newerSrc(m *mod.Mod) bool {
+	expInfo, err := os.Stat(ExportFile(m))
+	if err != nil {
+		return true
+	}
+	for _, srcFile := range m.SrcFiles {
+		srcInfo, err := os.Stat(srcFile)
+		if err != nil || !srcInfo.ModTime().Before(expInfo.ModTime()) {
+			return true
+		}
+	}
+	return false
+}
+
 func setMod(path string, defs []Def) {
 	for _, def := range defs {
 		switch def := def.(type) {
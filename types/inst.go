@@ -254,17 +254,14 @@ func instRecv(x *scope, loc ast.Node, recv *Type, fun *Fun) (_ *Fun, errs []chec
 		return nil, errs
 	}
 
-	file := x.curFile()
-	for _, inst := range file.funInsts {
-		if inst.Def != fun.Def {
-			continue
-		}
-		if len(inst.TArgs) > 0 {
+	meths := instMeths(x, loc, fun.Recv.Parms, sub)
+	for _, inst := range fun.Def.Insts {
+		if inst.Recv == nil || len(inst.TArgs) > 0 {
 			// This is a fully-instantiated function.
 			// We only want a receiver-instantiated instance.
 			continue
 		}
-		if typeNamesEq(inst.Recv.Args, recv.Args) {
+		if typeNamesEq(inst.Recv.Args, recv.Args) && funsEq(inst.Meths, meths) {
 			return inst, errs
 		}
 	}
@@ -273,9 +270,9 @@ func instRecv(x *scope, loc ast.Node, recv *Type, fun *Fun) (_ *Fun, errs []chec
 	inst.Def = fun.Def
 	inst.Recv.Type = recv
 	inst.Recv.Args = recv.Args
-	file.funInsts = append(file.funInsts, inst)
+	inst.Meths = meths
 	fun.Def.Insts = append(fun.Def.Insts, inst)
-	x.funTodo = append(x.funTodo, funFile{fun: inst, file: file})
+	x.funTodo = append(x.funTodo, funFile{fun: inst, file: x.curFile()})
 
 	x.log("instantiated: %s", inst)
 
@@ -326,20 +323,19 @@ func instFun(x *scope, loc ast.Node, infer *Type, fun *Fun, argTypes argTypes) (
 		args[i] = sub[&fun.TParms[i]]
 	}
 	if len(notes) > 0 {
-		err := x.err(argTypes.ast(), "cannot infer type parameters of %s", fun.Sig.Sel)
+		err := x.err(argTypes.ast(), CannotInfer, "cannot infer type parameters of %s", fun.Sig.Sel)
 		note(err, "%s", fun)
 		err.notes = append(err.notes, notes...)
 		errs = append(errs, *err)
 		return nil, errs
 	}
 
-	file := x.curFile()
-	for _, inst := range file.funInsts {
-		if inst.Def != fun.Def {
-			continue
-		}
+	meths := instMeths(x, loc, fun.TParms, sub)
+	// If fun is a receiver instance, its Meths are those of the receiver.
+	meths = append(fun.Meths[:len(fun.Meths):len(fun.Meths)], meths...)
+	for _, inst := range fun.Def.Insts {
 		if (fun.Recv == nil || fun.Recv.Type == inst.Recv.Type) &&
-			typeNamesEq(inst.TArgs, args) {
+			typeNamesEq(inst.TArgs, args) && funsEq(inst.Meths, meths) {
 			return inst, errs
 		}
 	}
@@ -347,12 +343,63 @@ func instFun(x *scope, loc ast.Node, infer *Type, fun *Fun, argTypes argTypes) (
 	inst := subFun(x, make(map[*Type]*Type), sub, fun)
 	inst.Def = fun.Def
 	inst.TArgs = args
-	file.funInsts = append(file.funInsts, inst)
+	inst.Meths = meths
 	fun.Def.Insts = append(fun.Def.Insts, inst)
-	x.funTodo = append(x.funTodo, funFile{fun: inst, file: file})
+	x.funTodo = append(x.funTodo, funFile{fun: inst, file: x.curFile()})
 	return inst, nil
 }
 
+// instMeths returns the methods of the type arguments
+// that satisfy the constraints of the type parameters.
+// The methods are found in the current file,
+// so they may differ between files
+// when the files have different Import statements.
+//
+// A method that is not found is nil;
+// the instance body only needs the methods that it calls.
+func instMeths(x *scope, loc ast.Node, parms []TypeVar, sub map[*TypeVar]TypeName) []*Fun {
+	defer x.tr("instMeths(%s)", subDebugString(sub))()
+
+	var meths []*Fun
+	seen := make(map[*Type]*Type)
+	for i := range parms {
+		parm := &parms[i]
+		arg, ok := sub[parm]
+		if !ok || !isGroundType(arg.Type) {
+			// Instances with non-ground type arguments are lifted;
+			// their bodies are never instantiated,
+			// so they do not need the methods.
+			continue
+		}
+		// Constraint method calls are on the dereferenced receiver.
+		recv := arg.Type
+		for isRef(recv) {
+			recv = recv.Args[0].Type
+		}
+		for j := range parm.Ifaces {
+			iface := subTypeName(x, seen, sub, &parm.Ifaces[j])
+			if iface.Type == nil {
+				continue
+			}
+			funs, _ := findVirts(x, loc, recv, iface.Type.Virts, true)
+			meths = append(meths, funs...)
+		}
+	}
+	return meths
+}
+
+func funsEq(as, bs []*Fun) bool {
+	if len(as) != len(bs) {
+		return false
+	}
+	for i := range as {
+		if as[i] != bs[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // unifyFunTParms sets msg.Args for each arg passed to
 // a fun param with a type variable in its type.
 // The rest of msg.Args are left nil.
@@ -411,7 +458,7 @@ func unifyFunTParms(x *scope, loc ast.Node, infer *Type, fun *Fun, argTypes argT
 	for i := range fun.TParms {
 		tparm := &fun.TParms[i]
 		if _, ok := sub[tparm]; !ok {
-			err := x.err(loc, "unable to infer type parameter %s of %s",
+			err := x.err(loc, CannotInfer, "unable to infer type parameter %s of %s",
 				tparm.Name, fun)
 			errs = append(errs, *err)
 		}
@@ -447,7 +494,7 @@ func unify(x *scope, loc ast.Node, pat, typ *TypeName, tparms map[*TypeVar]bool,
 		}
 		x.log("prev=%s", prev)
 		if prev.Type != typ.Type {
-			err = x.err(loc, "cannot bind %s to %s: already bound", typ, pat.Name)
+			err = x.err(loc, CannotUnify, "cannot bind %s to %s: already bound", typ, pat.Name)
 			note(err, "previous binding to %s at %s", prev, x.loc(prev))
 			return err
 		}
@@ -462,7 +509,7 @@ func unify(x *scope, loc ast.Node, pat, typ *TypeName, tparms map[*TypeVar]bool,
 		pat.Type.Name != typ.Type.Name ||
 		pat.Type.Arity != typ.Type.Arity {
 		x.log("type mismatch: have %s, want %s", typ.name(), pat.name())
-		return x.err(loc, "type mismatch: have %s, want %s", typ.name(), pat.name())
+		return x.err(loc, TypeMismatch, "type mismatch: have %s, want %s", typ.name(), pat.name())
 	}
 	var errs []checkError
 	for i := range pat.Type.Args {
@@ -477,7 +524,7 @@ func unify(x *scope, loc ast.Node, pat, typ *TypeName, tparms map[*TypeVar]bool,
 		}
 	}
 	if len(errs) > 0 {
-		err = x.err(loc, "%s cannot unify with %s", typ, pat)
+		err = x.err(loc, CannotUnify, "%s cannot unify with %s", typ, pat)
 		err.cause = errs
 		return err
 	}
@@ -761,6 +761,57 @@ func TestDifferentInstsFromDifferentFiles(t *testing.T) {
 	}
 }
 
+// Tests that calls from different files share an instance
+// if the type parameters have the same methods in each file.
+func TestSameInstsFromDifferentFiles(t *testing.T) {
+	t.Parallel()
+	const file0 = `
+		type Fooer {[foo]}
+		func (T Fooer) [doFoo: f T | f foo]
+		func T [id: t T ^T | ^t]
+	`
+	const file1 = `
+		Import "bar"
+		val file1Val := [doFoo: (id: 5)]
+	`
+	const file2 = `
+		Import "bar"
+		val file2Val := [doFoo: (id: 5)]
+	`
+	imports := [][2]string{
+		{"bar", "Meth Int [foo]"},
+	}
+	p := ast.NewParser("/test/test")
+	for i, src := range [...]string{file0, file1, file2} {
+		path := fmt.Sprintf("file%d", i)
+		if err := p.Parse(path, strings.NewReader(src)); err != nil {
+			t.Fatalf("failed to parse source file%d: %s", i, err)
+		}
+	}
+	cfg := Config{Importer: testImporter(imports)}
+	mod, errs := Check(p.Mod(), cfg)
+	if len(errs) > 0 {
+		t.Fatalf("failed to check source: %v", errs)
+	}
+	for _, sel := range [...]string{"doFoo:", "id:"} {
+		f := findTestFun(mod, sel)
+		if f == nil {
+			t.Fatalf("no function %s", sel)
+		}
+		if len(f.Insts) != 1 {
+			t.Errorf("%s len(Insts)=%d, want 1", sel, len(f.Insts))
+		}
+	}
+	file1DoFoo := findTestVal(mod, "file1Val").Init[0].(*Call).Msgs[0].Fun
+	file2DoFoo := findTestVal(mod, "file2Val").Init[0].(*Call).Msgs[0].Fun
+	if file1DoFoo != file2DoFoo {
+		t.Errorf("file1 and file2 call different doFoo: instances")
+	}
+	if len(file1DoFoo.Meths) != 1 || file1DoFoo.Meths[0].ModPath != "bar" {
+		t.Errorf("doFoo: Meths=%v, want [Int #bar foo]", file1DoFoo.Meths)
+	}
+}
+
 // Tests that Fun.Insts contains only grounded function instances.
 func TestFunInsts_Grounded(t *testing.T) {
 	t.Parallel()
@@ -24,9 +24,6 @@ type file struct {
 	ast     *ast.File
 	imports []imp
 	x       *scope
-
-	// Fun instances due to calls from in this file.
-	funInsts []*Fun
 }
 
 type imp struct {
@@ -113,7 +110,7 @@ func (x *scope) locals() *[]*Var {
 func findImport(x *scope, mod *ast.ModTag) (*imp, *checkError) {
 	imp := x.findImport(mod.Text)
 	if imp == nil {
-		return nil, x.err(mod, "module %s not found", mod.Text)
+		return nil, x.err(mod, NotFound, "module %s not found", mod.Text)
 	}
 	return imp, nil
 }
@@ -153,9 +150,9 @@ func findType(x *scope, loc ast.Node, mod *ast.ModTag, arity int, name string) (
 			return t, nil
 		}
 		if arity == 0 {
-			return nil, x.err(loc, "type %s %s not found", mod.Text, name)
+			return nil, x.err(loc, NotFound, "type %s %s not found", mod.Text, name)
 		}
-		return nil, x.err(loc, "type (%d) %s %s not found", arity, mod.Text, name)
+		return nil, x.err(loc, NotFound, "type (%d) %s %s not found", arity, mod.Text, name)
 	}
 	t, err := x.findType(loc, arity, name)
 	if err != nil {
@@ -165,9 +162,9 @@ func findType(x *scope, loc ast.Node, mod *ast.ModTag, arity int, name string) (
 		return t, nil
 	}
 	if arity == 0 {
-		return nil, x.err(loc, "type %s not found", name)
+		return nil, x.err(loc, NotFound, "type %s not found", name)
 	}
-	return nil, x.err(loc, "type (%d)%s not found", arity, name)
+	return nil, x.err(loc, NotFound, "type (%d)%s not found", arity, name)
 }
 
 func (x *scope) findType(loc ast.Node, arity int, name string) (*Type, *checkError) {
@@ -217,7 +214,7 @@ func (f *file) findType(loc ast.Node, arity int, name string) (*Type, *checkErro
 	if len(ts) == 1 {
 		return ts[0], nil
 	}
-	err := f.x.err(loc, "ambiguous type (%d)%s)", arity, name)
+	err := f.x.err(loc, Ambiguous, "ambiguous type (%d)%s)", arity, name)
 	for _, imp := range imps {
 		note(err, "imported from %s at %s", imp.name, f.x.loc(imp.ast))
 	}
@@ -262,14 +259,14 @@ func findIdent(x *scope, loc ast.Node, mod *ast.ModTag, name string) (id interfa
 		if id := imp.findIdent(name); id != nil {
 			return id, nil
 		}
-		return nil, x.err(loc, "identifier %s %s not found", mod.Text, name)
+		return nil, x.err(loc, NotFound, "identifier %s %s not found", mod.Text, name)
 	}
 	id, err = x.findIdent(loc, name)
 	if err != nil {
 		return nil, err
 	}
 	if id == nil {
-		return nil, x.err(loc, "identifier %s not found", name)
+		return nil, x.err(loc, NotFound, "identifier %s not found", name)
 	}
 	return id, nil
 }
@@ -327,7 +324,7 @@ func (f *file) findIdent(loc ast.Node, name string) (interface{}, *checkError) {
 	if len(ids) == 1 {
 		return ids[0], nil
 	}
-	err := f.x.err(loc, "ambiguous identifier %s", name)
+	err := f.x.err(loc, Ambiguous, "ambiguous identifier %s", name)
 	for _, imp := range imps {
 		note(err, "imported from %s at %s", imp.name, f.x.loc(imp.ast))
 	}
@@ -394,9 +391,9 @@ func findFun(x *scope, loc ast.Node, recv *Type, mod *ast.ModTag, sel string) (f
 		}
 	}
 	if recv == nil {
-		return nil, x.err(loc, "function %s%s not found", modName, sel)
+		return nil, x.err(loc, NotFound, "function %s%s not found", modName, sel)
 	}
-	return nil, x.err(loc, "method %s %s%s not found", recv, modName, sel)
+	return nil, x.err(loc, NotFound, "method %s %s%s not found", recv, modName, sel)
 }
 
 func findDefModMeth(x *scope, recv *Type, sel string) *Fun {
@@ -456,9 +453,9 @@ func (f *file) findFun(loc ast.Node, recv *Type, sel string) (*Fun, *checkError)
 	}
 	var err *checkError
 	if recv == nil {
-		err = f.x.err(loc, "ambiguous function %s", sel)
+		err = f.x.err(loc, Ambiguous, "ambiguous function %s", sel)
 	} else {
-		err = f.x.err(loc, "ambiguous method %s", sel)
+		err = f.x.err(loc, Ambiguous, "ambiguous method %s", sel)
 	}
 	for _, imp := range imps {
 		note(err, "imported from %s at %s", imp.name, f.x.loc(imp.ast))
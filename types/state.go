@@ -28,17 +28,15 @@ type state struct {
 
 	// Fun instances needing instFunStmts.
 	//
-	// Each file that calls a parameterized func or meth
-	// gets its own unique instantiation.
-	// This is because each file can have different methods
-	// for the same type due to different Import statements.
-	// To ensure that the instantatiation uses the correct methods,
-	// we make a different instance for each calling file.
-	//
-	// A future improvement would be to find constraint methods
-	// for all type arguments, and then dedup on the method sets.
-	// This would allow the possibility of sharing instances
-	// across multiple files if they all use the same methods.
+	// Each file can have different methods for the same type
+	// due to different Import statements.
+	// So instances are keyed not only by their type arguments,
+	// but also by the methods satisfying their type constraints
+	// as found from the calling file (Fun.Meths).
+	// Calls from multiple files share an instance
+	// if they all use the same methods.
+	// Each instance's body is instantiated in the scope
+	// of the first file that calls it.
 	funTodo []funFile
 
 	nextID        int
@@ -86,6 +84,14 @@ func setConfigDefaults(x *state) {
 	default:
 		panic("bad IntSize " + strconv.Itoa(x.cfg.IntSize))
 	}
+	switch x.cfg.FloatSize {
+	case 0:
+		x.cfg.FloatSize = 64
+	case 32, 64:
+		break
+	default:
+		panic("bad FloatSize " + strconv.Itoa(x.cfg.FloatSize))
+	}
 	if x.cfg.Importer == nil {
 		x.cfg.Importer = &SourceImporter{}
 	}
@@ -116,7 +122,7 @@ func (x *state) loc(n interface{}) *loc.Loc {
 	}
 }
 
-func (x *state) err(n interface{}, f string, vs ...interface{}) *checkError {
+func (x *state) err(n interface{}, code Code, f string, vs ...interface{}) *checkError {
 	for i, v := range vs {
 		switch v := v.(type) {
 		case *Val:
@@ -139,7 +145,7 @@ func (x *state) err(n interface{}, f string, vs ...interface{}) *checkError {
 			}
 		}
 	}
-	return &checkError{loc: *x.loc(n), msg: fmt.Sprintf(f, vs...)}
+	return &checkError{loc: *x.loc(n), code: code, msg: fmt.Sprintf(f, vs...)}
 }
 
 const indent = "-"
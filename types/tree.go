@@ -7,6 +7,7 @@ package types
 import (
 	"fmt"
 	"math/big"
+	"reflect"
 	"strconv"
 
 	"github.com/eaburns/pea/ast"
@@ -29,6 +30,11 @@ type Mod struct {
 	BoolType *Type
 	// ByteType is a pointer to the Byte type.
 	ByteType *Type
+
+	// IntSize is the bit size of the Int and UInt types.
+	IntSize int
+	// FloatSize is the bit size of the Float type.
+	FloatSize int
 }
 
 // A Node is a node of the AST with location information.
@@ -38,6 +44,19 @@ type Node interface {
 	ast() ast.Node
 }
 
+// NodeAST returns the AST node corresponding to the type-checked node,
+// or nil if there is none, as for nodes within built-in and imported definitions.
+func NodeAST(n Node) ast.Node {
+	a := n.ast()
+	if a == nil {
+		return nil
+	}
+	if v := reflect.ValueOf(a); v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil
+	}
+	return a
+}
+
 // A Def is a module-level definition.
 type Def interface {
 	Node
@@ -102,7 +121,13 @@ type Fun struct {
 	Recv        *Recv
 	TParms      []TypeVar
 	TArgs       []TypeName
-	Sig         FunSig
+	// Meths are the methods of the type arguments
+	// that satisfy the constraints of their type parameters,
+	// first for the receiver type parameters, then for TParms.
+	// An instance is shared by all calls in the module
+	// with the same receiver type, TArgs, and Meths.
+	Meths []*Fun
+	Sig   FunSig
 
 	Locals []*Var
 